@@ -0,0 +1,88 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+)
+
+// archiveFile moves a closed file to --archive_dir, taking it out of the
+// rotation so --num_files and the other limits only count files left behind
+func (fb *FileBuffer) archiveFile(path string) {
+	if fb.archiveDir == "" || path == "" {
+		return
+	}
+	i := slices.Index(fb.activeFiles, path)
+	if i < 0 {
+		return
+	}
+
+	dest := filepath.Join(fb.archiveDir, filepath.Base(path))
+	if err := moveFile(path, dest); err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to archive %s, leaving it in place: %v\n", path, err)
+		return
+	}
+	fb.activeFiles = slices.Delete(fb.activeFiles, i, i+1)
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Archived file: %s -> %s\n", path, dest)
+	}
+	if fb.dualOutput {
+		companion := fb.companionPath(path)
+		if err := moveFile(companion, fb.companionPath(dest)); err != nil {
+			fmt.Fprintf(logOutput, "Warning: failed to archive uncompressed file %s: %v\n", companion, err)
+		}
+	}
+	if fb.writeIndex {
+		index := fb.indexPath(path)
+		if err := moveFile(index, fb.indexPath(dest)); err != nil {
+			fmt.Fprintf(logOutput, "Warning: failed to archive index %s: %v\n", index, err)
+		}
+	}
+	fb.removeEmptySubdirs(path)
+	fb.removeMirror(path)
+	fb.saveState()
+}
+
+// moveFile renames src to dst, falling back to a copy and delete when they're
+// on different filesystems. The copy is written under a temporary name and
+// renamed into place, so dst never appears partly written.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// TestArchiveDir checks each file is moved to --archive_dir when it's
+// closed, leaving only the current file to count against --num_files
+func TestArchiveDir(t *testing.T) {
+	archiveDir := t.TempDir()
+	fb := newTestFileBuffer(t, WithArchiveDir(archiveDir), WithMaxNumFiles(2))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	var closed []string
+	for i := range 3 {
+		fb.write([]byte(fmt.Sprintf("file %d\n", i)))
+		path, err := fb.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed = append(closed, path)
+		if !slices.Equal(fb.activeFiles, []string{fb.currentFileName}) {
+			t.Errorf("activeFiles = %q, want just the current file", fb.activeFiles)
+		}
+	}
+	fb.close()
+
+	for i, path := range closed {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left in the output directory: %v", path, err)
+		}
+		archived := filepath.Join(archiveDir, filepath.Base(path))
+		if got := readGzipFiles(t, []string{archived}); string(got[0]) != fmt.Sprintf("file %d\n", i) {
+			t.Errorf("%s holds %q", archived, got[0])
+		}
+	}
+	if n := fb.counters.filesDeleted.Load(); n != 0 {
+		t.Errorf("%d files deleted, want none", n)
+	}
+}
+
+// A file that can't be archived stays in the rotation
+func TestArchiveDirFailure(t *testing.T) {
+	discardLog(t)
+	archiveDir := t.TempDir()
+	fb := newTestFileBuffer(t, WithArchiveDir(archiveDir))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	path, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.close()
+	if !slices.Contains(fb.activeFiles, path) {
+		t.Errorf("activeFiles = %q, want %s still in it", fb.activeFiles, path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("%s wasn't left in place: %v", path, err)
+	}
+}
+
+// TestMoveFileAcrossFilesystems moves a file to /dev/shm, where a rename
+// fails with EXDEV, so it's copied and deleted instead
+func TestMoveFileAcrossFilesystems(t *testing.T) {
+	shm, err := os.MkdirTemp("/dev/shm", "gzfb")
+	if err != nil {
+		t.Skipf("no /dev/shm: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(shm) })
+	src := filepath.Join(t.TempDir(), "src.gz")
+	if err := os.WriteFile(src, []byte("compressed data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(src, filepath.Join(shm, "probe")); !errors.Is(err, syscall.EXDEV) {
+		t.Skipf("/dev/shm is on the same filesystem as %s", src)
+	}
+
+	dst := filepath.Join(shm, "dst.gz")
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || string(got) != "compressed data" {
+		t.Errorf("%s holds %q, %v", dst, got, err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't removed: %v", src, err)
+	}
+	if temps, _ := filepath.Glob(dst + ".tmp*"); len(temps) != 0 {
+		t.Errorf("temporary files left behind: %q", temps)
+	}
+}
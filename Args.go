@@ -2,30 +2,151 @@
 // Use of this source code is governed by an MIT license that can be
 // found in the LICENSE file.
 
-package main
+package gzipfilebuffer
 
 import (
 	"compress/gzip"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
-func processArgs() *FileBuffer {
+// errExitEarly is returned by processArgs when it has done all there is to
+// do (e.g. --list_presets) and the program should exit successfully
+var errExitEarly = errors.New("exit early")
+
+// errBadFlag is returned by processArgs when the command line couldn't be
+// parsed, which the flag package has already reported along with the usage
+var errBadFlag = errors.New("bad flag")
+
+// stringsFlag collects each use of a flag that can be given more than once
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// processArgs parses and validates the command line (plus config file and
+// environment). All validation problems are collected and returned together
+// as one error, one per line. Returns flag.ErrHelp if usage was requested.
+func processArgs() (*FileBuffer, error) {
 	fileSizeKB := flag.Int64("file_size", 0, "Maximum size per file in kilobytes (required)")
+	rotateOnUncompressed := flag.Bool("rotate_on_uncompressed", false, "Apply --file_size to the bytes written to each file before compression, not its compressed size")
 	numFiles := flag.Int("num_files", 0, "Maximum number of files to keep (required)")
+	maxFileAge := flag.Duration("max_file_age", 0, "Also delete files older than this, e.g. 24h (optional)")
+	maxTotalBytes := flag.Int64("max_total_bytes", 0, "Also delete the oldest files while all files together exceed this many bytes (optional)")
+	protectRecentSeconds := flag.Float64("protect_recent_seconds", 0, "Don't delete files for --num_files if modified less than this many seconds ago, e.g. while still uploading (optional)")
+	preDeleteHook := flag.String("pre_delete_hook", "", "Command to run before deleting a file, {} is replaced by the file path (optional)")
+	preDeleteHookTimeout := flag.Duration("pre_delete_hook_timeout", 30*time.Second, "Time limit for --pre_delete_hook")
+	preDeleteHookStrict := flag.Bool("pre_delete_hook_strict", false, "Keep the file instead of deleting it if --pre_delete_hook fails")
 	filePrefix := flag.String("file_prefix", "", "Prefix for output files (required)")
-	timeFormat := flag.String("time_format", "2006-01-02T15:04:05.000Z", "Time format for filenames (Go time layout)")
+	outputExtension := flag.String("output_extension", ".gz", "Extension appended to each filename, empty for none")
+	filenameSep := flag.String("filename_sep", "_", "Separator between the prefix, counter and timestamp in filenames, may be empty")
+	filenameTemplate := flag.String("filename_template", "", "Go text/template for filenames, replacing the default format (optional, see Filename Template below)")
+	counterStart := flag.Int("counter_start", 0, "Counter for the first file, unless resuming (default: 0)")
+	subdirFormat := flag.String("subdir_format", "", "Go time layout for subdirectories to put files in, e.g. 2006/01/02 (optional)")
+	timeFormat := flag.String("time_format", defaultTimeFormat, "Time format for filenames (Go time layout, or epoch, epoch_ms or epoch_ns)")
 	useLocalTime := flag.Bool("local_time", false, "Use local time instead of UTC for timestamps")
 	headerBytes := flag.Int("header_bytes", 0, "Number of bytes from start of stream to copy as header for each file (default: 0)")
+	injectBlockFile := flag.String("inject_block_file", "", "Write the contents of this file to each file after the header, before any stream data, e.g. a pcapng interface description block. It's read once at startup (optional)")
+	trailerFile := flag.String("trailer_file", "", "Append the contents of this file to each file before it's closed, e.g. an end of records marker. It's read at each close (optional)")
+	trailerHex := flag.String("trailer_hex", "", "Append these bytes, as hex (e.g. 0xdeadbeef), to each file before it's closed (optional)")
+	trailerBytes := flag.Int("trailer_bytes", 0, "Length of the trailer, repeating --trailer_hex to fill it, or zero bytes without --trailer_hex (default: the length of --trailer_hex)")
+	sectionHeaderBytes := flag.Int("section_header_bytes", 0, "Number of bytes after the header at the start of the stream that make up a section header, written after the header in each later file so it can be read on its own. The first file isn't rotated part way through it (default: 0)")
 	blockHeader := flag.String("block_header", "", "Block header format for boundary detection (e.g., <u32:sec><u32:usec><u32:length><u32>)")
-	maxBlockSize := flag.Int("max_block_size", 262144, "Maximum block size in bytes when scanning for boundaries (default: 262144 / 256KB)")
-	readBufferSize := flag.Int("read_buffer_size", 262144, "Read buffer size in bytes (default: 262144 / 256KB)")
+	timestampHz := flag.Uint64("timestamp_hz", 1, "Ticks per second of block header sec fields, e.g. 1000 for milliseconds since the epoch (default: 1, seconds)")
+	var blockHeaderAlts stringsFlag
+	flag.Var(&blockHeaderAlts, "block_header_alt", "Alternate block header format, tried when --block_header doesn't match at an offset. Repeat for more, tried in order (optional)")
+	baseBlockHeader := flag.String("base_block_header", "", "Common block header fields that --block_header or --block_format_preset fields follow, e.g. <u32:sec><u32:usec> (optional)")
+	blockTrailerFormat := flag.String("block_trailer_format", "", "Format of fields after each block's data, e.g. <u32:fcs>, needs a length field in the block header (optional)")
+	blockValidatorPlugin := flag.String("block_validator_plugin", "", "Go plugin (.so) exporting ValidateBlock(data *byte, len int) int to find block boundaries, for formats --block_header can't express (optional)")
+	blockFormatFile := flag.String("block_format_file", "", "File of named block header formats (name = \"format\" lines) for --block_format_preset (optional)")
+	blockFormatPreset := flag.String("block_format_preset", "", "Use a named block header format instead of --block_header (see --list_presets)")
+	listPresetsFlag := flag.Bool("list_presets", false, "List the available block header format presets and exit")
+	autoDetectPcap := flag.Bool("auto_detect_pcap", false, "Detect a pcap or pcapng stream from its magic number and set the header bytes and block header format automatically")
+	requireCompleteBlock := flag.Bool("require_complete_block", true, "Only split on a block header if the whole block (per its length field) is in the read buffer")
+	splitOnNewline := flag.Bool("split_on_newline", false, "Rotate files after the last newline in the read buffer, so text and JSON Lines records aren't split (instead of a block header)")
+	minLineLength := flag.Int("min_line_length", 0, "With --split_on_newline, don't split after a line shorter than this many bytes (optional)")
+	recordSize := flag.Int("record_size", 0, "Input is fixed-size records of this many bytes (after the header), only rotate files between records (optional)")
+	recordDelimiter := flag.String("record_delimiter", "", "Rotate files after the last occurrence of this delimiter in the read buffer, as hex (e.g. 0d0a) or literal text, up to 8 bytes (optional)")
+	nullDelimiter := flag.Bool("null_delimiter", false, "Shorthand for --record_delimiter 00")
+	blockValidationStrict := flag.Bool("block_validation_strict", false, "Exit if more than --strict_miss_threshold read buffers in a row have no valid block header to rotate at, rather than writing misaligned files")
+	strictMissThreshold := flag.Int("strict_miss_threshold", 3, "With --block_validation_strict, how many read buffers in a row may have no valid block header")
+	debugBlockScan := flag.Bool("debug_block_scan", false, "Log up to 3 block header candidates per read buffer that partly matched, with the bytes around them")
+	maxBlockSize := flag.Int("max_block_size", defaultBufferSize, "Maximum block size in bytes when scanning for boundaries (default: 262144 / 256KB)")
+	readBufferSize := flag.Int("read_buffer_size", defaultBufferSize, "Read buffer size in bytes (default: 262144 / 256KB)")
+	maxInputRate := flag.Float64("max_input_rate_mbps", 0, "Limit uncompressed input processing to this many megabits per second (optional)")
+	maxOutputRate := flag.Float64("max_output_rate_mbps", 0, "Limit compressed output to this many megabits per second (optional)")
+	decompressInput := flag.Bool("decompress_input", false, "Input is gzip, decompress it first (e.g. to recompress at a different --compression_level)")
 	compressionLevel := flag.Int("compression_level", gzip.DefaultCompression, "Gzip compression level: -1 (default), 0 (none), 1 (best speed) to 9 (best compression)")
+	gzipWindowBits := flag.Int("gzip_window_bits", defaultGzipWindowBits, "zlib window_bits, 9-15: the deflate window is 2^n bytes (see Gzip Window below)")
+	gzipMemLevel := flag.Int("gzip_mem_level", defaultGzipMemLevel, "zlib mem_level, 1-9: memory for finding matches (see Gzip Window below)")
+	gzipSyncInterval := flag.Int64("gzip_sync_interval", 0, "Emit a gzip sync point every this many uncompressed bytes, so partial files can be decompressed (optional)")
+	gzipDictionaryFile := flag.String("gzip_dictionary_file", "", "Compress with this file as a preset dictionary, the output then needs it to decompress (optional, see Gzip Dictionary below)")
+	gzipDictionaryEmbed := flag.Bool("gzip_dictionary_embed", false, "With --gzip_dictionary_file, store the dictionary in each gzip header so files are self-describing")
+	embedChecksum := flag.Bool("embed_checksum", false, "Set each file's gzip header comment to the SHA-256 of its uncompressed contents, e.g. sha256=9f86..., filled in when it's closed")
+	gzipMultistream := flag.Bool("gzip_multistream", false, "Write each read buffer as a separate gzip member, so any member can be decompressed on its own (see Gzip Multistream below)")
+	crc16Poly := flag.String("crc16_poly", "ccitt", "CRC16 variant for crc16 block header fields: 'ccitt' (0x1021) or 'ibm' (0x8005)")
 	endianness := flag.String("endianness", "little", "Byte order for multi-byte fields: 'little' or 'big' (default: little)")
+	preallocateBytes := flag.Int64("preallocate_bytes", 0, "Reserve this much disk space for each new file to reduce fragmentation, released on close (Linux only, optional)")
 	resumeExisting := flag.Bool("resume_existing", false, "Resume with existing files (WARNING: may delete matching files if count exceeds num_files)")
-	quiet := flag.Bool("quiet", false, "Suppress non-error output")
+	verifyOutput := flag.Bool("verify_output", false, "Decompress each file in the background once it's closed, renaming it to .corrupt if that fails")
+	verifyOnResume := flag.Bool("verify_on_resume", false, "With --resume_existing, check existing files decompress and rename corrupt ones to .corrupt")
+	repairLastFile := flag.Bool("repair_last_file", false, "With --verify_on_resume, rewrite a corrupt last file with its recoverable data instead of renaming it")
+	stateFile := flag.String("state_file", "", "JSON file to save the file counter and active files to, used by --resume_existing (optional)")
+	inputTCP := flag.String("input_tcp", "", "Listen on this TCP address (e.g. :5000) and read input from a connection instead of stdin (optional)")
+	inputTCPMulti := flag.Bool("input_tcp_multi", false, "With --input_tcp, accept another connection when one closes instead of stopping")
+	inputTLSCert := flag.String("input_tls_cert", "", "With --input_tcp, accept TLS connections using this PEM certificate file")
+	inputTLSKey := flag.String("input_tls_key", "", "PEM private key file for --input_tls_cert")
+	inputUnix := flag.String("input_unix", "", "Listen on a Unix domain socket at this path and read input from a connection instead of stdin (optional)")
+	inputUnixMulti := flag.Bool("input_unix_multi", false, "With --input_unix, accept another connection when one closes instead of stopping")
+	inputUnixPerms := flag.String("input_unix_perms", "", "With --input_unix, octal permissions for the socket file, e.g. 0660 (default: from umask)")
+	goroutineRestart := flag.Bool("goroutine_restart", false, "After a panic reading or processing input, log it and carry on (the processor in a new file) rather than shutting down")
+	shutdownTimeout := flag.Duration("shutdown_timeout", 30*time.Second, "After SIGINT or SIGTERM, exit with code 1 if still finishing up after this long, e.g. stuck on a hung disk (0 to wait indefinitely)")
+	readTimeout := flag.Duration("read_timeout", 0, "Act on --read_timeout_action if no input arrives for this long, e.g. 30s (optional)")
+	readTimeoutAction := flag.String("read_timeout_action", "exit", "On --read_timeout: 'exit' (with exit code 3) or 'rotate' (start a new file if anything was written to the current one)")
+	writeErrorPolicy := flag.String("write_error_policy", "exit", "On a failed write: 'exit', 'warn_continue' (drop the data) or 'rotate_on_error' (retry in a new file)")
+	exitOnWriteError := flag.Bool("exit_on_write_error", true, "Exit with code 2 on a failed write (--write_error_policy exit), or with --exit_on_write_error=false log it and carry on (warn_continue)")
+	maxConsecutiveErrors := flag.Int("max_consecutive_errors", 3, "With --write_error_policy rotate_on_error, exit after this many failed writes in a row")
+	writeRetryCount := flag.Int("write_retry_count", 3, "Times to retry a write that fails with a transient error (e.g. disk full) before --write_error_policy applies")
+	writeRetryInterval := flag.Duration("write_retry_interval", 100*time.Millisecond, "Time to wait before each write retry")
+	quiet := flag.Bool("quiet", false, "Suppress informational output (warnings and errors are still printed)")
+	logFile := flag.String("log_file", "", "Append log output to this file instead of stderr, reopening it on SIGHUP (optional)")
+	logSyslog := flag.Bool("log_syslog", false, "Send log output to syslog instead of stderr (not on Windows)")
+	logSyslogFacility := flag.String("log_syslog_facility", "daemon", "Syslog facility for --log_syslog: daemon or local0 to local7")
+	verbose := flag.Bool("verbose", false, "Trace each write, block header scan and file open and close, for debugging header detection")
+	output := flag.String("output", "files", "Output destination: 'files' (rotating files) or 'stdout' (single gzip stream)")
+	outputFifo := flag.String("output_fifo", "", "Named pipe to write to instead of rotating files, each file becoming a gzip member on it (optional)")
+	mirrorDir := flag.String("mirror_dir", "", "Directory to write an identical backup copy of each file to (optional)")
+	dualOutput := flag.Bool("dual_output", false, "Also write an uncompressed copy of each file, named without the --output_extension")
+	dualOutputPerms := flag.String("dual_output_perms", "", "With --dual_output, octal permissions for the uncompressed files, e.g. 0640 (default: from umask)")
+	writeIndex := flag.Bool("write_index", false, "Write an index of each block's offsets alongside each file, for random access (needs a block header format with a length field)")
+	indexFormat := flag.String("index_format", "json", "Format of the --write_index files: 'json' (.idx.json) or 'binary' (.idx)")
+	archiveDir := flag.String("archive_dir", "", "Directory to move each file to once it's closed, taking it out of the rotation (optional)")
+	s3Bucket := flag.String("s3_bucket", "", "S3 bucket to upload each file to once it's closed (optional, see S3 Upload below)")
+	s3KeyPrefix := flag.String("s3_key_prefix", "", "Prefix for the S3 object keys, e.g. captures/ (optional)")
+	s3Region := flag.String("s3_region", "", "AWS region of --s3_bucket (default: from AWS_REGION, else us-east-1)")
+	s3DeleteAfterUpload := flag.Bool("s3_delete_after_upload", false, "Delete each file locally once it's uploaded to --s3_bucket")
+	s3RetryCount := flag.Int("s3_retry_count", 3, "Times to retry a failed S3 upload")
+	webhookURL := flag.String("webhook_url", "", "URL to POST a JSON notification to each time a file is closed (optional, see Webhook below)")
+	webhookAuthHeader := flag.String("webhook_auth_header", "", "Authorization header for --webhook_url, e.g. 'Bearer TOKEN' (optional)")
+	webhookTimeout := flag.Duration("webhook_timeout", 10*time.Second, "Time limit for each --webhook_url request")
+	controlAddr := flag.String("control_addr", "", "Serve runtime controls on this address, e.g. localhost:9091 (optional, see Control Endpoint below)")
+	metricsAddr := flag.String("metrics_addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. :9090 (optional)")
+	statsdAddr := flag.String("statsd_addr", "", "Send StatsD metrics over UDP to this host:port each time a file is closed, e.g. 127.0.0.1:8125 (optional)")
+	statsdPrefix := flag.String("statsd_prefix", "gzfb", "Prefix for --statsd_addr metric names")
+	flag.String("config", "", "Config file of key = value lines, keys are option names (optional)")
+	outputDirs := flag.String("output_dirs", "", "Comma-separated list of directories to place successive files in, round-robin (optional)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "GzipFileBuffer - Stream stdin to rotating gzip-compressed files\n\n")
@@ -37,88 +158,302 @@ func processArgs() *FileBuffer {
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nFilename Format:\n")
 		fmt.Fprintf(os.Stderr, "  prefix_NNNNNN_TIMESTAMP[.ext].gz\n")
-		fmt.Fprintf(os.Stderr, "  where NNNNNN is a zero-padded counter\n\n")
+		fmt.Fprintf(os.Stderr, "  where NNNNNN is a zero-padded counter, .ext is kept from the prefix,\n")
+		fmt.Fprintf(os.Stderr, "  _ can be changed with --filename_sep and .gz can be changed (or dropped)\n")
+		fmt.Fprintf(os.Stderr, "  with --output_extension. Existing files are never overwritten, if the name\n")
+		fmt.Fprintf(os.Stderr, "  is taken _1, _2 and so on is added to the timestamp.\n\n")
+		fmt.Fprintf(os.Stderr, "Subdirectories:\n")
+		fmt.Fprintf(os.Stderr, "  --subdir_format puts each file in a subdirectory of the output directory,\n")
+		fmt.Fprintf(os.Stderr, "  named by formatting the file's creation time (UTC unless --local_time).\n")
+		fmt.Fprintf(os.Stderr, "  e.g. 2006/01/02 gives one directory per day. Directories are created as\n")
+		fmt.Fprintf(os.Stderr, "  needed. --num_files and the other limits apply across all of them, and\n")
+		fmt.Fprintf(os.Stderr, "  --resume_existing looks for files at the matching depth.\n\n")
+		fmt.Fprintf(os.Stderr, "Filename Template:\n")
+		fmt.Fprintf(os.Stderr, "  --filename_template replaces the format above with a Go text/template.\n")
+		fmt.Fprintf(os.Stderr, "  It's the whole filename, --filename_sep and --output_extension aren't added.\n")
+		fmt.Fprintf(os.Stderr, "    {{.Prefix}}     --file_prefix without its directory or extension\n")
+		fmt.Fprintf(os.Stderr, "    {{.Counter}}    zero-padded file counter, e.g. 000042\n")
+		fmt.Fprintf(os.Stderr, "    {{.Timestamp}}  time formatted with --time_format\n")
+		fmt.Fprintf(os.Stderr, "    {{.Ext}}        extension of --file_prefix, e.g. .pcap\n")
+		fmt.Fprintf(os.Stderr, "    {{.Dir}}        directory of --file_prefix\n")
+		fmt.Fprintf(os.Stderr, "    {{.Hostname}}   this host's name\n")
+		fmt.Fprintf(os.Stderr, "  A result without a directory goes in the directory of --file_prefix. If it\n")
+		fmt.Fprintf(os.Stderr, "  fails to expand, the default format is used. --resume_existing needs\n")
+		fmt.Fprintf(os.Stderr, "  {{.Counter}} exactly once, in the file name rather than a directory.\n")
+		fmt.Fprintf(os.Stderr, "  e.g. --filename_template '{{.Hostname}}-{{.Prefix}}-{{.Counter}}{{.Ext}}.gz'\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  cat data.bin | %s --file_size 10240 --num_files 5 --file_prefix output\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  cat logs.txt | %s --file_size 51200 --num_files 10 --file_prefix logs.txt\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  tail -F events.jsonl | %s --file_size 51200 --num_files 10 --file_prefix events.jsonl --split_on_newline\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  cat stream | %s --file_size 1024 --num_files 3 --file_prefix data --time_format 20060102-150405\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  cat video.mp4 | %s --file_size 102400 --num_files 5 --file_prefix video.mp4 --header_bytes 1024 --compression_level 1\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  tcpdump -w - | %s --file_size 102400 --num_files 10 --file_prefix capture.pcap --block_header '<u32:sec><u32:usec><u32:length><u32>'\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  tcpdump -w - | %s --file_size 102400 --num_files 10 --file_prefix capture.pcap --block_header '<u32:sec><u32:usec><u32:length><u32>'\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  tcpdump -w - | %s --file_size 102400 --num_files 10 --file_prefix capture.pcap --auto_detect_pcap\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --input_tcp :5000 --input_tcp_multi --file_size 102400 --num_files 10 --file_prefix feed\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  tcpdump -w - | %s --output stdout --header_bytes 24 --block_header '<u32:sec><u32:usec><u32:length><u32>' | nc host 9000\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Output:\n")
+		fmt.Fprintf(os.Stderr, "  files  - Write rotating files (default). Requires --file_size, --num_files and --file_prefix.\n")
+		fmt.Fprintf(os.Stderr, "  stdout - Write a single gzip stream to stdout. File size, count and naming options\n")
+		fmt.Fprintf(os.Stderr, "           are ignored. Send SIGUSR2 to flush the gzip stream to a sync point.\n")
+		fmt.Fprintf(os.Stderr, "  --output_fifo PATH writes to an existing named pipe (mkfifo) instead of files,\n")
+		fmt.Fprintf(os.Stderr, "  e.g. for a live reader. Each --file_size worth is a gzip member (with the\n")
+		fmt.Fprintf(os.Stderr, "  --header_bytes) on the same pipe. Opening it waits for a reader. If the\n")
+		fmt.Fprintf(os.Stderr, "  reader goes away, the rest of that member is dropped and the next one waits\n")
+		fmt.Fprintf(os.Stderr, "  for a new reader. --num_files and --file_prefix aren't needed.\n\n")
+		fmt.Fprintf(os.Stderr, "Write Errors:\n")
+		fmt.Fprintf(os.Stderr, "  --write_error_policy sets what happens when writing to the output fails:\n")
+		fmt.Fprintf(os.Stderr, "    exit            - Exit with an error (default).\n")
+		fmt.Fprintf(os.Stderr, "    warn_continue   - Log it and drop the data. The gzip stream of\n")
+		fmt.Fprintf(os.Stderr, "                      the current file stays broken, so later writes to it\n")
+		fmt.Fprintf(os.Stderr, "                      fail too.\n")
+		fmt.Fprintf(os.Stderr, "    rotate_on_error - Close the file, open a new one and retry, exiting after\n")
+		fmt.Fprintf(os.Stderr, "                      --max_consecutive_errors failures in a row.\n")
+		fmt.Fprintf(os.Stderr, "  --exit_on_write_error is the same as exit, and --exit_on_write_error=false\n")
+		fmt.Fprintf(os.Stderr, "  the same as warn_continue.\n")
+		fmt.Fprintf(os.Stderr, "  Failing to open a new file always exits. Writes and file creation that fail\n")
+		fmt.Fprintf(os.Stderr, "  with a transient error (e.g. disk full, EAGAIN) are first retried\n")
+		fmt.Fprintf(os.Stderr, "  --write_retry_count times, --write_retry_interval apart.\n\n")
+		fmt.Fprintf(os.Stderr, "Pre-Delete Hook:\n")
+		fmt.Fprintf(os.Stderr, "  --pre_delete_hook runs a command before each file is deleted, e.g. to archive\n")
+		fmt.Fprintf(os.Stderr, "  it. Every {} in the command is replaced by the file path. The command is run\n")
+		fmt.Fprintf(os.Stderr, "  directly, not through a shell. Deletion waits for it to finish.\n")
+		fmt.Fprintf(os.Stderr, "    --pre_delete_hook 'cp {} /archive/'\n\n")
+		fmt.Fprintf(os.Stderr, "Archive Directory:\n")
+		fmt.Fprintf(os.Stderr, "  --archive_dir moves each file there once it's closed (and any rotate\n")
+		fmt.Fprintf(os.Stderr, "  callbacks have run), e.g. for a downstream job to pick up. Archived files\n")
+		fmt.Fprintf(os.Stderr, "  no longer count towards --num_files and the other limits, and are never\n")
+		fmt.Fprintf(os.Stderr, "  deleted. Across filesystems the file is copied then deleted.\n\n")
+		fmt.Fprintf(os.Stderr, "S3 Upload:\n")
+		fmt.Fprintf(os.Stderr, "  --s3_bucket uploads each file in the background once it's closed, as\n")
+		fmt.Fprintf(os.Stderr, "  --s3_key_prefix followed by the file's base name. Credentials are read from\n")
+		fmt.Fprintf(os.Stderr, "  AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and (optionally) AWS_SESSION_TOKEN.\n")
+		fmt.Fprintf(os.Stderr, "  AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL points it at another S3 compatible\n")
+		fmt.Fprintf(os.Stderr, "  service, e.g. MinIO. Failed uploads are logged and retried, and shutdown\n")
+		fmt.Fprintf(os.Stderr, "  waits for uploads in progress. Files deleted by --num_files or the other\n")
+		fmt.Fprintf(os.Stderr, "  limits before their upload finishes are still uploaded.\n\n")
+		fmt.Fprintf(os.Stderr, "Webhook:\n")
+		fmt.Fprintf(os.Stderr, "  --webhook_url is sent a POST in the background each time a file is closed,\n")
+		fmt.Fprintf(os.Stderr, "  with a JSON body like:\n")
+		fmt.Fprintf(os.Stderr, "    {\"event\": \"file_closed\", \"file\": \"capture_000042_....gz\", \"size_bytes\": 1048576,\n")
+		fmt.Fprintf(os.Stderr, "     \"opened_at\": \"...\", \"closed_at\": \"...\", \"duration_seconds\": 61.2,\n")
+		fmt.Fprintf(os.Stderr, "     \"bytes_uncompressed\": 4194304, \"block_count\": 1}\n")
+		fmt.Fprintf(os.Stderr, "  block_count is the block boundaries found while the file was open, which are\n")
+		fmt.Fprintf(os.Stderr, "  only searched for where the output is split. 5xx responses and connection\n")
+		fmt.Fprintf(os.Stderr, "  failures are retried %d times. file is where the file was written, before\n", webhookRetries)
+		fmt.Fprintf(os.Stderr, "  any move to --archive_dir.\n\n")
+		fmt.Fprintf(os.Stderr, "Config File:\n")
+		fmt.Fprintf(os.Stderr, "  --config loads options from a TOML/INI style file with one key = value per\n")
+		fmt.Fprintf(os.Stderr, "  line. Every option above except config is a valid key, named without the\n")
+		fmt.Fprintf(os.Stderr, "  leading dashes. Lines starting with # or ; and [section] headers are ignored.\n")
+		fmt.Fprintf(os.Stderr, "  Options given on the command line override the config file. For example:\n")
+		fmt.Fprintf(os.Stderr, "    file_size = 102400\n")
+		fmt.Fprintf(os.Stderr, "    file_prefix = \"capture.pcap\"\n")
+		fmt.Fprintf(os.Stderr, "    block_header = \"<u32:sec><u32:usec><u32:length><u32>\"\n\n")
+		fmt.Fprintf(os.Stderr, "Environment Variables:\n")
+		fmt.Fprintf(os.Stderr, "  Every option can also be set with a %s environment variable named after\n", envPrefix)
+		fmt.Fprintf(os.Stderr, "  the upper-cased option, e.g. %s, %s, %s.\n", envVarName("file_size"), envVarName("num_files"), envVarName("compression_level"))
+		fmt.Fprintf(os.Stderr, "  Precedence: command line > environment > config file > defaults.\n\n")
+		fmt.Fprintf(os.Stderr, "Output Directories:\n")
+		fmt.Fprintf(os.Stderr, "  --output_dirs spreads files across several directories (e.g., one per disk).\n")
+		fmt.Fprintf(os.Stderr, "  Each new file goes to the next directory in the list, using the base name of\n")
+		fmt.Fprintf(os.Stderr, "  --file_prefix. The file count limit applies across all directories.\n\n")
 		fmt.Fprintf(os.Stderr, "Time Format:\n")
 		fmt.Fprintf(os.Stderr, "  Uses Go time layout format. Default is ISO 8601: 2006-01-02T15:04:05.000Z\n")
 		fmt.Fprintf(os.Stderr, "  Common formats:\n")
 		fmt.Fprintf(os.Stderr, "    ISO 8601:     2006-01-02T15:04:05.000Z\n")
-		fmt.Fprintf(os.Stderr, "    Simple:       20060102-150405\n\n")
+		fmt.Fprintf(os.Stderr, "    Simple:       20060102-150405\n")
+		fmt.Fprintf(os.Stderr, "  Or one of these for Unix time (--local_time makes no difference):\n")
+		fmt.Fprintf(os.Stderr, "    epoch:        seconds, e.g. 1760659200\n")
+		fmt.Fprintf(os.Stderr, "    epoch_ms:     milliseconds\n")
+		fmt.Fprintf(os.Stderr, "    epoch_ns:     nanoseconds\n\n")
 		fmt.Fprintf(os.Stderr, "Header Bytes:\n")
 		fmt.Fprintf(os.Stderr, "  Captures the first N bytes of the input stream and prepends them to each\n")
 		fmt.Fprintf(os.Stderr, "  subsequent file (after the first). Useful for formats that require headers\n")
 		fmt.Fprintf(os.Stderr, "  (e.g., video containers, serialization formats). Set to 0 to disable.\n\n")
 		fmt.Fprintf(os.Stderr, "Block Header Format:\n")
 		fmt.Fprintf(os.Stderr, "  Specifies block/packet boundary detection to avoid splitting mid-block.\n")
-		fmt.Fprintf(os.Stderr, "  Format: <uN:type> or <sN:type> where N is bit width (8, 16, 32, 64)\n")
+		fmt.Fprintf(os.Stderr, "  Format: <uN:type> or <sN:type> where N is bit width (8, 16, 24, 32, 48, 64)\n")
 		fmt.Fprintf(os.Stderr, "  Use 'u' for unsigned, 's' for signed. Types:\n")
-		fmt.Fprintf(os.Stderr, "    sec     - Unix timestamp seconds (validated within ±48 hours)\n")
+		fmt.Fprintf(os.Stderr, "    sec     - Unix timestamp seconds (validated within ±48 hours), or ticks of a\n")
+		fmt.Fprintf(os.Stderr, "              --timestamp_hz clock since the epoch, e.g. <u48:sec> at 1000 Hz\n")
 		fmt.Fprintf(os.Stderr, "    usec    - Microseconds (0-999999)\n")
 		fmt.Fprintf(os.Stderr, "    nsec    - Nanoseconds (0-999999999)\n")
-		fmt.Fprintf(os.Stderr, "    length  - Block data length in bytes (0-%d, configurable with --max_block_size)\n", 262144)
+		fmt.Fprintf(os.Stderr, "    ntp     - NTP timestamp, 32.32 fixed point seconds since 1900, 64-bit only\n")
+		fmt.Fprintf(os.Stderr, "              (validated within ±48 hours like sec)\n")
+		fmt.Fprintf(os.Stderr, "    nsec_epoch - Nanoseconds since the Unix epoch, u64 or s64 only (validated\n")
+		fmt.Fprintf(os.Stderr, "              within ±48 hours like sec)\n")
+		fmt.Fprintf(os.Stderr, "    length  - Block data length in bytes (0-%d, configurable with --max_block_size)\n", defaultBufferSize)
 		fmt.Fprintf(os.Stderr, "    0xHEX   - Magic number (exact match required)\n")
+		fmt.Fprintf(os.Stderr, "    0xMASK&0xHEX - Masked magic number, matches if (value & MASK) == HEX\n")
+		fmt.Fprintf(os.Stderr, "    DECIMAL - Magic number in decimal, e.g. <u8:7> (exact match required)\n")
+		fmt.Fprintf(os.Stderr, "    MIN-MAX - Any value in the inclusive decimal range, e.g. <u16:100-1000>\n")
+		fmt.Fprintf(os.Stderr, "    float:MIN:MAX - IEEE 754 float32 in the inclusive range (not NaN or\n")
+		fmt.Fprintf(os.Stderr, "              infinite), u32 only, e.g. <u32:float:-40.0:85.0>\n")
+		fmt.Fprintf(os.Stderr, "    NAME?MASK:skipN - Any value, but if (value & MASK) == MASK skip N more header\n")
+		fmt.Fprintf(os.Stderr, "              bytes before the next field, e.g. <u8:ext_hdr?0x80:skip16>\n")
+		fmt.Fprintf(os.Stderr, "    crc16   - CRC16 of all the header bytes before it, u16 only. --crc16_poly\n")
+		fmt.Fprintf(os.Stderr, "              picks ccitt (0x1021, init 0xFFFF) or ibm (0x8005, reflected)\n")
 		fmt.Fprintf(os.Stderr, "    (none)  - Any value (ignored)\n")
+		fmt.Fprintf(os.Stderr, "  <strN:TEXT> is an N byte string magic, e.g. <str4:SHB\\x00>, use \\xNN for\n")
+		fmt.Fprintf(os.Stderr, "  non-ASCII bytes and \\\\ for a backslash.\n")
 		fmt.Fprintf(os.Stderr, "  Example for pcap: <u32:sec><u32:usec><u32:length><u32>\n")
 		fmt.Fprintf(os.Stderr, "  Example with 8-bit: <u8:0xAA><u8:0xBB><u16:length><u32>\n")
+		fmt.Fprintf(os.Stderr, "  Fields can be separated by whitespace and /* comments */, e.g.\n")
+		fmt.Fprintf(os.Stderr, "  '/* sec */ <u32:sec> /* usec */ <u32:usec> /* incl_len */ <u32:length> /* orig_len */ <u32>'\n")
 		fmt.Fprintf(os.Stderr, "  Endianness controlled by --endianness flag (default: little).\n")
-		fmt.Fprintf(os.Stderr, "  Note: Endianness does not apply to 8-bit fields.\n\n")
+		fmt.Fprintf(os.Stderr, "  A BE: or LE: prefix overrides it for one field, e.g. <LE:u32:sec><BE:u16:length>.\n")
+		fmt.Fprintf(os.Stderr, "  Note: Endianness does not apply to 8-bit fields.\n")
+		fmt.Fprintf(os.Stderr, "  Named formats can be used with --block_format_preset, e.g. pcap or pcap_ns.\n")
+		fmt.Fprintf(os.Stderr, "  pcapng matches pcapng enhanced or simple packet blocks, and pcapng_spb only\n")
+		fmt.Fprintf(os.Stderr, "  simple ones, with the block total length as the length. The section header and\n")
+		fmt.Fprintf(os.Stderr, "  interface description blocks are --header_bytes 48 if they have no options,\n")
+		fmt.Fprintf(os.Stderr, "  --auto_detect_pcap measures them (and picks the byte order) instead.\n")
+		fmt.Fprintf(os.Stderr, "  More can be defined in a --block_format_file, one per line: name = \"format\".\n")
+		fmt.Fprintf(os.Stderr, "  A prefix shared by several formats can be given once with --base_block_header,\n")
+		fmt.Fprintf(os.Stderr, "  e.g. --base_block_header \"<u32:sec><u32:usec>\" --block_header \"<u16:length><u16:0xABCD>\"\n")
+		fmt.Fprintf(os.Stderr, "  is the same as --block_header \"<u32:sec><u32:usec><u16:length><u16:0xABCD>\".\n\n")
+		fmt.Fprintf(os.Stderr, "Block Trailer Format:\n")
+		fmt.Fprintf(os.Stderr, "  --block_trailer_format uses the same syntax for fields straight after each\n")
+		fmt.Fprintf(os.Stderr, "  block's data (found from the header's length field, which doesn't count\n")
+		fmt.Fprintf(os.Stderr, "  the trailer). It can't have length, crc16 or conditional fields, but can end\n")
+		fmt.Fprintf(os.Stderr, "  with a trailer-only type:\n")
+		fmt.Fprintf(os.Stderr, "    fcs     - Ethernet CRC32 (0x04C11DB7, reflected) of the whole block from\n")
+		fmt.Fprintf(os.Stderr, "              the start of its header, u32 only. An Ethernet FCS is sent\n")
+		fmt.Fprintf(os.Stderr, "              least significant byte first, so it needs --endianness little\n")
+		fmt.Fprintf(os.Stderr, "  Example: --block_header \"<u16:0xAA55><u16:length>\" --block_trailer_format \"<u32:fcs>\"\n\n")
+		fmt.Fprintf(os.Stderr, "Block Validator Plugin:\n")
+		fmt.Fprintf(os.Stderr, "  For formats a block header can't describe, --block_validator_plugin loads a\n")
+		fmt.Fprintf(os.Stderr, "  Go plugin (go build -buildmode=plugin, same Go version) exporting:\n")
+		fmt.Fprintf(os.Stderr, "    func ValidateBlock(data *byte, len int) int\n")
+		fmt.Fprintf(os.Stderr, "  It's called at each candidate offset with a pointer to it and the bytes left\n")
+		fmt.Fprintf(os.Stderr, "  in the read buffer, a C-compatible signature, and returns the block's length\n")
+		fmt.Fprintf(os.Stderr, "  if a valid block starts at data[0], 0 if not, or -1 if the block runs past\n")
+		fmt.Fprintf(os.Stderr, "  data[len-1] (not a boundary). It mustn't keep data. See\n")
+		fmt.Fprintf(os.Stderr, "  examples/block_validator_plugin for one in C via cgo.\n\n")
+		fmt.Fprintf(os.Stderr, "Block Index:\n")
+		fmt.Fprintf(os.Stderr, "  --write_index walks the blocks by their length field and writes an entry per\n")
+		fmt.Fprintf(os.Stderr, "  block to FILE.idx.json (a JSON array) or, with --index_format binary, FILE.idx\n")
+		fmt.Fprintf(os.Stderr, "  (\"GZBIDX1\\n\" then 5 little-endian int64s per block). Each entry has:\n")
+		fmt.Fprintf(os.Stderr, "    block                      - Block number in the file, from 0\n")
+		fmt.Fprintf(os.Stderr, "    compressed_offset          - Start of the gzip member holding the block\n")
+		fmt.Fprintf(os.Stderr, "    member_uncompressed_offset - Uncompressed offset of that member's start\n")
+		fmt.Fprintf(os.Stderr, "    uncompressed_offset        - Uncompressed offset of the block\n")
+		fmt.Fprintf(os.Stderr, "    timestamp                  - From the first sec field, if any (binary: -1 if not)\n")
+		fmt.Fprintf(os.Stderr, "  gzip can only be decompressed from the start of a member, so seek to\n")
+		fmt.Fprintf(os.Stderr, "  compressed_offset, decompress and skip to the block. The whole file is one\n")
+		fmt.Fprintf(os.Stderr, "  member unless --gzip_multistream makes each read buffer its own.\n\n")
 		fmt.Fprintf(os.Stderr, "Compression Level:\n")
 		fmt.Fprintf(os.Stderr, "  -1: Default compression (balanced)\n")
 		fmt.Fprintf(os.Stderr, "   0: No compression (fastest, largest files)\n")
 		fmt.Fprintf(os.Stderr, "   1: Best speed (fast, larger files)\n")
 		fmt.Fprintf(os.Stderr, "   9: Best compression (slow, smallest files)\n\n")
+		fmt.Fprintf(os.Stderr, "Gzip Sync Interval:\n")
+		fmt.Fprintf(os.Stderr, "  A sync point flushes the compressor and byte-aligns the stream, so a\n")
+		fmt.Fprintf(os.Stderr, "  decoder reading a file that is still being written can decompress\n")
+		fmt.Fprintf(os.Stderr, "  everything up to the last one. Each costs a few bytes and resets the\n")
+		fmt.Fprintf(os.Stderr, "  compressor's lookahead, so small intervals compress worse. Rotating\n")
+		fmt.Fprintf(os.Stderr, "  files are also flushed once per read buffer to check their size.\n\n")
+		fmt.Fprintf(os.Stderr, "Gzip Dictionary:\n")
+		fmt.Fprintf(os.Stderr, "  --gzip_dictionary_file primes the compressor with sample data (e.g. typical\n")
+		fmt.Fprintf(os.Stderr, "  protocol headers), so repetitive input compresses better, especially small\n")
+		fmt.Fprintf(os.Stderr, "  files and --gzip_multistream members. Only the last 32KB is used. gzip has no\n")
+		fmt.Fprintf(os.Stderr, "  way to record a dictionary, so gunzip and zcat can't read the files: a reader\n")
+		fmt.Fprintf(os.Stderr, "  has to skip the gzip header and use the same dictionary, e.g. Go's\n")
+		fmt.Fprintf(os.Stderr, "  flate.NewReaderDict. --gzip_dictionary_embed stores the dictionary in each\n")
+		fmt.Fprintf(os.Stderr, "  header as extra subfield 'DC', so files can be read without the original.\n\n")
+		fmt.Fprintf(os.Stderr, "Gzip Window:\n")
+		fmt.Fprintf(os.Stderr, "  zlib's window_bits and mem_level trade compression for memory: a smaller\n")
+		fmt.Fprintf(os.Stderr, "  window (--gzip_window_bits) uses less memory and compresses worse, and a\n")
+		fmt.Fprintf(os.Stderr, "  lower --gzip_mem_level uses less memory and is slower. Go's compress/flate\n")
+		fmt.Fprintf(os.Stderr, "  only has the defaults (15 and 8), so other values use GzipFileBuffer's own\n")
+		fmt.Fprintf(os.Stderr, "  deflate compressor, which is slower and compresses a little worse. With\n")
+		fmt.Fprintf(os.Stderr, "  --gzip_dictionary_file only as much of the dictionary as fits the window\n")
+		fmt.Fprintf(os.Stderr, "  is used.\n\n")
+		fmt.Fprintf(os.Stderr, "Gzip Multistream:\n")
+		fmt.Fprintf(os.Stderr, "  --gzip_multistream ends the gzip stream after each read buffer and starts\n")
+		fmt.Fprintf(os.Stderr, "  another. The result is still a valid gzip file: gzip, zcat and Go's\n")
+		fmt.Fprintf(os.Stderr, "  compress/gzip read concatenated members as one stream. A reader that finds\n")
+		fmt.Fprintf(os.Stderr, "  the start of a member (1f 8b 08) can decompress from there without the\n")
+		fmt.Fprintf(os.Stderr, "  rest of the file. Each member costs about 18 bytes of header and trailer,\n")
+		fmt.Fprintf(os.Stderr, "  and compression restarts from scratch, so small buffers compress worse.\n\n")
+		fmt.Fprintf(os.Stderr, "Control Endpoint:\n")
+		fmt.Fprintf(os.Stderr, "  --control_addr serves HTTP requests that change settings while running. It\n")
+		fmt.Fprintf(os.Stderr, "  has no authentication, so bind it to localhost or a trusted network.\n")
+		fmt.Fprintf(os.Stderr, "    POST /compression {\"level\": 1}  Compression level (-1 to 9) for the next file\n")
+		fmt.Fprintf(os.Stderr, "    POST /rotate                    Close the current file and open the next now,\n")
+		fmt.Fprintf(os.Stderr, "                                    replying {\"closed\": \"path\"}\n\n")
+		fmt.Fprintf(os.Stderr, "Exit Codes:\n")
+		fmt.Fprintf(os.Stderr, "  %d  Input ended, or a signal asked for a graceful shutdown\n", ExitOK)
+		fmt.Fprintf(os.Stderr, "  %d  Bad arguments or configuration\n", ExitArgsError)
+		fmt.Fprintf(os.Stderr, "  %d  Writing the output failed\n", ExitWriteError)
+		fmt.Fprintf(os.Stderr, "  %d  Opening or reading the input failed, or --read_timeout expired\n", ExitReadError)
+		fmt.Fprintf(os.Stderr, "  %d  A second signal forced an exit during shutdown\n", ExitSignal)
+		fmt.Fprintf(os.Stderr, "  %d  With --block_validation_strict, no block header was found too many times\n", ExitBlockError)
+		fmt.Fprintf(os.Stderr, "  %d  Shutdown took longer than --shutdown_timeout\n\n", ExitTimeout)
 	}
 
-	flag.Parse()
+	var errs []string
 
-	// Check if help is needed (no args or explicit help)
-	if len(os.Args) == 1 {
-		flag.Usage()
-		os.Exit(0)
+	// Apply the config file first so the command line can override it
+	if configFile := findConfigArg(os.Args[1:]); configFile != "" {
+		if err := loadConfigFile(configFile); err != nil {
+			errs = append(errs, fmt.Sprintf("--config: %v", err))
+		}
 	}
 
-	// Validate required arguments
-	if *fileSizeKB <= 0 {
-		fmt.Fprintln(os.Stderr, "Error: --file_size is required and must be positive")
-		flag.Usage()
-		os.Exit(1)
-	}
-	if *numFiles <= 0 {
-		fmt.Fprintln(os.Stderr, "Error: --num_files is required and must be positive")
-		flag.Usage()
-		os.Exit(1)
-	}
-	if *filePrefix == "" {
-		fmt.Fprintln(os.Stderr, "Error: --file_prefix is required")
-		flag.Usage()
-		os.Exit(1)
+	// Then environment variables, which the command line also overrides
+	envApplied, err := applyEnvOverrides()
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("environment: %v", err))
 	}
 
-	// Validate time format
-	if *timeFormat == "" {
-		fmt.Fprintln(os.Stderr, "Error: --time_format cannot be empty")
-		os.Exit(1)
+	// The flag package would exit with 2 on a bad flag, not ExitArgsError
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); errors.Is(err, flag.ErrHelp) {
+		return nil, errExitEarly
+	} else if err != nil {
+		return nil, errBadFlag
 	}
-	if *headerBytes < 0 {
-		fmt.Fprintln(os.Stderr, "Error: --header_bytes cannot be negative")
-		os.Exit(1)
+
+	// Check if help is needed (no args or explicit help)
+	if len(os.Args) == 1 && envApplied == 0 {
+		return nil, flag.ErrHelp
 	}
-	if *maxBlockSize <= 0 {
-		fmt.Fprintln(os.Stderr, "Error: --max_block_size must be positive")
-		os.Exit(1)
+
+	// --exit_on_write_error is a shorthand for --write_error_policy, so it only
+	// counts when given, otherwise rotate_on_error couldn't be chosen
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if given["exit_on_write_error"] {
+		policy := "warn_continue"
+		if *exitOnWriteError {
+			policy = "exit"
+		}
+		if given["write_error_policy"] && *writeErrorPolicy != policy {
+			errs = append(errs, fmt.Sprintf("--exit_on_write_error=%v conflicts with --write_error_policy %s", *exitOnWriteError, *writeErrorPolicy))
+		}
+		*writeErrorPolicy = policy
 	}
-	if *readBufferSize <= 0 {
-		fmt.Fprintln(os.Stderr, "Error: --read_buffer_size must be positive")
-		os.Exit(1)
+
+	// Validate output mode
+	var toStdout bool
+	switch strings.ToLower(*output) {
+	case "files":
+		toStdout = false
+	case "stdout":
+		toStdout = true
+	default:
+		errs = append(errs, fmt.Sprintf("--output must be 'files' or 'stdout', got: %s", *output))
 	}
-	if *compressionLevel < -1 || *compressionLevel > 9 {
-		fmt.Fprintln(os.Stderr, "Error: --compression_level must be between -1 and 9")
-		os.Exit(1)
+
+	// Split output directories
+	var dirs []string
+	if *outputDirs != "" {
+		for _, dir := range strings.Split(*outputDirs, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
 	}
 
 	// Validate endianness
@@ -129,48 +464,239 @@ func processArgs() *FileBuffer {
 	case "big":
 		byteOrder = BigEndian
 	default:
-		fmt.Fprintf(os.Stderr, "Error: --endianness must be 'little' or 'big', got: %s\n", *endianness)
-		os.Exit(1)
+		errs = append(errs, fmt.Sprintf("--endianness must be 'little' or 'big', got: %s", *endianness))
+	}
+
+	var crc16Variant CRC16Variant
+	switch strings.ToLower(*crc16Poly) {
+	case "ccitt":
+		crc16Variant = CRC16CCITT
+	case "ibm":
+		crc16Variant = CRC16IBM
+	default:
+		errs = append(errs, fmt.Sprintf("--crc16_poly must be 'ccitt' or 'ibm', got: %s", *crc16Poly))
+	}
+
+	var companionPerms uint64
+	if *dualOutputPerms != "" {
+		companionPerms, err = strconv.ParseUint(*dualOutputPerms, 8, 32)
+		if err != nil || companionPerms > 0777 {
+			errs = append(errs, fmt.Sprintf("--dual_output_perms must be octal permissions like 0640, got: %s", *dualOutputPerms))
+		}
+	}
+
+	var unixPerms uint64
+	if *inputUnixPerms != "" {
+		unixPerms, err = strconv.ParseUint(*inputUnixPerms, 8, 32)
+		if err != nil || unixPerms > 0777 {
+			errs = append(errs, fmt.Sprintf("--input_unix_perms must be octal permissions like 0660, got: %s", *inputUnixPerms))
+		}
+	}
+
+	var delimiter []byte
+	if *nullDelimiter {
+		if *recordDelimiter != "" {
+			errs = append(errs, "--null_delimiter and --record_delimiter cannot be used together")
+		}
+		delimiter = []byte{0}
+	} else if *recordDelimiter != "" {
+		delimiter, err = parseRecordDelimiter(*recordDelimiter)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--record_delimiter: %v", err))
+		}
+	}
+
+	var injectBlock []byte
+	if *injectBlockFile != "" {
+		injectBlock, err = os.ReadFile(*injectBlockFile)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--inject_block_file: %v", err))
+		} else if len(injectBlock) == 0 {
+			errs = append(errs, fmt.Sprintf("--inject_block_file is empty: %s", *injectBlockFile))
+		}
 	}
 
-	// Validate header size vs read buffer size
-	if *headerBytes > *readBufferSize {
-		fmt.Fprintln(os.Stderr, "Error: --read_buffer_size must be at least as large as --header_bytes")
-		os.Exit(1)
+	trailer, err := parseTrailer(*trailerHex, *trailerBytes)
+	if err != nil {
+		errs = append(errs, err.Error())
 	}
 
-	// Validate max block size vs read buffer size
-	if *maxBlockSize > *readBufferSize {
-		fmt.Fprintln(os.Stderr, "Error: --read_buffer_size must be at least as large as --max_block_size")
-		os.Exit(1)
+	var dictionary []byte
+	if *gzipDictionaryFile != "" {
+		dictionary, err = os.ReadFile(*gzipDictionaryFile)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--gzip_dictionary_file: %v", err))
+		} else if len(dictionary) == 0 {
+			errs = append(errs, fmt.Sprintf("--gzip_dictionary_file is empty: %s", *gzipDictionaryFile))
+		} else if len(dictionary) > maxDictionaryBytes {
+			// Only the end of the dictionary fits in the deflate window
+			dictionary = dictionary[len(dictionary)-maxDictionaryBytes:]
+		}
 	}
 
-	fb := &FileBuffer{
-		filePrefix:       *filePrefix,
-		maxFileSize:      *fileSizeKB * 1024, // Convert KB to bytes
-		maxNumFiles:      *numFiles,
-		timeFormat:       *timeFormat,
-		useLocalTime:     *useLocalTime,
-		headerBytes:      *headerBytes,
-		maxBlockSize:     *maxBlockSize,
-		readBufferSize:   *readBufferSize,
-		compressionLevel: *compressionLevel,
-		activeFiles:      make([]string, 0, *numFiles),
-		resumeExisting:   *resumeExisting,
-		quiet:            *quiet,
+	// Load block header presets
+	presets, err := loadPresets(*blockFormatFile, byteOrder)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("--block_format_file: %v", err))
+	}
+	if *listPresetsFlag {
+		if len(errs) > 0 {
+			return nil, errors.New(strings.Join(errs, "\n"))
+		}
+		listPresets(os.Stdout, presets, byteOrder)
+		return nil, errExitEarly
+	}
+	formatStr := *blockHeader
+	var presetAlts []*BlockHeaderFormat
+	if *blockFormatPreset != "" {
+		if *blockHeader != "" {
+			errs = append(errs, "--block_header and --block_format_preset cannot be used together")
+		}
+		preset, ok := findPreset(presets, *blockFormatPreset)
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown --block_format_preset: %s (see --list_presets)", *blockFormatPreset))
+		}
+		formatStr = preset.Format
+		presetAlts, err = parsePresetAlts(presets, preset, *baseBlockHeader, byteOrder, crc16Variant)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--block_format_preset: %v", err))
+		}
+	}
+	// The base fields come first, as if they'd been written at the start
+	if *baseBlockHeader != "" {
+		if formatStr == "" {
+			errs = append(errs, "--base_block_header requires --block_header or --block_format_preset")
+		}
+		formatStr = *baseBlockHeader + formatStr
 	}
 
 	// Parse block header format if provided
-	if *blockHeader != "" {
-		fb.blockFormat = parseBlockHeaderFormat(*blockHeader, byteOrder)
-		endianStr := "little-endian"
-		if byteOrder == BigEndian {
-			endianStr = "big-endian"
+	var blockFormat *BlockHeaderFormat
+	if formatStr != "" {
+		blockFormat, err = ParseBlockHeaderFormat(formatStr, byteOrder, crc16Variant)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--block_header: %v", err))
 		}
-		if !fb.quiet {
-			fmt.Fprintf(os.Stderr, "Block header format: %d bytes, %d fields (%s)\n", fb.blockFormat.TotalBytes, len(fb.blockFormat.Fields), endianStr)
+	}
+
+	var blockFormatAlts []*BlockHeaderFormat
+	for i, alt := range blockHeaderAlts {
+		format, err := ParseBlockHeaderFormat(*baseBlockHeader+alt, byteOrder, crc16Variant)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--block_header_alt %d: %v", i+1, err))
+			continue
+		}
+		blockFormatAlts = append(blockFormatAlts, format)
+	}
+	// A preset's own alternates are tried after any --block_header_alt
+	blockFormatAlts = append(blockFormatAlts, presetAlts...)
+
+	var blockTrailer *BlockHeaderFormat
+	if *blockTrailerFormat != "" {
+		blockTrailer, err = ParseBlockTrailerFormat(*blockTrailerFormat, byteOrder)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--block_trailer_format: %v", err))
+		}
+	}
+
+	var blockValidator func([]byte) int
+	if *blockValidatorPlugin != "" {
+		blockValidator, err = loadBlockValidator(*blockValidatorPlugin)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--block_validator_plugin: %v", err))
 		}
 	}
 
-	return fb
+	var tmpl *template.Template
+	if *filenameTemplate != "" {
+		tmpl, err = parseFilenameTemplate(*filenameTemplate)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--filename_template: %v", err))
+		}
+	}
+
+	fb, err := NewFileBuffer(
+		WithPrefix(*filePrefix),
+		WithMaxFileSize(*fileSizeKB*1024), // Convert KB to bytes
+		WithRotateOnUncompressed(*rotateOnUncompressed),
+		WithMaxNumFiles(*numFiles),
+		WithMaxFileAge(*maxFileAge),
+		WithMaxTotalBytes(*maxTotalBytes),
+		WithProtectRecent(time.Duration(*protectRecentSeconds*float64(time.Second))),
+		WithPreDeleteHook(*preDeleteHook, *preDeleteHookTimeout, *preDeleteHookStrict),
+		WithOutputExtension(*outputExtension),
+		WithFilenameSep(*filenameSep),
+		WithFilenameTemplate(tmpl),
+		WithCounterStart(*counterStart),
+		WithSubdirFormat(*subdirFormat),
+		WithTimeFormat(*timeFormat),
+		WithLocalTime(*useLocalTime),
+		WithHeaderBytes(*headerBytes),
+		WithSectionHeaderBytes(*sectionHeaderBytes),
+		WithInjectBlock(injectBlock),
+		WithTrailer(trailer),
+		WithTrailerFile(*trailerFile),
+		WithBlockFormat(blockFormat),
+		WithBlockFormatAlts(blockFormatAlts...),
+		WithBlockTrailer(blockTrailer),
+		WithTimestampHz(*timestampHz),
+		WithBlockValidator(blockValidator),
+		WithAutoDetectPcap(*autoDetectPcap),
+		WithRequireCompleteBlock(*requireCompleteBlock),
+		WithDebugBlockScan(*debugBlockScan),
+		WithBlockValidationStrict(*blockValidationStrict, *strictMissThreshold),
+		WithSplitOnNewline(*splitOnNewline, *minLineLength),
+		WithRecordSize(*recordSize),
+		WithRecordDelimiter(delimiter),
+		WithMaxBlockSize(*maxBlockSize),
+		WithReadBufferSize(*readBufferSize),
+		WithMaxInputRate(*maxInputRate),
+		WithMaxOutputRate(*maxOutputRate),
+		WithCompressionLevel(*compressionLevel),
+		WithGzipWindow(*gzipWindowBits, *gzipMemLevel),
+		WithDecompressInput(*decompressInput),
+		WithReadTimeout(*readTimeout, *readTimeoutAction),
+		WithShutdownTimeout(*shutdownTimeout),
+		WithGoroutineRestart(*goroutineRestart),
+		WithInputTCP(*inputTCP, *inputTCPMulti),
+		WithInputUnix(*inputUnix, os.FileMode(unixPerms), *inputUnixMulti),
+		WithInputTLS(*inputTLSCert, *inputTLSKey),
+		WithWriteErrorPolicy(*writeErrorPolicy, *maxConsecutiveErrors),
+		WithWriteRetry(*writeRetryCount, *writeRetryInterval),
+		WithGzipSyncInterval(*gzipSyncInterval),
+		WithGzipDictionary(dictionary, *gzipDictionaryEmbed),
+		WithEmbedChecksum(*embedChecksum),
+		WithGzipMultistream(*gzipMultistream),
+		WithPreallocateBytes(*preallocateBytes),
+		WithResumeExisting(*resumeExisting),
+		WithStateFile(*stateFile),
+		WithVerifyOutput(*verifyOutput),
+		WithVerifyOnResume(*verifyOnResume),
+		WithRepairLastFile(*repairLastFile),
+		WithQuiet(*quiet),
+		WithVerbose(*verbose),
+		WithLogFile(*logFile),
+		WithLogSyslog(*logSyslog, *logSyslogFacility),
+		WithStdout(toStdout),
+		WithOutputFifo(*outputFifo),
+		WithOutputDirs(dirs),
+		WithMirrorDir(*mirrorDir),
+		WithDualOutput(*dualOutput, os.FileMode(companionPerms)),
+		WithIndex(*writeIndex, *indexFormat),
+		WithArchiveDir(*archiveDir),
+		WithWebhook(*webhookURL, *webhookAuthHeader, *webhookTimeout),
+		WithMetricsAddr(*metricsAddr),
+		WithControlAddr(*controlAddr),
+		WithStatsd(*statsdAddr, *statsdPrefix),
+		WithS3Upload(*s3Bucket, *s3KeyPrefix, *s3Region, *s3DeleteAfterUpload, *s3RetryCount),
+	)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	return fb, nil
 }
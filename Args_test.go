@@ -0,0 +1,208 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parseArgs runs processArgs on args with a fresh flag set, as if they were
+// the command line. Usage output is discarded.
+func parseArgs(t *testing.T, args ...string) (*FileBuffer, error) {
+	t.Helper()
+	savedArgs, savedFlags, savedUsage, savedStderr := os.Args, flag.CommandLine, flag.Usage, os.Stderr
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine, flag.Usage, os.Stderr = savedArgs, savedFlags, savedUsage, savedStderr
+	})
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { devNull.Close() })
+
+	os.Args = append([]string{"GzipFileBuffer"}, args...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Stderr = devNull
+	return processArgs()
+}
+
+// TestProcessArgs checks the command line overrides the environment, which
+// overrides the config file
+func TestProcessArgs(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	config := filepath.Join(t.TempDir(), "gzfb.conf")
+	if err := os.WriteFile(config, []byte("[output]\ncompression_level = 1\nfile_size = 64\nquiet = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	required := []string{"--file_prefix", prefix, "--num_files", "2"}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		args      []string
+		wantLevel int
+		wantSize  int64
+	}{
+		{"config file", nil, []string{"--config", config}, 1, 64 * 1024},
+		{"config file from the environment", map[string]string{"GZFB_CONFIG": config}, nil, 1, 64 * 1024},
+		{"environment over config file", map[string]string{"GZFB_COMPRESSION_LEVEL": "5"}, []string{"--config=" + config}, 5, 64 * 1024},
+		{"command line over environment", map[string]string{"GZFB_COMPRESSION_LEVEL": "5"}, []string{"--config", config, "--compression_level", "9"}, 9, 64 * 1024},
+		{"command line over config file", nil, []string{"--config", config, "--file_size", "128"}, 1, 128 * 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fb, err := parseArgs(t, append(tt.args, required...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fb.compressionLevel != tt.wantLevel {
+				t.Errorf("compression level %d, want %d", fb.compressionLevel, tt.wantLevel)
+			}
+			if fb.maxFileSize != tt.wantSize {
+				t.Errorf("file size %d, want %d", fb.maxFileSize, tt.wantSize)
+			}
+			if !fb.quiet {
+				t.Errorf("quiet from the config file wasn't applied")
+			}
+		})
+	}
+}
+
+func TestProcessArgs_Errors(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	unknownKey := filepath.Join(t.TempDir(), "unknown.conf")
+	if err := os.WriteFile(unknownKey, []byte("num_files = 2\nfile_sise = 64\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	required := []string{"--file_prefix", prefix, "--num_files", "2", "--file_size", "64"}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		wantErrs []string
+	}{
+		{
+			name:     "unknown config key",
+			args:     append([]string{"--config", unknownKey}, required...),
+			wantErrs: []string{"--config: " + unknownKey + ":2: unknown key: file_sise"},
+		},
+		{
+			name:     "missing config file",
+			args:     append([]string{"--config", unknownKey + ".missing"}, required...),
+			wantErrs: []string{"--config: open " + unknownKey + ".missing"},
+		},
+		{
+			name:     "bad environment value",
+			env:      map[string]string{"GZFB_NUM_FILES": "many"},
+			args:     required,
+			wantErrs: []string{`environment: invalid value "many" for GZFB_NUM_FILES`},
+		},
+		{
+			name:     "delimiters",
+			args:     append([]string{"--null_delimiter", "--record_delimiter", "0a"}, required...),
+			wantErrs: []string{"--null_delimiter and --record_delimiter cannot be used together"},
+		},
+		{
+			name:     "block header and preset",
+			args:     append([]string{"--block_header", "<u32:length>", "--block_format_preset", "pcap"}, required...),
+			wantErrs: []string{"--block_header and --block_format_preset cannot be used together"},
+		},
+		{
+			name:     "base block header alone",
+			args:     append([]string{"--base_block_header", "<u32:sec>"}, required...),
+			wantErrs: []string{"--base_block_header requires --block_header or --block_format_preset"},
+		},
+		{
+			name:     "write error flags",
+			args:     append([]string{"--exit_on_write_error=false", "--write_error_policy", "exit"}, required...),
+			wantErrs: []string{"--exit_on_write_error=false conflicts with --write_error_policy exit"},
+		},
+		{
+			name:     "missing dictionary file",
+			args:     append([]string{"--gzip_dictionary_file", prefix + ".dict"}, required...),
+			wantErrs: []string{"--gzip_dictionary_file: open " + prefix + ".dict"},
+		},
+		{
+			name:     "dictionary embed alone",
+			args:     append([]string{"--gzip_dictionary_embed"}, required...),
+			wantErrs: []string{"--gzip_dictionary_embed requires --gzip_dictionary_file"},
+		},
+		{
+			name:     "bad dual output permissions",
+			args:     append([]string{"--dual_output", "--dual_output_perms", "0999"}, required...),
+			wantErrs: []string{"--dual_output_perms must be octal permissions like 0640, got: 0999"},
+		},
+		{
+			name:     "missing inject block file",
+			args:     append([]string{"--inject_block_file", empty + ".missing"}, required...),
+			wantErrs: []string{"--inject_block_file: open " + empty + ".missing"},
+		},
+		{
+			name:     "empty inject block file",
+			args:     append([]string{"--inject_block_file", empty}, required...),
+			wantErrs: []string{"--inject_block_file is empty: " + empty},
+		},
+		{
+			name: "all reported together",
+			args: []string{"--output", "pipe", "--endianness", "middle", "--verbose", "--quiet"},
+			wantErrs: []string{
+				"--output must be 'files' or 'stdout', got: pipe",
+				"--endianness must be 'little' or 'big', got: middle",
+				"--verbose cannot be used with --quiet",
+				"--file_size is required and must be positive",
+				"--num_files is required and must be positive",
+				"--file_prefix is required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fb, err := parseArgs(t, tt.args...)
+			if err == nil || fb != nil {
+				t.Fatalf("processArgs() = %v, %v, want an error", fb, err)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.wantErrs) {
+				t.Errorf("got %d errors, want %d:\n%v", len(lines), len(tt.wantErrs), err)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// An undefined flag or a bad flag value isn't a validation error, the flag
+// package has already reported it
+func TestProcessArgs_BadFlag(t *testing.T) {
+	for _, args := range [][]string{{"--no_such_flag"}, {"--num_files", "many"}} {
+		if _, err := parseArgs(t, args...); !errors.Is(err, errBadFlag) {
+			t.Errorf("%v: got %v, want errBadFlag", args, err)
+		}
+	}
+	if _, err := parseArgs(t); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("no arguments: got %v, want flag.ErrHelp", err)
+	}
+}
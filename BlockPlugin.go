@@ -0,0 +1,74 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// blockValidatorSymbol is the function a --block_validator_plugin exports:
+//
+//	func ValidateBlock(data *byte, len int) int
+//
+// It's called with a pointer to each candidate offset in the read buffer and
+// the number of bytes from there to the end of the buffer, a C-compatible
+// signature so the validation can be a C function called through cgo. It
+// returns:
+//
+//	> 0  a valid block starts at data[0], the value is its length in bytes
+//	  0  no valid block starts at data[0]
+//	 -1  can't tell, the block continues past data[len-1]
+//
+// It's called from a single goroutine, never with len 0, and mustn't keep
+// data after returning.
+const blockValidatorSymbol = "ValidateBlock"
+
+// loadBlockValidator opens a Go plugin (built with -buildmode=plugin) and
+// looks up its ValidateBlock function
+func loadBlockValidator(path string) (func([]byte) int, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(blockValidatorSymbol)
+	if err != nil {
+		return nil, err
+	}
+	validate, ok := sym.(func(*byte, int) int)
+	if !ok {
+		return nil, fmt.Errorf("%s in %s is %T, expected func(data *byte, len int) int", blockValidatorSymbol, path, sym)
+	}
+	return sliceValidator(validate), nil
+}
+
+// sliceValidator adapts a plugin's ValidateBlock to take the rest of the
+// read buffer as a slice
+func sliceValidator(validate func(data *byte, len int) int) func([]byte) int {
+	return func(data []byte) int {
+		if len(data) == 0 {
+			return 0
+		}
+		return validate(&data[0], len(data))
+	}
+}
+
+// findPluginBlock returns the offset of the first block in data the
+// --block_validator_plugin accepts. A block that needs more data than is in
+// the buffer isn't a boundary, the same as with --require_complete_block.
+func (fb *FileBuffer) findPluginBlock(data []byte) int {
+	for offset := range data {
+		if fb.blockValidator(data[offset:]) > 0 {
+			fb.counters.blockValidationFailures.Add(int64(offset))
+			fb.countBlockScan(offset, true)
+			return offset
+		}
+	}
+	fb.counters.blockValidationFailures.Add(int64(len(data)))
+	fb.countBlockScan(len(data), false)
+
+	fmt.Fprintf(logOutput, "Warning: no block accepted by --block_validator_plugin (to split on) in read buffer. Try a bigger buffer?\n")
+	return len(data)
+}
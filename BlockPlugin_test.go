@@ -0,0 +1,128 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+// xorValidateBlock is the sample plugin's rule in Go, with the plugin ABI:
+// a block is 0x7E, a big-endian u16 payload length, the payload and the
+// XOR of the payload bytes. A block header format can't check the XOR.
+func xorValidateBlock(data *byte, n int) int {
+	b := unsafe.Slice(data, n)
+	if b[0] != 0x7E {
+		return 0
+	}
+	if n < 3 {
+		return -1
+	}
+	payload := int(b[1])<<8 | int(b[2])
+	if n < 3+payload+1 {
+		return -1
+	}
+	var x byte
+	for _, c := range b[3 : 3+payload] {
+		x ^= c
+	}
+	if x != b[3+payload] {
+		return 0
+	}
+	return 3 + payload + 1
+}
+
+// xorBlocks makes n blocks for xorValidateBlock. Each payload has a decoy
+// block in it with the wrong XOR, so only the XOR check tells the real
+// block starts from the decoys. Otherwise payloads have no 0x7E bytes.
+func xorBlocks(n int, seed int64) (stream []byte, starts map[int]bool) {
+	rng := rand.New(rand.NewSource(seed))
+	starts = make(map[int]bool)
+	for range n {
+		payload := make([]byte, 50+rng.Intn(200))
+		for i := range payload {
+			payload[i] = byte(rng.Intn(0x7E))
+		}
+		decoy := rng.Intn(len(payload) - 9)
+		copy(payload[decoy:], []byte{0x7E, 0x00, 0x05, 1, 2, 3, 4, 5, 0xFF})
+		var x byte
+		for _, c := range payload {
+			x ^= c
+		}
+		starts[len(stream)] = true
+		stream = append(stream, 0x7E, byte(len(payload)>>8), byte(len(payload)))
+		stream = append(append(stream, payload...), x)
+	}
+	return stream, starts
+}
+
+// Every file but the first starts at a real block, found by the XOR rule
+// only the validator implements
+func TestPluginBlockValidator(t *testing.T) {
+	stream, starts := xorBlocks(400, 1)
+	discardLog(t)
+	fb := newTestFileBuffer(t,
+		WithBlockValidator(sliceValidator(xorValidateBlock)),
+		WithReadBufferSize(2048),
+		WithMaxBlockSize(2048),
+		WithMaxFileSize(8192),
+		WithRotateOnUncompressed(true),
+		WithMaxNumFiles(100),
+	)
+	if err := fb.WriteFrom(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readGzipFiles(t, fb.activeFiles)
+	if len(files) < 3 {
+		t.Fatalf("got %d files, want at least 3", len(files))
+	}
+	offset := 0
+	for i, file := range files {
+		if i > 0 && !starts[offset] {
+			t.Errorf("file %d starts at stream offset %d, not at a block", i, offset)
+		}
+		offset += len(file)
+	}
+	if !bytes.Equal(bytes.Join(files, nil), stream) {
+		t.Errorf("files don't add up to the stream")
+	}
+}
+
+// The sample plugin builds, loads and implements the same rule as
+// xorValidateBlock. Building a plugin needs cgo and a C compiler, and it
+// has to match the test binary's build, so the test is skipped if it fails.
+func TestLoadBlockValidator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a plugin")
+	}
+	so := filepath.Join(t.TempDir(), "validator.so")
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", so, "examples/block_validator_plugin/validator.go")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("can't build the sample plugin: %v\n%s", err, out)
+	}
+	validate, err := loadBlockValidator(so)
+	if err != nil {
+		t.Skipf("can't load the sample plugin: %v", err)
+	}
+
+	stream, starts := xorBlocks(20, 2)
+	for offset := range stream {
+		got, want := validate(stream[offset:]), xorValidateBlock(&stream[offset], len(stream)-offset)
+		if got != want {
+			t.Fatalf("offset %d: plugin returned %d, want %d", offset, got, want)
+		}
+		if starts[offset] && got <= 0 {
+			t.Fatalf("offset %d: plugin rejected a block", offset)
+		}
+	}
+	if validate(nil) != 0 {
+		t.Errorf("empty data accepted")
+	}
+}
@@ -2,13 +2,15 @@
 // Use of this source code is governed by an MIT license that can be
 // found in the LICENSE file.
 
-package main
+package gzipfilebuffer
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
-	"os"
+	"math"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -23,8 +25,20 @@ const (
 	FieldLength
 	FieldMagic
 	FieldIgnore
+	FieldRange
+	FieldMasked
+	FieldCRC16
+	FieldStringMagic
+	FieldNTP
+	FieldNsecEpoch
+	FieldFCS
+	FieldFloat
 )
 
+// ntpUnixOffset is the number of seconds from the NTP epoch (1900) to the
+// Unix epoch (1970)
+const ntpUnixOffset = 2208988800
+
 type Endianness int
 
 const (
@@ -32,42 +46,145 @@ const (
 	BigEndian
 )
 
+// CRC16Variant selects the CRC16 algorithm for crc16 fields
+type CRC16Variant int
+
+const (
+	CRC16CCITT CRC16Variant = iota // poly 0x1021, init 0xFFFF, MSB first (CRC-16/CCITT-FALSE)
+	CRC16IBM                       // poly 0x8005, init 0x0000, reflected (CRC-16/ARC)
+)
+
 type HeaderField struct {
-	Width      int // 8, 16, 32, 64 bits
+	Width      int // 8, 16, 24, 32, 48, 64 bits
 	Type       FieldType
-	MagicValue uint64 // For magic number fields
-	Signed     bool   // For signed vs unsigned interpretation
+	MagicValue uint64  // For magic number fields (and masked fields, after masking)
+	MagicMask  uint64  // For masked fields
+	Signed     bool    // For signed vs unsigned interpretation
+	RangeMin   uint64  // For range fields, inclusive
+	RangeMax   uint64  // For range fields, inclusive
+	MagicBytes []byte  // For string magic fields, Width is len(MagicBytes)*8
+	FloatMin   float64 // For float fields, inclusive
+	FloatMax   float64 // For float fields, inclusive
+
+	// A BE: or LE: prefix overrides the format's endianness for this field
+	HasEndianness bool
+	Endianness    Endianness
+
+	// Conditional fields skip ConditionSkipBytes of optional header when
+	// (value & ConditionMask) == ConditionValue
+	Conditional        bool
+	ConditionMask      uint64
+	ConditionValue     uint64
+	ConditionSkipBytes int
 }
 
 type BlockHeaderFormat struct {
+	Name        string // For logging, if not the --block_header or a --block_header_alt format
 	Fields      []HeaderField
-	TotalBytes  int
+	TotalBytes  int // Minimum header size, conditional fields may add more per block
 	HasLength   bool
 	LengthIndex int
 	Endianness  Endianness
+	CRC16       CRC16Variant
+	CRCTable    [256]uint16 // Only filled in if there's a crc16 field
 }
 
-func parseBlockHeaderFormat(format string, endianness Endianness) *BlockHeaderFormat {
+// decimalRe matches a magic number given in decimal rather than 0xHEX
+var decimalRe = regexp.MustCompile(`^[0-9]+$`)
+
+// rangeRe matches a generic numeric field with an inclusive valid range, e.g. 0-255
+var rangeRe = regexp.MustCompile(`^([0-9]+)-([0-9]+)$`)
+
+// maskedRe matches a masked magic number, e.g. 0xF0&0x40 meaning (value & 0xF0) == 0x40
+var maskedRe = regexp.MustCompile(`^(0x[0-9A-Fa-f]+)&(0x[0-9A-Fa-f]+)$`)
+
+// floatRe matches an IEEE 754 float32 with a plausible range, e.g. float:-40.0:85.0
+var floatRe = regexp.MustCompile(`^float:([^:]+):([^:]+)$`)
+
+// conditionalRe matches a conditional skip, e.g. ext_hdr?0x80:skip16 meaning
+// "if the field has bit 0x80 set, skip another 16 bytes of header"
+var conditionalRe = regexp.MustCompile(`^\w+\?(0x[0-9A-Fa-f]+|[0-9]+):skip([0-9]+)$`)
+
+// stripFormatComments replaces each /* comment */ between the fields of a
+// format with a space, so long formats can be annotated, e.g.
+// /* sec */ <u32:sec> /* usec */ <u32:usec>. Text inside a field, like a
+// string magic, is left alone.
+func stripFormatComments(format string) (string, error) {
+	var sb strings.Builder
+	inField := false
+	for i := 0; i < len(format); i++ {
+		switch {
+		case format[i] == '<':
+			inField = true
+		case format[i] == '>':
+			inField = false
+		case !inField && strings.HasPrefix(format[i:], "/*"):
+			end := strings.Index(format[i+2:], "*/")
+			if end < 0 {
+				return "", fmt.Errorf("unclosed /* comment in block header format: %s", format)
+			}
+			sb.WriteByte(' ')
+			i += 2 + end + 1
+			continue
+		}
+		sb.WriteByte(format[i])
+	}
+	return sb.String(), nil
+}
+
+// ParseBlockHeaderFormat parses a block header format, e.g.
+// <u32:sec><u32:usec><u32:length><u32> for pcap, with multi-byte fields in
+// endianness unless they have a BE: or LE: prefix, and crc16 fields checked
+// with the crc16 variant
+func ParseBlockHeaderFormat(format string, endianness Endianness, crc16 CRC16Variant) (*BlockHeaderFormat, error) {
 	result := &BlockHeaderFormat{
 		Fields:     make([]HeaderField, 0),
 		Endianness: endianness,
+		CRC16:      crc16,
 	}
+	hasCRC := false
 
-	// Parse format like <u32:sec><u32:usec><u32:length><u32> or <s16:value> or <u8:0xFF>
-	re := regexp.MustCompile(`<([us])(\d+)(?::([^>]+))?>`)
+	format, err := stripFormatComments(format)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse format like <u32:sec><u32:usec><u32:length><u32> or <s16:value> or <u8:0xFF> or <str4:SHB\x00>,
+	// each optionally with a byte order prefix, e.g. <BE:u16:length>
+	re := regexp.MustCompile(`<(?:(BE|LE):)?([us]|str)(\d+)(?::([^>]+))?>`)
 	matches := re.FindAllStringSubmatch(format, -1)
 
 	if len(matches) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: Invalid block header format: %s\n", format)
-		os.Exit(1)
+		return nil, fmt.Errorf("invalid block header format: %s", format)
+	}
+
+	// Anything other than whitespace between fields is a malformed field,
+	// e.g. a missing bracket, which would otherwise be silently dropped
+	for _, gap := range re.Split(format, -1) {
+		if strings.TrimSpace(gap) != "" {
+			return nil, fmt.Errorf("unexpected text in block header format: %q", gap)
+		}
 	}
 
 	for i, match := range matches {
-		signedness := match[1]
-		width, err := strconv.Atoi(match[2])
-		if err != nil || (width != 8 && width != 16 && width != 32 && width != 64) {
-			fmt.Fprintf(os.Stderr, "Error: Invalid field width: %s\n", match[2])
-			os.Exit(1)
+		byteOrder := match[1]
+		signedness := match[2]
+		if signedness == "str" {
+			if byteOrder != "" {
+				return nil, fmt.Errorf("%s: prefix on str%s field, string magic has no byte order", byteOrder, match[3])
+			}
+			field, err := parseStringMagic(match[3], match[4])
+			if err != nil {
+				return nil, err
+			}
+			result.Fields = append(result.Fields, field)
+			result.TotalBytes += len(field.MagicBytes)
+			continue
+		}
+
+		width, err := strconv.Atoi(match[3])
+		if err != nil || (width != 8 && width != 16 && width != 24 && width != 32 && width != 48 && width != 64) {
+			return nil, fmt.Errorf("invalid field width: %s", match[3])
 		}
 
 		field := HeaderField{
@@ -75,9 +192,19 @@ func parseBlockHeaderFormat(format string, endianness Endianness) *BlockHeaderFo
 			Type:   FieldIgnore,
 			Signed: signedness == "s",
 		}
+		if byteOrder != "" {
+			if width == 8 {
+				return nil, fmt.Errorf("%s: prefix on %s8 field, a single byte has no byte order", byteOrder, signedness)
+			}
+			field.HasEndianness = true
+			field.Endianness = LittleEndian
+			if byteOrder == "BE" {
+				field.Endianness = BigEndian
+			}
+		}
 
-		if len(match) > 3 && match[3] != "" {
-			typeStr := match[3]
+		if len(match) > 4 && match[4] != "" {
+			typeStr := match[4]
 			switch {
 			case typeStr == "sec":
 				field.Type = FieldSec
@@ -85,21 +212,118 @@ func parseBlockHeaderFormat(format string, endianness Endianness) *BlockHeaderFo
 				field.Type = FieldUsec
 			case typeStr == "nsec":
 				field.Type = FieldNsec
+			case typeStr == "ntp":
+				if width != 64 {
+					return nil, fmt.Errorf("ntp field must be 64 bits")
+				}
+				field.Type = FieldNTP
+			case typeStr == "nsec_epoch":
+				if width != 64 {
+					return nil, fmt.Errorf("nsec_epoch field must be 64 bits")
+				}
+				field.Type = FieldNsecEpoch
 			case typeStr == "length":
+				if result.HasLength {
+					return nil, fmt.Errorf("only one length field is allowed")
+				}
 				field.Type = FieldLength
 				result.HasLength = true
 				result.LengthIndex = i
+			case typeStr == "crc16":
+				if width != 16 || field.Signed {
+					return nil, fmt.Errorf("crc16 field must be u16")
+				}
+				field.Type = FieldCRC16
+				hasCRC = true
+			case typeStr == "fcs":
+				if width != 32 || field.Signed {
+					return nil, fmt.Errorf("fcs field must be u32")
+				}
+				field.Type = FieldFCS
+			case floatRe.MatchString(typeStr):
+				if width != 32 || field.Signed {
+					return nil, fmt.Errorf("float field must be u32")
+				}
+				bounds := floatRe.FindStringSubmatch(typeStr)
+				minVal, errMin := strconv.ParseFloat(bounds[1], 64)
+				maxVal, errMax := strconv.ParseFloat(bounds[2], 64)
+				if errMin != nil || errMax != nil || math.IsNaN(minVal) || math.IsNaN(maxVal) {
+					return nil, fmt.Errorf("invalid float range: %s", typeStr)
+				}
+				if minVal > maxVal {
+					return nil, fmt.Errorf("invalid float range %s: min is greater than max", typeStr)
+				}
+				field.Type = FieldFloat
+				field.FloatMin = minVal
+				field.FloatMax = maxVal
+			case maskedRe.MatchString(typeStr):
+				field.Type = FieldMasked
+				parts := maskedRe.FindStringSubmatch(typeStr)
+				mask, errMask := strconv.ParseUint(parts[1], 0, 64)
+				val, errVal := strconv.ParseUint(parts[2], 0, 64)
+				if errMask != nil || errVal != nil {
+					return nil, fmt.Errorf("invalid masked magic number: %s", typeStr)
+				}
+				if mask > maxFieldValue(width) {
+					return nil, fmt.Errorf("mask %s does not fit in %d bits", parts[1], width)
+				}
+				if val&^mask != 0 {
+					return nil, fmt.Errorf("masked magic number %s can never match: value has bits outside the mask", typeStr)
+				}
+				field.MagicMask = mask
+				field.MagicValue = val
 			case strings.HasPrefix(typeStr, "0x"):
 				field.Type = FieldMagic
 				val, err := strconv.ParseUint(typeStr[2:], 16, 64)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: Invalid magic number: %s\n", typeStr)
-					os.Exit(1)
+					return nil, fmt.Errorf("invalid magic number: %s", typeStr)
+				}
+				if val > maxFieldValue(width) {
+					return nil, fmt.Errorf("magic number %s does not fit in %d bits", typeStr, width)
 				}
 				field.MagicValue = val
+			case decimalRe.MatchString(typeStr):
+				field.Type = FieldMagic
+				val, err := strconv.ParseUint(typeStr, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid magic number: %s", typeStr)
+				}
+				if val > maxFieldValue(width) {
+					return nil, fmt.Errorf("magic number %s does not fit in %d bits", typeStr, width)
+				}
+				field.MagicValue = val
+			case rangeRe.MatchString(typeStr):
+				field.Type = FieldRange
+				bounds := rangeRe.FindStringSubmatch(typeStr)
+				minVal, errMin := strconv.ParseUint(bounds[1], 10, 64)
+				maxVal, errMax := strconv.ParseUint(bounds[2], 10, 64)
+				if errMin != nil || errMax != nil {
+					return nil, fmt.Errorf("invalid range: %s", typeStr)
+				}
+				if minVal > maxVal {
+					return nil, fmt.Errorf("invalid range %s: min is greater than max", typeStr)
+				}
+				if maxVal > maxFieldValue(width) {
+					return nil, fmt.Errorf("range %s does not fit in %d bits", typeStr, width)
+				}
+				field.RangeMin = minVal
+				field.RangeMax = maxVal
+			case conditionalRe.MatchString(typeStr):
+				cond := conditionalRe.FindStringSubmatch(typeStr)
+				mask, err := strconv.ParseUint(cond[1], 0, 64)
+				if err != nil || mask == 0 || mask > maxFieldValue(width) {
+					return nil, fmt.Errorf("invalid condition mask in %s", typeStr)
+				}
+				skip, err := strconv.Atoi(cond[2])
+				if err != nil || skip <= 0 {
+					return nil, fmt.Errorf("invalid skip length in %s", typeStr)
+				}
+				field.Conditional = true
+				field.ConditionMask = mask
+				field.ConditionValue = mask
+				field.ConditionSkipBytes = skip
 			default:
-				fmt.Fprintf(os.Stderr, "Error: Unknown field type: %s\n", typeStr)
-				os.Exit(1)
+				return nil, fmt.Errorf("unknown field type: %s", typeStr)
 			}
 		}
 
@@ -107,104 +331,523 @@ func parseBlockHeaderFormat(format string, endianness Endianness) *BlockHeaderFo
 		result.TotalBytes += width / 8
 	}
 
-	return result
+	if hasCRC {
+		result.CRCTable = makeCRC16Table(crc16)
+	}
+
+	return result, nil
+}
+
+func makeCRC16Table(variant CRC16Variant) [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		var crc uint16
+		if variant == CRC16IBM {
+			// Reflected, so shift right with the bit-reversed polynomial
+			crc = uint16(i)
+			for range 8 {
+				if crc&1 != 0 {
+					crc = crc>>1 ^ 0xA001
+				} else {
+					crc >>= 1
+				}
+			}
+		} else {
+			crc = uint16(i) << 8
+			for range 8 {
+				if crc&0x8000 != 0 {
+					crc = crc<<1 ^ 0x1021
+				} else {
+					crc <<= 1
+				}
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// crc16 calculates the format's CRC16 variant over data
+func (f *BlockHeaderFormat) crc16(data []byte) uint16 {
+	if f.CRC16 == CRC16IBM {
+		var crc uint16
+		for _, b := range data {
+			crc = crc>>8 ^ f.CRCTable[byte(crc)^b]
+		}
+		return crc
+	}
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ f.CRCTable[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// parseStringMagic parses the length and value of a <strN:VALUE> field. The
+// value is ASCII, with \xNN for any other byte and \\ for a backslash.
+func parseStringMagic(length, value string) (HeaderField, error) {
+	n, err := strconv.Atoi(length)
+	if err != nil || n <= 0 || n > maxStringMagicBytes {
+		return HeaderField{}, fmt.Errorf("invalid string magic length: %s (must be 1-%d bytes)", length, maxStringMagicBytes)
+	}
+	if value == "" {
+		return HeaderField{}, fmt.Errorf("string magic field str%s needs a value", length)
+	}
+
+	var magic []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			magic = append(magic, value[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(value[i:], `\\`):
+			magic = append(magic, '\\')
+			i++
+		case strings.HasPrefix(value[i:], `\x`) && i+4 <= len(value):
+			b, err := strconv.ParseUint(value[i+2:i+4], 16, 8)
+			if err != nil {
+				return HeaderField{}, fmt.Errorf("invalid escape %s in string magic %s", value[i:i+4], value)
+			}
+			magic = append(magic, byte(b))
+			i += 3
+		default:
+			return HeaderField{}, fmt.Errorf("invalid escape in string magic %s, use \\xNN or \\\\", value)
+		}
+	}
+	if len(magic) != n {
+		return HeaderField{}, fmt.Errorf("string magic %s is %d bytes, expected %d", value, len(magic), n)
+	}
+
+	return HeaderField{Width: n * 8, Type: FieldStringMagic, MagicBytes: magic}, nil
+}
+
+// maxStringMagicBytes is the longest <strN:...> field allowed
+const maxStringMagicBytes = 64
+
+// maxFieldValue returns the largest unsigned value a field of width bits can hold
+func maxFieldValue(width int) uint64 {
+	if width >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<uint(width) - 1
 }
 
 func (fb *FileBuffer) findBlockHeader(data []byte) int {
 	if fb.blockFormat == nil {
-		fmt.Fprintf(os.Stderr, "Internal error: findBlockHeader called without block format")
+		fmt.Fprintf(logOutput, "Internal error: findBlockHeader called without block format")
 		return len(data)
 	}
 
-	// Search for valid block header
-	for offset := 0; offset <= len(data)-fb.blockFormat.TotalBytes; offset++ {
-		if valid := fb.validateBlockHeader(data[offset:]); valid {
+	// Search for valid block header, in any of the formats. The shortest
+	// one decides how close to the end of the data a header can start.
+	start := fb.streamOffset - int64(len(data))
+	minBytes := fb.blockFormat.TotalBytes
+	for _, format := range fb.blockFormatAlts {
+		minBytes = min(minBytes, format.TotalBytes)
+	}
+
+	// One clock read for the whole scan, not one per offset
+	now := time.Now().Unix()
+	logged := 0
+	for offset := 0; offset <= len(data)-minBytes; offset++ {
+		check := fb.matchBlock(data[offset:], false, now)
+		if fb.verbose && (offset%verboseScanInterval == 0 || check.field < 0) {
+			fb.logCandidate(data, offset, check)
+		}
+		if check.field < 0 {
+			fb.counters.blockValidationFailures.Add(int64(offset))
+			fb.countBlockScan(offset, true)
+			fb.verbosef("findBlockHeader found a %s block header at stream offset %d after searching %d bytes", fb.formatName(check.format), start+int64(offset), offset)
 			return offset
 		}
+		if fb.debugBlockScan && check.plausible && logged < maxBlockScanDebugLogs {
+			fb.logBlockCheck(data, offset, check)
+			logged++
+		}
 	}
+	fb.counters.blockValidationFailures.Add(int64(max(len(data)-minBytes+1, 0)))
+	fb.countBlockScan(len(data), false)
+	fb.verbosef("findBlockHeader searched stream offsets %d-%d, not found", start, start+int64(len(data)))
 
-	fmt.Fprintf(os.Stderr, "Warning: no valid block header found (to split on) in read buffer. Try a bigger buffer?\n")
+	fmt.Fprintf(logOutput, "Warning: no valid block header found (to split on) in read buffer. Try a bigger buffer?\n")
 	return len(data)
 }
 
-func (fb *FileBuffer) validateBlockHeader(data []byte) bool {
-	if len(data) < fb.blockFormat.TotalBytes {
-		return false
+// checkStrictBlockScan counts scans in a row that found no block header. With
+// --block_validation_strict, more than --strict_miss_threshold of them means
+// the format doesn't match the stream, so it exits rather than carry on
+// writing misaligned files.
+func (fb *FileBuffer) checkStrictBlockScan(found bool) {
+	if found {
+		fb.consecutiveMisses = 0
+		return
+	}
+	fb.consecutiveMisses++
+	if fb.blockValidationStrict && fb.consecutiveMisses > fb.strictMissThreshold {
+		fmt.Fprintf(logOutput, "Error: no valid block header in %d read buffers in a row (--strict_miss_threshold %d), exiting. Does the block header format match the stream?\n",
+			fb.consecutiveMisses, fb.strictMissThreshold)
+		fb.exitLocked(ExitBlockError)
+	}
+}
+
+// maxBlockScanDebugLogs limits how many near-miss candidates
+// --debug_block_scan logs per read buffer
+const maxBlockScanDebugLogs = 3
+
+// logBlockCheck logs a candidate block header that partly matched, with the
+// bytes around it, to help debug a block header format
+func (fb *FileBuffer) logBlockCheck(data []byte, offset int, check blockCheck) {
+	start := max(offset-4, 0)
+	end := min(offset+12, len(data))
+	streamOffset := fb.streamOffset - int64(len(data)) + int64(offset)
+	if check.trailer {
+		fmt.Fprintf(logOutput, "Debug: block header candidate at stream offset %d failed trailer field %d <%s>: %s\n",
+			streamOffset, check.field+1, fb.blockTrailer.Fields[check.field], check.reason)
+	} else {
+		fmt.Fprintf(logOutput, "Debug: %s block header candidate at stream offset %d failed field %d <%s>: %s\n",
+			fb.formatName(check.format), streamOffset, check.field+1, check.format.Fields[check.field], check.reason)
+	}
+	fmt.Fprintf(logOutput, "Debug:   bytes from offset %d: % X\n", streamOffset-int64(offset-start), data[start:end])
+}
+
+// String gives the field in block header format syntax, without the <>
+func (f HeaderField) String() string {
+	if f.Type == FieldStringMagic {
+		var sb strings.Builder
+		for _, b := range f.MagicBytes {
+			switch {
+			case b == '\\':
+				sb.WriteString(`\\`)
+			case b < 0x20 || b > 0x7E || b == '>':
+				fmt.Fprintf(&sb, `\x%02X`, b)
+			default:
+				sb.WriteByte(b)
+			}
+		}
+		return fmt.Sprintf("str%d:%s", len(f.MagicBytes), sb.String())
+	}
+
+	spec := fmt.Sprintf("u%d", f.Width)
+	if f.Signed {
+		spec = fmt.Sprintf("s%d", f.Width)
+	}
+	if f.HasEndianness && f.Endianness == BigEndian {
+		spec = "BE:" + spec
+	} else if f.HasEndianness {
+		spec = "LE:" + spec
+	}
+	switch f.Type {
+	case FieldSec:
+		spec += ":sec"
+	case FieldUsec:
+		spec += ":usec"
+	case FieldNsec:
+		spec += ":nsec"
+	case FieldNTP:
+		spec += ":ntp"
+	case FieldNsecEpoch:
+		spec += ":nsec_epoch"
+	case FieldLength:
+		spec += ":length"
+	case FieldCRC16:
+		spec += ":crc16"
+	case FieldFCS:
+		spec += ":fcs"
+	case FieldMagic:
+		spec += fmt.Sprintf(":0x%X", f.MagicValue)
+	case FieldMasked:
+		spec += fmt.Sprintf(":0x%X&0x%X", f.MagicMask, f.MagicValue)
+	case FieldRange:
+		spec += fmt.Sprintf(":%d-%d", f.RangeMin, f.RangeMax)
+	case FieldFloat:
+		spec += fmt.Sprintf(":float:%g:%g", f.FloatMin, f.FloatMax)
+	}
+	if f.Conditional {
+		spec += fmt.Sprintf(":flag?0x%X:skip%d", f.ConditionMask, f.ConditionSkipBytes)
+	}
+	return spec
+}
+
+// nearNow reports whether the Unix time sec is within ±48 hours of now, for
+// validating timestamp fields
+func nearNow(sec, now int64) bool {
+	diff := sec - now
+	return diff >= -48*3600 && diff <= 48*3600
+}
+
+// validateBlockHeader reports whether data starts with a block header, with
+// timestamp fields checked against now (Unix seconds)
+func (fb *FileBuffer) validateBlockHeader(data []byte, now int64) bool {
+	return fb.checkBlockHeader(data, now).field < 0
+}
+
+// blockCheck is the result of checking for a block header. field is the
+// index of the field that failed, or -1 if the header is valid.
+type blockCheck struct {
+	field     int
+	reason    string
+	format    *BlockHeaderFormat // The format checked
+	plausible bool               // A field other than an ignored one matched before the failure
+	trailer   bool               // field is in --block_trailer_format
+
+	// For a valid header
+	size         uint64 // header, data and trailer bytes, if there's a length field
+	timestamp    int64  // Unix seconds from the first sec field
+	hasTimestamp bool
+}
+
+// blockCheck reasons for when data ends part way through the header, the
+// block or its trailer. More data could make them valid.
+const (
+	notEnoughData  = "not enough data"
+	blockPastEnd   = "block continues past the end of the read buffer"
+	trailerPastEnd = "block trailer continues past the end of the read buffer"
+)
+
+func (fb *FileBuffer) checkBlockHeader(data []byte, now int64) blockCheck {
+	return fb.checkBlock(fb.blockFormat, data, false, now)
+}
+
+// matchBlock checks for a block header at the start of data in the block
+// header format, then each --block_header_alt format in turn. It returns
+// the first match, or else the block header format's failure.
+func (fb *FileBuffer) matchBlock(data []byte, headerOnly bool, now int64) blockCheck {
+	check := fb.checkBlock(fb.blockFormat, data, headerOnly, now)
+	for _, format := range fb.blockFormatAlts {
+		if check.field < 0 {
+			break
+		}
+		if alt := fb.checkBlock(format, data, headerOnly, now); alt.field < 0 {
+			return alt
+		}
+	}
+	return check
+}
+
+// formatName names format for logging: the block header format, which
+// --block_header_alt it is, or its own name, e.g. a preset's alternate
+func (fb *FileBuffer) formatName(format *BlockHeaderFormat) string {
+	if format.Name != "" {
+		return format.Name
+	}
+	if i := slices.Index(fb.blockFormatAlts, format); i >= 0 {
+		return fmt.Sprintf("--block_header_alt %d", i+1)
+	}
+	return "--block_header"
+}
+
+// checkBlock checks for a block header in format at the start of data.
+// headerOnly skips the checks that need the rest of the block
+// (--require_complete_block and the trailer), for walking from block to block.
+// Timestamp fields are checked against now, in Unix seconds, which the
+// caller reads once for a whole scan.
+func (fb *FileBuffer) checkBlock(format *BlockHeaderFormat, data []byte, headerOnly bool, now int64) blockCheck {
+	fieldIndex := 0
+	plausible := false
+	fail := func(reason string) blockCheck {
+		return blockCheck{field: fieldIndex, reason: reason, plausible: plausible, format: format}
+	}
+
+	if len(data) < format.TotalBytes {
+		return fail(notEnoughData)
 	}
 
-	now := time.Now().Unix()
 	offset := 0
+	var blockLength uint64
+	var timestamp int64
+	hasTimestamp := false
 
-	for _, field := range fb.blockFormat.Fields {
-		var value uint64
+	for i, field := range format.Fields {
+		fieldIndex = i
 
-		switch field.Width {
-		case 8:
-			if offset+1 > len(data) {
-				return false
-			}
-			value = uint64(data[offset])
-			offset += 1
-		case 16:
-			if offset+2 > len(data) {
-				return false
-			}
-			if fb.blockFormat.Endianness == LittleEndian {
-				value = uint64(binary.LittleEndian.Uint16(data[offset:]))
-			} else {
-				value = uint64(binary.BigEndian.Uint16(data[offset:]))
-			}
-			offset += 2
-		case 32:
-			if offset+4 > len(data) {
-				return false
+		// String magic is compared byte for byte, not read as a number
+		if field.Type == FieldStringMagic {
+			if offset+len(field.MagicBytes) > len(data) {
+				return fail(notEnoughData)
 			}
-			if fb.blockFormat.Endianness == LittleEndian {
-				value = uint64(binary.LittleEndian.Uint32(data[offset:]))
-			} else {
-				value = uint64(binary.BigEndian.Uint32(data[offset:]))
+			if !bytes.Equal(data[offset:offset+len(field.MagicBytes)], field.MagicBytes) {
+				return fail("string magic doesn't match")
 			}
-			offset += 4
-		case 64:
-			if offset+8 > len(data) {
-				return false
-			}
-			if fb.blockFormat.Endianness == LittleEndian {
-				value = binary.LittleEndian.Uint64(data[offset:])
-			} else {
-				value = binary.BigEndian.Uint64(data[offset:])
-			}
-			offset += 8
+			offset += len(field.MagicBytes)
+			plausible = true
+			continue
+		}
+
+		value, ok := format.readValue(data, offset, field)
+		if !ok {
+			return fail(notEnoughData)
 		}
+		offset += field.Width / 8
 
 		// Validate based on field type
 		switch field.Type {
-		case FieldSec:
-			// Within ±48 hours
-			diff := int64(value) - now
-			if diff < -48*3600 || diff > 48*3600 {
-				return false
-			}
-		case FieldUsec:
-			if value > 999999 {
-				return false
-			}
-		case FieldNsec:
-			if value > 999999999 {
-				return false
-			}
 		case FieldLength:
 			if value > uint64(fb.maxBlockSize) {
-				return false
+				return fail("over --max_block_size")
+			}
+			blockLength = value
+		case FieldCRC16:
+			// Covers all the header bytes before this field
+			if uint64(format.crc16(data[:offset-2])) != value {
+				return fail("CRC doesn't match")
 			}
-		case FieldMagic:
-			if value != field.MagicValue {
-				return false
+		default:
+			if reason := fb.checkValue(field, value, now); reason != "" {
+				return fail(reason)
 			}
-		case FieldIgnore:
-			// Any value is okay
+		}
+		if field.Type == FieldSec && !hasTimestamp {
+			timestamp = int64(value / fb.timestampHz)
+			hasTimestamp = true
+		}
+		if field.Type != FieldIgnore {
+			plausible = true
+		}
+
+		// Skip optional header bytes if the condition is met, so the
+		// following fields are read from after them
+		if field.Conditional && value&field.ConditionMask == field.ConditionValue {
+			offset += field.ConditionSkipBytes
+		}
+	}
+
+	// The whole block has to be in the buffer, so a partial block at the end
+	// of a chunk isn't mistaken for a boundary
+	if format.HasLength && fb.requireCompleteBlock && !headerOnly {
+		if uint64(offset)+blockLength > uint64(len(data)) {
+			fieldIndex = format.LengthIndex
+			return fail(blockPastEnd)
+		}
+	}
+
+	if fb.blockTrailer != nil && !headerOnly {
+		if check := fb.checkBlockTrailer(data, uint64(offset)+blockLength, now); check.field >= 0 {
+			return check
+		}
+	}
+
+	check := blockCheck{field: -1, format: format, timestamp: timestamp, hasTimestamp: hasTimestamp}
+	if format.HasLength {
+		check.size = uint64(offset) + blockLength
+		if fb.blockTrailer != nil {
+			check.size += uint64(fb.blockTrailer.TotalBytes)
+		}
+	}
+	return check
+}
+
+// readValue reads field's unsigned value at offset, in its own byte order if
+// it has one or else the format's, or returns false if data is too short
+func (f *BlockHeaderFormat) readValue(data []byte, offset int, field HeaderField) (uint64, bool) {
+	if offset+field.Width/8 > len(data) {
+		return 0, false
+	}
+	order := f.Endianness
+	if field.HasEndianness {
+		order = field.Endianness
+	}
+	var value uint64
+	switch field.Width {
+	case 8:
+		value = uint64(data[offset])
+	case 16:
+		if order == LittleEndian {
+			value = uint64(binary.LittleEndian.Uint16(data[offset:]))
+		} else {
+			value = uint64(binary.BigEndian.Uint16(data[offset:]))
+		}
+	case 24:
+		// No 24-bit helpers in encoding/binary, assemble the 3 bytes by hand:
+		// LE is b[0] | b[1]<<8 | b[2]<<16, BE is b[0]<<16 | b[1]<<8 | b[2]
+		b := data[offset : offset+3]
+		if order == LittleEndian {
+			value = uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+		} else {
+			value = uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+	case 32:
+		if order == LittleEndian {
+			value = uint64(binary.LittleEndian.Uint32(data[offset:]))
+		} else {
+			value = uint64(binary.BigEndian.Uint32(data[offset:]))
+		}
+	case 48:
+		// Likewise no 48-bit helpers, so read 6 bytes into the low end of
+		// a uint64. Masking keeps it to 48 bits either way.
+		var b [8]byte
+		if order == LittleEndian {
+			copy(b[:], data[offset:offset+6])
+			value = binary.LittleEndian.Uint64(b[:])
+		} else {
+			copy(b[2:], data[offset:offset+6])
+			value = binary.BigEndian.Uint64(b[:])
+		}
+		value &= 0xFFFFFFFFFFFF
+	case 64:
+		if order == LittleEndian {
+			value = binary.LittleEndian.Uint64(data[offset:])
+		} else {
+			value = binary.BigEndian.Uint64(data[offset:])
 		}
 	}
+	return value, true
+}
 
-	return true
+// checkValue validates the value of a field that doesn't depend on the rest
+// of the block, returning why it's invalid or "" if it's okay
+func (fb *FileBuffer) checkValue(field HeaderField, value uint64, now int64) string {
+	switch field.Type {
+	case FieldSec:
+		// Counts at --timestamp_hz, which is 1 for plain seconds
+		if !nearNow(int64(value/fb.timestampHz), now) {
+			return "not within 48 hours of now"
+		}
+	case FieldUsec:
+		if value > 999999 {
+			return "over 999999"
+		}
+	case FieldNsec:
+		if value > 999999999 {
+			return "over 999999999"
+		}
+	case FieldNTP:
+		// 32.32 fixed point seconds since 1900, only the whole
+		// seconds are checked
+		if !nearNow(int64(value>>32)-ntpUnixOffset, now) {
+			return "not within 48 hours of now"
+		}
+	case FieldNsecEpoch:
+		// Negative as an int64 is either before 1970 or, for u64,
+		// too far in the future to be a real timestamp
+		if int64(value) < 0 {
+			return "negative nanosecond timestamp"
+		}
+		if !nearNow(int64(value)/1e9, now) {
+			return "not within 48 hours of now"
+		}
+	case FieldMagic:
+		if value != field.MagicValue {
+			return "magic number doesn't match"
+		}
+	case FieldMasked:
+		if value&field.MagicMask != field.MagicValue {
+			return "masked magic number doesn't match"
+		}
+	case FieldRange:
+		if value < field.RangeMin || value > field.RangeMax {
+			return "out of range"
+		}
+	case FieldFloat:
+		f := float64(math.Float32frombits(uint32(value)))
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "float is NaN or infinite"
+		}
+		if f < field.FloatMin || f > field.FloatMax {
+			return "float out of range"
+		}
+	case FieldIgnore:
+		// Any value is okay
+	}
+	return ""
 }
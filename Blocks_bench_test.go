@@ -0,0 +1,104 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// recordsInNoise makes a 256KB buffer of n pcap records with random bytes
+// before and between them, returning it and the offset of each record
+func recordsInNoise(n int, seed int64) ([]byte, []int) {
+	const size = 256 * 1024
+	rng := rand.New(rand.NewSource(seed))
+	data := make([]byte, size)
+	rng.Read(data)
+	slot := size / n
+	offsets := make([]int, n)
+	for i := range n {
+		record := pcapRecords(1, slot/2, seed+int64(i))
+		offsets[i] = i*slot + rng.Intn(slot-len(record))
+		copy(data[offsets[i]:], record)
+	}
+	return data, offsets
+}
+
+// BenchmarkFindBlockHeader scans a 256KB buffer of 200 pcap records
+// separated by random bytes for the first record header, calling
+// findBlockHeader 1, 10 and 100 times per op
+func BenchmarkFindBlockHeader(b *testing.B) {
+	data, offsets := recordsInNoise(200, 1)
+	for _, calls := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("calls=%d", calls), func(b *testing.B) {
+			discardLog(b)
+			fb := newTestFileBuffer(b, WithBlockFormat(presetFormat(b, "pcap")))
+			fb.streamOffset = int64(len(data))
+			b.SetBytes(int64(calls * offsets[0]))
+			for range b.N {
+				for range calls {
+					if offset := fb.findBlockHeader(data); offset != offsets[0] {
+						b.Fatalf("findBlockHeader = %d, want %d", offset, offsets[0])
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFindBlockHeaderWorstCase scans 256KB of random bytes, with no
+// block header to find, so every offset is checked
+func BenchmarkFindBlockHeaderWorstCase(b *testing.B) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	discardLog(b)
+	fb := newTestFileBuffer(b, WithBlockFormat(presetFormat(b, "pcap")))
+	fb.streamOffset = int64(len(data))
+	b.SetBytes(int64(len(data)))
+	for range b.N {
+		if offset := fb.findBlockHeader(data); offset != len(data) {
+			b.Fatalf("found a block header at %d in random data", offset)
+		}
+	}
+}
+
+// BenchmarkValidateBlockHeader checks every offset of 256KB of random bytes
+// with one pcap record at the very end, so the header check runs once per
+// offset. It compares reading the clock for each check with reading it once
+// per scan, as findBlockHeader does.
+func BenchmarkValidateBlockHeader(b *testing.B) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	record := pcapRecords(1, 100, 1)
+	headerAt := len(data) - len(record)
+	copy(data[headerAt:], record)
+
+	for _, perCall := range []bool{true, false} {
+		name := "now=per_call"
+		if !perCall {
+			name = "now=per_scan"
+		}
+		b.Run(name, func(b *testing.B) {
+			fb := newTestFileBuffer(b, WithBlockFormat(presetFormat(b, "pcap")))
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				now := time.Now().Unix()
+				for offset := 0; ; offset++ {
+					if perCall {
+						now = time.Now().Unix()
+					}
+					if fb.checkBlockHeader(data[offset:], now).field < 0 {
+						if offset != headerAt {
+							b.Fatalf("header found at %d, want %d", offset, headerAt)
+						}
+						break
+					}
+				}
+			}
+		})
+	}
+}
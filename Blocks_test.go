@@ -0,0 +1,688 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Each read buffer is scanned for a block header at most once, whether or
+// not it has one, so no byte of the stream is scanned twice
+func TestFindBlockHeaderScansOnce(t *testing.T) {
+	stream := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(stream)
+
+	discardLog(t)
+	fb := newTestFileBuffer(t,
+		WithBlockFormat(presetFormat(t, "pcap")),
+		WithReadBufferSize(4096),
+		WithMaxBlockSize(4096),
+		WithMaxFileSize(1),
+		WithMaxNumFiles(100),
+	)
+	if err := fb.WriteFrom(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	scanned := fb.counters.blockScanBytes.Load()
+	if scanned == 0 || scanned > int64(len(stream)) {
+		t.Errorf("scanned %d bytes of a %d byte stream, want some but no more", scanned, len(stream))
+	}
+}
+
+// formatSpec gives format's fields in block header format syntax
+func formatSpec(format *BlockHeaderFormat) string {
+	var sb strings.Builder
+	for _, field := range format.Fields {
+		fmt.Fprintf(&sb, "<%s>", field)
+	}
+	return sb.String()
+}
+
+func TestParseBlockHeaderFormat(t *testing.T) {
+	tests := []struct {
+		format    string
+		want      string // the fields as formatSpec gives them
+		wantBytes int
+		wantErr   string
+	}{
+		// pcap and pcap_ns
+		{format: "<u32:sec><u32:usec><u32:length><u32>", want: "<u32:sec><u32:usec><u32:length><u32>", wantBytes: 16},
+		{format: "<u32:sec><u32:nsec><u32:length><u32>", want: "<u32:sec><u32:nsec><u32:length><u32>", wantBytes: 16},
+		{format: "<u8><u16><u64><s8><s16><s32><s64>", want: "<u8><u16><u64><s8><s16><s32><s64>", wantBytes: 26},
+		{format: "<u32:0xA1B2C3D4>", want: "<u32:0xA1B2C3D4>", wantBytes: 4},
+		{format: "<u8:200>", want: "<u8:0xC8>", wantBytes: 1},
+		{format: "<u8:255>", want: "<u8:0xFF>", wantBytes: 1},
+		{format: "<u16:1234>", want: "<u16:0x4D2>", wantBytes: 2},
+		{format: "<u32:4294967295>", want: "<u32:0xFFFFFFFF>", wantBytes: 4},
+		{format: "<u24:0><u64:18446744073709551615>", want: "<u24:0x0><u64:0xFFFFFFFFFFFFFFFF>", wantBytes: 11},
+		{format: "<u64:nsec_epoch>", want: "<u64:nsec_epoch>", wantBytes: 8},
+		{format: "<BE:s64:nsec_epoch>", want: "<BE:s64:nsec_epoch>", wantBytes: 8},
+		{format: "<u24><s24:0xFFFFFF>", want: "<u24><s24:0xFFFFFF>", wantBytes: 6},
+		{format: "<u16:100-200><u8:0-0>", want: "<u16:100-200><u8:0-0>", wantBytes: 3},
+		{format: "<u8:0xf0&0x40>", want: "<u8:0xF0&0x40>", wantBytes: 1},
+		{format: "<u32><u16:crc16>", want: "<u32><u16:crc16>", wantBytes: 6},
+		{format: "<u64:ntp>", want: "<u64:ntp>", wantBytes: 8},
+		{format: "<u48:sec><s48><u48:0xFFFFFFFFFFFF>", want: "<u48:sec><s48><u48:0xFFFFFFFFFFFF>", wantBytes: 18},
+		{format: "<BE:u16:length><LE:s32><u32:sec>", want: "<BE:u16:length><LE:s32><u32:sec>", wantBytes: 10},
+		{format: "<u32:float:-40.0:85.5>", want: "<u32:float:-40:85.5>", wantBytes: 4},
+		{format: "<u32:float:-Inf:1e10>", want: "<u32:float:-Inf:1e+10>", wantBytes: 4},
+		{format: "<str4:SHB\\x00>", want: "<str4:SHB\\x00>", wantBytes: 4},
+		{format: "<str2:\\\\a>", want: "<str2:\\\\a>", wantBytes: 2},
+		{format: "<u8:flags?0x80:skip16><u16:length>", want: "<u8:flag?0x80:skip16><u16:length>", wantBytes: 3},
+		{format: " /* sec */ <u32:sec>\n\t/* usec */ <u32:usec> ", want: "<u32:sec><u32:usec>", wantBytes: 8},
+
+		{format: "", wantErr: "invalid block header format"},
+		{format: "u32:sec", wantErr: "invalid block header format"},
+		{format: "<u32:sec><u32", wantErr: "unexpected text"},
+		{format: "<u32:sec>x<u32:usec>", wantErr: "unexpected text"},
+		{format: "<u12>", wantErr: "invalid field width"},
+		{format: "<u0:sec>", wantErr: "invalid field width"},
+		{format: "<u32:bogus>", wantErr: "unknown field type"},
+		{format: "<u32:0xGGGG>", wantErr: "invalid magic number"},
+		{format: "<u8:0x100>", wantErr: "does not fit in 8 bits"},
+		{format: "<u8:256>", wantErr: "does not fit in 8 bits"},
+		{format: "<u16:65536>", wantErr: "does not fit in 16 bits"},
+		{format: "<u32:4294967296>", wantErr: "does not fit in 32 bits"},
+		{format: "<u64:18446744073709551616>", wantErr: "invalid magic number"},
+		{format: "<u24:0x1000000>", wantErr: "does not fit in 24 bits"},
+		{format: "<u16:200-100>", wantErr: "min is greater than max"},
+		{format: "<u8:0-256>", wantErr: "range 0-256 does not fit in 8 bits"},
+		{format: "<u64:0-99999999999999999999>", wantErr: "invalid range"},
+		{format: "<u8:0x1F0&0x40>", wantErr: "mask 0x1F0 does not fit in 8 bits"},
+		{format: "<u8:0x0F&0x40>", wantErr: "can never match"},
+		{format: "<s16:crc16>", wantErr: "crc16 field must be u16"},
+		{format: "<u32:crc16>", wantErr: "crc16 field must be u16"},
+		{format: "<u32:ntp>", wantErr: "ntp field must be 64 bits"},
+		{format: "<u48:0x1000000000000>", wantErr: "does not fit in 48 bits"},
+		{format: "<u48:0-281474976710656>", wantErr: "does not fit in 48 bits"},
+		{format: "<BE:u8>", wantErr: "a single byte has no byte order"},
+		{format: "<LE:str2:AB>", wantErr: "string magic has no byte order"},
+		{format: "<be:u16>", wantErr: "invalid block header format"},
+		{format: "<u16:float:0:1>", wantErr: "float field must be u32"},
+		{format: "<s32:float:0:1>", wantErr: "float field must be u32"},
+		{format: "<u32:float:2:1>", wantErr: "min is greater than max"},
+		{format: "<u32:float:NaN:1>", wantErr: "invalid float range"},
+		{format: "<u32:float:low:1>", wantErr: "invalid float range"},
+		{format: "<u32:length><u32:length>", wantErr: "only one length field"},
+		{format: "<u32:nsec_epoch>", wantErr: "nsec_epoch field must be 64 bits"},
+		{format: "<s48:nsec_epoch>", wantErr: "nsec_epoch field must be 64 bits"},
+		{format: "<u8:flags?0x00:skip4>", wantErr: "invalid condition mask"},
+		{format: "<u8:flags?0x100:skip4>", wantErr: "invalid condition mask"},
+		{format: "<u8:flags?0x80:skip0>", wantErr: "invalid skip length"},
+		{format: "<str0:AB>", wantErr: "invalid string magic length"},
+		{format: "<str0:>", wantErr: "invalid block header format"},
+		{format: "<str3:AB>", wantErr: "is 2 bytes, expected 3"},
+		{format: "<str2:A\\q>", wantErr: "invalid escape"},
+		{format: "/* unclosed <u32>", wantErr: "unclosed /* comment"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBlockHeaderFormat(tt.format, LittleEndian, CRC16CCITT)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseBlockHeaderFormat(%q) error = %v, want one containing %q", tt.format, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBlockHeaderFormat(%q): %v", tt.format, err)
+			continue
+		}
+		if spec := formatSpec(got); spec != tt.want {
+			t.Errorf("ParseBlockHeaderFormat(%q) = %s, want %s", tt.format, spec, tt.want)
+		}
+		if got.TotalBytes != tt.wantBytes {
+			t.Errorf("ParseBlockHeaderFormat(%q) TotalBytes = %d, want %d", tt.format, got.TotalBytes, tt.wantBytes)
+		}
+	}
+}
+
+// Comments and whitespace between fields parse to the same format as
+// the compact form, and a comment can't hide in a field
+func TestParseBlockHeaderFormatComments(t *testing.T) {
+	tests := []struct {
+		annotated, compact string
+	}{
+		{"/* sec */ <u32:sec> /* usec */ <u32:usec> /* incl_len */ <u32:length> /* orig_len */ <u32>", "<u32:sec><u32:usec><u32:length><u32>"},
+		{"\n\t<u32:sec>\n\t<u32:nsec>\n", "<u32:sec><u32:nsec>"},
+		{"<u16:0xABCD>/* a <u8:0xFF> field commented out */<u16:length>", "<u16:0xABCD><u16:length>"},
+		{"/**/<u8>/* multi\n * line\n */<BE:u16:crc16>", "<u8><BE:u16:crc16>"},
+		{"<str5:/*x*/> /* string magic */", "<str5:/*x*/>"},
+	}
+
+	for _, tt := range tests {
+		annotated, err := ParseBlockHeaderFormat(tt.annotated, LittleEndian, CRC16CCITT)
+		if err != nil {
+			t.Errorf("ParseBlockHeaderFormat(%q): %v", tt.annotated, err)
+			continue
+		}
+		compact, err := ParseBlockHeaderFormat(tt.compact, LittleEndian, CRC16CCITT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(annotated, compact) {
+			t.Errorf("ParseBlockHeaderFormat(%q) = %+v, want the same as %q, %+v", tt.annotated, annotated, tt.compact, compact)
+		}
+	}
+
+	for _, format := range []string{"<u32:sec> */ <u32>", "<u32:sec> /* <u32>"} {
+		if _, err := ParseBlockHeaderFormat(format, LittleEndian, CRC16CCITT); err == nil {
+			t.Errorf("ParseBlockHeaderFormat(%q) succeeded with an unbalanced comment", format)
+		}
+	}
+}
+
+// checkNow is the time, in Unix seconds, timestamp fields are checked
+// against in TestCheckBlockFields
+const checkNow = 1741064767
+
+// Each field type reads its value in the right byte order and width, and
+// validates it
+func TestCheckBlockFields(t *testing.T) {
+	// ntp is a big-endian NTP timestamp for the Unix time sec and a half
+	ntp := func(sec int64) []byte {
+		return binary.BigEndian.AppendUint64(nil, uint64(sec+ntpUnixOffset)<<32|0x80000000)
+	}
+
+	// nsec is a little-endian nanosecond timestamp for the Unix time sec
+	nsec := func(sec int64) []byte {
+		return binary.LittleEndian.AppendUint64(nil, uint64(sec*1e9+123456789))
+	}
+
+	// float is a little-endian float32
+	float := func(f float32) []byte {
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(f))
+	}
+
+	tests := []struct {
+		name       string
+		format     string
+		endianness Endianness
+		crc16      CRC16Variant
+		opts       []Option
+		data       []byte
+		wantField  int // -1 if valid
+		wantReason string
+	}{
+		{name: "u24 LE", format: "<u24:0x123456>", data: []byte{0x56, 0x34, 0x12}, wantField: -1},
+		{name: "u24 BE", format: "<u24:0x123456>", endianness: BigEndian, data: []byte{0x12, 0x34, 0x56}, wantField: -1},
+		{name: "u24 wrong byte order", format: "<u24:0x123456>", data: []byte{0x12, 0x34, 0x56}, wantField: 0, wantReason: "magic number doesn't match"},
+		{name: "u24 is 3 bytes", format: "<u24><u8:0xAA>", data: []byte{1, 2, 3, 0xAA}, wantField: -1},
+		{name: "u24 is not 2 bytes", format: "<u24><u8:0xAA>", data: []byte{1, 2, 0xAA, 4}, wantField: 1, wantReason: "magic number doesn't match"},
+		{name: "u24 short", format: "<u24:0x123456>", data: []byte{0x56, 0x34}, wantField: 0, wantReason: notEnoughData},
+		{name: "decimal magic u16", format: "<u16:1234>", data: []byte{0xD2, 0x04}, wantField: -1},
+		{name: "decimal magic u16 BE", format: "<u16:1234>", endianness: BigEndian, data: []byte{0x04, 0xD2}, wantField: -1},
+		{name: "decimal magic mismatch", format: "<u8><u32:4294967295>", data: []byte{0, 0xFF, 0xFF, 0xFF, 0xFE}, wantField: 1, wantReason: "magic number doesn't match"},
+		{name: "conditional skip", format: "<u8:flags?0x80:skip4><u8:0xAA>", data: []byte{0x81, 1, 2, 3, 4, 0xAA}, wantField: -1},
+		{name: "conditional no skip", format: "<u8:flags?0x80:skip4><u8:0xAA>", data: []byte{0x7F, 0xAA}, wantField: -1},
+		{name: "conditional skip reads past", format: "<u8:flags?0x80:skip4><u8:0xAA>", data: []byte{0x80, 0xAA, 2, 3, 4, 5}, wantField: 1, wantReason: "magic number doesn't match"},
+		{name: "conditional skip short", format: "<u8:flags?0x80:skip4><u8:0xAA>", data: []byte{0x80, 1, 2, 3}, wantField: 1, wantReason: notEnoughData},
+		{name: "range min", format: "<u8><u16:100-200>", data: []byte{0, 100, 0}, wantField: -1},
+		{name: "range max", format: "<u8><u16:100-200>", data: []byte{0, 200, 0}, wantField: -1},
+		{name: "range below min", format: "<u8><u16:100-200>", data: []byte{0, 99, 0}, wantField: 1, wantReason: "out of range"},
+		{name: "range above max", format: "<u8><u16:100-200>", data: []byte{0, 201, 0}, wantField: 1, wantReason: "out of range"},
+		{name: "range high byte", format: "<u8><u16:100-200>", data: []byte{0, 100, 1}, wantField: 1, wantReason: "out of range"},
+		{name: "masked", format: "<u8:0xF0&0x40>", data: []byte{0x40}, wantField: -1},
+		{name: "masked ignores unmasked bits", format: "<u8:0xF0&0x40>", data: []byte{0x4F}, wantField: -1},
+		{name: "masked mismatch", format: "<u8:0xF0&0x40>", data: []byte{0x50}, wantField: 0, wantReason: "masked magic number doesn't match"},
+		{name: "masked u16", format: "<u16:0xFF00&0x1200>", data: []byte{0xAB, 0x12}, wantField: -1},
+		{name: "masked u16 mismatch", format: "<u16:0xFF00&0x1200>", data: []byte{0x12, 0xAB}, wantField: 0, wantReason: "masked magic number doesn't match"},
+		{name: "string magic", format: "<str4:SHB\\x00><u8>", data: []byte("SHB\x00\x07"), wantField: -1},
+		{name: "string magic escapes", format: "<u8><str4:\\x0A\\\\\\xffz>", data: []byte("\x01\n\\\xFFz"), wantField: -1},
+		{name: "string magic mismatch", format: "<str4:SHB\\x00>", data: []byte("SHB0"), wantField: 0, wantReason: "string magic doesn't match"},
+		{name: "string magic case", format: "<u8><str4:SHB\\x00>", data: []byte("\x01shb\x00"), wantField: 1, wantReason: "string magic doesn't match"},
+		{name: "string magic unescaped", format: "<str3:A\\x42C>", data: []byte("A\\x"), wantField: 0, wantReason: "string magic doesn't match"},
+		{name: "string magic short", format: "<str4:SHB\\x00>", data: []byte("SHB"), wantField: 0, wantReason: notEnoughData},
+		// The check values for "123456789" are 0x29B1 (CCITT-FALSE) and 0xBB3D (ARC)
+		{name: "crc16 CCITT", format: "<str9:123456789><u16:crc16>", data: []byte("123456789\xB1\x29"), wantField: -1},
+		{name: "crc16 CCITT BE", format: "<str9:123456789><u16:crc16>", endianness: BigEndian, data: []byte("123456789\x29\xB1"), wantField: -1},
+		{name: "crc16 IBM", format: "<str9:123456789><u16:crc16>", crc16: CRC16IBM, data: []byte("123456789\x3D\xBB"), wantField: -1},
+		{name: "crc16 mismatch", format: "<str9:123456789><u16:crc16>", data: []byte("123456789\xB1\x28"), wantField: 1, wantReason: "CRC doesn't match"},
+		{name: "crc16 wrong variant", format: "<str9:123456789><u16:crc16>", crc16: CRC16IBM, data: []byte("123456789\xB1\x29"), wantField: 1, wantReason: "CRC doesn't match"},
+		{name: "crc16 of the header", format: "<u32><u16:crc16>", data: []byte("1234\x49\x53"), wantField: -1},
+		{name: "crc16 of another header", format: "<u32><u16:crc16>", data: []byte("1235\x49\x53"), wantField: 1, wantReason: "CRC doesn't match"},
+		{name: "ntp now", format: "<u64:ntp>", endianness: BigEndian, data: ntp(checkNow), wantField: -1},
+		{name: "ntp 48 hours ago", format: "<u64:ntp>", endianness: BigEndian, data: ntp(checkNow - 48*3600), wantField: -1},
+		{name: "ntp over 48 hours ahead", format: "<u64:ntp>", endianness: BigEndian, data: ntp(checkNow + 48*3600 + 1), wantField: 0, wantReason: "not within 48 hours of now"},
+		{name: "ntp without the 1900 epoch", format: "<u64:ntp>", endianness: BigEndian, data: ntp(checkNow - ntpUnixOffset), wantField: 0, wantReason: "not within 48 hours of now"},
+		{name: "ntp wrong byte order", format: "<u64:ntp>", data: ntp(checkNow), wantField: 0, wantReason: "not within 48 hours of now"},
+		{name: "nsec_epoch now", format: "<u64:nsec_epoch>", data: nsec(checkNow), wantField: -1},
+		{name: "nsec_epoch s64 now", format: "<s64:nsec_epoch>", data: nsec(checkNow), wantField: -1},
+		{name: "nsec_epoch BE now", format: "<u64:nsec_epoch>", endianness: BigEndian, data: binary.BigEndian.AppendUint64(nil, checkNow*1e9), wantField: -1},
+		{name: "nsec_epoch 48 hours ago", format: "<u64:nsec_epoch>", data: nsec(checkNow - 48*3600), wantField: -1},
+		{name: "nsec_epoch 49 hours ago", format: "<u64:nsec_epoch>", data: nsec(checkNow - 49*3600), wantField: 0, wantReason: "not within 48 hours of now"},
+		{name: "nsec_epoch 49 hours ahead", format: "<s64:nsec_epoch>", data: nsec(checkNow + 49*3600), wantField: 0, wantReason: "not within 48 hours of now"},
+		{name: "nsec_epoch in seconds", format: "<u64:nsec_epoch>", data: binary.LittleEndian.AppendUint64(nil, checkNow), wantField: 0, wantReason: "not within 48 hours of now"},
+		{name: "nsec_epoch negative", format: "<s64:nsec_epoch>", data: nsec(-checkNow), wantField: 0, wantReason: "negative nanosecond timestamp"},
+		{name: "nsec_epoch u64 top bit", format: "<u64:nsec_epoch>", data: binary.LittleEndian.AppendUint64(nil, 1<<63|checkNow*1_000_000_000), wantField: 0, wantReason: "negative nanosecond timestamp"},
+		{name: "u48 LE", format: "<u48:0x123456789ABC>", data: []byte{0xBC, 0x9A, 0x78, 0x56, 0x34, 0x12}, wantField: -1},
+		{name: "u48 BE", format: "<u48:0x123456789ABC>", endianness: BigEndian, data: []byte{0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC}, wantField: -1},
+		{name: "u48 wrong byte order", format: "<u48:0x123456789ABC>", data: []byte{0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC}, wantField: 0, wantReason: "magic number doesn't match"},
+		{name: "u48 is 6 bytes", format: "<u48><u8:0xAA>", data: []byte{1, 2, 3, 4, 5, 6, 0xAA, 0xFF}, wantField: -1},
+		{name: "u48 BE is 6 bytes", format: "<u48><u8:0xAA>", endianness: BigEndian, data: []byte{1, 2, 3, 4, 5, 6, 0xAA, 0xFF}, wantField: -1},
+		{name: "u48 short", format: "<u48>", data: []byte{1, 2, 3, 4, 5}, wantField: 0, wantReason: notEnoughData},
+		{name: "u48 sec in ms", format: "<u48:sec>", opts: []Option{WithTimestampHz(1000)}, data: binary.LittleEndian.AppendUint64(nil, checkNow*1000+999)[:6], wantField: -1},
+		{name: "u48 sec in ms read as seconds", format: "<u48:sec>", data: binary.LittleEndian.AppendUint64(nil, checkNow*1000)[:6], wantField: 0, wantReason: "not within 48 hours of now"},
+		{name: "BE: in an LE format", format: "<BE:u16:0x1234><u16:0x1234>", data: []byte{0x12, 0x34, 0x34, 0x12}, wantField: -1},
+		{name: "LE: in a BE format", format: "<LE:u32:0xA1B2C3D4><u32:0xA1B2C3D4>", endianness: BigEndian, data: []byte{0xD4, 0xC3, 0xB2, 0xA1, 0xA1, 0xB2, 0xC3, 0xD4}, wantField: -1},
+		{name: "BE: with LE bytes", format: "<BE:u16:0x1234>", data: []byte{0x34, 0x12}, wantField: 0, wantReason: "magic number doesn't match"},
+		{name: "BE: u24", format: "<BE:u24:0x123456>", data: []byte{0x12, 0x34, 0x56}, wantField: -1},
+		{name: "BE: u48", format: "<BE:u48:0x123456789ABC>", data: []byte{0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC}, wantField: -1},
+		{name: "BE: u64", format: "<BE:u64:0x0102030405060708>", data: []byte{1, 2, 3, 4, 5, 6, 7, 8}, wantField: -1},
+		{name: "BE: masked", format: "<BE:u16:0xFF00&0x1200>", data: []byte{0x12, 0xAB}, wantField: -1},
+		{name: "BE: length", format: "<BE:u16:length>", data: []byte{0x00, 0x02, 'a', 'b'}, wantField: -1},
+		{name: "LE: length with BE bytes", format: "<LE:u16:length>", data: []byte{0x00, 0x02, 'a', 'b'}, wantField: 0, wantReason: blockPastEnd},
+		{name: "float", format: "<u32:float:-40.0:85.0>", data: float(25.5), wantField: -1},
+		{name: "float min", format: "<u32:float:-40.0:85.0>", data: float(-40), wantField: -1},
+		{name: "float max", format: "<u32:float:-40.0:85.0>", data: float(85), wantField: -1},
+		{name: "float below min", format: "<u32:float:-40.0:85.0>", data: float(-40.5), wantField: 0, wantReason: "float out of range"},
+		{name: "float above max", format: "<u32:float:-40.0:85.0>", data: float(85.5), wantField: 0, wantReason: "float out of range"},
+		{name: "float NaN", format: "<u32:float:-40.0:85.0>", data: float(float32(math.NaN())), wantField: 0, wantReason: "float is NaN or infinite"},
+		{name: "float infinite", format: "<u32:float:-Inf:Inf>", data: float(float32(math.Inf(1))), wantField: 0, wantReason: "float is NaN or infinite"},
+		{name: "float BE", format: "<BE:u32:float:-40.0:85.0>", data: binary.BigEndian.AppendUint32(nil, math.Float32bits(25.3)), wantField: -1},
+		{name: "float wrong byte order", format: "<u32:float:-40.0:85.0>", data: binary.BigEndian.AppendUint32(nil, math.Float32bits(25.3)), wantField: 0, wantReason: "float out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := ParseBlockHeaderFormat(tt.format, tt.endianness, tt.crc16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fb := newTestFileBuffer(t, append(tt.opts, WithBlockFormat(format))...)
+			check := fb.checkBlock(format, tt.data, false, checkNow)
+			if check.field != tt.wantField || check.reason != tt.wantReason {
+				t.Errorf("checkBlock(% X) failed field %d (%q), want %d (%q)", tt.data, check.field, check.reason, tt.wantField, tt.wantReason)
+			}
+		})
+	}
+}
+
+// A conditional skip makes the header longer for the blocks that have the
+// optional bytes, moving the fields after it and so the block's size
+func TestConditionalSkip(t *testing.T) {
+	format, err := ParseBlockHeaderFormat("<u8:0x7E><u8:flags?0x80:skip4><u8:length>", LittleEndian, CRC16CCITT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		data      []byte
+		wantField int
+		wantSize  uint64
+	}{
+		{"not triggered", []byte{0x7E, 0x00, 3, 'a', 'b', 'c'}, -1, 6},
+		{"triggered", []byte{0x7E, 0x80, 0, 0, 0, 0, 3, 'a', 'b', 'c'}, -1, 10},
+		{"triggered by the mask bit only", []byte{0x7E, 0xC1, 9, 9, 9, 9, 1, 'a'}, -1, 8},
+		{"triggered, block incomplete", []byte{0x7E, 0x80, 0, 0, 0, 0, 5, 'a', 'b'}, 2, 0},
+	}
+
+	fb := newTestFileBuffer(t, WithBlockFormat(format))
+	for _, tt := range tests {
+		check := fb.checkBlock(format, tt.data, false, checkNow)
+		if check.field != tt.wantField || check.size != tt.wantSize {
+			t.Errorf("%s: failed field %d (%q), size %d, want field %d, size %d", tt.name, check.field, check.reason, check.size, tt.wantField, tt.wantSize)
+		}
+	}
+
+	// Scanning passes over a header cut short by the next one, whose length
+	// is read from after the optional bytes and runs past the end of the
+	// data, to the complete block
+	discardLog(t)
+	data := append([]byte{0xFF, 0x7E, 0x80, 1}, 0x7E, 0x80, 9, 9, 9, 9, 2, 'h', 'i')
+	fb.streamOffset = int64(len(data))
+	if offset := fb.findBlockHeader(data); offset != 4 {
+		t.Errorf("findBlockHeader found offset %d, want 4", offset)
+	}
+}
+
+// A block header at the end of a read buffer, without all the data its
+// length field claims, isn't a boundary with --require_complete_block
+func TestRequireCompleteBlock(t *testing.T) {
+	noise := bytes.Repeat([]byte{0xFF}, 20)
+	record := pcapRecords(1, 100, 1)
+	tests := []struct {
+		name       string
+		require    bool
+		data       []byte
+		wantOffset int
+	}{
+		{"complete", true, slices.Concat(noise, record), len(noise)},
+		{"one byte short", true, slices.Concat(noise, record[:len(record)-1]), len(noise) + len(record) - 1},
+		{"header only", true, slices.Concat(noise, record[:16]), len(noise) + 16},
+		{"one byte short, not required", false, slices.Concat(noise, record[:len(record)-1]), len(noise)},
+		{"header only, not required", false, slices.Concat(noise, record[:16]), len(noise)},
+	}
+
+	discardLog(t)
+	for _, tt := range tests {
+		fb := newTestFileBuffer(t, WithBlockFormat(presetFormat(t, "pcap")), WithRequireCompleteBlock(tt.require))
+		fb.streamOffset = int64(len(tt.data))
+		if offset := fb.findBlockHeader(tt.data); offset != tt.wantOffset {
+			t.Errorf("%s: findBlockHeader = %d, want %d", tt.name, offset, tt.wantOffset)
+		}
+	}
+}
+
+// TestBlockHeaderAlt scans data where only the second --block_header_alt
+// format matches, and checks the earliest header in any format is found,
+// down to the shortest format's length from the end
+func TestBlockHeaderAlt(t *testing.T) {
+	var formats []*BlockHeaderFormat
+	for _, spec := range []string{"<u32:0xDEADBEEF><u32:length>", "<u32:0x01020304><u16:length>", "<u16:0xCAFE><u8:length>"} {
+		format, err := ParseBlockHeaderFormat(spec, LittleEndian, CRC16CCITT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formats = append(formats, format)
+	}
+	noise := bytes.Repeat([]byte{0xFF}, 10)
+	alt2 := []byte{0xFE, 0xCA, 2, 'h', 'i'}
+	primary := []byte{0xEF, 0xBE, 0xAD, 0xDE, 2, 0, 0, 0, 'h', 'i'}
+	tests := []struct {
+		name       string
+		alts       []*BlockHeaderFormat
+		data       []byte
+		wantOffset int
+		wantFormat string // logged
+	}{
+		{"second alternate", formats[1:], slices.Concat(noise, alt2, noise), 10, "--block_header_alt 2"},
+		{"before the primary format", formats[1:], slices.Concat(noise, alt2, primary), 10, "--block_header_alt 2"},
+		{"after the primary format", formats[1:], slices.Concat(noise, primary, alt2), 10, "--block_header"},
+		{"shorter than the primary format", formats[1:], slices.Concat(noise, []byte{0xFE, 0xCA, 0}), 10, "--block_header_alt 2"},
+		{"without alternates", nil, slices.Concat(noise, alt2, noise), 25, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := captureLog(t)
+			fb := newTestFileBuffer(t, WithBlockFormat(formats[0]), WithBlockFormatAlts(tt.alts...), WithQuiet(false), WithVerbose(true))
+			fb.streamOffset = int64(len(tt.data))
+			if offset := fb.findBlockHeader(tt.data); offset != tt.wantOffset {
+				t.Fatalf("findBlockHeader = %d, want %d", offset, tt.wantOffset)
+			}
+			if tt.wantFormat == "" {
+				return
+			}
+			if want := "Debug: findBlockHeader found a " + tt.wantFormat + " block header at stream offset 10 "; !strings.Contains(log(), want) {
+				t.Errorf("log doesn't contain %q:\n%s", want, log())
+			}
+		})
+	}
+}
+
+// TestBlockValidationStrict runs GzipFileBuffer on random data, where the
+// pcap format never matches, and checks --block_validation_strict exits
+// once more than --strict_miss_threshold rotations in a row find no block
+// header, and without it the data is written anyway
+func TestBlockValidationStrict(t *testing.T) {
+	inChild()
+
+	input := make([]byte, 32*1024)
+	rand.New(rand.NewSource(1)).Read(input)
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"strict", []string{"--block_validation_strict", "--strict_miss_threshold", "2"}, ExitBlockError},
+		{"threshold not reached", []string{"--block_validation_strict", "--strict_miss_threshold", "10"}, 0},
+		{"not strict", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--file_prefix", filepath.Join(t.TempDir(), "out"), "--file_size", "1", "--num_files", "20",
+				"--block_format_preset", "pcap", "--read_buffer_size", "4096", "--max_block_size", "4096", "--quiet"}, tt.args...)
+			code, stderr := runMain(t, string(input), args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			want := "Error: no valid block header in 3 read buffers in a row (--strict_miss_threshold 2), exiting."
+			if exited := code == ExitBlockError; strings.Contains(stderr, want) != exited {
+				t.Errorf("stderr containing %q is %v, want %v:\n%s", want, !exited, exited, stderr)
+			}
+		})
+	}
+
+	// Finding a block header starts the count again
+	discardLog(t)
+	fb := newTestFileBuffer(t, WithBlockFormat(presetFormat(t, "pcap")), WithBlockValidationStrict(true, 1))
+	exitCode := -1
+	fb.exitFn = func(code int) { exitCode = code }
+	for _, found := range []bool{false, true, false, true, false} {
+		fb.checkStrictBlockScan(found)
+	}
+	if exitCode != -1 {
+		t.Fatalf("exited with %d, with no more than one miss in a row", exitCode)
+	}
+	fb.checkStrictBlockScan(false)
+	if exitCode != ExitBlockError {
+		t.Errorf("exit code %d after two misses in a row, want %d", exitCode, ExitBlockError)
+	}
+}
+
+// blockFormatSeeds are valid and malformed block header formats, for fuzzing
+// TestDebugBlockScan writes headers with the timestamp in the wrong byte
+// order, so each candidate's magic number matches but its sec field
+// doesn't, and checks --debug_block_scan logs the first 3 per read buffer
+// with the field that failed and the bytes around them
+func TestDebugBlockScan(t *testing.T) {
+	format, err := ParseBlockHeaderFormat("<u8:0x7E><u32:sec><u16:length>", LittleEndian, CRC16CCITT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 80)
+	for _, offset := range []int{5, 20, 35, 50, 65} {
+		data[offset] = 0x7E
+		binary.BigEndian.PutUint32(data[offset+1:], uint32(time.Now().Unix()))
+	}
+
+	for _, debug := range []bool{false, true} {
+		t.Run(fmt.Sprintf("debug=%v", debug), func(t *testing.T) {
+			logged := captureLog(t)
+			fb := newTestFileBuffer(t, WithBlockFormat(format), WithDebugBlockScan(debug))
+			fb.streamOffset = int64(len(data))
+			if offset := fb.findBlockHeader(data); offset != len(data) {
+				t.Fatalf("found a block header at %d", offset)
+			}
+			fb.streamOffset += int64(len(data))
+			fb.findBlockHeader(data)
+
+			var lines []string
+			for _, line := range strings.Split(logged(), "\n") {
+				if strings.HasPrefix(line, "Debug:") {
+					lines = append(lines, line)
+				}
+			}
+			if !debug {
+				if len(lines) != 0 {
+					t.Errorf("logged %q without --debug_block_scan", lines)
+				}
+				return
+			}
+			var want []string
+			for _, offset := range []int{5, 20, 35, 85, 100, 115} {
+				dump := data[offset%len(data)-4 : offset%len(data)+12]
+				want = append(want,
+					fmt.Sprintf("Debug: --block_header block header candidate at stream offset %d failed field 2 <u32:sec>: not within 48 hours of now", offset),
+					fmt.Sprintf("Debug:   bytes from offset %d: % X", offset-4, dump))
+			}
+			if !slices.Equal(lines, want) {
+				t.Errorf("logged:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+var blockFormatSeeds = []string{
+	// pcap, pcap_ns and pcapng
+	"<u32:sec><u32:usec><u32:length><u32>",
+	"<u32:sec><u32:nsec><u32:length><u32>",
+	"<u32:0x00000006><u32:length><u32:0-65535><u32><u32><u32><u32>",
+	// ERF: timestamp, type, flags, record length, loss counter, wire length
+	"<LE:u64:ntp><u8:0-48><u8><BE:u16:length><BE:u16><BE:u16>",
+	// Every field type
+	"<u64:ntp><u64:nsec_epoch><u16:0xF0&0x40><u8:200><u24:0-100><u48><s16><str4:SHB\\x00><u32:float:-40.0:85.0><u16:crc16>",
+	"<u8:flags?0x80:skip16><u16:length>",
+	"/* sec */ <u32:sec> /* usec */ <u32:usec>",
+	// Edge cases
+	"",
+	"<u8>",
+	"<u32:0xGGGG>",
+	"<u0:sec>",
+	"<<u32:sec>>",
+	"<u32:sec",
+	"<str0:>",
+	"<BE:u8>",
+	"<u16:70000-80000>",
+	"<u32:float:NaN:1>",
+	"/* unclosed <u32>",
+	strings.Repeat("<u64>", 1000),
+}
+
+func FuzzParseBlockHeaderFormat(f *testing.F) {
+	for _, seed := range blockFormatSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, format string) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("ParseBlockHeaderFormat(%q) panicked: %v", format, r)
+			}
+		}()
+		result, err := ParseBlockHeaderFormat(format, LittleEndian, CRC16CCITT)
+		if err != nil {
+			return
+		}
+		if result.TotalBytes <= 0 {
+			t.Errorf("ParseBlockHeaderFormat(%q): TotalBytes = %d", format, result.TotalBytes)
+		}
+		for i, field := range result.Fields {
+			switch {
+			case field.Type == FieldStringMagic:
+				if field.Width != len(field.MagicBytes)*8 || field.Width == 0 {
+					t.Errorf("ParseBlockHeaderFormat(%q): field %d is a %d bit string magic of %d bytes", format, i, field.Width, len(field.MagicBytes))
+				}
+			case field.Width != 8 && field.Width != 16 && field.Width != 24 && field.Width != 32 && field.Width != 48 && field.Width != 64:
+				t.Errorf("ParseBlockHeaderFormat(%q): field %d is %d bits", format, i, field.Width)
+			}
+		}
+	})
+}
+
+// pcapHeaderSeeds are a pcap record header timestamped now, the same with
+// each field one past what's valid, and all-zero and all-0xFF buffers, for
+// fuzzing
+func pcapHeaderSeeds(now time.Time) [][]byte {
+	header := func(sec, usec, length, wireLength uint32) []byte {
+		b := make([]byte, 16)
+		binary.LittleEndian.PutUint32(b[0:], sec)
+		binary.LittleEndian.PutUint32(b[4:], usec)
+		binary.LittleEndian.PutUint32(b[8:], length)
+		binary.LittleEndian.PutUint32(b[12:], wireLength)
+		return b
+	}
+	sec := uint32(now.Unix())
+	return [][]byte{
+		header(sec, 0, 0, 0),
+		header(sec, 999999, 100, 100),
+		header(sec+48*3600+1, 0, 100, 100),
+		header(sec-48*3600-1, 0, 100, 100),
+		header(sec, 1000000, 100, 100),
+		header(sec, 0, defaultBufferSize+1, 100),
+		header(sec, 0, 100, 101),
+		header(sec, 0, 100, 100)[:15],
+		make([]byte, 4),
+		make([]byte, 16),
+		bytes.Repeat([]byte{0xFF}, 4),
+		bytes.Repeat([]byte{0xFF}, 16),
+		nil,
+	}
+}
+
+func FuzzValidateBlockHeader(f *testing.F) {
+	now := time.Now()
+	for _, seed := range pcapHeaderSeeds(now) {
+		f.Add(seed)
+	}
+	pcap := newTestFileBuffer(f, WithBlockFormat(presetFormat(f, "pcap")))
+	secFormat, err := ParseBlockHeaderFormat("<u32:sec>", LittleEndian, CRC16CCITT)
+	if err != nil {
+		f.Fatal(err)
+	}
+	secOnly := newTestFileBuffer(f, WithBlockFormat(secFormat))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("validateBlockHeader(%x) panicked: %v", data, r)
+			}
+		}()
+		for _, fb := range []*FileBuffer{pcap, secOnly} {
+			if fb.validateBlockHeader(data, now.Unix()) && len(data) < fb.blockFormat.TotalBytes {
+				t.Errorf("validateBlockHeader(%x) = true for %d bytes, shorter than the %d byte header", data, len(data), fb.blockFormat.TotalBytes)
+			}
+		}
+		if bytes.Equal(data, make([]byte, 4)) && secOnly.validateBlockHeader(data, now.Unix()) {
+			t.Errorf("validateBlockHeader(%x) = true with a zero sec field", data)
+		}
+	})
+}
+
+// TestBaseBlockHeader checks --base_block_header's fields come before
+// --block_header's and each --block_header_alt's, with the length field's
+// index counted from the start of the combined header
+func TestBaseBlockHeader(t *testing.T) {
+	fb, err := parseArgs(t, "--file_prefix", filepath.Join(t.TempDir(), "test"), "--file_size", "64", "--num_files", "2",
+		"--base_block_header", "<u32:sec><u32:usec>", "--block_header", "<u16:length><u16:0xABCD>", "--block_header_alt", "<u32:0xFEEDFACE>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := fb.blockFormat
+	if spec := formatSpec(format); spec != "<u32:sec><u32:usec><u16:length><u16:0xABCD>" {
+		t.Errorf("block header = %s, want the base fields then the --block_header ones", spec)
+	}
+	if format.TotalBytes != 12 || !format.HasLength || format.LengthIndex != 2 {
+		t.Errorf("TotalBytes %d, HasLength %v, LengthIndex %d, want 12, true, 2", format.TotalBytes, format.HasLength, format.LengthIndex)
+	}
+	if len(fb.blockFormatAlts) != 1 || formatSpec(fb.blockFormatAlts[0]) != "<u32:sec><u32:usec><u32:0xFEEDFACE>" {
+		t.Errorf("alternate formats = %+v, want the base fields then 0xFEEDFACE", fb.blockFormatAlts)
+	}
+
+	header := func(sec uint32, magic uint16) []byte {
+		data := binary.LittleEndian.AppendUint32(nil, sec)
+		data = binary.LittleEndian.AppendUint32(data, 500000)
+		data = binary.LittleEndian.AppendUint16(data, 4)
+		data = binary.LittleEndian.AppendUint16(data, magic)
+		return append(data, "data"...)
+	}
+	tests := []struct {
+		name       string
+		data       []byte
+		wantField  int
+		wantReason string
+	}{
+		{"valid", header(checkNow, 0xABCD), -1, ""},
+		{"base field fails", header(checkNow-49*3600, 0xABCD), 0, "not within 48 hours of now"},
+		{"extension field fails", header(checkNow, 0xABCE), 3, "magic number doesn't match"},
+		{"length past the end", header(checkNow, 0xABCD)[:15], 2, blockPastEnd},
+	}
+	for _, tt := range tests {
+		check := fb.checkBlock(format, tt.data, false, checkNow)
+		if check.field != tt.wantField || check.reason != tt.wantReason {
+			t.Errorf("%s: failed field %d (%q), want %d (%q)", tt.name, check.field, check.reason, tt.wantField, tt.wantReason)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import "fmt"
+
+// OnRotate registers cb to be called each time a new file is opened, with
+// the path of the file just closed (empty for the first file) and the new one.
+// It's called once the FileBuffer's lock is released, so it can call back
+// into the FileBuffer, e.g. for Stats.
+func (fb *FileBuffer) OnRotate(cb func(closed, opened string)) {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	fb.rotateCallbacks = append(fb.rotateCallbacks, cb)
+}
+
+// OnDelete registers cb to be called with the path of each file deleted, once
+// the FileBuffer's lock is released
+func (fb *FileBuffer) OnDelete(cb func(deleted string)) {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	fb.deleteCallbacks = append(fb.deleteCallbacks, cb)
+}
+
+// notifyRotate queues the rotate callbacks, which unlock runs. Called with
+// the lock held.
+func (fb *FileBuffer) notifyRotate(closed, opened string) {
+	for _, cb := range fb.rotateCallbacks {
+		fb.pendingCallbacks = append(fb.pendingCallbacks, func() {
+			runCallback("rotate", func() { cb(closed, opened) })
+		})
+	}
+}
+
+// notifyDelete queues the delete callbacks, which unlock runs. Called with
+// the lock held.
+func (fb *FileBuffer) notifyDelete(deleted string) {
+	for _, cb := range fb.deleteCallbacks {
+		fb.pendingCallbacks = append(fb.pendingCallbacks, func() {
+			runCallback("delete", func() { cb(deleted) })
+		})
+	}
+}
+
+// unlock releases the lock, then runs the callbacks queued while it was
+// held. Running them outside the lock means a callback calling back into
+// the FileBuffer doesn't deadlock.
+func (fb *FileBuffer) unlock() {
+	callbacks := fb.pendingCallbacks
+	fb.pendingCallbacks = nil
+	fb.mu.Unlock()
+
+	for _, cb := range callbacks {
+		cb()
+	}
+}
+
+// runCallback calls cb, recovering from any panic so one bad callback can't
+// take down the process
+func runCallback(event string, cb func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(logOutput, "Warning: %s callback panicked: %v\n", event, r)
+		}
+	}()
+	cb()
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// rotatingFileBuffer makes a FileBuffer that rotates every few KB of random
+// input, keeping 2 files
+func rotatingFileBuffer(t *testing.T) (*FileBuffer, []byte) {
+	t.Helper()
+	fb := newTestFileBuffer(t,
+		WithReadBufferSize(4096),
+		WithMaxBlockSize(4096),
+		WithMaxFileSize(8192),
+		WithMaxNumFiles(2),
+	)
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	return fb, data
+}
+
+func TestCallbacks(t *testing.T) {
+	fb, data := rotatingFileBuffer(t)
+
+	var rotated, rotated2 []string
+	var deleted []string
+	fb.OnRotate(func(closed, opened string) { rotated = append(rotated, opened) })
+	fb.OnRotate(func(closed, opened string) { rotated2 = append(rotated2, opened) })
+	fb.OnDelete(func(path string) { deleted = append(deleted, path) })
+
+	if err := fb.WriteFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+
+	created := int(fb.Stats().FilesCreated)
+	if len(rotated) != created {
+		t.Errorf("first rotate callback called %d times, want %d", len(rotated), created)
+	}
+	if !slices.Equal(rotated, rotated2) {
+		t.Errorf("rotate callbacks saw different files: %v and %v", rotated, rotated2)
+	}
+	if want := created - 2; len(deleted) != want {
+		t.Errorf("delete callback called %d times, want %d", len(deleted), want)
+	}
+	if len(rotated) > 0 && len(deleted) > 0 && deleted[0] != rotated[0] {
+		t.Errorf("first deleted file is %s, want the first file %s", deleted[0], rotated[0])
+	}
+}
+
+func TestCallbackPanic(t *testing.T) {
+	fb, data := rotatingFileBuffer(t)
+
+	calls := 0
+	fb.OnRotate(func(closed, opened string) { panic("bad callback") })
+	fb.OnRotate(func(closed, opened string) { calls++ })
+	fb.OnDelete(func(path string) { panic("bad callback") })
+
+	if err := fb.WriteFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+	if want := int(fb.Stats().FilesCreated); calls != want {
+		t.Errorf("callback after a panicking one called %d times, want %d", calls, want)
+	}
+}
+
+// Callbacks run after the lock is released, so they can call back into the
+// FileBuffer without deadlocking
+func TestCallbackReentry(t *testing.T) {
+	fb, data := rotatingFileBuffer(t)
+
+	var files []int64
+	fb.OnRotate(func(closed, opened string) {
+		files = append(files, fb.Stats().FilesCreated)
+		fb.OnDelete(func(string) {})
+	})
+	fb.OnDelete(func(path string) { fb.Stats() })
+
+	if err := fb.WriteFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+	if len(files) == 0 || files[0] != 1 {
+		t.Errorf("Stats from the rotate callbacks: %v, want to start at 1", files)
+	}
+}
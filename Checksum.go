@@ -0,0 +1,129 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// With --embed_checksum the gzip header comment of each file is
+// "sha256=" and the hex SHA-256 of its uncompressed contents. The header
+// is written before the contents, so it starts out with a placeholder of
+// the same length that's overwritten in place once the file is closed.
+const checksumCommentPrefix = "sha256="
+
+var checksumPlaceholder = checksumCommentPrefix + strings.Repeat("0", sha256.Size*2)
+
+// resetChecksum starts the checksum of a new file, and gives its gzip
+// header the placeholder comment
+func (fb *FileBuffer) resetChecksum(z gzipStream) {
+	if !fb.embedChecksum {
+		return
+	}
+	fb.checksum = sha256.New()
+	setChecksumPlaceholder(z, true)
+}
+
+// setChecksumPlaceholder sets the placeholder comment in z's header, if enabled
+func setChecksumPlaceholder(z gzipStream, enabled bool) {
+	if !enabled {
+		return
+	}
+	switch z := z.(type) {
+	case *gzip.Writer:
+		z.Comment = checksumPlaceholder
+	case *deflateGzipWriter:
+		z.comment = checksumPlaceholder
+	}
+}
+
+// updateChecksum adds data written to the current file to its checksum
+func (fb *FileBuffer) updateChecksum(data []byte) {
+	if fb.checksum != nil {
+		fb.checksum.Write(data)
+	}
+}
+
+// finishChecksum writes the checksum over the placeholder in the header of
+// the closed gzip file f
+func (fb *FileBuffer) finishChecksum(f *os.File) error {
+	if fb.checksum == nil {
+		return nil
+	}
+	comment := checksumCommentPrefix + hex.EncodeToString(fb.checksum.Sum(nil))
+
+	offset, err := gzipCommentOffset(f)
+	if err != nil {
+		return err
+	}
+	placeholder := make([]byte, len(checksumPlaceholder))
+	if _, err := f.ReadAt(placeholder, offset); err != nil {
+		return err
+	}
+	if string(placeholder) != checksumPlaceholder {
+		return fmt.Errorf("checksum placeholder not found in the gzip header")
+	}
+	_, err = f.WriteAt([]byte(comment), offset)
+	return err
+}
+
+// gzipCommentOffset returns where the comment starts in the gzip header at
+// the start of f
+func gzipCommentOffset(f io.ReaderAt) (int64, error) {
+	const (
+		flagExtra   = 0x04
+		flagName    = 0x08
+		flagComment = 0x10
+	)
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if header[0] != 0x1f || header[1] != 0x8b || header[3]&flagComment == 0 {
+		return 0, fmt.Errorf("no gzip header comment")
+	}
+	offset := int64(10)
+	if header[3]&flagExtra != 0 {
+		xlen := make([]byte, 2)
+		if _, err := f.ReadAt(xlen, offset); err != nil {
+			return 0, err
+		}
+		offset += 2 + int64(xlen[0]) + int64(xlen[1])<<8
+	}
+	if header[3]&flagName != 0 {
+		// The name is NUL terminated
+		buf := make([]byte, 256)
+		for {
+			n, err := f.ReadAt(buf, offset)
+			if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+				offset += int64(i) + 1
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			offset += int64(n)
+		}
+	}
+	return offset, nil
+}
+
+// checksumFromComment returns the SHA-256 in a gzip comment written with
+// --embed_checksum, if it is one. A placeholder left by a file that was
+// never closed doesn't count.
+func checksumFromComment(comment string) (string, bool) {
+	if comment == checksumPlaceholder {
+		return "", false
+	}
+	sum, ok := strings.CutPrefix(comment, checksumCommentPrefix)
+	return sum, ok && len(sum) == sha256.Size*2
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readChecksumFile returns the contents of a gzip file and the comment in
+// its first header
+func readChecksumFile(t *testing.T, path string) ([]byte, string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	comment := z.Header.Comment
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return data, comment
+}
+
+// TestEmbedChecksum writes known data to several files and checks each
+// one's gzip comment is the SHA-256 of what was written to it
+func TestEmbedChecksum(t *testing.T) {
+	mirrorDir := t.TempDir()
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"plain", nil},
+		{"multistream", []Option{WithGzipMultistream(true)}},
+		{"mirrored", []Option{WithMirrorDir(mirrorDir)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := newTestFileBuffer(t, append([]Option{WithEmbedChecksum(true), WithMaxNumFiles(3)}, tt.opts...)...)
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			var written [][]byte
+			for i := range 3 {
+				if i > 0 {
+					if _, err := fb.Rotate(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				data := syntheticLog(16*1024 + i)
+				fb.write(data[:8*1024])
+				fb.flush()
+				fb.write(data[8*1024:])
+				written = append(written, data)
+			}
+			fb.close()
+
+			for i, path := range fb.activeFiles {
+				sum := sha256.Sum256(written[i])
+				want := "sha256=" + hex.EncodeToString(sum[:])
+				paths := []string{path}
+				if tt.name == "mirrored" {
+					paths = append(paths, filepath.Join(mirrorDir, filepath.Base(path)))
+				}
+				for _, path := range paths {
+					data, comment := readChecksumFile(t, path)
+					if !bytes.Equal(data, written[i]) {
+						t.Errorf("%s has %d bytes, want the %d written", path, len(data), len(written[i]))
+					}
+					if comment != want {
+						t.Errorf("%s comment = %q, want %q", path, comment, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestVerifyChecksum checks verification reports a file that doesn't match
+// its embedded checksum, but ignores the placeholder of one never closed
+func TestVerifyChecksum(t *testing.T) {
+	fb := newTestFileBuffer(t, WithEmbedChecksum(true))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	fb.write(syntheticLog(4096))
+	fb.close()
+	path := fb.activeFiles[0]
+	if _, err := verifyGzipFile(path); err != nil {
+		t.Fatalf("intact file: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, comment := readChecksumFile(t, path)
+	offset := bytes.Index(data, []byte(comment))
+	replace := func(comment string) {
+		t.Helper()
+		copy(data[offset:], comment)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A gzip comment isn't covered by the CRC-32, so only the checksum
+	// notices it's changed
+	replace("sha256=" + strings.Repeat("1", 64))
+	if _, err := verifyGzipFile(path); err == nil || !strings.Contains(err.Error(), "but the embedded checksum is 1111") {
+		t.Errorf("altered checksum: got error %v", err)
+	}
+	replace(checksumPlaceholder)
+	if _, err := verifyGzipFile(path); err != nil {
+		t.Errorf("placeholder: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// companionPath is the uncompressed --dual_output copy of filename, which is
+// the same name without the output extension
+func (fb *FileBuffer) companionPath(filename string) string {
+	return strings.TrimSuffix(filename, fb.outputExtension)
+}
+
+// openCompanion creates the uncompressed copy of filename. Failure isn't
+// fatal, the gzip file carries on without one.
+func (fb *FileBuffer) openCompanion(filename string) {
+	if !fb.dualOutput {
+		return
+	}
+
+	path := fb.companionPath(filename)
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to create uncompressed file %s: %v\n", path, err)
+		return
+	}
+	if fb.dualOutputPerms != 0 {
+		if err := f.Chmod(fb.dualOutputPerms); err != nil {
+			fmt.Fprintf(logOutput, "Warning: failed to set permissions on uncompressed file %s: %v\n", path, err)
+		}
+	}
+	fb.companionFile = f
+
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Created uncompressed file: %s\n", path)
+	}
+}
+
+// writeCompanion copies data to the uncompressed file, giving up on it for
+// the rest of the current file if the write fails
+func (fb *FileBuffer) writeCompanion(data []byte) {
+	if fb.companionFile == nil {
+		return
+	}
+
+	if _, err := fb.companionFile.Write(data); err != nil {
+		fmt.Fprintf(logOutput, "Warning: write to uncompressed file %s failed, disabling it for this file: %v\n", fb.companionFile.Name(), err)
+		fb.closeCompanion()
+	}
+}
+
+func (fb *FileBuffer) closeCompanion() {
+	if fb.companionFile == nil {
+		return
+	}
+	if err := fb.companionFile.Close(); err != nil {
+		fmt.Fprintf(logOutput, "Warning: error closing uncompressed file: %v\n", err)
+	}
+	fb.companionFile = nil
+}
+
+// removeCompanion deletes the uncompressed copy of a file being deleted
+func (fb *FileBuffer) removeCompanion(filename string) {
+	if !fb.dualOutput {
+		return
+	}
+
+	path := fb.companionPath(filename)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(logOutput, "Warning: failed to delete uncompressed file %s: %v\n", path, err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestDualOutput checks each gzip file decompresses to its uncompressed
+// companion, which has --dual_output_perms and is deleted along with it
+func TestDualOutput(t *testing.T) {
+	fb := newTestFileBuffer(t, WithDualOutput(true, 0640), WithMaxNumFiles(2))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	var opened []string
+	for i := range 3 {
+		if i > 0 {
+			if _, err := fb.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		opened = append(opened, fb.currentFileName)
+		fb.write(syntheticLog(32*1024 + i))
+	}
+	fb.close()
+
+	for _, path := range opened[1:] {
+		companion := strings.TrimSuffix(path, ".gz")
+		plain, err := os.ReadFile(companion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readGzipFiles(t, []string{path}); !bytes.Equal(got[0], plain) || len(plain) == 0 {
+			t.Errorf("%s decompresses to %d bytes, but %s has %d", path, len(got[0]), companion, len(plain))
+		}
+		info, err := os.Stat(companion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0640 && runtime.GOOS != "windows" {
+			t.Errorf("%s has permissions %04o, want 0640", companion, perm)
+		}
+	}
+	if _, err := os.Stat(strings.TrimSuffix(opened[0], ".gz")); !os.IsNotExist(err) {
+		t.Errorf("companion of deleted %s wasn't deleted: %v", opened[0], err)
+	}
+}
+
+// A companion that can't be written is dropped, and the gzip file carries on
+func TestDualOutputFailure(t *testing.T) {
+	discardLog(t)
+	fb := newTestFileBuffer(t, WithDualOutput(true, 0))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	fb.companionFile.Close()
+	fb.write([]byte("gzip only\n"))
+	if fb.companionFile != nil {
+		t.Error("companion still enabled after a failed write")
+	}
+	fb.close()
+	if got := readGzipFiles(t, []string{fb.currentFileName}); string(got[0]) != "gzip only\n" {
+		t.Errorf("gzip file has %q, want %q", got[0], "gzip only\n")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// findConfigArg scans the raw command line (then the environment) for
+// --config, since it has to be applied before the rest of the flags are parsed
+func findConfigArg(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if value, ok := strings.CutPrefix(name, "config="); ok {
+			return value
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(envVarName("config"))
+}
+
+// loadConfigFile reads TOML/INI style key = value pairs and applies them as
+// flag values. Keys are the flag names. Flags given on the command line are
+// parsed afterwards, so they override anything set here.
+func loadConfigFile(path string) error {
+	return readKeyValueFile(path, func(key, value string) error {
+		if key == "config" || flag.Lookup(key) == nil {
+			return fmt.Errorf("unknown key: %s", key)
+		}
+		if err := flag.Set(key, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+		return nil
+	})
+}
+
+// readKeyValueFile calls fn for each key = value line of a TOML/INI style
+// file, skipping blank lines, comments and section headers. Errors are
+// prefixed with the file name and line number.
+func readKeyValueFile(path string, fn func(key, value string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip blank lines, comments and section headers
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '[' {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, lineNum)
+		}
+		key = strings.TrimSpace(key)
+		value = unquoteConfigValue(strings.TrimSpace(value))
+
+		if err := fn(key, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// envPrefix is prepended to the upper-cased option name to form its
+// environment variable, e.g. GZFB_FILE_SIZE for --file_size
+const envPrefix = "GZFB_"
+
+func envVarName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvOverrides sets any flag that has a matching GZFB_ environment
+// variable. Command line flags are parsed afterwards and take precedence.
+// Returns the number of variables applied.
+func applyEnvOverrides() (int, error) {
+	var errs []string
+	applied := 0
+	flag.VisitAll(func(f *flag.Flag) {
+		name := envVarName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := flag.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q for %s: %v", value, name, err))
+			return
+		}
+		applied++
+	})
+	if len(errs) > 0 {
+		return applied, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return applied, nil
+}
+
+func unquoteConfigValue(value string) string {
+	if len(value) >= 2 {
+		first, last := value[0], value[len(value)-1]
+		if (first == '"' || first == '\'') && first == last {
+			return value[1 : len(value)-1]
+		}
+	}
+	// Strip trailing comment from unquoted values
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
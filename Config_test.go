@@ -0,0 +1,118 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestConfigFile sets several options from a config file, with the quoting,
+// comments and sections it allows
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "capture.pcap")
+	config := filepath.Join(dir, "gzfb.conf")
+	contents := `# GzipFileBuffer settings
+[files]
+file_prefix = "` + prefix + `"
+file_size = 512   # KB
+num_files=7
+time_format = '20060102 150405'
+
+; compression
+[gzip]
+compression_level = 9
+split_on_newline = true
+quiet = true
+`
+	if err := os.WriteFile(config, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fb, err := parseArgs(t, "--config", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.filePrefix != prefix {
+		t.Errorf("filePrefix = %q, want %q", fb.filePrefix, prefix)
+	}
+	if fb.maxFileSize != 512*1024 {
+		t.Errorf("maxFileSize = %d, want %d", fb.maxFileSize, 512*1024)
+	}
+	if fb.maxNumFiles != 7 {
+		t.Errorf("maxNumFiles = %d, want 7", fb.maxNumFiles)
+	}
+	if fb.timeFormat != "20060102 150405" {
+		t.Errorf("timeFormat = %q, want %q", fb.timeFormat, "20060102 150405")
+	}
+	if fb.compressionLevel != 9 || !fb.splitOnNewline || !fb.quiet {
+		t.Errorf("compressionLevel %d, splitOnNewline %v, quiet %v, want 9, true, true", fb.compressionLevel, fb.splitOnNewline, fb.quiet)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name, contents, wantErr string
+	}{
+		{"unknown key", "num_files = 2\nfile_sise = 64\n", ":2: unknown key: file_sise"},
+		{"config key", "config = other.conf\n", ":1: unknown key: config"},
+		{"bad value", "\n\nnum_files = two\n", ":3: invalid value for num_files"},
+		{"no value", "[files]\nquiet\n", ":2: expected key = value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := filepath.Join(t.TempDir(), "gzfb.conf")
+			if err := os.WriteFile(config, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := parseArgs(t, "--config", config, "--file_prefix", "test", "--file_size", "64", "--num_files", "2")
+			if want := "--config: " + config + tt.wantErr; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("got error %v, want %q", err, want)
+			}
+		})
+	}
+}
+
+// TestEnvOverrides checks GZFB_ variables set options, the command line
+// overrides them, and bad values name the variable
+func TestEnvOverrides(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	t.Setenv("GZFB_FILE_PREFIX", prefix)
+	t.Setenv("GZFB_FILE_SIZE", "32")
+	t.Setenv("GZFB_NUM_FILES", "4")
+	t.Setenv("GZFB_SPLIT_ON_NEWLINE", "true")
+
+	// The environment is enough on its own, with no arguments at all
+	fb, err := parseArgs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.filePrefix != prefix || fb.maxFileSize != 32*1024 || fb.maxNumFiles != 4 || !fb.splitOnNewline {
+		t.Errorf("got prefix %q, size %d, %d files, split %v, want %q, %d, 4, true",
+			fb.filePrefix, fb.maxFileSize, fb.maxNumFiles, fb.splitOnNewline, prefix, 32*1024)
+	}
+
+	fb, err = parseArgs(t, "--num_files", "8", "--split_on_newline=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.maxNumFiles != 8 || fb.splitOnNewline || fb.maxFileSize != 32*1024 {
+		t.Errorf("got %d files, split %v, size %d, want the command line's 8, false and the environment's %d",
+			fb.maxNumFiles, fb.splitOnNewline, fb.maxFileSize, 32*1024)
+	}
+
+	t.Setenv("GZFB_COMPRESSION_LEVEL", "fast")
+	t.Setenv("GZFB_QUIET", "maybe")
+	_, err = parseArgs(t)
+	for _, want := range []string{`invalid value "fast" for GZFB_COMPRESSION_LEVEL`, `invalid value "maybe" for GZFB_QUIET`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+}
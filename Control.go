@@ -0,0 +1,81 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// maxControlBodyBytes limits the JSON body of a control request
+const maxControlBodyBytes = 4096
+
+// startControlServer serves runtime controls on --control_addr. Listening
+// happens up front so a bad address is reported straight away.
+//
+//	POST /compression {"level": N}  sets the compression level for the next file
+//	POST /rotate                    closes the current file and opens the next
+func (fb *FileBuffer) startControlServer() error {
+	ln, err := net.Listen("tcp", fb.controlAddr)
+	if err != nil {
+		return fmt.Errorf("--control_addr: %w", err)
+	}
+	mux := fb.controlHandler()
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			fmt.Fprintf(logOutput, "Error: control server stopped: %v\n", err)
+		}
+	}()
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Serving controls on http://%s/\n", ln.Addr())
+	}
+	return nil
+}
+
+// controlHandler routes the control requests
+func (fb *FileBuffer) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /compression", fb.handleCompression)
+	mux.HandleFunc("POST /rotate", fb.handleRotate)
+	return mux
+}
+
+func (fb *FileBuffer) handleCompression(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level *int `json:"level"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxControlBodyBytes)).Decode(&req); err != nil || req.Level == nil {
+		http.Error(w, `expected a JSON body like {"level": 6}`, http.StatusBadRequest)
+		return
+	}
+	if err := fb.SetCompressionLevel(*req.Level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Compression level set to %d by %s, from the next file\n", *req.Level, r.RemoteAddr)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRotate rotates now, replying with the file that was closed as
+// {"closed": "path"}
+func (fb *FileBuffer) handleRotate(w http.ResponseWriter, r *http.Request) {
+	closed, err := fb.Rotate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Rotated by %s, closed %s\n", r.RemoteAddr, closed)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Closed string `json:"closed"`
+	}{closed})
+}
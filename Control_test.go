@@ -0,0 +1,83 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestControlRotate(t *testing.T) {
+	fb := newTestFileBuffer(t)
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	first := fb.currentFileName
+	srv := httptest.NewServer(fb.controlHandler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/rotate", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		Closed string `json:"closed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || reply.Closed != first {
+		t.Errorf("status %d, closed %q, want %d and %q", resp.StatusCode, reply.Closed, http.StatusOK, first)
+	}
+	if fb.currentFileName == first || len(fb.activeFiles) != 2 {
+		t.Errorf("current file %q of %q, want a second file", fb.currentFileName, fb.activeFiles)
+	}
+	fb.close()
+
+	resp, err = http.Get(srv.URL + "/rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /rotate: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+// POST /compression sets the level for the next file, and rejects a bad
+// or missing one
+func TestControlCompression(t *testing.T) {
+	fb := newTestFileBuffer(t)
+	srv := httptest.NewServer(fb.controlHandler())
+	defer srv.Close()
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantLevel  int
+	}{
+		{`{"level": 1}`, http.StatusNoContent, 1},
+		{`{"level": 10}`, http.StatusBadRequest, 1},
+		{`{}`, http.StatusBadRequest, 1},
+		{`{"level": -1}`, http.StatusNoContent, -1},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(srv.URL+"/compression", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.body, resp.StatusCode, tt.wantStatus)
+		}
+		if fb.compressionLevel != tt.wantLevel {
+			t.Errorf("%s: compression level %d, want %d", tt.body, fb.compressionLevel, tt.wantLevel)
+		}
+	}
+}
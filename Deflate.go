@@ -0,0 +1,496 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"compress/gzip"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// deflater is the deflate stream under a deflateGzipWriter: a *flate.Writer
+// or a windowDeflater
+type deflater interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+const (
+	deflateBlockSize = 64 * 1024 // input compressed per deflate block
+	deflateMinMatch  = 3
+	deflateMaxMatch  = 258
+	deflateTooFar    = 4096 // farthest a 3 byte match is worth coding, as in zlib
+)
+
+// Per compression level 1-9, how many hash chain entries are tried for a
+// match and the length that's good enough to stop looking, from zlib
+var (
+	deflateMaxChain = [10]int{0, 4, 8, 32, 16, 32, 128, 256, 1024, 4096}
+	deflateNiceLen  = [10]int{0, 8, 16, 32, 16, 32, 128, 128, 258, 258}
+)
+
+// The base values and extra bits of the length (257-285) and distance
+// (0-29) codes, RFC 1951 section 3.2.5
+var (
+	lengthBase  = []uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = []uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = []uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = []uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// codeLengthOrder is the order code length code lengths are sent in
+	codeLengthOrder = []int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+// windowDeflater is a deflate compressor with zlib's window_bits and
+// mem_level, which compress/flate fixes at 15 and 8: matches are looked for
+// in the last 1<<windowBits bytes, through a hash table of 1<<(memLevel+7)
+// chains. It's simpler than zlib (greedy matching, and Huffman codes limited
+// by halving frequencies), so it's only used when they aren't the defaults.
+type windowDeflater struct {
+	w          io.Writer
+	level      int
+	windowSize int
+	hashBits   uint
+	dict       []byte // primes the window on Reset, nil for none
+
+	hist   []byte  // the window: the end of the input compressed so far
+	in     []byte  // input not compressed yet
+	buf    []byte  // hist then the block being compressed
+	head   []int32 // per hash, the last position in buf with it, plus one
+	prev   []int32 // per position in the window, the one before with its hash, plus one
+	tokens []uint32
+	bw     bitWriter
+	err    error
+}
+
+// newWindowDeflater returns a deflate writer to w at level (-1 to 9), with
+// a window of 1<<windowBits bytes (9-15) and 1<<(memLevel+7) hash chains
+// (memLevel 1-9), primed with the end of dict
+func newWindowDeflater(w io.Writer, level, windowBits, memLevel int, dict []byte) *windowDeflater {
+	if level == gzip.DefaultCompression {
+		level = 6
+	}
+	z := &windowDeflater{
+		level:      level,
+		windowSize: 1 << windowBits,
+		hashBits:   uint(memLevel + 7),
+		dict:       dict,
+	}
+	z.head = make([]int32, 1<<z.hashBits)
+	z.prev = make([]int32, z.windowSize)
+	z.Reset(w)
+	return z
+}
+
+// Reset discards any pending input and starts a new stream on w
+func (z *windowDeflater) Reset(w io.Writer) {
+	z.w = w
+	z.hist = append(z.hist[:0], z.dict[max(0, len(z.dict)-z.windowSize):]...)
+	z.in = z.in[:0]
+	z.bw = bitWriter{out: z.bw.out[:0]}
+	z.err = nil
+}
+
+func (z *windowDeflater) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.in = append(z.in, p...)
+	if len(z.in) >= deflateBlockSize {
+		n := len(z.in) / deflateBlockSize * deflateBlockSize
+		for i := 0; i < n; i += deflateBlockSize {
+			z.compress(z.in[i:i+deflateBlockSize], false)
+		}
+		z.in = z.in[:copy(z.in, z.in[n:])]
+		z.writeOut()
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	return len(p), nil
+}
+
+// Flush compresses any pending input and ends with an empty stored block,
+// so everything written so far can be decompressed (a zlib sync flush)
+func (z *windowDeflater) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.in) > 0 {
+		z.compress(z.in, false)
+		z.in = z.in[:0]
+	}
+	z.writeStored(nil, false)
+	z.writeOut()
+	return z.err
+}
+
+// Close compresses any pending input as the final block
+func (z *windowDeflater) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	z.compress(z.in, true)
+	z.in = z.in[:0]
+	z.bw.align()
+	z.writeOut()
+	return z.err
+}
+
+func (z *windowDeflater) writeOut() {
+	if z.err == nil && len(z.bw.out) > 0 {
+		_, z.err = z.w.Write(z.bw.out)
+	}
+	z.bw.out = z.bw.out[:0]
+}
+
+// compress writes block as one or more deflate blocks, the last of them
+// final if final is set
+func (z *windowDeflater) compress(block []byte, final bool) {
+	if z.level == 0 || len(block) == 0 {
+		z.writeStored(block, final)
+		return
+	}
+
+	z.buf = append(append(z.buf[:0], z.hist...), block...)
+	z.findMatches(len(z.hist))
+
+	// Keep the Huffman coded block unless storing it is smaller
+	pending, n, size := z.bw.bits, z.bw.n, len(z.bw.out)
+	z.writeHuffman(final)
+	if len(z.bw.out)-size > len(block)+5 {
+		z.bw.bits, z.bw.n, z.bw.out = pending, n, z.bw.out[:size]
+		z.writeStored(block, final)
+	}
+
+	z.hist = append(z.hist[:0], z.buf[max(0, len(z.buf)-z.windowSize):]...)
+}
+
+func (z *windowDeflater) hash(i int) uint32 {
+	b := z.buf[i : i+3]
+	return (uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])) * 0x9E3779B1 >> (32 - z.hashBits)
+}
+
+func (z *windowDeflater) insert(i int) {
+	if i+deflateMinMatch <= len(z.buf) {
+		h := z.hash(i)
+		z.prev[i&(z.windowSize-1)] = z.head[h]
+		z.head[h] = int32(i + 1)
+	}
+}
+
+// findMatches turns buf[start:] into tokens, literals and matches into the
+// window before them: a literal byte is its value, a match is
+// 1<<31 | length<<16 | distance
+func (z *windowDeflater) findMatches(start int) {
+	clear(z.head)
+	for i := range start {
+		z.insert(i)
+	}
+	z.tokens = z.tokens[:0]
+	maxChain, niceLen := deflateMaxChain[z.level], deflateNiceLen[z.level]
+	for i := start; i < len(z.buf); {
+		best, dist := 0, 0
+		if i+deflateMinMatch <= len(z.buf) {
+			limit := min(deflateMaxMatch, len(z.buf)-i)
+			cand := int(z.head[z.hash(i)]) - 1
+			for chain := maxChain; cand >= 0 && cand >= i-z.windowSize && chain > 0; chain-- {
+				n := matchLen(z.buf[cand:cand+limit], z.buf[i:i+limit])
+				if n > best {
+					best, dist = n, i-cand
+					if n >= niceLen {
+						break
+					}
+				}
+				cand = int(z.prev[cand&(z.windowSize-1)]) - 1
+			}
+		}
+		if best < deflateMinMatch || (best == deflateMinMatch && dist > deflateTooFar) {
+			z.tokens = append(z.tokens, uint32(z.buf[i]))
+			z.insert(i)
+			i++
+			continue
+		}
+		z.tokens = append(z.tokens, 1<<31|uint32(best)<<16|uint32(dist))
+		for end := i + best; i < end; i++ {
+			z.insert(i)
+		}
+	}
+}
+
+func matchLen(a, b []byte) int {
+	for i := range b {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// baseIndex is the index of the code whose base covers v
+func baseIndex(base []uint16, v int) int {
+	return sort.Search(len(base), func(i int) bool { return int(base[i]) > v }) - 1
+}
+
+// writeHuffman writes the tokens as a block with dynamic Huffman codes
+func (z *windowDeflater) writeHuffman(final bool) {
+	litFreq, distFreq := make([]int, 286), make([]int, 30)
+	for _, t := range z.tokens {
+		if t < 1<<31 {
+			litFreq[t]++
+		} else {
+			litFreq[257+baseIndex(lengthBase, int(t>>16&0x7FFF))]++
+			distFreq[baseIndex(distBase, int(t&0xFFFF))]++
+		}
+	}
+	litFreq[256] = 1
+	litLens := huffmanLengths(atLeastTwo(litFreq), 15)
+	distLens := huffmanLengths(atLeastTwo(distFreq), 15)
+	litCodes, distCodes := huffmanCodes(litLens), huffmanCodes(distLens)
+
+	hlit, hdist := max(257, trimmedLen(litLens)), max(1, trimmedLen(distLens))
+	lens := rleCodeLengths(append(append([]uint8(nil), litLens[:hlit]...), distLens[:hdist]...))
+	clFreq := make([]int, 19)
+	for _, l := range lens {
+		clFreq[l&0x1F]++
+	}
+	clLens := huffmanLengths(atLeastTwo(clFreq), 7)
+	clCodes := huffmanCodes(clLens)
+	hclen := 19
+	for hclen > 4 && clLens[codeLengthOrder[hclen-1]] == 0 {
+		hclen--
+	}
+
+	z.bw.write(boolBit(final), 1)
+	z.bw.write(2, 2)
+	z.bw.write(uint32(hlit-257), 5)
+	z.bw.write(uint32(hdist-1), 5)
+	z.bw.write(uint32(hclen-4), 4)
+	for _, sym := range codeLengthOrder[:hclen] {
+		z.bw.write(uint32(clLens[sym]), 3)
+	}
+	for _, l := range lens {
+		sym := l & 0x1F
+		z.bw.write(uint32(clCodes[sym]), uint(clLens[sym]))
+		switch sym {
+		case 16:
+			z.bw.write(uint32(l>>5), 2)
+		case 17:
+			z.bw.write(uint32(l>>5), 3)
+		case 18:
+			z.bw.write(uint32(l>>5), 7)
+		}
+	}
+
+	for _, t := range z.tokens {
+		if t < 1<<31 {
+			z.bw.write(uint32(litCodes[t]), uint(litLens[t]))
+			continue
+		}
+		length, dist := int(t>>16&0x7FFF), int(t&0xFFFF)
+		lc, dc := baseIndex(lengthBase, length), baseIndex(distBase, dist)
+		z.bw.write(uint32(litCodes[257+lc]), uint(litLens[257+lc]))
+		z.bw.write(uint32(length-int(lengthBase[lc])), uint(lengthExtra[lc]))
+		z.bw.write(uint32(distCodes[dc]), uint(distLens[dc]))
+		z.bw.write(uint32(dist-int(distBase[dc])), uint(distExtra[dc]))
+	}
+	z.bw.write(uint32(litCodes[256]), uint(litLens[256]))
+}
+
+// writeStored writes data as stored blocks of up to 65535 bytes, or one
+// empty one if there's no data
+func (z *windowDeflater) writeStored(data []byte, final bool) {
+	for {
+		n := min(len(data), 0xFFFF)
+		z.bw.write(boolBit(final && n == len(data)), 1)
+		z.bw.write(0, 2)
+		z.bw.align()
+		size := uint16(n)
+		z.bw.out = append(z.bw.out, byte(size), byte(size>>8), byte(^size), byte(^size>>8))
+		z.bw.out = append(z.bw.out, data[:n]...)
+		if data = data[n:]; len(data) == 0 {
+			return
+		}
+	}
+}
+
+// rleCodeLengths run length codes lengths with the code length alphabet
+// (RFC 1951 section 3.2.7). Each entry is a symbol (0-18) in the low 5 bits
+// and its repeat count's extra bits value above them.
+func rleCodeLengths(lengths []uint8) []uint16 {
+	var out []uint16
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				out = append(out, 18|uint16(n-11)<<5)
+				run -= n
+			}
+			if run >= 3 {
+				out = append(out, 17|uint16(run-3)<<5)
+				run = 0
+			}
+		} else {
+			out = append(out, uint16(l))
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				out = append(out, 16|uint16(n-3)<<5)
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			out = append(out, uint16(l))
+		}
+	}
+	return out
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for freq, none
+// longer than maxBits: if the optimal code has longer ones the frequencies
+// are halved until it doesn't
+func huffmanLengths(freq []int, maxBits int) []uint8 {
+	var syms []int
+	for s, f := range freq {
+		if f > 0 {
+			syms = append(syms, s)
+		}
+	}
+	f := append([]int(nil), freq...)
+	lengths := make([]uint8, len(freq))
+	for {
+		sort.SliceStable(syms, func(a, b int) bool { return f[syms[a]] < f[syms[b]] })
+
+		// Leaves sorted by weight, then the internal nodes, which are made
+		// in order of weight, each from the two lightest nodes left
+		weight := make([]int, len(syms), 2*len(syms))
+		parent := make([]int, len(syms), 2*len(syms))
+		for i, s := range syms {
+			weight[i] = f[s]
+		}
+		leaf, node := 0, len(syms)
+		lightest := func() int {
+			if leaf < len(syms) && (node == len(weight) || weight[leaf] <= weight[node]) {
+				leaf++
+				return leaf - 1
+			}
+			node++
+			return node - 1
+		}
+		for len(weight) < 2*len(syms)-1 {
+			a, b := lightest(), lightest()
+			weight = append(weight, weight[a]+weight[b])
+			parent = append(parent, 0)
+			parent[a], parent[b] = len(weight)-1, len(weight)-1
+		}
+
+		depth := make([]int, len(weight))
+		longest := 0
+		for i := len(weight) - 2; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+			longest = max(longest, depth[i])
+		}
+		if longest <= maxBits {
+			for i, s := range syms {
+				lengths[s] = uint8(depth[i])
+			}
+			return lengths
+		}
+		for _, s := range syms {
+			f[s] = (f[s] + 1) / 2
+		}
+	}
+}
+
+// huffmanCodes returns the canonical codes for lengths, bit reversed to be
+// written least significant bit first
+func huffmanCodes(lengths []uint8) []uint16 {
+	var count, next [16]int
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	code := 0
+	for n := 1; n < 16; n++ {
+		code = (code + count[n-1]) << 1
+		next[n] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			codes[s] = bits.Reverse16(uint16(next[l])) >> (16 - l)
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// atLeastTwo gives freq at least two used symbols, so its Huffman code is
+// complete, which inflaters insist on
+func atLeastTwo(freq []int) []int {
+	used := 0
+	for _, f := range freq {
+		if f > 0 {
+			used++
+		}
+	}
+	for s := 0; used < 2; s++ {
+		if freq[s] == 0 {
+			freq[s] = 1
+			used++
+		}
+	}
+	return freq
+}
+
+// trimmedLen is the length of lengths without its trailing zeros
+func trimmedLen(lengths []uint8) int {
+	n := len(lengths)
+	for n > 0 && lengths[n-1] == 0 {
+		n--
+	}
+	return n
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// bitWriter packs deflate's least significant bit first fields into bytes
+type bitWriter struct {
+	bits uint64
+	n    uint
+	out  []byte
+}
+
+func (b *bitWriter) write(v uint32, n uint) {
+	b.bits |= uint64(v) << b.n
+	b.n += n
+	for b.n >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.n -= 8
+	}
+}
+
+// align pads to a byte boundary with zero bits
+func (b *bitWriter) align() {
+	if b.n > 0 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits, b.n = 0, 0
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// syntheticLog makes size bytes of log lines that repeat with variations,
+// a few KB apart, like a busy service's log
+func syntheticLog(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	paths := []string{"/api/v1/items", "/api/v1/users", "/api/v1/orders", "/healthz", "/metrics"}
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		fmt.Fprintf(&buf, "2025-03-04T05:%02d:%02d.%03dZ host%02d app[%d]: %s %s/%d status=%d latency=%dms id=%08x\n",
+			rng.Intn(60), rng.Intn(60), rng.Intn(1000), rng.Intn(8), 1000+rng.Intn(4),
+			[]string{"GET", "POST", "PUT"}[rng.Intn(3)], paths[rng.Intn(len(paths))], rng.Intn(500),
+			[]int{200, 200, 200, 404, 500}[rng.Intn(5)], rng.Intn(250), rng.Uint32())
+	}
+	return buf.Bytes()[:size]
+}
+
+// deflateWindow compresses data with a windowDeflater, flushing every flushEvery
+// bytes if it isn't zero
+func deflateWindow(t testing.TB, data []byte, level, windowBits, memLevel, flushEvery int, dict []byte) []byte {
+	var out bytes.Buffer
+	z := newWindowDeflater(&out, level, windowBits, memLevel, dict)
+	for len(data) > 0 {
+		n := len(data)
+		if flushEvery > 0 {
+			n = min(n, flushEvery)
+		}
+		if _, err := z.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if flushEvery > 0 {
+			if err := z.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data = data[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestWindowDeflaterRoundTrip(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":  nil,
+		"byte":   {'x'},
+		"repeat": bytes.Repeat([]byte{'a'}, 300000),
+		"log":    syntheticLog(200000),
+		"random": func() []byte {
+			b := make([]byte, 100000)
+			rand.New(rand.NewSource(2)).Read(b)
+			return b
+		}(),
+		"pcap": packetCapture(150000),
+	}
+	for name, data := range inputs {
+		for _, tt := range []struct{ level, windowBits, memLevel, flushEvery int }{
+			{-1, 9, 8, 0},
+			{-1, 15, 1, 0},
+			{0, 12, 8, 0},
+			{1, 10, 9, 0},
+			{9, 15, 9, 0},
+			{6, 9, 1, 1000},
+			{6, 13, 5, 70000},
+		} {
+			compressed := deflateWindow(t, data, tt.level, tt.windowBits, tt.memLevel, tt.flushEvery, nil)
+			got, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+			if err != nil {
+				t.Errorf("%s %+v: %v", name, tt, err)
+			} else if !bytes.Equal(got, data) {
+				t.Errorf("%s %+v: got %d bytes back, want %d", name, tt, len(got), len(data))
+			}
+		}
+	}
+}
+
+func TestWindowDeflaterDictionary(t *testing.T) {
+	dict := syntheticLog(40000)
+	data := syntheticLog(5000)
+	for _, windowBits := range []int{9, 15} {
+		compressed := deflateWindow(t, data, 6, windowBits, 8, 0, dict)
+		got, err := io.ReadAll(flate.NewReaderDict(bytes.NewReader(compressed), dict))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("window bits %d: got %d bytes, %v, want %d bytes back", windowBits, len(got), err, len(data))
+		}
+		if plain := deflateWindow(t, data, 6, windowBits, 8, 0, nil); len(compressed) >= len(plain) {
+			t.Errorf("window bits %d: %d bytes with the dictionary, %d without", windowBits, len(compressed), len(plain))
+		}
+	}
+}
+
+// TestWindowDeflaterWindow checks matches only reach back as far as the window:
+// data that only repeats every 4KB compresses with an 8KB window but not a 2KB one
+func TestWindowDeflaterWindow(t *testing.T) {
+	block := make([]byte, 4096)
+	rand.New(rand.NewSource(3)).Read(block)
+	data := bytes.Repeat(block, 16)
+
+	if n := len(deflateWindow(t, data, 6, 11, 8, 0, nil)); n < len(data)*99/100 {
+		t.Errorf("2KB window: compressed to %d bytes, want about %d", n, len(data))
+	}
+	if n := len(deflateWindow(t, data, 6, 13, 8, 0, nil)); n > 2*len(block) {
+		t.Errorf("8KB window: compressed to %d bytes, want under %d", n, 2*len(block))
+	}
+}
+
+func TestHuffmanLengthsLimit(t *testing.T) {
+	// Fibonacci frequencies make the deepest Huffman tree
+	freq := make([]int, 30)
+	a, b := 1, 1
+	for i := range freq {
+		freq[i] = a
+		a, b = b, a+b
+	}
+	lengths := huffmanLengths(freq, 15)
+	kraft := 0.0
+	for s, l := range lengths {
+		if l == 0 || l > 15 {
+			t.Fatalf("symbol %d: length %d, want 1-15", s, l)
+		}
+		kraft += 1 / float64(uint(1)<<l)
+	}
+	if kraft != 1 {
+		t.Errorf("Kraft sum %v, want a complete code", kraft)
+	}
+}
+
+// TestGzipWindowFiles writes files through a FileBuffer with a small window
+// and memory level, which gzip.Reader must read back as written
+func TestGzipWindowFiles(t *testing.T) {
+	data := syntheticLog(300000)
+	fb := newTestFileBuffer(t, WithGzipWindow(9, 2), WithCompressionLevel(9))
+	if err := fb.WriteFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	got := bytes.Join(readGzipFiles(t, fb.activeFiles), nil)
+	if !bytes.Equal(got, data) {
+		t.Errorf("read back %d bytes, want the %d written", len(got), len(data))
+	}
+}
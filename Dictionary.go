@@ -0,0 +1,194 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gzipStream is what compressed output is written through: a *gzip.Writer,
+// or a deflateGzipWriter with --gzip_dictionary_file or a non-default
+// --gzip_window_bits or --gzip_mem_level
+type gzipStream interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// maxDictionaryBytes is the deflate window, the most of a dictionary that
+// can be used
+const maxDictionaryBytes = 32 * 1024
+
+// The gzip extra subfield (RFC 1952 section 2.3.1.1) the dictionary is
+// embedded in with --gzip_dictionary_embed
+const (
+	dictSubfieldID1 = 'D'
+	dictSubfieldID2 = 'C'
+)
+
+// newGzipStream returns a gzip writer to w, with the dictionary if there is
+// one, and the window and memory level
+func (fb *FileBuffer) newGzipStream(w io.Writer) (gzipStream, error) {
+	var fw deflater
+	switch {
+	case fb.gzipWindowBits != defaultGzipWindowBits || fb.gzipMemLevel != defaultGzipMemLevel:
+		fw = newWindowDeflater(w, fb.compressionLevel, fb.gzipWindowBits, fb.gzipMemLevel, fb.dictionary)
+	case fb.dictionary != nil:
+		var err error
+		if fw, err = flate.NewWriterDict(w, fb.compressionLevel, fb.dictionary); err != nil {
+			return nil, err
+		}
+	default:
+		return gzip.NewWriterLevel(w, fb.compressionLevel)
+	}
+	z := &deflateGzipWriter{w: w, fw: fw}
+	if fb.dictionary != nil && fb.embedDictionary {
+		z.extra = dictionarySubfield(fb.dictionary)
+	}
+	return z, nil
+}
+
+// setGzipName sets the original name and modification time in z's header,
+// which gunzip -N restores. The name is the base name of filename without
+// the output extension. gzip header strings are Latin-1, so a name that
+// isn't is left out rather than failing the write.
+func (fb *FileBuffer) setGzipName(z gzipStream, filename string, modTime time.Time) {
+	name := filepath.Base(filename)
+	if fb.outputExtension != "" {
+		name = strings.TrimSuffix(name, fb.outputExtension)
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r == 0 || r > 0xFF }) {
+		name = ""
+	}
+	switch z := z.(type) {
+	case *gzip.Writer:
+		z.Name = name
+		z.ModTime = modTime
+	case *deflateGzipWriter:
+		z.name = name
+		z.modTime = modTime
+	}
+}
+
+// deflateGzipWriter writes a gzip member around a deflate writer
+// compress/gzip can't be given: a windowDeflater, or a flate.Writer with a
+// preset dictionary. gzip has no way to record a dictionary, so a member
+// with one can only be read by something that knows it, i.e.
+// flate.NewReaderDict after the gzip header.
+type deflateGzipWriter struct {
+	w           io.Writer
+	fw          deflater
+	extra       []byte    // FEXTRA field contents, nil for none
+	name        string    // FNAME, for the first member only like gzip.Writer
+	modTime     time.Time // MTIME, for the first member only like gzip.Writer
+	comment     string    // FCOMMENT, for the first member only like gzip.Writer
+	crc         uint32
+	size        uint32
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+func (z *deflateGzipWriter) writeHeader() error {
+	z.wroteHeader = true
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	if z.modTime.Unix() > 0 {
+		binary.LittleEndian.PutUint32(header[4:8], uint32(z.modTime.Unix()))
+	}
+	if z.extra != nil {
+		header[3] |= 0x04 // FEXTRA
+		header = binary.LittleEndian.AppendUint16(header, uint16(len(z.extra)))
+		header = append(header, z.extra...)
+	}
+	if z.name != "" {
+		header[3] |= 0x08 // FNAME
+		for _, r := range z.name {
+			header = append(header, byte(r)) // Latin-1
+		}
+		header = append(header, 0)
+	}
+	if z.comment != "" {
+		header[3] |= 0x10 // FCOMMENT
+		header = append(append(header, z.comment...), 0)
+	}
+	_, err := z.w.Write(header)
+	return err
+}
+
+func (z *deflateGzipWriter) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errors.New("gzip: write to closed writer")
+	}
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return 0, z.err
+		}
+	}
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+	var n int
+	n, z.err = z.fw.Write(p)
+	return n, z.err
+}
+
+func (z *deflateGzipWriter) Flush() error {
+	if z.err != nil || z.closed {
+		return z.err
+	}
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return z.err
+		}
+	}
+	z.err = z.fw.Flush()
+	return z.err
+}
+
+// Close finishes the member with the CRC-32 and size trailer
+func (z *deflateGzipWriter) Close() error {
+	if z.err != nil || z.closed {
+		return z.err
+	}
+	z.closed = true
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return z.err
+		}
+	}
+	if z.err = z.fw.Close(); z.err != nil {
+		return z.err
+	}
+	trailer := binary.LittleEndian.AppendUint32(nil, z.crc)
+	trailer = binary.LittleEndian.AppendUint32(trailer, z.size)
+	_, z.err = z.w.Write(trailer)
+	return z.err
+}
+
+// Reset starts a new member on w with the same level and dictionary
+func (z *deflateGzipWriter) Reset(w io.Writer) {
+	z.w = w
+	z.fw.Reset(w)
+	z.crc, z.size = 0, 0
+	z.name, z.modTime, z.comment = "", time.Time{}, ""
+	z.wroteHeader, z.closed, z.err = false, false, nil
+}
+
+// dictionarySubfield is an extra field holding the dictionary
+func dictionarySubfield(dict []byte) []byte {
+	field := []byte{dictSubfieldID1, dictSubfieldID2}
+	field = binary.LittleEndian.AppendUint16(field, uint16(len(dict)))
+	return append(field, dict...)
+}
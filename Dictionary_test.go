@@ -0,0 +1,115 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+)
+
+// readDictionaryFile decompresses a file written with --gzip_dictionary_file
+// the way the usage tells a reader to: skip the gzip header, inflate with
+// flate.NewReaderDict and check the trailer. It returns the data and the
+// header's extra field.
+func readDictionaryFile(t *testing.T, path string, dict []byte) ([]byte, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	extra := z.Extra
+
+	// The header is 10 bytes, then the optional fields present
+	flags := data[3]
+	pos := 10
+	if flags&0x04 != 0 {
+		pos += 2 + int(binary.LittleEndian.Uint16(data[pos:]))
+	}
+	for _, flag := range []byte{0x08, 0x10} {
+		if flags&flag != 0 {
+			pos += bytes.IndexByte(data[pos:], 0) + 1
+		}
+	}
+	body := bytes.NewReader(data[pos:])
+	got, err := io.ReadAll(flate.NewReaderDict(body, dict))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	trailer := data[len(data)-body.Len():]
+	if len(trailer) != 8 || binary.LittleEndian.Uint32(trailer) != crc32.ChecksumIEEE(got) ||
+		binary.LittleEndian.Uint32(trailer[4:]) != uint32(len(got)) {
+		t.Errorf("%s: trailer % x doesn't match the %d bytes read", path, trailer, len(got))
+	}
+	return got, extra
+}
+
+// TestGzipDictionary writes several files with a dictionary, each of which
+// needs it to read back, with the dictionary embedded in the header or not
+func TestGzipDictionary(t *testing.T) {
+	capture := packetCapture(320 * 1024)
+	dict, data := capture[:32*1024], capture[64*1024:]
+
+	for _, embed := range []bool{false, true} {
+		name := "not embedded"
+		if embed {
+			name = "embedded"
+		}
+		t.Run(name, func(t *testing.T) {
+			fb := newTestFileBuffer(t, WithGzipDictionary(dict, embed), WithMaxNumFiles(4))
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < len(data); i += 64 * 1024 {
+				if i > 0 {
+					if _, err := fb.Rotate(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				fb.write(data[i : i+64*1024])
+			}
+			fb.close()
+
+			var got []byte
+			for _, path := range fb.activeFiles {
+				contents, extra := readDictionaryFile(t, path, dict)
+				got = append(got, contents...)
+				var want []byte
+				if embed {
+					want = dictionarySubfield(dict)
+				}
+				if !bytes.Equal(extra, want) {
+					t.Errorf("%s: extra field has %d bytes, want %d", path, len(extra), len(want))
+				}
+
+				// Like gunzip, gzip.Reader can't read it without the dictionary
+				f, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				z, err := gzip.NewReader(f)
+				if err == nil {
+					_, err = io.Copy(io.Discard, z)
+				}
+				f.Close()
+				if err == nil {
+					t.Errorf("%s: read without the dictionary", path)
+				}
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("read back %d bytes, want the %d written", len(got), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import "fmt"
+
+// Exit codes, so a supervisor can tell why GzipFileBuffer stopped
+const (
+	ExitOK         = 0 // Input ended, or a signal asked for a graceful shutdown
+	ExitArgsError  = 1 // Bad command line arguments or configuration
+	ExitWriteError = 2 // Writing the output failed
+	ExitReadError  = 3 // Opening or reading the input failed, or --read_timeout expired
+	ExitSignal     = 4 // A second signal forced an exit part way through shutting down
+	ExitBlockError = 5 // No block header found for --strict_miss_threshold read buffers in a row
+	ExitTimeout    = 6 // Shutting down took longer than --shutdown_timeout
+)
+
+// FileBufferError is an error from a FileBuffer operation, given to the error
+// handler to decide whether to carry on
+type FileBufferError struct {
+	Op    string // The operation that failed, e.g. "write" or "openNewFile"
+	Path  string // The file involved, if any
+	Err   error
+	Fatal bool // There's no carrying on, e.g. there's no file to write to
+}
+
+// Error is the underlying error's message, which already says what failed
+func (e *FileBufferError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FileBufferError) Unwrap() error {
+	return e.Err
+}
+
+// defaultErrorHandler logs the error and carries on unless it's fatal
+func defaultErrorHandler(e *FileBufferError) bool {
+	fmt.Fprintf(logOutput, "Error: %v\n", e)
+	return !e.Fatal
+}
+
+// Handle passes e to the error handler, exiting with ExitWriteError if it
+// returns false. A fatal error exits whatever the handler returns, once it's
+// seen it. The current file is closed first, so what was written to it can
+// still be read. Call it without the lock held.
+func (fb *FileBuffer) Handle(e *FileBufferError) {
+	if !fb.carryOn(e) {
+		fb.exit(ExitWriteError)
+	}
+}
+
+// handleLocked is Handle with the lock already held, for errors part way
+// through a write
+func (fb *FileBuffer) handleLocked(e *FileBufferError) {
+	if !fb.carryOn(e) {
+		fb.exitLocked(ExitWriteError)
+	}
+}
+
+// carryOn reports whether the error handler wants to carry on after e
+func (fb *FileBuffer) carryOn(e *FileBufferError) bool {
+	handler := fb.errorHandler
+	if handler == nil {
+		handler = defaultErrorHandler
+	}
+	return handler(e) && !e.Fatal
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestFileBufferError(t *testing.T) {
+	e := &FileBufferError{Op: "openNewFile", Path: "x.gz", Err: fs.ErrPermission, Fatal: true}
+	if e.Error() != fs.ErrPermission.Error() {
+		t.Errorf("Error() = %q, want %q", e.Error(), fs.ErrPermission.Error())
+	}
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Errorf("errors.Is doesn't find the wrapped error")
+	}
+}
+
+func TestCarryOn(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(*FileBufferError) bool
+		fatal   bool
+		want    bool
+	}{
+		{"default, not fatal", nil, false, true},
+		{"default, fatal", nil, true, false},
+		{"handler carries on", func(*FileBufferError) bool { return true }, false, true},
+		{"handler exits", func(*FileBufferError) bool { return false }, false, false},
+		{"fatal whatever the handler says", func(*FileBufferError) bool { return true }, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := newTestFileBuffer(t, WithErrorHandler(tt.handler))
+			e := &FileBufferError{Op: "write", Err: errors.New("test"), Fatal: tt.fatal}
+			if got := fb.carryOn(e); got != tt.want {
+				t.Errorf("carryOn = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A handler can collect the errors instead of them being logged, here from
+// writing to a file closed underneath the FileBuffer
+func TestErrorHandlerCollects(t *testing.T) {
+	var errs []*FileBufferError
+	fb := newTestFileBuffer(t,
+		WithWriteErrorPolicy("warn_continue", 3),
+		WithWriteRetry(0, 0),
+		WithErrorHandler(func(e *FileBufferError) bool {
+			errs = append(errs, e)
+			return true
+		}),
+	)
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	fb.currentFile.Close()
+
+	fb.write(make([]byte, 64*1024))
+	if len(errs) == 0 {
+		t.Fatal("no errors collected")
+	}
+	for _, e := range errs {
+		if e.Op != "write" || e.Fatal || e.Path != fb.currentFileName {
+			t.Errorf("got %+v, want a non-fatal write error for %s", e, fb.currentFileName)
+		}
+		if !errors.Is(e, os.ErrClosed) {
+			t.Errorf("%v doesn't wrap os.ErrClosed", e)
+		}
+	}
+}
+
+// failingWriter is an output that fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("device failed") }
+
+// TestWriteErrorPolicy faults the current file's output with a failingWriter
+// and checks each policy: exit exits with ExitWriteError and goes no further,
+// warn_continue drops the data, and rotate_on_error writes it to a new file
+// unless that's more than max_consecutive_errors failures in a row
+func TestWriteErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy         string
+		maxConsecutive int
+		wantExit       int // -1 for none
+		wantFiles      int
+		wantWritten    bool // The data is in the last file
+	}{
+		{"exit", 3, ExitWriteError, 1, false},
+		{"warn_continue", 3, -1, 1, false},
+		{"rotate_on_error", 3, -1, 2, true},
+		{"rotate_on_error", 0, ExitWriteError, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.policy, tt.maxConsecutive), func(t *testing.T) {
+			discardLog(t)
+			var errs []*FileBufferError
+			fb := newTestFileBuffer(t,
+				WithWriteErrorPolicy(tt.policy, tt.maxConsecutive),
+				WithWriteRetry(0, 0),
+				WithErrorHandler(func(e *FileBufferError) bool {
+					errs = append(errs, e)
+					return true
+				}),
+			)
+			exitCode := -1
+			fb.exitFn = func(code int) { exitCode = code }
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			fb.gzipDest = failingWriter{}
+			fb.gzipWriter.Reset(fb.gzipDest)
+
+			data := []byte("data that fails to write\n")
+			fb.write(data)
+
+			if exitCode != tt.wantExit {
+				t.Errorf("exit code %d, want %d", exitCode, tt.wantExit)
+			}
+			if n := fb.counters.filesCreated.Load(); n != int64(tt.wantFiles) {
+				t.Errorf("%d files created, want %d", n, tt.wantFiles)
+			}
+			if len(errs) == 0 || errs[0].Op != "write" {
+				t.Fatalf("errors %v, want a write error first", errs)
+			}
+			if last := errs[len(errs)-1]; last.Fatal != (tt.wantExit >= 0) {
+				t.Errorf("last error %q fatal %v, want %v", last, last.Fatal, tt.wantExit >= 0)
+			}
+			if !tt.wantWritten {
+				return
+			}
+			fb.close()
+			contents := readGzipFiles(t, fb.activeFiles[len(fb.activeFiles)-1:])
+			if !bytes.Equal(contents[0], data) {
+				t.Errorf("new file holds %q, want %q", contents[0], data)
+			}
+			if fb.consecutiveWriteErrors != 0 {
+				t.Errorf("consecutiveWriteErrors %d after a good write, want 0", fb.consecutiveWriteErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fifoWriter writes to the --output_fifo named pipe. When the reader goes
+// away, the rest of the gzip member being written is dropped, and the next
+// member waits for a new reader, so a reader never starts mid-member.
+type fifoWriter struct {
+	path         string
+	f            *os.File
+	memberBytes  int64 // compressed bytes of the current gzip member
+	disconnected bool  // the reader went away part way through the member
+	quiet        bool
+}
+
+// open blocks until a reader opens the FIFO
+func (w *fifoWriter) open() error {
+	if !w.quiet {
+		fmt.Fprintf(logOutput, "Waiting for a reader on FIFO %s\n", w.path)
+	}
+	f, err := os.OpenFile(w.path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	w.f = f
+	w.disconnected = false
+	return nil
+}
+
+func (w *fifoWriter) Write(p []byte) (int, error) {
+	w.memberBytes += int64(len(p))
+	if w.disconnected {
+		return len(p), nil
+	}
+	n, err := w.f.Write(p)
+	if errors.Is(err, syscall.EPIPE) {
+		fmt.Fprintf(logOutput, "Warning: reader of FIFO %s went away, waiting for a new one\n", w.path)
+		w.disconnected = true
+		return len(p), nil
+	}
+	return n, err
+}
+
+func (w *fifoWriter) close() {
+	if w.f == nil {
+		return
+	}
+	if err := w.f.Close(); err != nil && !errors.Is(err, syscall.EPIPE) {
+		fmt.Fprintf(logOutput, "Warning: error closing FIFO %s: %v\n", w.path, err)
+	}
+	w.f = nil
+}
+
+// openFifoMember starts the next gzip member on the FIFO, first waiting for
+// a reader if there isn't one
+func (fb *FileBuffer) openFifoMember() error {
+	if fb.fifo == nil {
+		fb.fifo = &fifoWriter{path: fb.outputFifo, quiet: fb.quiet}
+	}
+	if fb.fifo.f == nil || fb.fifo.disconnected {
+		fb.fifo.close()
+		if err := fb.fifo.open(); err != nil {
+			return fmt.Errorf("opening FIFO %s: %w", fb.outputFifo, err)
+		}
+	}
+	fb.fifo.memberBytes = 0
+	fb.fileStartCompressed = fb.counters.bytesCompressed.Load()
+
+	fb.gzipDest = &countingWriter{&rateLimitedWriter{&retryWriter{fb, fb.fifo, "writing to FIFO " + fb.outputFifo}, fb.outputLimiter}, &fb.counters.bytesCompressed}
+	gzWriter, err := fb.newGzipStream(fb.gzipDest)
+	if err != nil {
+		return fmt.Errorf("creating gzip writer for FIFO %s: %w", fb.outputFifo, err)
+	}
+	fb.gzipWriter = gzWriter
+	fb.gzipMemberClosed = false
+	fb.syncBytesWritten = 0
+	fb.fileDataBytes = 0
+	fb.fileStartUncompressed = fb.counters.bytesUncompressed.Load()
+	fb.currentFileOpenedAt = time.Now()
+	fb.counters.filesCreated.Add(1)
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Started gzip member on FIFO %s (compression: %d)\n", fb.outputFifo, fb.compressionLevel)
+	}
+
+	// Each member stands alone for a new reader, so gets the header too
+	if fb.headerCaptured && fb.headerBytes > 0 {
+		if _, err := fb.gzipWriter.Write(fb.header); err != nil {
+			return fmt.Errorf("writing header to FIFO %s: %w", fb.outputFifo, err)
+		}
+		fb.counters.bytesUncompressed.Add(int64(len(fb.header)))
+	}
+	if err := fb.injectBlockAfterHeader(); err != nil {
+		return err
+	}
+	return fb.startSectionHeader()
+}
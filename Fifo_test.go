@@ -0,0 +1,143 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeReader is the reading end of an os.Pipe standing in for a FIFO, and
+// everything read from it
+type pipeReader struct {
+	r    *os.File
+	path string // Opens the writing end, like a FIFO's path
+	w    *os.File
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	done chan struct{}
+}
+
+// newPipeReader makes a pipe that can be opened for writing by path, through
+// /proc/self/fd, and reads it until it's closed
+func newPipeReader(t *testing.T) *pipeReader {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pipeReader{r: r, w: w, path: fmt.Sprintf("/proc/self/fd/%d", w.Fd()), done: make(chan struct{})}
+	t.Cleanup(func() { r.Close(); w.Close() })
+	go func() {
+		defer close(p.done)
+		chunk := make([]byte, 4096)
+		for {
+			n, err := r.Read(chunk)
+			p.mu.Lock()
+			p.buf.Write(chunk[:n])
+			p.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *pipeReader) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.buf.Len()
+}
+
+// gzipMembers decompresses each gzip member in data, stopping at the first
+// that's incomplete
+func gzipMembers(data []byte) [][]byte {
+	var members [][]byte
+	br := bufio.NewReader(bytes.NewReader(data))
+	for {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return members
+		}
+		zr.Multistream(false)
+		member, err := io.ReadAll(zr)
+		if err != nil {
+			return members
+		}
+		members = append(members, member)
+	}
+}
+
+// TestOutputFifo writes to a pipe standing in for --output_fifo. Every
+// file's gzip member arrives on it, and when the reader goes away, writing
+// carries on with a new member once another reader opens the FIFO.
+func TestOutputFifo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("opening a pipe by path needs /proc/self/fd")
+	}
+	discardLog(t)
+	first := newPipeReader(t)
+	fb := newTestFileBuffer(t, WithOutputFifo(first.path))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	first.w.Close() // Only the FileBuffer writes to it now
+
+	a1, a2 := packetCapture(32*1024), syntheticLog(32*1024)
+	fb.write(a1)
+	if _, err := fb.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fb.write(a2)
+	if _, err := fb.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fb.write(packetCapture(64 * 1024))
+
+	// Wait for everything so far to be read, then the reader goes away
+	for deadline := time.Now().Add(5 * time.Second); int64(first.len()) != fb.counters.bytesCompressed.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("read %d bytes, %d written", first.len(), fb.counters.bytesCompressed.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	first.r.Close()
+	<-first.done
+
+	// A new reader comes along. A pipe can't be reopened once its reader
+	// has gone, so the FIFO's path now opens another one.
+	second := newPipeReader(t)
+	fb.fifo.path = second.path
+	c, d := syntheticLog(48*1024), packetCapture(48*1024)
+	fb.write(c)
+	if fb.fifo.disconnected {
+		t.Fatal("still disconnected after the new reader connected")
+	}
+	fb.write(d)
+	fb.close()
+	second.w.Close()
+	<-second.done
+
+	got := gzipMembers(first.buf.Bytes())
+	if len(got) != 2 || !bytes.Equal(got[0], a1) || !bytes.Equal(got[1], a2) {
+		t.Errorf("first reader got %d whole members, want the 2 written before it went away", len(got))
+	}
+	got = gzipMembers(second.buf.Bytes())
+	if len(got) != 1 || !bytes.Equal(got[0], append(c, d...)) {
+		t.Errorf("second reader got %d whole members, want 1 with everything written after it connected", len(got))
+	}
+	if n := fb.counters.filesCreated.Load(); n != 4 {
+		t.Errorf("%d members started, want 4", n)
+	}
+}
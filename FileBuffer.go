@@ -2,155 +2,669 @@
 // Use of this source code is governed by an MIT license that can be
 // found in the LICENSE file.
 
-package main
+package gzipfilebuffer
 
 import (
-	"compress/gzip"
+	"bufio"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
 type FileBuffer struct {
-	filePrefix       string
-	maxFileSize      int64
-	maxNumFiles      int
-	timeFormat       string
-	useLocalTime     bool
-	headerBytes      int
-	header           []byte
-	headerCaptured   bool
-	blockFormat      *BlockHeaderFormat
-	maxBlockSize     int
-	readBufferSize   int
-	compressionLevel int
-	currentFile      *os.File
-	gzipWriter       *gzip.Writer
-	fileCounter      int
-	activeFiles      []string
-	resumeExisting   bool
-	quiet            bool
+	filePrefix              string
+	outputExtension         string             // appended to each filename, e.g. .gz, may be empty
+	filenameSep             string             // between the prefix, counter and timestamp
+	filenameTemplate        *template.Template // replaces the default filename format if set
+	subdirFormat            string             // Go time layout of the subdirectory each file goes in, e.g. 2006/01/02
+	maxFileSize             int64
+	rotateOnUncompressed    bool // maxFileSize applies to the bytes before compression
+	maxNumFiles             int
+	maxFileAge              time.Duration // Delete files older than this, 0 to disable
+	maxTotalBytes           int64         // Delete oldest files while all together exceed this, 0 to disable
+	protectRecent           time.Duration // Keep files modified within this long past --num_files, 0 to disable
+	preDeleteHook           string        // Command run before deleting a file, {} replaced by its path (optional)
+	preDeleteHookTimeout    time.Duration
+	preDeleteHookStrict     bool
+	shutdownTimeout         time.Duration               // Time allowed to finish after a shutdown signal, 0 for no limit
+	goroutineRestart        bool                        // Carry on reading or processing after a panic
+	errorHandler            func(*FileBufferError) bool // Decides whether to carry on after an error, nil for defaultErrorHandler
+	timeFormat              string
+	useLocalTime            bool
+	headerBytes             int
+	header                  []byte // captured so far, complete once headerCaptured
+	headerCaptured          bool
+	injectBlock             []byte // --inject_block_file contents, written after the header in each file
+	injectPending           bool   // the current file's injectBlock waits for the header from the stream
+	sectionHeaderBytes      int    // bytes at the start of each file's data kept as its section header, 0 for none
+	sectionHeader           []byte // captured from the first file's data, then written to each later one
+	sectionCapturing        bool   // sectionHeader isn't complete yet
+	trailer                 []byte // appended to each file before it's closed, nil for none
+	trailerFile             string // file read for the trailer on each close, instead of trailer
+	streamOffset            int64  // bytes of input seen before the current write
+	blockFormat             *BlockHeaderFormat
+	blockFormatAlts         []*BlockHeaderFormat // --block_header_alt formats, tried in order when blockFormat doesn't match
+	blockTrailer            *BlockHeaderFormat   // fields after each block's data, nil for none
+	blockValidator          func([]byte) int     // ValidateBlock from --block_validator_plugin, nil for none
+	autoDetectPcap          bool
+	requireCompleteBlock    bool
+	debugBlockScan          bool
+	blockValidationStrict   bool // exit when more than strictMissThreshold scans in a row find no block header
+	strictMissThreshold     int
+	consecutiveMisses       int    // scans in a row that found no block header
+	verbose                 bool   // trace each write, block header check and file open and close
+	logFile                 string // log output is appended here instead of stderr, empty for stderr
+	logSyslog               bool   // log output goes to syslog instead of stderr
+	logSyslogFacility       string
+	splitOnNewline          bool // rotate after the last newline rather than at a block header
+	minLineLength           int
+	recordSize              int    // rotate only between records of this many bytes, 0 for any offset
+	recordDelimiter         []byte // rotate only after this delimiter, nil for any offset
+	delimiterTail           []byte // end of the last chunk, for a delimiter spanning two
+	maxBlockSize            int
+	timestampHz             uint64 // ticks per second of sec fields
+	readBufferSize          int
+	maxInputRate            float64 // uncompressed input limit in megabits per second, 0 for none
+	maxOutputRate           float64 // compressed output limit in megabits per second, 0 for none
+	inputLimiter            *rateLimiter
+	outputLimiter           *rateLimiter
+	compressionLevel        int
+	gzipWindowBits          int    // zlib window_bits, the deflate window is 1<<gzipWindowBits bytes
+	gzipMemLevel            int    // zlib mem_level, there are 1<<(gzipMemLevel+7) hash chains
+	dictionary              []byte // preset deflate dictionary, nil for none
+	embedDictionary         bool   // put the dictionary in each gzip header
+	embedChecksum           bool
+	checksum                hash.Hash // SHA-256 of the current file so far, with --embed_checksum
+	decompressInput         bool
+	inputTCP                string // listen address to read input from instead of stdin
+	inputTCPMulti           bool
+	inputUnix               string // socket path to read input from instead of stdin
+	inputUnixPerms          os.FileMode
+	inputUnixMulti          bool
+	inputTLSCert            string
+	inputTLSKey             string
+	readTimeout             time.Duration
+	readTimeoutAction       string // exit or rotate
+	writeErrorPolicy        string // exit, warn_continue or rotate_on_error
+	maxConsecutiveErrors    int
+	writeRetryCount         int
+	writeRetryInterval      time.Duration
+	consecutiveWriteErrors  int
+	syncInterval            int64 // emit a gzip sync point every this many uncompressed bytes, 0 for off
+	multistream             bool  // write each chunk as a separate gzip member
+	syncBytesWritten        int64 // uncompressed bytes written since the last sync point in the current file
+	preallocateBytes        int64 // disk space to reserve for each new file, 0 for none
+	preallocated            bool  // the current file has preallocated space to release on close
+	fileDataBytes           int64 // stream bytes (not counting the header) written to the current file
+	fileStartUncompressed   int64 // bytesUncompressed counter when the current file was opened
+	fileStartCompressed     int64 // bytesCompressed counter when the current file was opened
+	memberStartCompressed   int64 // where the current gzip member starts in the file, compressed
+	memberStartUncompressed int64 // and uncompressed
+	fileStartBlocks         int64 // blocksFound counter when the current file was opened
+	fileStartScansFailed    int64 // blockScansFailed counter when the current file was opened
+	fileStartScanBytes      int64 // blockScanBytes counter when the current file was opened
+	currentFile             *os.File
+	gzipWriter              gzipStream
+	gzipDest                io.Writer // what gzipWriter writes to, to start each new member of a multistream file
+	gzipMemberClosed        bool
+	fileCounter             int
+	clockFn                 func() time.Time // Time source for filenames, time.Now unless replaced in tests
+	exitFn                  func(code int)   // os.Exit unless replaced in tests
+	activeFiles             []string
+	resumeExisting          bool
+	stateFile               string // JSON file to persist the counter and active files to (optional)
+	verifyOnResume          bool
+	verifyOutput            bool // decompress each file once it's closed, renaming corrupt ones to .corrupt
+	repairLastFile          bool
+	quiet                   bool
+	toStdout                bool
+	outputFifo              string // Named pipe to write gzip members to instead of files (optional)
+	fifo                    *fifoWriter
+	outputDirs              []string // Directories to round-robin new files across (optional)
+	mirrorDir               string   // Directory to write a second copy of each file to (optional)
+	mirrorFile              *os.File
+	mirrorWriter            gzipStream
+	dualOutput              bool        // Also write an uncompressed copy of each file, without the output extension
+	dualOutputPerms         os.FileMode // for the uncompressed copies, 0 for the default
+	companionFile           *os.File
+	writeIndex              bool   // Write a block offset index alongside each file
+	indexFormat             string // json or binary
+	indexFile               *os.File
+	indexWriter             *bufio.Writer
+	indexBlocks             int64        // entries in the current index
+	indexPending            []indexBlock // block starts found but not yet written
+	indexCarry              []byte       // a block header split across chunks
+	indexNext               int64        // stream offset of the next block, per the last one's length
+	indexWritten            int64        // stream bytes passed to writeData
+	archiveDir              string       // Directory to move each closed file to (optional)
+	s3Bucket                string       // Bucket to upload each closed file to (optional)
+	s3KeyPrefix             string
+	s3Region                string
+	s3DeleteAfterUpload     bool
+	s3RetryCount            int
+	s3Credentials           s3Credentials
+	webhookURL              string // URL to POST a WebhookPayload to when a file is closed (optional)
+	metricsAddr             string // Address to serve Prometheus metrics on at /metrics (optional)
+	controlAddr             string // Address to serve runtime controls on, e.g. POST /compression (optional)
+	statsdAddr              string // host:port to send StatsD metrics to over UDP when a file is closed (optional)
+	statsdPrefix            string
+	statsdConn              net.Conn // Dialled on first use
+	webhookAuthHeader       string   // Authorization header value, e.g. "Bearer TOKEN"
+	webhookTimeout          time.Duration
+	background              sync.WaitGroup // Uploads and webhooks in progress
+	currentFileName         string
+	currentFileOpenedAt     time.Time
+	rotateCallbacks         []func(closed, opened string)
+	deleteCallbacks         []func(deleted string)
+	pendingCallbacks        []func() // queued while the lock is held, run by unlock
+	startTime               time.Time
+	counters                counters
+	mu                      sync.Mutex // Guards the writer against concurrent flush/close
 }
 
 func (fb *FileBuffer) write(data []byte) {
-	// Capture header from first data if needed
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	streamOffset := fb.streamOffset
+	fb.streamOffset += int64(len(data))
+	delimiterTail := fb.delimiterTail
+	if fb.recordDelimiter != nil {
+		fb.delimiterTail = fb.nextDelimiterTail(delimiterTail, data)
+	}
+
+	// Configure for pcap from the first data if asked to. Only the start of
+	// the stream is checked, a magic number later on isn't a global header.
+	if fb.autoDetectPcap && streamOffset == 0 {
+		fb.detectPcap(data)
+	}
+
+	// Capture the header from the start of the stream. A short read may not
+	// hold all of it, so it's built up over as many writes as it takes.
+	headerEnd := 0
 	if !fb.headerCaptured && fb.headerBytes > 0 {
-		bytesToCapture := fb.headerBytes
-		if len(data) < fb.headerBytes {
-			fmt.Fprintf(os.Stderr, "Error: Insufficient data to capture header: need %d bytes, got %d bytes", fb.headerBytes, len(data))
-			bytesToCapture = len(data)
+		headerEnd = min(len(data), fb.headerBytes-len(fb.header))
+		fb.header = append(fb.header, data[:headerEnd]...)
+		if len(fb.header) == fb.headerBytes {
+			fb.headerCaptured = true
+			if !fb.quiet {
+				fmt.Fprintf(logOutput, "Captured %d header bytes from stream\n", fb.headerBytes)
+			}
+			fb.checkPcapHeader()
 		}
+	}
+
+	// The first file's injected block goes between the header and the rest
+	// of the data
+	if fb.injectPending && (fb.headerCaptured || fb.headerBytes == 0) {
+		if err := fb.writeData(data[:headerEnd]); err != nil {
+			fb.handleWriteError(err, data[:headerEnd])
+		}
+		if err := fb.writeInjectBlock(); err != nil {
+			fb.handleLocked(&FileBufferError{Op: "injectBlock", Path: fb.currentFileName, Err: err, Fatal: true})
+		}
+		data = data[headerEnd:]
+		streamOffset += int64(headerEnd)
+	}
 
-		fb.header = make([]byte, bytesToCapture)
-		copy(fb.header, data[:bytesToCapture])
-		fb.headerCaptured = true
+	fb.findIndexBlocks(data, streamOffset)
 
-		if !fb.quiet {
-			fmt.Fprintf(os.Stderr, "Captured %d header bytes from stream\n", fb.headerBytes)
+	// No rotation when streaming to stdout, just compress and write
+	if fb.toStdout {
+		if err := fb.writeData(data); err != nil {
+			fb.handleWriteError(err, data)
 		}
+		return
 	}
 
 	// Flush to ensure data is written to file
 	if err := fb.gzipWriter.Flush(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error flushing gzip writer: %s", err.Error())
+		fb.handleWriteError(fmt.Errorf("flushing gzip writer: %w", err), data)
+		return
 	}
 
-	// Check actual file size on disk
-	fileInfo, err := fb.currentFile.Stat()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting file stats: %s", err.Error())
+	size := fb.currentSize()
+	uncompressed := fb.currentUncompressedSize()
+	fb.verbosef("write %d bytes, current file %d bytes (%d uncompressed)", len(data), size, uncompressed)
+	full := size >= fb.maxFileSize
+	if fb.rotateOnUncompressed {
+		full = uncompressed >= fb.maxFileSize
 	}
 
-	// Check for rotate condition before writing new data
-	if fileInfo.Size() >= fb.maxFileSize {
+	// Check for rotate condition before writing new data. A file isn't left
+	// with part of a section header. A FIFO whose reader went away moves on
+	// straight away, for the next reader.
+	if full && !fb.sectionCapturing || fb.fifo != nil && fb.fifo.disconnected {
+		if full && !fb.quiet {
+			fmt.Fprintf(logOutput, "Rotating at %d bytes compressed, %d uncompressed\n", size, uncompressed)
+		}
 		nextBlockOffset := int(0)
 		if fb.blockFormat != nil {
 			nextBlockOffset = fb.findBlockHeader(data)
+		} else if fb.blockValidator != nil {
+			nextBlockOffset = fb.findPluginBlock(data)
+		} else if fb.splitOnNewline {
+			nextBlockOffset = fb.findNewlineSplit(data)
+		} else if fb.recordDelimiter != nil {
+			nextBlockOffset = fb.findDelimiterSplit(delimiterTail, data)
+		} else if fb.recordSize > 0 {
+			nextBlockOffset = fb.findRecordSplit(streamOffset, len(data))
 		}
 		//write up to nextBlockOffset and rotate
-		n, err := fb.gzipWriter.Write(data[:nextBlockOffset])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing to gzip: %s", err.Error())
-		}
-		if n != nextBlockOffset {
-			fmt.Fprintf(os.Stderr, "Error: short write to gzip: wrote %d bytes, expected %d bytes", n, nextBlockOffset)
+		if err := fb.writeData(data[:nextBlockOffset]); err != nil {
+			fb.handleWriteError(err, data[:nextBlockOffset])
 		}
 		fb.closeCurrentFile()
+		data = data[nextBlockOffset:]
+		if err := fb.openNewFile(); err != nil {
+			fb.handleLocked(&FileBufferError{Op: "openNewFile", Err: err, Fatal: true})
+		}
+	}
+
+	fb.captureSectionHeader(data, fb.streamOffset-int64(len(data)))
+	if err := fb.writeData(data); err != nil {
+		fb.handleWriteError(err, data)
+	}
+}
+
+// currentSize is the compressed size of the current file, or of the gzip
+// member being written to the FIFO. Everything written to a file goes
+// through the counting writer, so its count since the file was opened is
+// the size on disk, without a Stat per write.
+func (fb *FileBuffer) currentSize() int64 {
+	if fb.fifo != nil {
+		return fb.fifo.memberBytes
+	}
+	return fb.counters.bytesCompressed.Load() - fb.fileStartCompressed
+}
+
+// currentUncompressedSize is how much has been written to the current file,
+// or FIFO member, before compression, including any header
+func (fb *FileBuffer) currentUncompressedSize() int64 {
+	return fb.counters.bytesUncompressed.Load() - fb.fileStartUncompressed
+}
+
+func (fb *FileBuffer) writeData(data []byte) error {
+	fb.recordIndex(len(data))
+
+	// Split the write at each sync interval boundary
+	for fb.syncInterval > 0 && fb.syncBytesWritten+int64(len(data)) >= fb.syncInterval {
+		n := fb.syncInterval - fb.syncBytesWritten
+		if err := fb.writeGzip(data[:n]); err != nil {
+			return err
+		}
+		if err := fb.gzipWriter.Flush(); err != nil {
+			return fmt.Errorf("flushing gzip writer: %w", err)
+		}
+		fb.syncBytesWritten = 0
 		data = data[n:]
-		fb.openNewFile()
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := fb.writeGzip(data); err != nil {
+		return err
+	}
+	fb.syncBytesWritten += int64(len(data))
+
+	// With --gzip_multistream each chunk is a complete gzip member. The next
+	// one is started lazily, so closing the file doesn't add an empty one.
+	if fb.multistream {
+		if err := fb.gzipWriter.Close(); err != nil {
+			return fmt.Errorf("closing gzip stream: %w", err)
+		}
+		fb.gzipMemberClosed = true
+	}
+	return nil
+}
+
+func (fb *FileBuffer) writeGzip(data []byte) error {
+	// Start the next gzip member if the last one was ended
+	if fb.gzipMemberClosed {
+		fb.memberStartCompressed = fb.counters.bytesCompressed.Load() - fb.fileStartCompressed
+		fb.memberStartUncompressed = fb.counters.bytesUncompressed.Load() - fb.fileStartUncompressed
+		fb.gzipWriter.Reset(fb.gzipDest)
+		fb.gzipMemberClosed = false
 	}
 
 	// Write data to gzip writer
 	n, err := fb.gzipWriter.Write(data)
+	fb.updateChecksum(data[:n])
+	fb.counters.bytesUncompressed.Add(int64(n))
+	fb.fileDataBytes += int64(n)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing to gzip: %s", err.Error())
+		return fmt.Errorf("writing to gzip: %w", err)
 	}
 	if n != len(data) {
-		fmt.Fprintf(os.Stderr, "Error: short write to gzip: wrote %d bytes, expected %d bytes", n, len(data))
+		return fmt.Errorf("short write to gzip: wrote %d bytes, expected %d bytes", n, len(data))
 	}
+
+	fb.writeMirror(data)
+	fb.writeCompanion(data)
+	fb.consecutiveWriteErrors = 0
+	return nil
 }
 
-func (fb *FileBuffer) openNewFile() {
-	// Delete oldest file if we've reached the limit
-	if len(fb.activeFiles) >= fb.maxNumFiles {
-		oldestFile := fb.activeFiles[0]
-		if err := os.Remove(oldestFile); err != nil && !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Warning: failed to delete oldest file %s: %v\n", oldestFile, err)
-		} else {
-			if !fb.quiet {
-				fmt.Fprintf(os.Stderr, "Deleted oldest file: %s\n", oldestFile)
-			}
+// handleWriteError deals with a failed write of data according to the write
+// error policy: exit, drop the data, or move on to a new file and retry
+func (fb *FileBuffer) handleWriteError(err error, data []byte) {
+	for {
+		fb.consecutiveWriteErrors++
+		switch fb.writeErrorPolicy {
+		case "exit":
+			fb.handleLocked(&FileBufferError{Op: "write", Path: fb.currentFileName, Err: fmt.Errorf("%w, exiting", err), Fatal: true})
+			return
+		case "warn_continue":
+			fb.handleLocked(&FileBufferError{Op: "write", Path: fb.currentFileName, Err: fmt.Errorf("%w, dropping %d bytes", err, len(data))})
+			return
+		}
+
+		// rotate_on_error
+		if fb.consecutiveWriteErrors > fb.maxConsecutiveErrors {
+			fb.handleLocked(&FileBufferError{Op: "write", Path: fb.currentFileName,
+				Err: fmt.Errorf("%w, giving up after %d consecutive write errors", err, fb.consecutiveWriteErrors), Fatal: true})
+			return
+		}
+		fb.handleLocked(&FileBufferError{Op: "write", Path: fb.currentFileName, Err: fmt.Errorf("%w, retrying %d bytes in a new file", err, len(data))})
+		fb.closeCurrentFile()
+		if err := fb.openNewFile(); err != nil {
+			fb.handleLocked(&FileBufferError{Op: "openNewFile", Err: err, Fatal: true})
+		}
+		if err = fb.writeData(data); err == nil {
+			return
+		}
+	}
+}
+
+// flush emits a gzip sync point so everything written so far can be decompressed
+func (fb *FileBuffer) flush() {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	if fb.gzipWriter == nil {
+		return
+	}
+	if err := fb.gzipWriter.Flush(); err != nil {
+		fmt.Fprintf(logOutput, "Error flushing gzip writer: %s\n", err.Error())
+	}
+}
+
+// close closes the current output, holding the lock so it can't race a flush
+func (fb *FileBuffer) close() {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	fb.closeCurrentFile()
+	fb.archiveFile(fb.currentFileName)
+	if fb.fifo != nil {
+		fb.fifo.close()
+	}
+}
+
+// waitForBackground blocks until uploads and webhooks still in progress
+// have finished
+func (fb *FileBuffer) waitForBackground() {
+	fb.background.Wait()
+}
+
+// rotateIfWritten rotates to a new file unless nothing but the header has
+// been written to the current one
+func (fb *FileBuffer) rotateIfWritten() (bool, error) {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	if fb.fileDataBytes == 0 {
+		return false, nil
+	}
+	fb.closeCurrentFile()
+	return true, fb.openNewFile()
+}
+
+// exit closes the current output and exits with code. The lock is never
+// released, so nothing more can be written in the meantime.
+func (fb *FileBuffer) exit(code int) {
+	fb.mu.Lock()
+	fb.exitLocked(code)
+}
+
+// exitLocked is exit with the lock already held
+func (fb *FileBuffer) exitLocked(code int) {
+	fb.closeCurrentFile()
+	fb.archiveFile(fb.currentFileName)
+	fb.exitFn(code)
+}
+
+// closeAfterPanic closes the current file after a panic part way through
+// processing, and with reopen opens a new one to carry on in
+func (fb *FileBuffer) closeAfterPanic(reopen bool) error {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	fb.closeCurrentFile()
+	if !reopen {
+		return nil
+	}
+	return fb.openNewFile()
+}
+
+// forceClose closes the current output when giving up on a shutdown. If a
+// write is stuck holding the lock, the file is closed underneath it, which is
+// all that can be done.
+func (fb *FileBuffer) forceClose() {
+	if fb.mu.TryLock() {
+		fb.closeCurrentFile()
+		return
+	}
+	if f := fb.currentFile; f != nil && f != os.Stdout {
+		f.Close()
+	}
+}
+
+// SetCompressionLevel changes the gzip compression level, from -1 (default)
+// to 9, for the files (or FIFO members) opened from now on. The current one
+// carries on at the level it was opened with.
+func (fb *FileBuffer) SetCompressionLevel(level int) error {
+	if level < -1 || level > 9 {
+		return fmt.Errorf("compression level must be between -1 and 9, got %d", level)
+	}
+	fb.mu.Lock()
+	defer fb.unlock()
+	fb.compressionLevel = level
+	return nil
+}
+
+// Rotate closes the current file and opens the next one, returning the path
+// of the file that was closed
+func (fb *FileBuffer) Rotate() (closedFile string, err error) {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	if fb.toStdout {
+		return "", errors.New("rotation is not supported when writing to stdout")
+	}
+
+	closedFile = fb.currentFileName
+	fb.closeCurrentFile()
+	if err := fb.openNewFile(); err != nil {
+		return closedFile, err
+	}
+	return closedFile, nil
+}
+
+func (fb *FileBuffer) openNewFile() error {
+	if fb.verbose {
+		defer fb.logTiming("openNewFile", time.Now())
+	}
+
+	// Stdout gets a single gzip stream for the life of the process
+	if fb.toStdout {
+		fb.gzipDest = &countingWriter{&rateLimitedWriter{&retryWriter{fb, os.Stdout, "writing to stdout"}, fb.outputLimiter}, &fb.counters.bytesCompressed}
+		gzWriter, err := fb.newGzipStream(fb.gzipDest)
+		if err != nil {
+			return fmt.Errorf("creating gzip writer for stdout: %w", err)
+		}
+		fb.currentFile = os.Stdout
+		fb.gzipWriter = gzWriter
+		fb.gzipMemberClosed = false
+		fb.currentFileOpenedAt = time.Now()
+		fb.counters.filesCreated.Add(1)
+		if !fb.quiet {
+			fmt.Fprintf(logOutput, "Writing gzip stream to stdout (compression: %d)\n", fb.compressionLevel)
+		}
+		return fb.injectBlockAfterHeader()
+	}
+
+	// A FIFO gets a gzip member per file, one after another
+	if fb.outputFifo != "" {
+		return fb.openFifoMember()
+	}
+
+	// Delete the oldest files if we've reached the limit, unless they were
+	// closed too recently (e.g. an upload of them may still be running)
+	for len(fb.activeFiles) >= fb.maxNumFiles {
+		if age, recent := fb.recentlyModified(fb.activeFiles[0]); recent {
+			fmt.Fprintf(logOutput, "Error: not deleting %s, modified %v ago (--protect_recent_seconds), so %d files will exceed --num_files %d\n",
+				fb.activeFiles[0], age.Round(time.Millisecond), len(fb.activeFiles)+1, fb.maxNumFiles)
+			break
+		}
+		// Kept, e.g. by --pre_delete_hook_strict, so it's still tracked
+		if !fb.removeFile(fb.activeFiles[0], "oldest") {
+			break
 		}
 		fb.activeFiles = fb.activeFiles[1:]
 	}
 
+	// Delete files past their maximum age
+	fb.deleteExpiredFiles()
+
 	// Generate filename
-	filename := fb.generateFilename()
+	filename, err := fb.generateFilename()
+	if err != nil {
+		return err
+	}
 
 	// Create file
-	f, err := os.Create(filename)
+	var f *os.File
+	err = fb.retryTransient("creating "+filename, func() (err error) {
+		f, err = os.Create(filename)
+		return err
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating file %s: %s", filename, err.Error())
-		os.Exit(1)
+		return fmt.Errorf("creating file %s: %w", filename, err)
 	}
 
+	fb.preallocateFile(f)
+
 	// Store file handle and create NEW gzip writer for this file with specified compression level
 	fb.currentFile = f
-	gzWriter, err := gzip.NewWriterLevel(f, fb.compressionLevel)
+	fb.fileStartCompressed = fb.counters.bytesCompressed.Load()
+	fb.memberStartCompressed = 0
+	fb.memberStartUncompressed = 0
+	fb.gzipDest = &countingWriter{&rateLimitedWriter{&retryWriter{fb, f, "writing " + filename}, fb.outputLimiter}, &fb.counters.bytesCompressed}
+	gzWriter, err := fb.newGzipStream(fb.gzipDest)
 	if err != nil {
 		f.Close()
-		fmt.Fprintf(os.Stderr, "Error creating gzip writer for file %s: %s", filename, err.Error())
-		os.Exit(1)
+		fb.currentFile = nil
+		return fmt.Errorf("creating gzip writer for file %s: %w", filename, err)
 	}
 	fb.gzipWriter = gzWriter
+	fb.gzipMemberClosed = false
+	fb.resetChecksum(gzWriter)
+	openedAt := time.Now()
+	fb.setGzipName(gzWriter, filename, openedAt)
+	fb.syncBytesWritten = 0
+	fb.fileDataBytes = 0
+	fb.fileStartUncompressed = fb.counters.bytesUncompressed.Load()
+	fb.fileStartBlocks = fb.counters.blocksFound.Load()
+	fb.fileStartScansFailed = fb.counters.blockScansFailed.Load()
+	fb.fileStartScanBytes = fb.counters.blockScanBytes.Load()
 	fb.fileCounter++
 	fb.activeFiles = append(fb.activeFiles, filename)
+	closedFile := fb.currentFileName
+	fb.currentFileName = filename
+	fb.currentFileOpenedAt = openedAt
+	fb.counters.filesCreated.Add(1)
 
 	if !fb.quiet {
-		fmt.Fprintf(os.Stderr, "Created new file: %s (counter: %d, compression: %d)\n", filename, fb.fileCounter, fb.compressionLevel)
+		fmt.Fprintf(logOutput, "Created new file: %s (counter: %d, compression: %d)\n", filename, fb.fileCounter, fb.compressionLevel)
 	}
+	fb.openMirror(filename)
+	fb.openCompanion(filename)
+	fb.openIndex(filename)
 
 	// Write header to new files if it's been captured
 	if fb.headerCaptured && fb.headerBytes > 0 {
+		fb.updateChecksum(fb.header)
 		if _, err := fb.gzipWriter.Write(fb.header); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing header to file %s: %s", filename, err.Error())
-			os.Exit(1)
+			return fmt.Errorf("writing header to file %s: %w", filename, err)
 		}
+		fb.counters.bytesUncompressed.Add(int64(len(fb.header)))
+		fb.writeMirror(fb.header)
+		fb.writeCompanion(fb.header)
 		if !fb.quiet {
-			fmt.Fprintf(os.Stderr, "Wrote %d header bytes to file\n", len(fb.header))
+			fmt.Fprintf(logOutput, "Wrote %d header bytes to file\n", len(fb.header))
 		}
 	}
+	if err := fb.injectBlockAfterHeader(); err != nil {
+		return err
+	}
+	if err := fb.startSectionHeader(); err != nil {
+		return err
+	}
+
+	fb.saveState()
+	fb.notifyRotate(closedFile, filename)
+	fb.archiveFile(closedFile)
+	return nil
+}
+
+// removeFile deletes a managed file (and its mirror, uncompressed copy and index), with kind describing
+// why for the log, e.g. "oldest". Returns false if the file is still on disk.
+func (fb *FileBuffer) removeFile(path, kind string) bool {
+	if !fb.runPreDeleteHook(path) {
+		return false
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(logOutput, "Warning: failed to delete %s file %s: %v\n", kind, path, err)
+		return false
+	}
+	fb.counters.filesDeleted.Add(1)
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Deleted %s file: %s\n", kind, path)
+	}
+	fb.removeEmptySubdirs(path)
+	fb.removeMirror(path)
+	fb.removeCompanion(path)
+	fb.removeIndex(path)
+	fb.notifyDelete(path)
+	return true
 }
 
 func (fb *FileBuffer) closeCurrentFile() {
+	if fb.verbose {
+		defer fb.logTiming("closeCurrentFile", time.Now())
+	}
+
+	fb.writeFileTrailer()
+	fb.closeMirror(true)
+	fb.closeCompanion()
+	fb.closeIndex()
+
 	if fb.gzipWriter == nil && fb.currentFile == nil {
 		return
 	}
+	fb.logBlockScans()
 
 	// Close gzip writer first to flush compressed data
 	if fb.gzipWriter != nil {
@@ -158,77 +672,185 @@ func (fb *FileBuffer) closeCurrentFile() {
 			if fb.currentFile != nil {
 				fb.currentFile.Close()
 			}
-			fmt.Fprintf(os.Stderr, "Error closing gzip writer: %s", err.Error())
+			fmt.Fprintf(logOutput, "Error closing gzip writer: %s", err.Error())
 		}
 		fb.gzipWriter = nil
 	}
 
+	// The FIFO stays open for the next member
+	if fb.fifo != nil {
+		return
+	}
+
+	// Leave stdout open, it isn't ours to close
+	if fb.currentFile == os.Stdout {
+		fb.currentFile = nil
+		return
+	}
+
 	// Close the file
 	if fb.currentFile != nil {
+		fb.trimPreallocation()
+		if err := fb.finishChecksum(fb.currentFile); err != nil {
+			fmt.Fprintf(logOutput, "Error writing checksum to %s: %v\n", fb.currentFileName, err)
+		}
 		if err := fb.currentFile.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing file: %s", err.Error())
+			fmt.Fprintf(logOutput, "Error closing file: %s", err.Error())
 		}
 		fb.currentFile = nil
 	}
+
+	closed := fb.newWebhookPayload()
+	fb.sendWebhook(closed)
+	fb.sendStatsd(closed)
+	fb.queueVerify(closed.File, closed.BytesUncompressed)
+	fb.queueUpload(fb.currentFileName)
+	fb.enforceTotalBytes()
+	fb.saveState()
 }
 
-func (fb *FileBuffer) generateFilename() string {
-	var timestamp string
+// formatTimestamp formats t for a filename with the time format, which is a
+// Go time layout or one of epoch, epoch_ms or epoch_ns
+func (fb *FileBuffer) formatTimestamp(t time.Time) string {
+	switch fb.timeFormat {
+	case "epoch":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "epoch_ms":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case "epoch_ns":
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
 	if fb.useLocalTime {
-		timestamp = time.Now().Local().Format(fb.timeFormat)
-	} else {
-		timestamp = time.Now().UTC().Format(fb.timeFormat)
+		return t.Local().Format(fb.timeFormat)
 	}
+	return t.UTC().Format(fb.timeFormat)
+}
 
+// timestampPattern matches the timestamp part of a filename
+func (fb *FileBuffer) timestampPattern() string {
+	switch fb.timeFormat {
+	case "epoch", "epoch_ms", "epoch_ns":
+		return `\d+(?:_\d+)?` // Allowing for a collision suffix
+	}
+	return `.*`
+}
+
+// maxCollisionSuffix is how many _N suffixes generateFilename tries when the
+// filename it generates already exists
+const maxCollisionSuffix = 999
+
+// generateFilename returns the next filename, adding a _N suffix to the
+// timestamp if needed so an existing file is never overwritten
+func (fb *FileBuffer) generateFilename() (string, error) {
+	// A seventh digit would break the name order and resuming
+	if fb.fileCounter > maxFileCounter {
+		return "", fmt.Errorf("file counter %d doesn't fit the 6 digits in filenames", fb.fileCounter)
+	}
+
+	now := fb.clockFn()
+	timestamp := fb.formatTimestamp(now)
+	for n := 0; n <= maxCollisionSuffix; n++ {
+		candidate := timestamp
+		if n > 0 {
+			candidate = fmt.Sprintf("%s_%d", timestamp, n)
+		}
+		filename, err := fb.withSubdir(fb.buildFilename(candidate), now)
+		if err != nil {
+			return "", fmt.Errorf("creating subdirectory: %w", err)
+		}
+		if _, err := os.Lstat(filename); os.IsNotExist(err) {
+			if n > 0 {
+				fmt.Fprintf(logOutput, "Warning: file already exists, using %s instead\n", filename)
+			}
+			return filename, nil
+		}
+	}
+	return "", fmt.Errorf("no unused filename for counter %d after %d attempts", fb.fileCounter, maxCollisionSuffix+1)
+}
+
+func (fb *FileBuffer) buildFilename(timestamp string) string {
 	// Split prefix into name and extension
 	ext := filepath.Ext(fb.filePrefix)
 	nameWithoutExt := strings.TrimSuffix(fb.filePrefix, ext)
 
 	// Create filename with zero-padded counter
 	// Using 6 digits for counter to support large rotations
-	if ext != "" {
-		return fmt.Sprintf("%s_%06d_%s%s.gz", nameWithoutExt, fb.fileCounter, timestamp, ext)
+	var filename string
+	if fb.filenameTemplate != nil {
+		var err error
+		filename, err = fb.expandFilenameTemplate(fmt.Sprintf("%06d", fb.fileCounter), timestamp)
+		if err != nil {
+			fmt.Fprintf(logOutput, "Warning: --filename_template failed, using the default filename: %v\n", err)
+		}
 	}
-	return fmt.Sprintf("%s_%06d_%s.gz", fb.filePrefix, fb.fileCounter, timestamp)
+	if filename == "" {
+		if ext != "" {
+			filename = fmt.Sprintf("%s%s%06d%s%s%s%s", nameWithoutExt, fb.filenameSep, fb.fileCounter, fb.filenameSep, timestamp, ext, fb.outputExtension)
+		} else {
+			filename = fmt.Sprintf("%s%s%06d%s%s%s", fb.filePrefix, fb.filenameSep, fb.fileCounter, fb.filenameSep, timestamp, fb.outputExtension)
+		}
+	}
+
+	// Spread successive files across the output directories round-robin
+	if len(fb.outputDirs) > 0 {
+		dir := fb.outputDirs[fb.fileCounter%len(fb.outputDirs)]
+		return filepath.Join(dir, filepath.Base(filename))
+	}
+	return filename
 }
 
-func (fb *FileBuffer) loadExistingFiles() {
+// existingFilePattern returns a pattern matching the names of the files
+// generateFilename creates, with the counter as group 1, and the directory
+// they're in
+func (fb *FileBuffer) existingFilePattern() (*regexp.Regexp, string, error) {
+	if fb.filenameTemplate != nil {
+		dir, re, err := fb.templatePattern()
+		if err != nil {
+			return nil, "", fmt.Errorf("--filename_template: %w", err)
+		}
+		return re, dir, nil
+	}
+
 	// Build regex pattern for matching files
 	ext := filepath.Ext(fb.filePrefix)
 	nameWithoutExt := strings.TrimSuffix(fb.filePrefix, ext)
-	escapedName := regexp.QuoteMeta(nameWithoutExt)
+	escapedName := regexp.QuoteMeta(filepath.Base(nameWithoutExt))
+	escapedOutputExt := regexp.QuoteMeta(fb.outputExtension)
+	escapedSep := regexp.QuoteMeta(fb.filenameSep)
 
 	var pattern string
 	if ext != "" {
 		escapedExt := regexp.QuoteMeta(ext)
-		pattern = fmt.Sprintf(`^%s_(\d{6})_.*%s\.gz$`, escapedName, escapedExt)
+		pattern = fmt.Sprintf(`^%s%s(\d{6})%s%s%s%s$`, escapedName, escapedSep, escapedSep, fb.timestampPattern(), escapedExt, escapedOutputExt)
 	} else {
-		pattern = fmt.Sprintf(`^%s_(\d{6})_.*\.gz$`, escapedName)
+		pattern = fmt.Sprintf(`^%s%s(\d{6})%s%s%s$`, escapedName, escapedSep, escapedSep, fb.timestampPattern(), escapedOutputExt)
 	}
 
 	re, err := regexp.Compile(pattern)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error compiling regex pattern: %s", err.Error())
-		return
+		return nil, "", err
 	}
 
-	// Get directory and base name for globbing
 	dir := filepath.Dir(fb.filePrefix)
-	if dir == "." || dir == "" {
+	if dir == "" {
 		dir = "."
 	}
+	return re, dir, nil
+}
 
-	// Read directory entries
-	entries, err := os.ReadDir(dir)
+func (fb *FileBuffer) loadExistingFiles() {
+	re, dir, err := fb.existingFilePattern()
 	if err != nil {
-		// If directory doesn't exist, that's okay - no files to load
-		if os.IsNotExist(err) {
-			return
-		}
-		fmt.Fprintf(os.Stderr, "Error reading directory %s: %s", dir, err.Error())
+		fmt.Fprintf(logOutput, "Error: can't match existing files: %s\n", err.Error())
 		return
 	}
 
+	// Get directories to search
+	dirs := fb.outputDirs
+	if len(dirs) == 0 {
+		dirs = []string{dir}
+	}
+
 	// Find and parse matching files
 	type fileInfo struct {
 		path    string
@@ -236,32 +858,42 @@ func (fb *FileBuffer) loadExistingFiles() {
 	}
 	var matchedFiles []fileInfo
 
-	for _, entry := range entries {
-		if entry.IsDir() {
+	for _, dir := range dirs {
+		paths, err := fb.listOutputFiles(dir)
+		if err != nil {
+			// If directory doesn't exist, that's okay - no files to load
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(logOutput, "Error reading directory %s: %s\n", dir, err.Error())
+			}
 			continue
 		}
+		for _, fullPath := range paths {
+			filename := filepath.Base(fullPath)
+			if !re.MatchString(filename) {
+				continue
+			}
+			// Without an output extension the pattern also matches files
+			// set aside by --verify_on_resume
+			if strings.HasSuffix(filename, ".corrupt") {
+				continue
+			}
 
-		filename := entry.Name()
-		if !re.MatchString(filename) {
-			continue
-		}
+			// Extract counter from filename
+			matches := re.FindStringSubmatch(filename)
+			if len(matches) < 2 {
+				continue
+			}
 
-		// Extract counter from filename
-		matches := re.FindStringSubmatch(filename)
-		if len(matches) < 2 {
-			continue
-		}
+			counter, err := strconv.Atoi(matches[1])
+			if err != nil {
+				continue
+			}
 
-		counter, err := strconv.Atoi(matches[1])
-		if err != nil {
-			continue
+			matchedFiles = append(matchedFiles, fileInfo{
+				path:    fullPath,
+				counter: counter,
+			})
 		}
-
-		fullPath := filepath.Join(dir, filename)
-		matchedFiles = append(matchedFiles, fileInfo{
-			path:    fullPath,
-			counter: counter,
-		})
 	}
 
 	// Sort by counter
@@ -272,12 +904,13 @@ func (fb *FileBuffer) loadExistingFiles() {
 	// Delete excess files if more than maxNumFiles
 	if len(matchedFiles) > fb.maxNumFiles {
 		filesToDelete := matchedFiles[:len(matchedFiles)-fb.maxNumFiles]
+		var kept []fileInfo
 		for _, f := range filesToDelete {
-			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "Warning: failed to delete excess file %s: %v\n", f.path, err)
+			if !fb.removeFile(f.path, "excess") {
+				kept = append(kept, f)
 			}
 		}
-		matchedFiles = matchedFiles[len(matchedFiles)-fb.maxNumFiles:]
+		matchedFiles = append(kept, matchedFiles[len(matchedFiles)-fb.maxNumFiles:]...)
 	}
 
 	// Populate activeFiles
@@ -293,7 +926,7 @@ func (fb *FileBuffer) loadExistingFiles() {
 
 	if len(fb.activeFiles) > 0 {
 		if !fb.quiet {
-			fmt.Fprintf(os.Stderr, "Loaded %d existing file(s), resuming from counter %d\n",
+			fmt.Fprintf(logOutput, "Loaded %d existing file(s), resuming from counter %d\n",
 				len(fb.activeFiles), fb.fileCounter)
 		}
 	}
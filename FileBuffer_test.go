@@ -0,0 +1,931 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFileBuffer makes a FileBuffer writing quietly to a temporary
+// directory, with opts applied after the defaults
+func newTestFileBuffer(t testing.TB, opts ...Option) *FileBuffer {
+	t.Helper()
+	defaults := []Option{
+		WithPrefix(filepath.Join(t.TempDir(), "test")),
+		WithMaxFileSize(1 << 20),
+		WithMaxNumFiles(10),
+		WithQuiet(true),
+	}
+	fb, err := NewFileBuffer(append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("NewFileBuffer: %v", err)
+	}
+	return fb
+}
+
+// readGzipFiles decompresses each of paths
+func readGzipFiles(t testing.TB, paths []string) [][]byte {
+	t.Helper()
+	var contents [][]byte
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			t.Fatalf("%s: %v", path, err)
+		}
+		data, err := io.ReadAll(zr)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		contents = append(contents, data)
+	}
+	return contents
+}
+
+// discardLog sends log output to os.DevNull until the test ends, for
+// benchmarks that would otherwise log on every iteration
+func discardLog(tb testing.TB) {
+	tb.Helper()
+	if err := logOutput.open(os.DevNull); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { logOutput.Close() })
+}
+
+// captureLog sends log output to a file for the rest of the test and
+// returns a function giving what's been logged so far
+func captureLog(tb testing.TB) func() string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "log")
+	if err := logOutput.open(path); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { logOutput.Close() })
+	return func() string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return string(data)
+	}
+}
+
+func TestGenerateFilename(t *testing.T) {
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 890_000_000, time.UTC)
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*3600)
+	t.Cleanup(func() { time.Local = local })
+	discardLog(t)
+
+	// collisions names the files for counter 1 at clock in epoch format
+	// that already exist: the name without a suffix and then _1, _2...
+	collisions := func(n int) []string {
+		var names []string
+		for i := range n {
+			suffix := ""
+			if i > 0 {
+				suffix = fmt.Sprintf("_%d", i)
+			}
+			names = append(names, "cap_000001_1741064767"+suffix+".gz")
+		}
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		opts     []Option
+		counter  int
+		existing []string
+		want     string
+		wantErr  string
+	}{
+		{name: "prefix with extension", prefix: "cap.pcap", counter: 1, want: "cap_000001_2025-03-04T05:06:07.890Z.pcap.gz"},
+		{name: "prefix without extension", prefix: "cap", counter: 1, want: "cap_000001_2025-03-04T05:06:07.890Z.gz"},
+		{name: "local time", prefix: "cap", opts: []Option{WithLocalTime(true)}, counter: 1, want: "cap_000001_2025-03-04T10:06:07.890Z.gz"},
+		{name: "custom time format", prefix: "cap", opts: []Option{WithTimeFormat("Jan _2 15:04:05 (MST) .000")}, counter: 1, want: "cap_000001_Mar  4 05:06:07 (UTC) .890.gz"},
+		{name: "epoch", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: 1, want: "cap_000001_1741064767.gz"},
+		{name: "epoch_ms", prefix: "cap", opts: []Option{WithTimeFormat("epoch_ms")}, counter: 1, want: "cap_000001_1741064767890.gz"},
+		{name: "epoch_ns", prefix: "cap", opts: []Option{WithTimeFormat("epoch_ns")}, counter: 1, want: "cap_000001_1741064767890000000.gz"},
+		{name: "no output extension or separator", prefix: "cap.pcap", opts: []Option{WithOutputExtension(""), WithFilenameSep(""), WithTimeFormat("epoch")}, counter: 1, want: "cap0000011741064767.pcap"},
+		{name: "no output extension", prefix: "cap", opts: []Option{WithOutputExtension(""), WithTimeFormat("epoch")}, counter: 1, want: "cap_000001_1741064767"},
+		{name: "zst output extension", prefix: "cap", opts: []Option{WithOutputExtension(".zst"), WithTimeFormat("epoch")}, counter: 1, want: "cap_000001_1741064767.zst"},
+		{name: "output extension without a dot", prefix: "cap.pcap", opts: []Option{WithOutputExtension("zst"), WithTimeFormat("epoch")}, counter: 1, want: "cap_000001_1741064767.pcap.zst"},
+		{name: "counter 0", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: 0, want: "cap_000000_1741064767.gz"},
+		{name: "counter 999999", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: maxFileCounter, want: "cap_999999_1741064767.gz"},
+		{name: "counter 1000000", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: maxFileCounter + 1, wantErr: "doesn't fit the 6 digits"},
+		{name: "collision", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: 1, existing: collisions(1), want: "cap_000001_1741064767_1.gz"},
+		{name: "collisions", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: 1, existing: collisions(3), want: "cap_000001_1741064767_3.gz"},
+		{name: "last collision suffix", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: 1, existing: collisions(maxCollisionSuffix), want: "cap_000001_1741064767_999.gz"},
+		{name: "out of collision suffixes", prefix: "cap", opts: []Option{WithTimeFormat("epoch")}, counter: 1, existing: collisions(maxCollisionSuffix + 1), wantErr: "no unused filename"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fb := newTestFileBuffer(t, append([]Option{WithPrefix(filepath.Join(dir, tt.prefix))}, tt.opts...)...)
+			fb.clockFn = func() time.Time { return clock }
+			fb.fileCounter = tt.counter
+
+			got, err := fb.generateFilename()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("generateFilename() = %q, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generateFilename: %v", err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("generateFilename() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// Each rotation takes the next counter, so files opened at the same time
+// still get different names
+func TestGenerateFilenameCounter(t *testing.T) {
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	dir := t.TempDir()
+	fb := newTestFileBuffer(t, WithPrefix(filepath.Join(dir, "cap")), WithTimeFormat("epoch"), WithCounterStart(7))
+	fb.clockFn = func() time.Time { return clock }
+	for range 3 {
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb.close()
+
+	var names []string
+	for _, path := range fb.activeFiles {
+		names = append(names, filepath.Base(path))
+	}
+	want := []string{"cap_000007_1741064767.gz", "cap_000008_1741064767.gz", "cap_000009_1741064767.gz"}
+	if !slices.Equal(names, want) {
+		t.Errorf("files = %q, want %q", names, want)
+	}
+	if fb.fileCounter != 10 {
+		t.Errorf("fileCounter = %d, want 10", fb.fileCounter)
+	}
+}
+
+// TestFilenameCollision opens a file whose name is already taken, by
+// another instance sharing the directory, and checks it's left alone
+func TestFilenameCollision(t *testing.T) {
+	discardLog(t)
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	prefix := filepath.Join(t.TempDir(), "cap")
+	existing := prefix + "_000000_1741064767.gz"
+	if err := os.WriteFile(existing, []byte("another instance's file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fb := newTestFileBuffer(t, WithPrefix(prefix), WithTimeFormat("epoch"))
+	fb.clockFn = func() time.Time { return clock }
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	fb.write([]byte("this instance's data\n"))
+	fb.close()
+
+	if want := prefix + "_000000_1741064767_1.gz"; fb.currentFileName != want {
+		t.Errorf("opened %s, want %s", fb.currentFileName, want)
+	}
+	if got, err := os.ReadFile(existing); err != nil || string(got) != "another instance's file" {
+		t.Errorf("existing file now holds %q, %v", got, err)
+	}
+	if got := readGzipFiles(t, []string{fb.currentFileName}); string(got[0]) != "this instance's data\n" {
+		t.Errorf("new file holds %q", got[0])
+	}
+}
+
+// A resumed counter takes precedence over --counter_start, which is only
+// used when there are no files to resume
+func TestCounterStartResume(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "cap")
+	resume := func() *FileBuffer {
+		fb := newTestFileBuffer(t, WithPrefix(prefix), WithCounterStart(100), WithResumeExisting(true))
+		fb.resume()
+		if err := fb.openNewFile(); err != nil {
+			t.Fatal(err)
+		}
+		fb.close()
+		return fb
+	}
+
+	fb := resume()
+	if !strings.HasPrefix(filepath.Base(fb.currentFileName), "cap_000100_") {
+		t.Errorf("first file %s, want counter 100", fb.currentFileName)
+	}
+	if err := os.Rename(fb.currentFileName, prefix+"_000003_2025-03-04T05:06:07.890Z.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if fb = resume(); !strings.HasPrefix(filepath.Base(fb.currentFileName), "cap_000004_") {
+		t.Errorf("resumed with file %s, want counter 4", fb.currentFileName)
+	}
+}
+
+// TestEpochTimestamp checks the epoch time formats name files with the
+// current time as a number, and those names are found again on resuming,
+// with or without a collision suffix
+func TestEpochTimestamp(t *testing.T) {
+	tests := []struct {
+		format string
+		unit   time.Duration
+	}{
+		{"epoch", time.Second},
+		{"epoch_ms", time.Millisecond},
+		{"epoch_ns", time.Nanosecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "cap")
+			fb := newTestFileBuffer(t, WithPrefix(prefix), WithTimeFormat(tt.format))
+			before := time.Now()
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			after := time.Now()
+			fb.close()
+
+			name := filepath.Base(fb.currentFileName)
+			timestamp, ok := strings.CutPrefix(strings.TrimSuffix(name, ".gz"), "cap_000000_")
+			if !ok {
+				t.Fatalf("file %s isn't named cap_000000_<timestamp>.gz", name)
+			}
+			n, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				t.Fatalf("timestamp %q isn't a number: %v", timestamp, err)
+			}
+			if got := time.Unix(0, n*int64(tt.unit)); got.Before(before.Truncate(tt.unit)) || got.After(after) {
+				t.Errorf("timestamp %s is %v, want between %v and %v", timestamp, got, before, after)
+			}
+
+			// Another file from the same time, and one that isn't an epoch
+			collision := fmt.Sprintf("%s_000001_%s_1.gz", prefix, timestamp)
+			for _, path := range []string{collision, prefix + "_000002_2025-03-04T05:06:07.890Z.gz"} {
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resumed := newTestFileBuffer(t, WithPrefix(prefix), WithTimeFormat(tt.format))
+			resumed.loadExistingFiles()
+			if want := []string{fb.currentFileName, collision}; !slices.Equal(resumed.activeFiles, want) || resumed.fileCounter != 2 {
+				t.Errorf("resumed files %q, counter %d, want %q and 2", resumed.activeFiles, resumed.fileCounter, want)
+			}
+		})
+	}
+}
+
+// TestFilenameSep checks --filename_sep separates the prefix, counter and
+// timestamp, and loadExistingFiles finds the files named with the same
+// separator but not with another
+func TestFilenameSep(t *testing.T) {
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		sep, other string
+		want       []string
+	}{
+		{"_", "-", []string{"cap_000000_1741064767.pcap.gz", "cap_000001_1741064767.pcap.gz"}},
+		{"-", "_", []string{"cap-000000-1741064767.pcap.gz", "cap-000001-1741064767.pcap.gz"}},
+		{"", "_", []string{"cap0000001741064767.pcap.gz", "cap0000011741064767.pcap.gz"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.sep), func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "cap.pcap")
+			newFileBuffer := func(sep string) *FileBuffer {
+				fb := newTestFileBuffer(t, WithPrefix(prefix), WithTimeFormat("epoch"), WithFilenameSep(sep))
+				fb.clockFn = func() time.Time { return clock }
+				return fb
+			}
+			fb := newFileBuffer(tt.sep)
+			for range 2 {
+				if _, err := fb.Rotate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fb.close()
+			var names []string
+			for _, path := range fb.activeFiles {
+				names = append(names, filepath.Base(path))
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("files = %q, want %q", names, tt.want)
+			}
+
+			resumed := newFileBuffer(tt.sep)
+			resumed.loadExistingFiles()
+			if !slices.Equal(resumed.activeFiles, fb.activeFiles) || resumed.fileCounter != 2 {
+				t.Errorf("resumed files %q, counter %d, want %q and 2", resumed.activeFiles, resumed.fileCounter, fb.activeFiles)
+			}
+			other := newFileBuffer(tt.other)
+			other.loadExistingFiles()
+			if len(other.activeFiles) != 0 {
+				t.Errorf("with separator %q resumed files %q, want none", tt.other, other.activeFiles)
+			}
+		})
+	}
+}
+
+// TestRotateOnUncompressed writes zeros, which compress so well the file on
+// disk never reaches --file_size, and checks --rotate_on_uncompressed
+// rotates on the bytes written instead, counted afresh for each file
+func TestRotateOnUncompressed(t *testing.T) {
+	zeros := make([]byte, 64*1024)
+	for _, uncompressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("uncompressed=%v", uncompressed), func(t *testing.T) {
+			log := captureLog(t)
+			fb := newTestFileBuffer(t, WithMaxFileSize(100*1024), WithRotateOnUncompressed(uncompressed), WithQuiet(false))
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			for range 4 {
+				fb.write(zeros)
+			}
+			fb.close()
+
+			want := [][]byte{make([]byte, 256*1024)}
+			if uncompressed {
+				want = [][]byte{make([]byte, 128*1024), make([]byte, 128*1024)}
+			}
+			contents := readGzipFiles(t, fb.activeFiles)
+			if !reflect.DeepEqual(contents, want) {
+				t.Fatalf("wrote %d files, want %d", len(contents), len(want))
+			}
+			rotating := regexp.MustCompile(`Rotating at \d+ bytes compressed, 131072 uncompressed\n`)
+			if rotating.MatchString(log()) != uncompressed {
+				t.Errorf("log has a line matching %s: %v, want %v:\n%s", rotating, !uncompressed, uncompressed, log())
+			}
+		})
+	}
+}
+
+// Rotate returns the file it closed, the first one generated, and the next
+// rotation closes the file it opened and opens another
+func TestRotate(t *testing.T) {
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	dir := t.TempDir()
+	fb := newTestFileBuffer(t, WithPrefix(filepath.Join(dir, "cap")), WithTimeFormat("epoch"))
+	fb.clockFn = func() time.Time { return clock }
+	first, err := fb.generateFilename()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	closed, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed != first {
+		t.Errorf("first Rotate() = %q, want the first file %q", closed, first)
+	}
+	second := fb.currentFileName
+	fb.write([]byte("written to the second file\n"))
+
+	closed, err = fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed != second || closed == first {
+		t.Errorf("second Rotate() = %q, want the second file %q", closed, second)
+	}
+	if fb.currentFileName == second {
+		t.Errorf("second Rotate() didn't open a new file")
+	}
+	fb.close()
+
+	if !slices.Equal(fb.activeFiles, []string{first, second, fb.currentFileName}) {
+		t.Errorf("files = %q, want %q, %q and a third", fb.activeFiles, first, second)
+	}
+	contents := readGzipFiles(t, fb.activeFiles)
+	if string(contents[1]) != "written to the second file\n" || len(contents[0]) != 0 || len(contents[2]) != 0 {
+		t.Errorf("contents = %q, want the write in the second file only", contents)
+	}
+}
+
+func TestLoadExistingFiles(t *testing.T) {
+	const timestamp = "2025-03-04T05:06:07.890Z"
+	// counted names the files for counters, with the default prefix "cap"
+	counted := func(counters ...int) []string {
+		var names []string
+		for _, c := range counters {
+			names = append(names, fmt.Sprintf("cap_%06d_%s.gz", c, timestamp))
+		}
+		return names
+	}
+
+	tests := []struct {
+		name        string
+		prefix      string // in the test's directory, "cap" if empty
+		opts        []Option
+		existing    []string
+		wantActive  []string
+		wantDeleted []string
+		wantCounter int
+	}{
+		{
+			name:        "exactly max files",
+			existing:    counted(1, 2, 3),
+			wantActive:  counted(1, 2, 3),
+			wantCounter: 4,
+		},
+		{
+			name:        "more than max files",
+			existing:    counted(1, 2, 3, 4, 5),
+			wantActive:  counted(3, 4, 5),
+			wantDeleted: counted(1, 2),
+			wantCounter: 6,
+		},
+		{
+			name:        "non-matching files ignored",
+			existing:    append(counted(1, 2), "other.txt", "cap_12345_"+timestamp+".gz", "cap_000009_"+timestamp, "capture_000009_"+timestamp+".gz"),
+			wantActive:  counted(1, 2),
+			wantCounter: 3,
+		},
+		{
+			name:   "different extension",
+			prefix: "cap.pcap",
+			existing: []string{
+				"cap_000001_" + timestamp + ".pcap.gz",
+				"cap_000002_" + timestamp + ".txt.gz",
+				"cap_000003_" + timestamp + ".gz",
+			},
+			wantActive:  []string{"cap_000001_" + timestamp + ".pcap.gz"},
+			wantCounter: 2,
+		},
+		{
+			name:   "output extension",
+			prefix: "cap.pcap",
+			opts:   []Option{WithOutputExtension("zst")},
+			existing: []string{
+				"cap_000001_" + timestamp + ".pcap.zst",
+				"cap_000002_" + timestamp + ".pcap.gz",
+				"cap_000003_" + timestamp + ".pcap",
+			},
+			wantActive:  []string{"cap_000001_" + timestamp + ".pcap.zst"},
+			wantCounter: 2,
+		},
+		{
+			name:        "no output extension",
+			prefix:      "cap.pcap",
+			opts:        []Option{WithOutputExtension("")},
+			existing:    []string{"cap_000001_" + timestamp + ".pcap", "cap_000002_" + timestamp + ".pcap.gz"},
+			wantActive:  []string{"cap_000001_" + timestamp + ".pcap"},
+			wantCounter: 2,
+		},
+		{
+			name:        "counter gaps",
+			existing:    counted(1, 3, 5),
+			wantActive:  counted(1, 3, 5),
+			wantCounter: 6,
+		},
+		{
+			name:        "no matching files",
+			existing:    []string{"other.txt"},
+			wantCounter: 0,
+		},
+		{
+			name:        "missing directory",
+			prefix:      filepath.Join("missing", "cap"),
+			wantCounter: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				f, err := os.Create(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Close()
+			}
+			prefix := tt.prefix
+			if prefix == "" {
+				prefix = "cap"
+			}
+			fb := newTestFileBuffer(t, append([]Option{WithPrefix(filepath.Join(dir, prefix)), WithMaxNumFiles(3)}, tt.opts...)...)
+
+			fb.loadExistingFiles()
+
+			if len(fb.activeFiles) != len(tt.wantActive) {
+				t.Fatalf("activeFiles = %q, want %q", fb.activeFiles, tt.wantActive)
+			}
+			for i, name := range tt.wantActive {
+				if want := filepath.Join(dir, name); fb.activeFiles[i] != want {
+					t.Errorf("activeFiles[%d] = %q, want %q", i, fb.activeFiles[i], want)
+				}
+			}
+			if fb.fileCounter != tt.wantCounter {
+				t.Errorf("fileCounter = %d, want %d", fb.fileCounter, tt.wantCounter)
+			}
+
+			for _, name := range tt.existing {
+				_, err := os.Stat(filepath.Join(dir, name))
+				if exists, want := err == nil, !slices.Contains(tt.wantDeleted, name); exists != want {
+					t.Errorf("%s exists = %v, want %v", name, exists, want)
+				}
+			}
+		})
+	}
+}
+
+// With --output stdout, everything goes into one gzip stream on stdout
+// whatever --file_size is, and a flush makes what's been written so far
+// readable
+func TestStdoutOutput(t *testing.T) {
+	stdout := newPipeReader(t)
+	saved := os.Stdout
+	os.Stdout = stdout.w
+	defer func() { os.Stdout = saved }()
+
+	dir := t.TempDir()
+	fb := newTestFileBuffer(t, WithStdout(true), WithPrefix(filepath.Join(dir, "test")), WithMaxFileSize(1024))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	input := packetCapture(256 * 1024)
+	for i := 0; i < 128*1024; i += 16 * 1024 {
+		fb.write(input[i : i+16*1024])
+	}
+	fb.flush()
+
+	// Up to the flush decompresses before the stream is finished
+	for deadline := time.Now().Add(5 * time.Second); int64(stdout.len()) != fb.counters.bytesCompressed.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("read %d bytes, %d written", stdout.len(), fb.counters.bytesCompressed.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stdout.mu.Lock()
+	zr, err := gzip.NewReader(bytes.NewReader(stdout.buf.Bytes()))
+	stdout.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flushed := make([]byte, 128*1024)
+	if _, err := io.ReadFull(zr, flushed); err != nil || !bytes.Equal(flushed, input[:128*1024]) {
+		t.Errorf("didn't get everything written before the flush: %v", err)
+	}
+
+	for i := 128 * 1024; i < len(input); i += 16 * 1024 {
+		fb.write(input[i : i+16*1024])
+	}
+	fb.close()
+	os.Stdout = saved
+	stdout.w.Close()
+	<-stdout.done
+
+	got := gzipMembers(stdout.buf.Bytes())
+	if len(got) != 1 || !bytes.Equal(got[0], input) {
+		t.Errorf("stdout has %d gzip members, want 1 with the %d input bytes", len(got), len(input))
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d files written, want none", len(files))
+	}
+}
+
+// Successive files go to each of --output_dirs in turn, and the oldest are
+// deleted from whichever directory they're in
+func TestOutputDirs(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	fb := newTestFileBuffer(t, WithOutputDirs(dirs), WithMaxNumFiles(4))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	var opened []string
+	for i := range 6 {
+		opened = append(opened, fb.currentFileName)
+		fb.write([]byte(fmt.Sprintf("file %d\n", i)))
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb.close()
+
+	for i, path := range opened {
+		if dir := filepath.Dir(path); dir != dirs[i%len(dirs)] {
+			t.Errorf("file %d is in %s, want %s", i, dir, dirs[i%len(dirs)])
+		}
+		_, err := os.Stat(path)
+		// The 7th file opened by the last Rotate leaves 4 with the first 3 deleted
+		if exists, want := err == nil, i >= 3; exists != want {
+			t.Errorf("%s exists = %v, want %v", path, exists, want)
+		}
+	}
+	if want := append(opened[3:], fb.currentFileName); !slices.Equal(fb.activeFiles, want) {
+		t.Errorf("activeFiles = %q, want %q", fb.activeFiles, want)
+	}
+	contents := readGzipFiles(t, opened[3:])
+	for i, data := range contents {
+		if want := fmt.Sprintf("file %d\n", i+3); string(data) != want {
+			t.Errorf("%s holds %q, want %q", opened[i+3], data, want)
+		}
+	}
+
+	// Resuming finds the files in every directory, in counter order
+	resumed := newTestFileBuffer(t, WithOutputDirs(dirs), WithMaxNumFiles(4))
+	resumed.loadExistingFiles()
+	if !slices.Equal(resumed.activeFiles, fb.activeFiles) {
+		t.Errorf("resumed with %q, want %q", resumed.activeFiles, fb.activeFiles)
+	}
+}
+
+// TestGzipSyncInterval writes 1MB with a sync point every 64KB and checks
+// the file decompresses up to each one, and that each 64KB segment can be
+// decompressed on its own starting from the sync point before it, given
+// only the preceding window of data
+func TestGzipSyncInterval(t *testing.T) {
+	const interval = 64 * 1024
+	fb := newTestFileBuffer(t, WithGzipSyncInterval(interval))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	data := syntheticLog(1 << 20)[:1<<20]
+	for i := 0; i < len(data); i += 10000 {
+		fb.write(data[i:min(i+10000, len(data))])
+	}
+	fb.close()
+	file, err := os.ReadFile(fb.currentFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A sync point ends with an empty stored block, 00 00 FF FF. The same
+	// bytes could turn up in the compressed data, so only those that the
+	// file decompresses exactly up to a boundary count.
+	syncPoints := make(map[int]int) // uncompressed boundary to file offset
+	for off := 0; ; {
+		i := bytes.Index(file[off:], []byte{0, 0, 0xFF, 0xFF})
+		if i < 0 {
+			break
+		}
+		off += i + 4
+		zr, err := gzip.NewReader(bytes.NewReader(file[:off]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(zr)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("reading up to offset %d: %v", off, err)
+		}
+		if len(got) > 0 && len(got)%interval == 0 && bytes.Equal(got, data[:len(got)]) {
+			syncPoints[len(got)] = off
+		}
+	}
+	if len(syncPoints) != len(data)/interval {
+		t.Fatalf("found %d sync points, want %d", len(syncPoints), len(data)/interval)
+	}
+
+	for start := interval; start < len(data); start += interval {
+		off, ok := syncPoints[start]
+		if !ok {
+			t.Fatalf("no sync point after %d bytes", start)
+		}
+		zr := flate.NewReaderDict(bytes.NewReader(file[off:]), data[start-32*1024:start])
+		segment := make([]byte, interval)
+		if _, err := io.ReadFull(zr, segment); err != nil {
+			t.Fatalf("decompressing from offset %d: %v", off, err)
+		}
+		if !bytes.Equal(segment, data[start:start+interval]) {
+			t.Errorf("segment from offset %d doesn't match the data written after %d bytes", off, start)
+		}
+	}
+}
+
+// TestGzipMultistream writes each chunk as its own gzip member and checks
+// each decompresses on its own from where the index says it starts, and
+// the index locates every block within its member
+func TestGzipMultistream(t *testing.T) {
+	fb := newTestFileBuffer(t, WithGzipMultistream(true), WithBlockFormat(presetFormat(t, "pcap")), WithIndex(true, "json"))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	var chunks [][]byte
+	for i := range 5 {
+		chunk := pcapRecords(3, 200, int64(i))
+		fb.write(chunk)
+		chunks = append(chunks, chunk)
+	}
+	fb.close()
+	all := slices.Concat(chunks...)
+
+	file, err := os.ReadFile(fb.currentFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// zcat and the like read it as one stream
+	if got := readGzipFiles(t, []string{fb.currentFileName}); !bytes.Equal(got[0], all) {
+		t.Errorf("file decompresses to %d bytes, want %d", len(got[0]), len(all))
+	}
+	if members := gzipMembers(file); len(members) != len(chunks) {
+		t.Fatalf("%d gzip members, want one for each of %d chunks", len(members), len(chunks))
+	}
+
+	indexData, err := os.ReadFile(fb.indexPath(fb.currentFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index []indexEntry
+	if err := json.Unmarshal(indexData, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 15 {
+		t.Fatalf("%d index entries, want one for each of 15 blocks", len(index))
+	}
+	var memberStarts []int64
+	for _, entry := range index {
+		if !slices.Contains(memberStarts, entry.CompressedOffset) {
+			memberStarts = append(memberStarts, entry.CompressedOffset)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(file[entry.CompressedOffset:]))
+		if err != nil {
+			t.Fatalf("block %d: no gzip member at offset %d: %v", entry.Block, entry.CompressedOffset, err)
+		}
+		zr.Multistream(false)
+		member, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("block %d: %v", entry.Block, err)
+		}
+		skip := entry.UncompressedOffset - entry.MemberUncompressedOffset
+		if !bytes.HasPrefix(member[skip:], all[entry.UncompressedOffset:entry.UncompressedOffset+16]) {
+			t.Errorf("block %d isn't %d bytes into the member at %d", entry.Block, skip, entry.CompressedOffset)
+		}
+	}
+	if len(memberStarts) != len(chunks) || memberStarts[0] != 0 {
+		t.Fatalf("index has members at %v, want %d starting at 0", memberStarts, len(chunks))
+	}
+	for i, start := range memberStarts {
+		if got := gzipMembers(file[start:])[0]; !bytes.Equal(got, chunks[i]) {
+			t.Errorf("member %d at offset %d decompresses to %d bytes, want chunk %d's %d", i, start, len(got), i, len(chunks[i]))
+		}
+	}
+}
+
+// TestHeaderCapture captures a header spanning exactly three writes, and
+// checks it's written once to the first file and in full to the next
+func TestHeaderCapture(t *testing.T) {
+	const chunkSize = 1024
+	stream := syntheticLog(5 * chunkSize)
+	header := stream[:3*chunkSize]
+
+	fb := newTestFileBuffer(t, WithHeaderBytes(len(header)), WithMaxNumFiles(2))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4*chunkSize; i += chunkSize {
+		if fb.headerCaptured != (i >= len(header)) {
+			t.Errorf("after %d bytes headerCaptured = %v", i, fb.headerCaptured)
+		}
+		fb.write(stream[i : i+chunkSize])
+	}
+	if !bytes.Equal(fb.header, header) {
+		t.Errorf("captured %d header bytes, want the first %d of the stream", len(fb.header), len(header))
+	}
+	first, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := stream[4*chunkSize:]
+	fb.write(rest)
+	fb.close()
+
+	got := readGzipFiles(t, []string{first, fb.currentFileName})
+	if !bytes.Equal(got[0], stream[:4*chunkSize]) {
+		t.Errorf("first file has %d bytes, want the %d written", len(got[0]), 4*chunkSize)
+	}
+	if want := slices.Concat(header, rest); !bytes.Equal(got[1], want) {
+		t.Errorf("second file has %d bytes, want the %d byte header then the %d written", len(got[1]), len(header), len(rest))
+	}
+}
+
+// TestGzipHeaderName checks each file's gzip header has its base name,
+// without the output extension, and the time it was opened, also when
+// written by the deflate writer for a preset dictionary
+func TestGzipHeaderName(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		ext    string
+		noName bool
+	}{
+		{"default", nil, ".gz", false},
+		{"output extension", []Option{WithOutputExtension(".pcap.gz")}, ".pcap.gz", false},
+		{"preset dictionary", []Option{WithGzipDictionary([]byte("some dictionary"), false)}, ".gz", false},
+		// gzip header names are Latin-1
+		{"not Latin-1", []Option{WithPrefix(filepath.Join(t.TempDir(), "捕获"))}, ".gz", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := newTestFileBuffer(t, tt.opts...)
+			before := time.Now().Truncate(time.Second)
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			after := time.Now()
+			fb.write([]byte("some data\n"))
+			fb.close()
+
+			f, err := os.Open(fb.currentFileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			z, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.TrimSuffix(filepath.Base(fb.currentFileName), tt.ext)
+			if tt.noName {
+				want = ""
+			}
+			if z.Name != want || !strings.HasSuffix(fb.currentFileName, tt.ext) {
+				t.Errorf("%s has name %q in its gzip header, want %q", fb.currentFileName, z.Name, want)
+			}
+			if z.ModTime.Before(before) || z.ModTime.After(after) {
+				t.Errorf("gzip header time %v, want the time the file was opened, %v to %v", z.ModTime, before, after)
+			}
+		})
+	}
+}
+
+// TestSetCompressionLevel changes the level part way through a file, and
+// checks it's used from the next file on, going by the gzip header's XFL
+// byte: 4 for the fastest level, 2 for the best, 0 otherwise
+func TestSetCompressionLevel(t *testing.T) {
+	fb := newTestFileBuffer(t)
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	data := syntheticLog(64 * 1024)
+	for _, level := range []int{flate.BestSpeed, flate.BestCompression} {
+		fb.write(data[:32*1024])
+		if err := fb.SetCompressionLevel(level); err != nil {
+			t.Fatal(err)
+		}
+		fb.write(data[32*1024:])
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb.write(data)
+	fb.close()
+
+	if err := fb.SetCompressionLevel(10); err == nil || fb.compressionLevel != flate.BestCompression {
+		t.Errorf("level 10 gave error %v, level %d, want an error and level %d", err, fb.compressionLevel, flate.BestCompression)
+	}
+	files := fb.activeFiles
+	for i, contents := range readGzipFiles(t, files) {
+		if !bytes.Equal(contents, data) {
+			t.Errorf("%s has %d bytes, want the %d written", files[i], len(contents), len(data))
+		}
+	}
+	for i, wantXFL := range []byte{0, 4, 2} {
+		header := make([]byte, 10)
+		f, err := os.Open(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadFull(f, header)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header[8] != wantXFL {
+			t.Errorf("%s has XFL %d, want %d", files[i], header[8], wantXFL)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseTrailer builds the --trailer_hex bytes, repeated to fill trailerBytes
+// if that's set. Without any hex, that's trailerBytes zero bytes.
+func parseTrailer(hexStr string, trailerBytes int) ([]byte, error) {
+	pattern, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(hexStr, "0x"), "0X"))
+	if err != nil {
+		return nil, fmt.Errorf("--trailer_hex: %v", err)
+	}
+	if trailerBytes < 0 {
+		return nil, fmt.Errorf("--trailer_bytes cannot be negative")
+	}
+	if trailerBytes == 0 {
+		return pattern, nil
+	}
+	if len(pattern) == 0 {
+		pattern = []byte{0}
+	}
+	trailer := make([]byte, trailerBytes)
+	for i := 0; i < trailerBytes; i += len(pattern) {
+		copy(trailer[i:], pattern)
+	}
+	return trailer, nil
+}
+
+// writeFileTrailer appends the trailer to the current file before it's
+// closed. --trailer_file is read afresh each time, so it can be updated while
+// running; if it can't be read the file is closed without one.
+func (fb *FileBuffer) writeFileTrailer() {
+	if fb.gzipWriter == nil {
+		return
+	}
+
+	trailer := fb.trailer
+	if fb.trailerFile != "" {
+		var err error
+		trailer, err = os.ReadFile(fb.trailerFile)
+		if err != nil {
+			fmt.Fprintf(logOutput, "Error: reading --trailer_file, closing %s without a trailer: %v\n", fb.currentFileName, err)
+			return
+		}
+	}
+	if len(trailer) == 0 {
+		return
+	}
+
+	if err := fb.writeGzip(trailer); err != nil {
+		fmt.Fprintf(logOutput, "Error: writing trailer to %s: %v\n", fb.currentFileName, err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTrailer(t *testing.T) {
+	tests := []struct {
+		hex     string
+		bytes   int
+		want    []byte
+		wantErr string
+	}{
+		{"", 0, []byte{}, ""},
+		{"0xdeadbeef", 0, []byte{0xDE, 0xAD, 0xBE, 0xEF}, ""},
+		{"0XCAFE", 0, []byte{0xCA, 0xFE}, ""},
+		{"0a", 0, []byte{0x0A}, ""},
+		{"0xCAFE", 5, []byte{0xCA, 0xFE, 0xCA, 0xFE, 0xCA}, ""},
+		{"", 3, []byte{0, 0, 0}, ""},
+		{"0xABC", 0, nil, "--trailer_hex: "},
+		{"0xzz", 0, nil, "--trailer_hex: "},
+		{"00", -1, nil, "--trailer_bytes cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTrailer(tt.hex, tt.bytes)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseTrailer(%q, %d) error = %v, want one containing %q", tt.hex, tt.bytes, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("parseTrailer(%q, %d) = % X, %v, want % X", tt.hex, tt.bytes, got, err, tt.want)
+		}
+	}
+}
+
+// TestFileTrailer checks the trailer ends the decompressed contents of each
+// rotated file, and the last one
+func TestFileTrailer(t *testing.T) {
+	trailer := []byte("\x00END OF RECORDS\x00")
+	fb := newTestFileBuffer(t, WithTrailer(trailer), WithMaxNumFiles(3))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	var written [][]byte
+	for i := range 3 {
+		if i > 0 {
+			if _, err := fb.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data := syntheticLog(8*1024 + i)
+		fb.write(data)
+		written = append(written, data)
+	}
+	fb.close()
+
+	for i, got := range readGzipFiles(t, fb.activeFiles) {
+		if want := slices.Concat(written[i], trailer); !bytes.Equal(got, want) {
+			t.Errorf("%s ends with %q, want the %d bytes written then %q", fb.activeFiles[i], got[max(0, len(got)-len(trailer)):], len(written[i]), trailer)
+		}
+	}
+}
+
+// TestTrailerFile checks --trailer_file is read at each close, so changes
+// to it are picked up, and a file is closed without one if it can't be read
+func TestTrailerFile(t *testing.T) {
+	log := captureLog(t)
+	trailerFile := filepath.Join(t.TempDir(), "trailer")
+	if err := os.WriteFile(trailerFile, []byte("first trailer\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fb := newTestFileBuffer(t, WithTrailerFile(trailerFile), WithMaxNumFiles(3))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	fb.write([]byte("one\n"))
+	if _, err := fb.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(trailerFile, []byte("second trailer\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fb.write([]byte("two\n"))
+	if _, err := fb.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(trailerFile); err != nil {
+		t.Fatal(err)
+	}
+	fb.write([]byte("three\n"))
+	last := fb.currentFileName
+	fb.close()
+
+	got := readGzipFiles(t, fb.activeFiles)
+	want := []string{"one\nfirst trailer\n", "two\nsecond trailer\n", "three\n"}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Errorf("%s has %q, want %q", fb.activeFiles[i], got[i], want[i])
+		}
+	}
+	if want := "Error: reading --trailer_file, closing " + last + " without a trailer"; !strings.Contains(log(), want) {
+		t.Errorf("log doesn't contain %q:\n%s", want, log())
+	}
+}
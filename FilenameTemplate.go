@@ -0,0 +1,90 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// filenameData is what --filename_template is executed with
+type filenameData struct {
+	Prefix    string // --file_prefix without its directory or extension
+	Counter   string // zero-padded file counter, e.g. 000042
+	Timestamp string // formatted with --time_format
+	Ext       string // extension of --file_prefix, e.g. .pcap, may be empty
+	Dir       string // directory of --file_prefix
+	Hostname  string
+}
+
+// Stand-ins for the counter and timestamp, used to turn the template into a
+// pattern matching the files it generates
+const (
+	templateCounterMarker   = "\x00counter\x00"
+	templateTimestampMarker = "\x00timestamp\x00"
+)
+
+func parseFilenameTemplate(text string) (*template.Template, error) {
+	return template.New("filename").Option("missingkey=error").Parse(text)
+}
+
+func (fb *FileBuffer) newFilenameData(counter, timestamp string) filenameData {
+	ext := filepath.Ext(fb.filePrefix)
+	hostname, _ := os.Hostname()
+	return filenameData{
+		Prefix:    strings.TrimSuffix(filepath.Base(fb.filePrefix), ext),
+		Counter:   counter,
+		Timestamp: timestamp,
+		Ext:       ext,
+		Dir:       filepath.Dir(fb.filePrefix),
+		Hostname:  hostname,
+	}
+}
+
+// expandFilenameTemplate executes the filename template. A result without a
+// directory goes in the directory of --file_prefix.
+func (fb *FileBuffer) expandFilenameTemplate(counter, timestamp string) (string, error) {
+	var sb strings.Builder
+	if err := fb.filenameTemplate.Execute(&sb, fb.newFilenameData(counter, timestamp)); err != nil {
+		return "", err
+	}
+	name := sb.String()
+	if name == "" || strings.HasSuffix(name, "/") {
+		return "", fmt.Errorf("filename template expanded to %q, which is not a file name", name)
+	}
+	if !strings.ContainsRune(name, os.PathSeparator) {
+		name = filepath.Join(filepath.Dir(fb.filePrefix), name)
+	}
+	return name, nil
+}
+
+// templatePattern returns the directory a filename template writes to and a
+// pattern matching the names of its files, with the counter as group 1
+func (fb *FileBuffer) templatePattern() (string, *regexp.Regexp, error) {
+	sample, err := fb.expandFilenameTemplate(templateCounterMarker, templateTimestampMarker)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, base := filepath.Split(sample)
+	if strings.Contains(dir, templateCounterMarker) || strings.Contains(dir, templateTimestampMarker) {
+		return "", nil, fmt.Errorf("the counter and timestamp can only be used in the file name, not its directory")
+	}
+	if strings.Count(base, templateCounterMarker) != 1 {
+		return "", nil, fmt.Errorf("the template needs {{.Counter}} exactly once to order existing files")
+	}
+
+	pattern := regexp.QuoteMeta(base)
+	pattern = strings.Replace(pattern, templateCounterMarker, `(\d+)`, 1)
+	pattern = strings.ReplaceAll(pattern, templateTimestampMarker, fb.timestampPattern())
+	re, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Clean(dir), re, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilenameTemplate(t *testing.T) {
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	discardLog(t)
+
+	tests := []struct {
+		name, template, want string
+	}{
+		{"hostname and epoch", "{{.Hostname}}-{{.Prefix}}-{{.Counter}}-{{.Timestamp}}{{.Ext}}.gz", hostname + "-cap-000001-1741064767.pcap.gz"},
+		{"directory", "{{.Dir}}/{{.Counter}}/{{.Prefix}}.gz", "000001/cap.gz"},
+		// Falls back to the default format
+		{"unknown field", "{{.Host}}_{{.Counter}}.gz", "cap_000001_1741064767.pcap.gz"},
+		{"empty", "{{if false}}{{.Counter}}{{end}}", "cap_000001_1741064767.pcap.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseFilenameTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			fb := newTestFileBuffer(t, WithPrefix(filepath.Join(dir, "cap.pcap")), WithTimeFormat("epoch"), WithFilenameTemplate(tmpl))
+			fb.clockFn = func() time.Time { return clock }
+			fb.fileCounter = 1
+
+			got, err := fb.generateFilename()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("generateFilename() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// Files named by a template are found again when resuming, in counter
+// order, and a template that can't be matched is rejected
+func TestFilenameTemplateResume(t *testing.T) {
+	dir := t.TempDir()
+	tmpl, err := parseFilenameTemplate("{{.Timestamp}}.{{.Hostname}}.{{.Counter}}{{.Ext}}.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := []Option{WithPrefix(filepath.Join(dir, "cap.pcap")), WithTimeFormat("epoch"), WithFilenameTemplate(tmpl)}
+	fb := newTestFileBuffer(t, opts...)
+	for i := range 3 {
+		clock := time.Unix(1741064767-int64(i), 0) // newer files needn't sort last by name
+		fb.clockFn = func() time.Time { return clock }
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb.close()
+	if err := os.WriteFile(filepath.Join(dir, "cap_000009_1741064767.pcap.gz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newTestFileBuffer(t, opts...)
+	resumed.loadExistingFiles()
+	if !slices.Equal(resumed.activeFiles, fb.activeFiles) || resumed.fileCounter != 3 {
+		t.Errorf("resumed files %q, counter %d, want %q and 3", resumed.activeFiles, resumed.fileCounter, fb.activeFiles)
+	}
+
+	for template, want := range map[string]string{
+		"{{.Prefix}}_{{.Timestamp}}.gz":            "needs {{.Counter}} exactly once",
+		"{{.Counter}}/{{.Prefix}}_{{.Counter}}.gz": "only be used in the file name",
+	} {
+		tmpl, err := parseFilenameTemplate(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewFileBuffer(WithPrefix(filepath.Join(dir, "cap")), WithMaxFileSize(1024), WithMaxNumFiles(1),
+			WithFilenameTemplate(tmpl), WithResumeExisting(true))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", template, err, want)
+		}
+	}
+}
@@ -2,28 +2,106 @@
 // Use of this source code is governed by an MIT license that can be
 // found in the LICENSE file.
 
-package main
+// Package gzipfilebuffer streams data to a rotating set of gzip-compressed
+// files, split on block boundaries if there's a block header format so each
+// file can be read on its own. NewFileBuffer and its options are the library
+// API, and Main is the GzipFileBuffer command, built from cmd/GzipFileBuffer.
+package gzipfilebuffer
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
-func main() {
-	fb := processArgs()
+// Main runs the GzipFileBuffer command with the process's arguments, reading
+// stdin (or --listen) until EOF or a signal. It exits the process with one of
+// the Exit codes rather than returning.
+func Main() {
+	fb, err := processArgs()
+	if errors.Is(err, flag.ErrHelp) {
+		flag.Usage()
+		os.Exit(ExitOK)
+	}
+	if errors.Is(err, errExitEarly) {
+		os.Exit(ExitOK)
+	}
+	if errors.Is(err, errBadFlag) {
+		os.Exit(ExitArgsError)
+	}
+	if err != nil {
+		for _, msg := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", os.Args[0])
+		os.Exit(ExitArgsError)
+	}
+
+	if fb.logSyslog {
+		if err := logOutput.openSyslog(fb.logSyslogFacility); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to connect to syslog, logging to stderr: %v\n", err)
+		}
+		defer logOutput.Close()
+	}
+
+	// Log to --log_file instead of stderr, reopening it on SIGHUP for logrotate
+	if fb.logFile != "" {
+		if err := logOutput.open(fb.logFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to open log file, logging to stderr: %v\n", err)
+		}
+		defer logOutput.Close()
+
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go func() {
+			for range hupChan {
+				logOutput.reopen()
+			}
+		}()
+		defer signal.Stop(hupChan)
+	}
+
+	// Logged once logging is set up, so it goes to --log_file or syslog too
+	if fb.blockFormat != nil && !fb.quiet {
+		endianStr := "little-endian"
+		if fb.blockFormat.Endianness == BigEndian {
+			endianStr = "big-endian"
+		}
+		fmt.Fprintf(logOutput, "Block header format: %d bytes, %d fields (%s)\n", fb.blockFormat.TotalBytes, len(fb.blockFormat.Fields), endianStr)
+	}
 
 	// Resume from existing files if requested
 	if fb.resumeExisting {
-		fb.loadExistingFiles()
+		fb.resume()
 	}
 
-	dataChannel := make(chan []byte, 100) //allow up to 100 reads per processor iteration
-	var wg sync.WaitGroup
-	wg.Add(1)
+	// Open the input (stdin unless listening for a connection)
+	input, closeInput, err := openInput(fb)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Error: %v\n", err)
+		os.Exit(ExitReadError)
+	}
+
+	if fb.metricsAddr != "" {
+		if err := fb.startMetricsServer(); err != nil {
+			fmt.Fprintf(logOutput, "Error: %v\n", err)
+			os.Exit(ExitArgsError)
+		}
+	}
+	if fb.controlAddr != "" {
+		if err := fb.startControlServer(); err != nil {
+			fmt.Fprintf(logOutput, "Error: %v\n", err)
+			os.Exit(ExitArgsError)
+		}
+	}
 
 	// Setup signal handler for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -31,36 +109,149 @@ func main() {
 	go func() {
 		sig := <-sigChan
 		if !fb.quiet {
-			fmt.Fprintf(os.Stderr, "Main: Received signal: %v. Initiating graceful shutdown...\n", sig)
-			fmt.Fprintf(os.Stderr, "Main: Press Ctrl+C again to force exit (will lose unprocessed data).\n")
+			fmt.Fprintf(logOutput, "Main: Received signal: %v. Initiating graceful shutdown...\n", sig)
+			fmt.Fprintf(logOutput, "Main: Press Ctrl+C again to force exit (will lose unprocessed data).\n")
 		}
-		os.Stdin.Close()
+		closeInput()
+		startShutdownTimer(fb)
 		<-sigChan
-		fmt.Fprintf(os.Stderr, "Main: Received second signal. Forcing exit.\n")
-		os.Exit(1)
+		fmt.Fprintf(logOutput, "Main: Received second signal. Forcing exit.\n")
+		os.Exit(ExitSignal)
 	}()
 	defer signal.Stop(sigChan)
 
+	// SIGUSR1 dumps statistics
+	statsChan := make(chan os.Signal, 1)
+	signal.Notify(statsChan, syscall.SIGUSR1)
+	go func() {
+		for range statsChan {
+			printStats(logOutput, fb.Stats())
+		}
+	}()
+	defer signal.Stop(statsChan)
+
+	// When streaming to stdout, SIGUSR2 flushes the gzip stream to a sync point
+	if fb.toStdout {
+		flushChan := make(chan os.Signal, 1)
+		signal.Notify(flushChan, syscall.SIGUSR2)
+		go func() {
+			for range flushChan {
+				fb.flush()
+				if !fb.quiet {
+					fmt.Fprintf(logOutput, "Main: Flushed gzip stream on SIGUSR2\n")
+				}
+			}
+		}()
+		defer signal.Stop(flushChan)
+	}
+
 	// Let's go!
-	fb.openNewFile()
+	var gunzip *gunzipReader
+	if fb.decompressInput {
+		gunzip = newGunzipReader(input)
+		input = gunzip
+	}
+	readerDone := make(chan struct{})
+	if fb.readTimeout > 0 {
+		activity := newActivityReader(input)
+		input = activity
+		go watchReadTimeout(fb, activity, readerDone)
+	}
+	// A read error has already been logged, and ends the input like EOF
+	// apart from the exit code
+	var fbErr *FileBufferError
+	readErr := fb.WriteFrom(input)
+	if errors.As(readErr, &fbErr) {
+		fb.Handle(fbErr)
+	}
+	close(readerDone)
+	closeInput() // Removes a Unix domain socket file
+	if gunzip != nil && !fb.quiet {
+		fmt.Fprintf(logOutput, "Main: Decompressed %d input bytes to %d, recompressed to %d\n",
+			gunzip.bytesIn.Load(), gunzip.bytesOut.Load(), fb.Stats().BytesWrittenCompressed)
+	}
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Main: Shutdown cleanly.\n")
+	}
+	if readErr != nil {
+		os.Exit(ExitReadError)
+	}
+}
+
+// startShutdownTimer gives the shutdown started by a signal
+// --shutdown_timeout to finish. A write stuck on a hung disk or a blocked
+// output would otherwise keep the process around indefinitely.
+func startShutdownTimer(fb *FileBuffer) {
+	if fb.shutdownTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(fb.shutdownTimeout, func() {
+		fmt.Fprintf(logOutput, "Warning: shutdown didn't finish within --shutdown_timeout %v, forcing exit (unprocessed data is lost)\n", fb.shutdownTimeout)
+		fb.forceClose()
+		os.Exit(ExitTimeout)
+	})
+}
+
+// WriteFrom compresses everything read from r into the output until EOF, with
+// a reader goroutine feeding the processor, then closes the output and waits
+// for any uploads or webhooks to finish. It returns a *FileBufferError if the
+// first file can't be opened, or else any read error other than EOF.
+func (fb *FileBuffer) WriteFrom(r io.Reader) error {
+	fb.mu.Lock()
+	err := fb.openNewFile()
+	fb.unlock()
+	if err != nil {
+		return &FileBufferError{Op: "openNewFile", Err: err, Fatal: true}
+	}
+
+	dataChannel := make(chan []byte, 100) //allow up to 100 reads per processor iteration
+	readErr := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
 	go processor(dataChannel, fb, &wg)
-	go reader(dataChannel, fb.readBufferSize)
+	go func() {
+		defer close(dataChannel)
+		readErr <- reader(dataChannel, r, fb.readBufferSize, fb.goroutineRestart)
+	}()
 	wg.Wait()
-	fb.closeCurrentFile()
-	if !fb.quiet {
-		fmt.Fprintf(os.Stderr, "Main: Shutdown cleanly.\n")
+	fb.close()
+	fb.waitForBackground()
+
+	// The reader is still blocked if the processor stopped after a panic
+	select {
+	case err = <-readErr:
+		return err
+	default:
+		return nil
 	}
 }
 
 // "producer" goroutine.
-// It reads data from os.Stdin as fast as possible and sends it to the dataChannel.
-func reader(dataChannel chan<- []byte, maxsize int) {
-	defer close(dataChannel)
-
+// It reads data from input (stdin) as fast as possible and sends it to the dataChannel.
+// A panic ends the input, unless restart is set, when reading carries on.
+// Returns the read error that ended the input, if not EOF.
+func reader(dataChannel chan<- []byte, input io.Reader, maxsize int, restart bool) error {
 	readBuffer := make([]byte, maxsize)
+	for {
+		ok, err := readInput(dataChannel, input, readBuffer)
+		if ok || !restart {
+			return err
+		}
+		fmt.Fprintf(logOutput, "Warning: restarting reader after panic\n")
+	}
+}
+
+// readInput reads until EOF or an error, returning false if it panicked,
+// and the error if not EOF
+func readInput(dataChannel chan<- []byte, input io.Reader, readBuffer []byte) (ok bool, readErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(logOutput, "Error: reader panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
 
 	for {
-		n, err := os.Stdin.Read(readBuffer)
+		n, err := input.Read(readBuffer)
 		if n > 0 {
 			// Copy the read data to a new slice to avoid overwriting
 			// in the next read.
@@ -74,40 +265,69 @@ func reader(dataChannel chan<- []byte, maxsize int) {
 		// Handle errors
 		if err != nil {
 			if err != io.EOF {
-				fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+				fmt.Fprintf(logOutput, "Error reading stdin: %v\n", err)
+				return true, err
 			}
-			break // Exit loop on EOF or any error
+			return true, nil // EOF ends the input
 		}
 	}
 }
 
 // "consumer" goroutine.
-// It receives data from the dataChannel, buffers it, and processes it in chunks
+// It receives data from the dataChannel, buffers it, and processes it in chunks.
+// A panic closes the current file, so what was written before it can be read,
+// then processing either stops or, with --goroutine_restart, carries on in a
+// new file.
 func processor(dataChannel <-chan []byte, fb *FileBuffer, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	var processingBuffer []byte
+	ring := NewRingBuffer(fb.readBufferSize * 4)
+	chunk := make([]byte, fb.readBufferSize)
+
+	for !processInput(dataChannel, fb, ring, chunk) {
+		if err := fb.closeAfterPanic(fb.goroutineRestart); err != nil {
+			fb.Handle(&FileBufferError{Op: "openNewFile", Err: err, Fatal: true})
+		}
+		if !fb.goroutineRestart {
+			return
+		}
+		fmt.Fprintf(logOutput, "Warning: restarting processor after panic\n")
+	}
+}
+
+// processInput processes the dataChannel until it's closed and empty,
+// returning false if it panicked
+func processInput(dataChannel <-chan []byte, fb *FileBuffer, ring *RingBuffer, chunk []byte) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(logOutput, "Error: processor panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
 
 	// This 'for range' loop will automatically run until the
 	// dataChannel is closed (by the reader) and empty.
 	for receivedData := range dataChannel {
-		processingBuffer = append(processingBuffer, receivedData...)
+		for len(receivedData) > 0 {
+			// When the ring is full, this waits for it to be drained
+			// before taking any more from the reader
+			n := ring.Write(receivedData)
+			receivedData = receivedData[n:]
 
-		// Process once there's more than a chunk available
-		for len(processingBuffer) >= fb.readBufferSize {
-			// extract the chunk to process
-			chunk := processingBuffer[:fb.readBufferSize]
-			processingBuffer = processingBuffer[fb.readBufferSize:]
-
-			fb.write(chunk)
+			// Process once there's more than a chunk available
+			for ring.Len() >= fb.readBufferSize {
+				ring.Read(chunk)
+				fb.write(chunk)
+				fb.inputLimiter.wait(len(chunk))
+			}
 		}
 	}
 
 	// After the channel is closed, there might be some data left
-	if len(processingBuffer) > 0 {
+	if ring.Len() > 0 {
 		if !fb.quiet {
-			fmt.Fprintf(os.Stderr, "Processing final %d bytes of data\n", len(processingBuffer))
+			fmt.Fprintf(logOutput, "Processing final %d bytes of data\n", ring.Len())
 		}
-		fb.write(processingBuffer)
+		fb.write(chunk[:ring.Read(chunk)])
 	}
+	return true
 }
@@ -0,0 +1,327 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// mainArgsEnv has the arguments, one per line, for runMain to run Main with
+// in a child test process
+const mainArgsEnv = "GZIPFILEBUFFER_TEST_ARGS"
+
+// runMain runs Main in a child process with args and stdin, returning its
+// exit code and stderr
+func runMain(t *testing.T, stdin string, args ...string) (int, string) {
+	t.Helper()
+	test, _, _ := strings.Cut(t.Name(), "/")
+	cmd := exec.Command(os.Args[0], "-test.run=^"+test+"$")
+	cmd.Env = append(os.Environ(), mainArgsEnv+"="+strings.Join(args, "\n"))
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.ExitCode(), stderr.String()
+}
+
+// inChild runs Main if this is runMain's child process, and never returns
+// then: Main exits on an error, and the child exits 0 if Main returns
+func inChild() {
+	if args, ok := os.LookupEnv(mainArgsEnv); ok {
+		os.Args = append([]string{"GzipFileBuffer"}, strings.Split(args, "\n")...)
+		Main()
+		os.Exit(0)
+	}
+}
+
+// TestExitWriteError checks GzipFileBuffer exits with ExitWriteError when it
+// can't open the output file. --exit_on_write_error=false doesn't change
+// that, there's no file to carry on writing to.
+func TestExitWriteError(t *testing.T) {
+	inChild()
+
+	readOnly := filepath.Join(t.TempDir(), "readonly")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, prefix string
+		args         []string
+	}{
+		{"read-only directory", filepath.Join(readOnly, "out"), nil},
+		{"not a directory", filepath.Join(notDir, "out"), nil},
+		{"exit_on_write_error=false", filepath.Join(notDir, "out"), []string{"--exit_on_write_error=false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.HasPrefix(tt.prefix, readOnly) && os.Geteuid() == 0 {
+				t.Skip("root can write to a read-only directory")
+			}
+			args := append([]string{"--file_prefix", tt.prefix, "--file_size", "10", "--num_files", "2", "--quiet"}, tt.args...)
+			code, stderr := runMain(t, "some data\n", args...)
+			if code != ExitWriteError {
+				t.Errorf("exit code %d, want %d, stderr:\n%s", code, ExitWriteError, stderr)
+			}
+		})
+	}
+}
+
+// TestDecompressInput feeds GzipFileBuffer two concatenated gzip streams
+// compressed at level 1 and checks --decompress_input recompresses the
+// data they hold, and input that isn't gzip is a read error
+func TestDecompressInput(t *testing.T) {
+	inChild()
+
+	data := syntheticLog(256 * 1024)
+	var input bytes.Buffer
+	for _, part := range [][]byte{data[:100000], data[100000:]} {
+		zw, err := gzip.NewWriterLevel(&input, gzip.BestSpeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(part)
+		zw.Close()
+	}
+
+	tests := []struct {
+		name     string
+		stdin    string
+		wantCode int
+		wantLog  string
+	}{
+		{"gzip", input.String(), 0, fmt.Sprintf("Main: Decompressed %d input bytes to %d, recompressed to ", input.Len(), len(data))},
+		{"not gzip", string(data), ExitReadError, "decompressing input: gzip: invalid header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "out")
+			code, stderr := runMain(t, tt.stdin, "--file_prefix", prefix, "--file_size", "1024", "--num_files", "2",
+				"--decompress_input", "--compression_level", "9")
+			if code != tt.wantCode || !strings.Contains(stderr, tt.wantLog) {
+				t.Fatalf("exit code %d, want %d and a log with %q, stderr:\n%s", code, tt.wantCode, tt.wantLog, stderr)
+			}
+			if code != 0 {
+				return
+			}
+			files, _ := filepath.Glob(prefix + "_*.gz")
+			if len(files) != 1 {
+				t.Fatalf("wrote %q, want one file", files)
+			}
+			if got := readGzipFiles(t, files); !bytes.Equal(got[0], data) {
+				t.Errorf("output decompresses to %d bytes, want the %d compressed in the input", len(got[0]), len(data))
+			}
+		})
+	}
+}
+
+// TestShutdownTimeout gets a write stuck on a stdout pipe nothing reads,
+// and checks a SIGTERM makes the process exit with ExitTimeout once
+// --shutdown_timeout is up, not hang there
+func TestShutdownTimeout(t *testing.T) {
+	inChild()
+	if runtime.GOOS == "windows" {
+		t.Skip("no SIGTERM on Windows")
+	}
+
+	// Random data compresses to more than a pipe holds
+	input := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(input)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestShutdownTimeout$")
+	cmd.Env = append(os.Environ(), mainArgsEnv+"="+strings.Join([]string{"--output", "stdout", "--shutdown_timeout", "500ms"}, "\n"))
+	cmd.Stdin = bytes.NewReader(input)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Long enough for the pipe to fill
+	time.Sleep(500 * time.Millisecond)
+	start := time.Now()
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("still running 10s after SIGTERM:\n%s", stderr.String())
+	}
+	elapsed := time.Since(start)
+
+	if code := cmd.ProcessState.ExitCode(); code != ExitTimeout {
+		t.Errorf("exit code %d, want %d (ExitTimeout):\n%s", code, ExitTimeout, stderr.String())
+	}
+	if elapsed < 500*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("exited %v after SIGTERM, want soon after the 500ms timeout", elapsed)
+	}
+	if want := "Warning: shutdown didn't finish within --shutdown_timeout 500ms"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("stderr doesn't contain %q:\n%s", want, stderr.String())
+	}
+}
+
+// panicReader reads from r, panicking on the read numbered panicAt
+type panicReader struct {
+	r       io.Reader
+	reads   int
+	panicAt int
+}
+
+func (p *panicReader) Read(b []byte) (int, error) {
+	p.reads++
+	if p.reads == p.panicAt {
+		panic("injected reader panic")
+	}
+	return p.r.Read(b)
+}
+
+// TestReaderPanic panics part way through reading, and checks the input
+// ends there with the file properly closed, or with --goroutine_restart
+// reading carries on
+func TestReaderPanic(t *testing.T) {
+	data := syntheticLog(64 * 1024)
+	for _, restart := range []bool{false, true} {
+		t.Run(fmt.Sprintf("restart=%v", restart), func(t *testing.T) {
+			log := captureLog(t)
+			fb := newTestFileBuffer(t, WithReadBufferSize(4096), WithMaxBlockSize(4096), WithGoroutineRestart(restart))
+			if err := fb.WriteFrom(&panicReader{r: bytes.NewReader(data), panicAt: 3}); err != nil {
+				t.Fatal(err)
+			}
+			want := data[:2*4096]
+			if restart {
+				want = data
+			}
+			if got := bytes.Join(readGzipFiles(t, fb.activeFiles), nil); !bytes.Equal(got, want) {
+				t.Errorf("files hold %d bytes, want %d", len(got), len(want))
+			}
+			if !strings.Contains(log(), "Error: reader panicked: injected reader panic") {
+				t.Errorf("panic not logged:\n%s", log())
+			}
+			if restarted := strings.Contains(log(), "restarting reader after panic"); restarted != restart {
+				t.Errorf("reader restarted: %v, want %v", restarted, restart)
+			}
+		})
+	}
+}
+
+// TestProcessorPanic panics in a block validator when the first file is
+// full, and checks the files are properly closed, and with
+// --goroutine_restart processing carries on in a new file
+func TestProcessorPanic(t *testing.T) {
+	data := syntheticLog(64 * 1024)
+	for _, restart := range []bool{false, true} {
+		t.Run(fmt.Sprintf("restart=%v", restart), func(t *testing.T) {
+			log := captureLog(t)
+			panicked := false
+			fb := newTestFileBuffer(t,
+				WithReadBufferSize(4096),
+				WithMaxBlockSize(4096),
+				WithMaxFileSize(16*1024),
+				WithRotateOnUncompressed(true),
+				WithMaxNumFiles(100),
+				WithGoroutineRestart(restart),
+				WithBlockValidator(func([]byte) int {
+					if !panicked {
+						panicked = true
+						panic("injected processor panic")
+					}
+					return 1
+				}),
+			)
+			if err := fb.WriteFrom(bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+
+			// The chunk being written when it panicked is lost
+			want := data[:16*1024]
+			if restart {
+				want = slices.Concat(want, data[20*1024:])
+			}
+			if got := bytes.Join(readGzipFiles(t, fb.activeFiles), nil); !bytes.Equal(got, want) {
+				t.Errorf("files hold %d bytes, want %d", len(got), len(want))
+			}
+			if !strings.Contains(log(), "Error: processor panicked: injected processor panic") {
+				t.Errorf("panic not logged:\n%s", log())
+			}
+			if restarted := strings.Contains(log(), "restarting processor after panic"); restarted != restart {
+				t.Errorf("processor restarted: %v, want %v", restarted, restart)
+			}
+		})
+	}
+}
+
+// TestQuietMode runs GzipFileBuffer on input with no newlines to split on,
+// and checks --quiet leaves only the warnings about that in the log, where
+// without it the informational messages are there too
+func TestQuietMode(t *testing.T) {
+	inChild()
+
+	rng := rand.New(rand.NewSource(1))
+	input := make([]byte, 300*1024)
+	for i := range input {
+		input[i] = 'a' + byte(rng.Intn(26))
+	}
+	const warning = "Warning: no newline found (to split on) in read buffer"
+
+	for _, quiet := range []bool{false, true} {
+		t.Run(fmt.Sprintf("quiet=%v", quiet), func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "out")
+			code, stderr := runMain(t, string(input), "--file_prefix", prefix, "--file_size", "64", "--num_files", "2",
+				"--read_buffer_size", "65536", "--max_block_size", "65536", "--split_on_newline", fmt.Sprintf("--quiet=%v", quiet))
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			if !strings.Contains(stderr, warning) {
+				t.Errorf("stderr doesn't contain %q:\n%s", warning, stderr)
+			}
+			var info []string
+			for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+				if !strings.HasPrefix(line, "Warning: ") && !strings.HasPrefix(line, "Error") {
+					info = append(info, line)
+				}
+			}
+			if quiet && len(info) > 0 {
+				t.Errorf("informational lines with --quiet:\n%s", strings.Join(info, "\n"))
+			}
+			if !quiet {
+				for _, want := range []string{"Created new file: ", "Deleted oldest file: ", "Processing final ", "Main: Shutdown cleanly."} {
+					if !strings.Contains(stderr, want) {
+						t.Errorf("stderr without --quiet doesn't contain %q:\n%s", want, stderr)
+					}
+				}
+			}
+		})
+	}
+}
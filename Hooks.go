@@ -0,0 +1,62 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// runHook runs command with each {} replaced by path. The command is split
+// on whitespace and run directly (not via a shell), so the path needs no
+// quoting. The hook's output goes to stderr.
+func runHook(command, path string, timeout time.Duration) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return errors.New("empty hook command")
+	}
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, "{}", path)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = logOutput
+	cmd.Stderr = logOutput
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
+
+// runPreDeleteHook runs --pre_delete_hook for path. Returns false if the
+// file should be kept, i.e. the hook failed and --pre_delete_hook_strict is set.
+func (fb *FileBuffer) runPreDeleteHook(path string) bool {
+	if fb.preDeleteHook == "" {
+		return true
+	}
+
+	err := runHook(fb.preDeleteHook, path, fb.preDeleteHookTimeout)
+	if err == nil {
+		return true
+	}
+	if fb.preDeleteHookStrict {
+		fmt.Fprintf(logOutput, "Warning: pre-delete hook failed for %s, keeping file: %v\n", path, err)
+		return false
+	}
+	fmt.Fprintf(logOutput, "Warning: pre-delete hook failed for %s, deleting anyway: %v\n", path, err)
+	return true
+}
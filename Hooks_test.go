@@ -0,0 +1,66 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPreDeleteHook checks --pre_delete_hook runs before each file is
+// deleted, here copying it elsewhere, and a failed or timed out hook only
+// keeps the file with --pre_delete_hook_strict
+func TestPreDeleteHook(t *testing.T) {
+	for _, cmd := range []string{"cp", "false", "sleep"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("no %s command: %v", cmd, err)
+		}
+	}
+	backup := t.TempDir()
+	tests := []struct {
+		name     string
+		hook     string
+		strict   bool
+		wantKept bool
+		wantCopy bool
+	}{
+		{"copied", "cp {} " + backup, true, false, true},
+		{"failed", "false {}", false, false, false},
+		{"failed, strict", "false {}", true, true, false},
+		{"timed out, strict", "sleep 10", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discardLog(t)
+			fb := newTestFileBuffer(t, WithMaxNumFiles(1), WithPreDeleteHook(tt.hook, 100*time.Millisecond, tt.strict))
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			fb.write([]byte("data to keep a copy of\n"))
+			first, err := fb.Rotate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fb.close()
+
+			_, err = os.Stat(first)
+			if kept := err == nil; kept != tt.wantKept {
+				t.Errorf("%s kept = %v, want %v", first, kept, tt.wantKept)
+			}
+			if !tt.wantCopy {
+				return
+			}
+			copied := readGzipFiles(t, []string{filepath.Join(backup, filepath.Base(first))})
+			if !bytes.Equal(copied[0], []byte("data to keep a copy of\n")) {
+				t.Errorf("copy holds %q", copied[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// indexBinaryMagic starts a binary --index_format file, followed by one
+// indexBinaryRecordBytes record per block
+const indexBinaryMagic = "GZBIDX1\n"
+
+// indexBinaryRecordBytes is the size of a binary index record: block number,
+// compressed offset, member uncompressed offset, uncompressed offset and
+// timestamp, each a little-endian 64-bit integer (timestamp -1 if none)
+const indexBinaryRecordBytes = 40
+
+// indexEntry locates a block in a gzip file. A gzip stream can only be
+// decompressed from the start of a member, so CompressedOffset is the start
+// of the member holding the block (0 unless each chunk is its own member,
+// with --gzip_multistream). Decompress from there and skip
+// UncompressedOffset-MemberUncompressedOffset bytes to get to the block.
+type indexEntry struct {
+	Block                    int64  `json:"block"`
+	CompressedOffset         int64  `json:"compressed_offset"`
+	MemberUncompressedOffset int64  `json:"member_uncompressed_offset"`
+	UncompressedOffset       int64  `json:"uncompressed_offset"`
+	Timestamp                *int64 `json:"timestamp,omitempty"` // Unix seconds, if the format has a sec field
+}
+
+// indexBlock is a block start found in the stream, waiting to be written
+type indexBlock struct {
+	streamOffset int64
+	timestamp    int64
+	hasTimestamp bool
+}
+
+func (fb *FileBuffer) indexPath(filename string) string {
+	if fb.indexFormat == "binary" {
+		return filename + ".idx"
+	}
+	return filename + ".idx.json"
+}
+
+// findIndexBlocks walks data from block to block using the length field,
+// queueing each block start for the index. If a block isn't where the last
+// one said it would be, it scans forward for the next valid header. A header
+// split across two chunks is kept until the next one.
+func (fb *FileBuffer) findIndexBlocks(data []byte, streamOffset int64) {
+	if !fb.writeIndex || fb.blockFormat == nil {
+		return
+	}
+
+	buf := data
+	bufStart := streamOffset
+	if len(fb.indexCarry) > 0 {
+		buf = append(fb.indexCarry, data...)
+		bufStart -= int64(len(fb.indexCarry))
+		fb.indexCarry = nil
+	}
+
+	// Blocks start after the captured header, e.g. a pcap global header
+	pos := max(fb.indexNext, int64(fb.headerBytes), bufStart) - bufStart
+	now := time.Now().Unix()
+	for pos < int64(len(buf)) {
+		check := fb.matchBlock(buf[pos:], true, now)
+		if check.field < 0 && check.size > 0 {
+			fb.indexPending = append(fb.indexPending, indexBlock{bufStart + pos, check.timestamp, check.hasTimestamp})
+			pos += int64(check.size)
+			continue
+		}
+		if check.reason == notEnoughData {
+			fb.indexCarry = append([]byte(nil), buf[pos:]...)
+			break
+		}
+		pos++
+	}
+	fb.indexNext = bufStart + pos
+}
+
+// recordIndex writes index entries for the blocks starting in the next n
+// stream bytes, which are about to be written to the current file
+func (fb *FileBuffer) recordIndex(n int) {
+	end := fb.indexWritten + int64(n)
+	defer func() { fb.indexWritten = end }()
+	if fb.indexWriter == nil {
+		// Not for this file, but don't let them pile up
+		for len(fb.indexPending) > 0 && fb.indexPending[0].streamOffset < end {
+			fb.indexPending = fb.indexPending[1:]
+		}
+		return
+	}
+
+	uncompressed := fb.counters.bytesUncompressed.Load() - fb.fileStartUncompressed
+	memberCompressed, memberUncompressed := fb.memberStartCompressed, fb.memberStartUncompressed
+	if fb.gzipMemberClosed {
+		// The data starts a new member
+		memberCompressed = fb.counters.bytesCompressed.Load() - fb.fileStartCompressed
+		memberUncompressed = uncompressed
+	}
+
+	for len(fb.indexPending) > 0 && fb.indexPending[0].streamOffset < end {
+		block := fb.indexPending[0]
+		fb.indexPending = fb.indexPending[1:]
+		entry := indexEntry{
+			Block:                    fb.indexBlocks,
+			CompressedOffset:         memberCompressed,
+			MemberUncompressedOffset: memberUncompressed,
+			UncompressedOffset:       uncompressed + block.streamOffset - fb.indexWritten,
+		}
+		if block.hasTimestamp {
+			entry.Timestamp = &block.timestamp
+		}
+		if err := fb.writeIndexEntry(entry); err != nil {
+			fmt.Fprintf(logOutput, "Warning: failed to write index %s, disabling it for this file: %v\n", fb.indexFile.Name(), err)
+			fb.closeIndex()
+			return
+		}
+		fb.indexBlocks++
+	}
+}
+
+func (fb *FileBuffer) writeIndexEntry(entry indexEntry) error {
+	if fb.indexFormat == "binary" {
+		timestamp := int64(-1)
+		if entry.Timestamp != nil {
+			timestamp = *entry.Timestamp
+		}
+		var record [indexBinaryRecordBytes]byte
+		for i, v := range []int64{entry.Block, entry.CompressedOffset, entry.MemberUncompressedOffset, entry.UncompressedOffset, timestamp} {
+			binary.LittleEndian.PutUint64(record[i*8:], uint64(v))
+		}
+		_, err := fb.indexWriter.Write(record[:])
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if entry.Block == 0 {
+		sep = "\n"
+	}
+	_, err = fb.indexWriter.WriteString(sep + string(line))
+	return err
+}
+
+// openIndex creates the index for filename. Failure isn't fatal, the file
+// just doesn't get an index.
+func (fb *FileBuffer) openIndex(filename string) {
+	if !fb.writeIndex {
+		return
+	}
+
+	path := fb.indexPath(filename)
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to create index %s: %v\n", path, err)
+		return
+	}
+	fb.indexFile = f
+	fb.indexWriter = bufio.NewWriter(f)
+	fb.indexBlocks = 0
+	if fb.indexFormat == "binary" {
+		fb.indexWriter.WriteString(indexBinaryMagic)
+	} else {
+		fb.indexWriter.WriteString("[")
+	}
+}
+
+// closeIndex finishes and closes the index of the current file
+func (fb *FileBuffer) closeIndex() {
+	if fb.indexFile == nil {
+		return
+	}
+	if fb.indexFormat != "binary" {
+		fb.indexWriter.WriteString("\n]\n")
+	}
+	err := fb.indexWriter.Flush()
+	if closeErr := fb.indexFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: error closing index %s: %v\n", fb.indexFile.Name(), err)
+	}
+	fb.indexFile = nil
+	fb.indexWriter = nil
+}
+
+// removeIndex deletes the index of a file being deleted
+func (fb *FileBuffer) removeIndex(filename string) {
+	if !fb.writeIndex {
+		return
+	}
+
+	path := fb.indexPath(filename)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(logOutput, "Warning: failed to delete index %s: %v\n", path, err)
+	}
+}
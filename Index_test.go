@@ -0,0 +1,148 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+// readIndex reads a --write_index file in either format
+func readIndex(t *testing.T, path, format string) []indexEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index []indexEntry
+	if format == "json" {
+		if err := json.Unmarshal(data, &index); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return index
+	}
+
+	records, ok := bytes.CutPrefix(data, []byte(indexBinaryMagic))
+	if !ok || len(records)%indexBinaryRecordBytes != 0 {
+		t.Fatalf("%s: no magic, or %d bytes of records isn't a whole number", path, len(records))
+	}
+	for ; len(records) > 0; records = records[indexBinaryRecordBytes:] {
+		var v [5]int64
+		for i := range v {
+			v[i] = int64(binary.LittleEndian.Uint64(records[i*8:]))
+		}
+		entry := indexEntry{Block: v[0], CompressedOffset: v[1], MemberUncompressedOffset: v[2], UncompressedOffset: v[3]}
+		if v[4] != -1 {
+			entry.Timestamp = &v[4]
+		}
+		index = append(index, entry)
+	}
+	return index
+}
+
+// TestWriteIndex writes pcap records across several files and checks each
+// file's index has every record in it, and can be used to decompress the
+// file from any of them
+func TestWriteIndex(t *testing.T) {
+	var records []byte
+	for i := 0; len(records) < 96*1024; i++ {
+		records = append(records, pcapRecords(1, 500, int64(i))...)
+	}
+	stream := append(pcapGlobalHeader(), records...)
+
+	for _, format := range []string{"json", "binary"} {
+		t.Run(format, func(t *testing.T) {
+			fb := newTestFileBuffer(t,
+				WithHeaderBytes(pcapGlobalHeaderBytes),
+				WithBlockFormat(presetFormat(t, "pcap")),
+				WithIndex(true, format),
+				WithReadBufferSize(4096),
+				WithMaxBlockSize(4096),
+				WithMaxFileSize(32*1024),
+				WithRotateOnUncompressed(true),
+				WithMaxNumFiles(100),
+			)
+			if err := fb.WriteFrom(bytes.NewReader(stream)); err != nil {
+				t.Fatal(err)
+			}
+			if len(fb.activeFiles) < 3 {
+				t.Fatalf("got %d files, want at least 3", len(fb.activeFiles))
+			}
+
+			for _, path := range fb.activeFiles {
+				contents := readGzipFiles(t, []string{path})[0]
+				var starts []int64
+				for offset := pcapGlobalHeaderBytes; offset < len(contents); {
+					starts = append(starts, int64(offset))
+					offset += 16 + int(binary.LittleEndian.Uint32(contents[offset+8:]))
+				}
+				index := readIndex(t, fb.indexPath(path), format)
+				if len(index) != len(starts) {
+					t.Fatalf("%s: %d index entries, want one for each of %d records", path, len(index), len(starts))
+				}
+
+				file, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for n, entry := range index {
+					if entry.Block != int64(n) || entry.UncompressedOffset != starts[n] || entry.CompressedOffset != 0 {
+						t.Errorf("%s: entry %d is %+v, want block %d at %d in the one member", path, n, entry, n, starts[n])
+						continue
+					}
+					sec := int64(binary.LittleEndian.Uint32(contents[starts[n]:]))
+					if entry.Timestamp == nil || *entry.Timestamp != sec {
+						t.Errorf("%s: block %d timestamp %v, want %d", path, n, entry.Timestamp, sec)
+					}
+				}
+
+				// Decompress from the middle block on
+				n := len(index) / 2
+				entry := index[n]
+				z, err := gzip.NewReader(bytes.NewReader(file[entry.CompressedOffset:]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.CopyN(io.Discard, z, entry.UncompressedOffset-entry.MemberUncompressedOffset); err != nil {
+					t.Fatal(err)
+				}
+				rest, err := io.ReadAll(z)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(rest, contents[starts[n]:]) {
+					t.Errorf("%s: decompressed %d bytes from block %d, want %d", path, len(rest), n, len(contents)-int(starts[n]))
+				}
+			}
+		})
+	}
+}
+
+// The index of a deleted file is deleted with it
+func TestWriteIndexDeleted(t *testing.T) {
+	fb := newTestFileBuffer(t, WithBlockFormat(presetFormat(t, "pcap")), WithIndex(true, "json"), WithMaxNumFiles(1))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	fb.write(pcapRecords(3, 100, 1))
+	first, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.close()
+	for _, path := range []string{first, fb.indexPath(first)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't deleted: %v", path, err)
+		}
+	}
+	if index := readIndex(t, fb.indexPath(fb.currentFileName), "json"); len(index) != 0 {
+		t.Errorf("empty file's index has %d entries", len(index))
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import "fmt"
+
+// injectBlockAfterHeader writes the --inject_block_file block to a new file
+// straight after the global header. The first file is opened before the
+// header has been read from the stream (or even its length, with
+// --auto_detect_pcap), so its block waits for the header to pass through
+// (see write).
+func (fb *FileBuffer) injectBlockAfterHeader() error {
+	if len(fb.injectBlock) == 0 {
+		return nil
+	}
+	if !fb.headerCaptured && (fb.headerBytes > 0 || fb.autoDetectPcap && fb.streamOffset == 0) {
+		fb.injectPending = true
+		return nil
+	}
+	return fb.writeInjectBlock()
+}
+
+func (fb *FileBuffer) writeInjectBlock() error {
+	fb.injectPending = false
+	fb.updateChecksum(fb.injectBlock)
+	if _, err := fb.gzipWriter.Write(fb.injectBlock); err != nil {
+		return fmt.Errorf("writing injected block to %s: %w", fb.currentFileName, err)
+	}
+	fb.counters.bytesUncompressed.Add(int64(len(fb.injectBlock)))
+	fb.writeMirror(fb.injectBlock)
+	fb.writeCompanion(fb.injectBlock)
+	return nil
+}
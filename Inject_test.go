@@ -0,0 +1,69 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestInjectBlock checks the injected block comes between the global header
+// and the first record of every file, whether the header length is given,
+// found by --auto_detect_pcap or there's no header at all
+func TestInjectBlock(t *testing.T) {
+	var records []byte
+	for i := 0; len(records) < 80*1024; i++ {
+		records = append(records, pcapRecords(1, 500, int64(i))...)
+	}
+	// A pcap record of its own, like an annotation the reader would show
+	inject := binary.LittleEndian.AppendUint32(nil, 0)
+	inject = binary.LittleEndian.AppendUint32(inject, 0)
+	inject = binary.LittleEndian.AppendUint32(inject, 4)
+	inject = binary.LittleEndian.AppendUint32(inject, 4)
+	inject = append(inject, "MARK"...)
+
+	tests := []struct {
+		name   string
+		header []byte
+		opts   []Option
+	}{
+		{"header bytes", pcapGlobalHeader(), []Option{WithHeaderBytes(pcapGlobalHeaderBytes), WithBlockFormat(presetFormat(t, "pcap"))}},
+		{"auto detected pcap", pcapGlobalHeader(), []Option{WithAutoDetectPcap(true)}},
+		{"no header", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discardLog(t)
+			fb := newTestFileBuffer(t, append(tt.opts,
+				WithInjectBlock(inject),
+				WithReadBufferSize(4096),
+				WithMaxBlockSize(4096),
+				WithMaxFileSize(32*1024),
+				WithRotateOnUncompressed(true),
+				WithMaxNumFiles(100),
+			)...)
+			if err := fb.WriteFrom(bytes.NewReader(append(tt.header, records...))); err != nil {
+				t.Fatal(err)
+			}
+			if len(fb.activeFiles) < 2 {
+				t.Fatalf("got %d files, want several", len(fb.activeFiles))
+			}
+
+			var rest []byte
+			for i, file := range readGzipFiles(t, fb.activeFiles) {
+				start := append(append([]byte(nil), tt.header...), inject...)
+				if !bytes.HasPrefix(file, start) {
+					t.Fatalf("file %d starts % X, want the %d byte header then the injected block", i, file[:min(len(file), len(start))], len(tt.header))
+				}
+				rest = append(rest, file[len(start):]...)
+			}
+			if !bytes.Equal(rest, records) {
+				t.Errorf("after the injected blocks the files have %d bytes, want the %d bytes of records", len(rest), len(records))
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r     io.Reader
+	count *atomic.Int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.count.Add(int64(n))
+	return n, err
+}
+
+// gunzipReader decompresses a gzip input stream for --decompress_input.
+// Concatenated gzip streams are read one after the other as a single stream.
+type gunzipReader struct {
+	in       countingReader
+	gz       *gzip.Reader
+	bytesIn  atomic.Int64 // compressed
+	bytesOut atomic.Int64 // decompressed
+}
+
+func newGunzipReader(r io.Reader) *gunzipReader {
+	g := &gunzipReader{}
+	g.in = countingReader{r, &g.bytesIn}
+	return g
+}
+
+func (g *gunzipReader) Read(p []byte) (int, error) {
+	// Opened on the first read, so the gzip header is read by the reader
+	// goroutine rather than blocking startup
+	if g.gz == nil {
+		gz, err := gzip.NewReader(&g.in)
+		if err == io.EOF {
+			return 0, io.EOF // No input at all
+		}
+		if err != nil {
+			return 0, fmt.Errorf("decompressing input: %w", err)
+		}
+		g.gz = gz
+	}
+	n, err := g.gz.Read(p)
+	g.bytesOut.Add(int64(n))
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("decompressing input: %w", err)
+	}
+	return n, err
+}
+
+// activityReader records when data was last read through it
+type activityReader struct {
+	r        io.Reader
+	lastRead atomic.Int64 // UnixNano
+}
+
+func newActivityReader(r io.Reader) *activityReader {
+	ar := &activityReader{r: r}
+	ar.lastRead.Store(time.Now().UnixNano())
+	return ar
+}
+
+func (ar *activityReader) Read(p []byte) (int, error) {
+	n, err := ar.r.Read(p)
+	if n > 0 {
+		ar.lastRead.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// watchReadTimeout applies the read timeout action whenever nothing has been
+// read from input for the read timeout, until done is closed. A read deadline
+// can't be used because stdin is usually a blocking file, which doesn't
+// support them.
+func watchReadTimeout(fb *FileBuffer, input *activityReader, done <-chan struct{}) {
+	timer := time.NewTimer(fb.readTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		idle := time.Since(time.Unix(0, input.lastRead.Load()))
+		if idle < fb.readTimeout {
+			timer.Reset(fb.readTimeout - idle)
+			continue
+		}
+
+		switch fb.readTimeoutAction {
+		case "exit":
+			fmt.Fprintf(logOutput, "Error: no input for %v, exiting\n", idle.Round(time.Millisecond))
+			fb.exit(ExitReadError)
+		case "rotate":
+			rotated, err := fb.rotateIfWritten()
+			if err != nil {
+				fb.Handle(&FileBufferError{Op: "openNewFile", Err: err, Fatal: true})
+			}
+			if rotated {
+				fmt.Fprintf(logOutput, "Warning: no input for %v, rotated to a new file\n", idle.Round(time.Millisecond))
+			}
+		}
+		timer.Reset(fb.readTimeout)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// openInput returns the stream to read, and a function that closes it to
+// start a graceful shutdown
+func openInput(fb *FileBuffer) (io.Reader, func(), error) {
+	var ln net.Listener
+	var err error
+	switch {
+	case fb.inputTCP != "":
+		ln, err = net.Listen("tcp", fb.inputTCP)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--input_tcp: %w", err)
+		}
+	case fb.inputUnix != "":
+		ln, err = listenUnix(fb.inputUnix, fb.inputUnixPerms)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--input_unix: %w", err)
+		}
+	default:
+		return os.Stdin, func() { os.Stdin.Close() }, nil
+	}
+
+	if fb.inputTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(fb.inputTLSCert, fb.inputTLSKey)
+		if err != nil {
+			ln.Close()
+			return nil, nil, fmt.Errorf("--input_tls_cert/--input_tls_key: %w", err)
+		}
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Listening for input on %s\n", ln.Addr())
+	}
+
+	in := &socketInput{ln: ln, multi: fb.inputTCPMulti || fb.inputUnixMulti, quiet: fb.quiet}
+	return in, in.close, nil
+}
+
+// listenUnix listens on a Unix domain socket at path, which is removed again
+// when the listener is closed. A socket file left behind by a process that's
+// no longer listening is replaced.
+func listenUnix(path string, perms os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perms != 0 {
+		if err := os.Chmod(path, perms); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// socketInput reads from connections accepted on a listener, one at a time,
+// so others wait their turn. Without multi, the first connection closing is
+// the end of the input, otherwise the next connection carries on the stream.
+type socketInput struct {
+	ln    net.Listener
+	multi bool
+	quiet bool
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+func (t *socketInput) Read(p []byte) (int, error) {
+	for {
+		conn, err := t.current()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := conn.Read(p)
+		if err == nil {
+			return n, nil
+		}
+
+		t.mu.Lock()
+		closed := t.closed
+		t.conn = nil
+		t.mu.Unlock()
+		conn.Close()
+		if closed {
+			return n, io.EOF
+		}
+		if err != io.EOF {
+			fmt.Fprintf(logOutput, "Error reading from %s: %v\n", conn.RemoteAddr(), err)
+		}
+		if !t.multi {
+			t.ln.Close()
+			return n, io.EOF
+		}
+		if !t.quiet {
+			fmt.Fprintf(logOutput, "Connection from %s closed, waiting for the next one\n", peerName(conn))
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// current returns the connection being read, accepting one if needed
+func (t *socketInput) current() (net.Conn, error) {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := t.ln.Accept()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	if !t.quiet {
+		fmt.Fprintf(logOutput, "Accepted input connection from %s\n", peerName(conn))
+	}
+	return conn, nil
+}
+
+// peerName describes the other end of a connection for logging. Unix domain
+// socket clients are usually unnamed.
+func peerName(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil && addr.String() != "" && addr.String() != "@" {
+		return addr.String()
+	}
+	return "local client"
+}
+
+// close stops accepting connections and closes the current one, so a
+// pending Read returns io.EOF
+func (t *socketInput) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.ln.Close()
+	if t.conn != nil {
+		t.conn.Close()
+	}
+}
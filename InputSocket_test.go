@@ -0,0 +1,252 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// socketTest starts fb reading from its socket input, returning the address
+// it listens on, a function that ends the input, and a channel for the
+// result of WriteFrom
+func socketTest(t *testing.T, fb *FileBuffer) (net.Addr, func(), <-chan error) {
+	t.Helper()
+	input, closeInput, err := openInput(fb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() { result <- fb.WriteFrom(input) }()
+	return input.(*socketInput).ln.Addr(), closeInput, result
+}
+
+// TestInputTCP sends data over TCP connections, one after the other, and
+// checks the output file holds it all. Without --input_tcp_multi the first
+// connection closing ends the input.
+func TestInputTCP(t *testing.T) {
+	data := syntheticLog(64 * 1024)
+	parts := [][]byte{data[:20000], data[20000:]}
+
+	for _, multi := range []bool{false, true} {
+		name := "single"
+		if multi {
+			name = "multi"
+		}
+		t.Run(name, func(t *testing.T) {
+			logged := captureLog(t)
+			fb := newTestFileBuffer(t, WithInputTCP("127.0.0.1:0", multi), WithQuiet(false))
+			addr, closeInput, result := socketTest(t, fb)
+
+			sends := parts[:1]
+			if multi {
+				sends = parts
+			}
+			sent := 0
+			for _, part := range sends {
+				conn, err := net.Dial("tcp", addr.String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.Write(part)
+				conn.Close()
+				sent += len(part)
+			}
+			if multi {
+				// Closing the input drops a connection that's still being
+				// read, so wait for the last one to be read to its end
+				deadline := time.Now().Add(5 * time.Second)
+				for strings.Count(logged(), "closed, waiting for the next one") < len(sends) {
+					if time.Now().After(deadline) {
+						t.Fatalf("connections weren't all read:\n%s", logged())
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				closeInput()
+			}
+			if err := <-result; err != nil {
+				t.Fatal(err)
+			}
+			if got := readGzipFiles(t, fb.activeFiles); !bytes.Equal(got[0], data[:sent]) {
+				t.Errorf("output has %d bytes, want the %d sent", len(got[0]), sent)
+			}
+		})
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key
+// to PEM files, returning their paths and the certificate
+func writeTestCert(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "GzipFileBuffer test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile, cert
+}
+
+// TestInputTLS sends data over a TLS connection checked against the
+// --input_tls_cert certificate
+func TestInputTLS(t *testing.T) {
+	certFile, keyFile, cert := writeTestCert(t)
+	fb := newTestFileBuffer(t, WithInputTCP("127.0.0.1:0", false), WithInputTLS(certFile, keyFile))
+	addr, _, result := socketTest(t, fb)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := syntheticLog(32 * 1024)
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	if got := readGzipFiles(t, fb.activeFiles); !bytes.Equal(got[0], data) {
+		t.Errorf("output has %d bytes, want the %d sent", len(got[0]), len(data))
+	}
+}
+
+// TestInputUnix sends data over a Unix domain socket and checks its
+// permissions and that it's removed afterwards
+func TestInputUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain socket files have no permissions on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "in.sock")
+	fb := newTestFileBuffer(t, WithInputUnix(path, 0660, false))
+	_, _, result := socketTest(t, fb)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0660 {
+		t.Errorf("socket mode %v, want a socket with permissions 0660", info.Mode())
+	}
+	data := []byte("\x00\x01\x02 known bytes \xFE\xFF")
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write(data)
+	conn.Close()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	if got := readGzipFiles(t, fb.activeFiles); !bytes.Equal(got[0], data) {
+		t.Errorf("output has %q, want %q", got[0], data)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file left behind: %v", err)
+	}
+}
+
+// With --input_unix_multi, a connection made while another is being read
+// waits its turn, so their data isn't interleaved
+func TestInputUnixMulti(t *testing.T) {
+	logged := captureLog(t)
+	path := filepath.Join(t.TempDir(), "in.sock")
+	// A socket file left by a process that's gone is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	// but not one another process is listening on
+	inUse := filepath.Join(t.TempDir(), "in-use.sock")
+	ln, err := net.Listen("unix", inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := listenUnix(inUse, 0); err == nil || !strings.Contains(err.Error(), "is already in use") {
+		t.Errorf("listening on a socket in use: got error %v", err)
+	}
+
+	fb := newTestFileBuffer(t, WithInputUnix(path, 0, true), WithQuiet(false))
+	_, closeInput, result := socketTest(t, fb)
+	first, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Write([]byte("first connection\n"))
+	waiting, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting.Write([]byte("second connection\n"))
+	waiting.Close()
+	first.Write([]byte("first connection again\n"))
+	first.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(logged(), "closed, waiting for the next one") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("connections weren't both read:\n%s", logged())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	closeInput()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	want := "first connection\nfirst connection again\nsecond connection\n"
+	if got := readGzipFiles(t, fb.activeFiles); string(got[0]) != want {
+		t.Errorf("output has %q, want %q", got[0], want)
+	}
+}
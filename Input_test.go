@@ -0,0 +1,96 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stalledInput returns an activityReader over a pipe nothing is written to
+// unless the test does, being read as the reader goroutine would
+func stalledInput(t *testing.T) (*activityReader, *os.File) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		w.Close()
+		r.Close()
+	})
+	input := newActivityReader(r)
+	go io.Copy(io.Discard, input)
+	return input, w
+}
+
+// TestReadTimeoutExit checks the read timeout exits once nothing has been
+// read for the timeout, counted from the last read
+func TestReadTimeoutExit(t *testing.T) {
+	discardLog(t)
+	const timeout = 100 * time.Millisecond
+	fb := newTestFileBuffer(t, WithReadTimeout(timeout, "exit"))
+	exited := make(chan int, 1)
+	fb.exitFn = func(code int) {
+		exited <- code
+		runtime.Goexit() // as os.Exit would, stop watching
+	}
+	input, w := stalledInput(t)
+	done := make(chan struct{})
+	defer close(done)
+
+	start := time.Now()
+	go watchReadTimeout(fb, input, done)
+	time.Sleep(timeout / 2)
+	w.Write([]byte("some data\n"))
+	lastRead := time.Now()
+
+	select {
+	case code := <-exited:
+		if code != ExitReadError {
+			t.Errorf("exit code %d, want %d", code, ExitReadError)
+		}
+		if idle := time.Since(lastRead); idle < timeout {
+			t.Errorf("exited %v after the last read, %v after starting, want at least %v", idle, time.Since(start), timeout)
+		}
+	case <-time.After(10 * timeout):
+		t.Fatalf("didn't exit within %v", 10*timeout)
+	}
+}
+
+// TestReadTimeoutRotate checks the read timeout rotates to a new file when
+// something was written to the current one, and not again while the new
+// one stays empty
+func TestReadTimeoutRotate(t *testing.T) {
+	logged := captureLog(t)
+	const timeout = 50 * time.Millisecond
+	fb := newTestFileBuffer(t, WithReadTimeout(timeout, "rotate"))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	fb.write([]byte("written before the input stalled\n"))
+	input, _ := stalledInput(t)
+	done := make(chan struct{})
+	go watchReadTimeout(fb, input, done)
+
+	deadline := time.Now().Add(20 * timeout)
+	for fb.counters.filesCreated.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(timeout / 5)
+	}
+	time.Sleep(3 * timeout)
+	close(done)
+	fb.close()
+
+	if n := fb.counters.filesCreated.Load(); n != 2 {
+		t.Errorf("%d files created, want 2", n)
+	}
+	if n := strings.Count(logged(), ", rotated to a new file"); n != 1 {
+		t.Errorf("logged %d rotations, want 1:\n%s", n, logged())
+	}
+}
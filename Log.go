@@ -0,0 +1,149 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logOutput is where log lines go once running: stderr, or --log_file
+var logOutput = &logWriter{}
+
+// logWriter writes to a log file opened for append, or syslog, falling back
+// to stderr if there isn't one or it fails
+type logWriter struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	syslog syslogLogger
+}
+
+// syslogLogger sends a message at a syslog priority. *syslog.Writer is one.
+type syslogLogger interface {
+	Debug(m string) error
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+	Close() error
+}
+
+// syslogFacilities are the --log_syslog_facility names
+var syslogFacilities = []string{"daemon", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// normalizeSyslogFacility accepts a facility with or without the LOG_
+// prefix, in either case
+func normalizeSyslogFacility(facility string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.ToUpper(facility), "LOG_"))
+}
+
+// openSyslog starts logging to syslog. On failure, logging stays on stderr.
+func (l *logWriter) openSyslog(facility string) error {
+	w, err := openSyslog(facility)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.syslog = w
+	return nil
+}
+
+// writeSyslog sends each line of p to syslog, at the priority given by the
+// prefix of the first line, so a stack trace goes with its error
+func (l *logWriter) writeSyslog(p []byte) error {
+	send := l.syslog.Info
+	switch {
+	case bytes.HasPrefix(p, []byte("Error")), bytes.HasPrefix(p, []byte("Internal error")):
+		send = l.syslog.Err
+	case bytes.HasPrefix(p, []byte("Warning")):
+		send = l.syslog.Warning
+	case bytes.HasPrefix(p, []byte("Debug")):
+		send = l.syslog.Debug
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if err := send(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open starts logging to path. On failure, logging stays on stderr.
+func (l *logWriter) open(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.path = path
+	return l.openLocked()
+}
+
+func (l *logWriter) openLocked() error {
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	return nil
+}
+
+// reopen closes and reopens the log file, for after logrotate has moved it
+func (l *logWriter) reopen() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	if err := l.openLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to reopen log file, logging to stderr: %v\n", err)
+	}
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.syslog != nil {
+		err := l.writeSyslog(p)
+		if err == nil {
+			return len(p), nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: writing to syslog failed, logging to stderr: %v\n", err)
+		l.syslog.Close()
+		l.syslog = nil
+	}
+	if l.file != nil {
+		n, err := l.file.Write(p)
+		if err == nil {
+			return n, nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: writing to log file %s failed, logging to stderr until SIGHUP: %v\n", l.path, err)
+		l.file.Close()
+		l.file = nil
+	}
+	return os.Stderr.Write(p)
+}
+
+// Close closes the log file or syslog connection, if there is one
+func (l *logWriter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.syslog != nil {
+		err := l.syslog.Close()
+		l.syslog = nil
+		return err
+	}
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLogFile checks the log goes to --log_file, appended to, and not to
+// stderr, with --quiet leaving only the warnings there, and a log file that
+// can't be opened falls back to stderr
+func TestLogFile(t *testing.T) {
+	inChild()
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "gzfb.log")
+	if err := os.WriteFile(logFile, []byte("earlier line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Random letters, so the files fill up, in long lines
+	rng := rand.New(rand.NewSource(1))
+	letters := make([]byte, 300*1024)
+	for i := range letters {
+		letters[i] = 'a' + byte(rng.Intn(26))
+		if i%10000 == 9999 {
+			letters[i] = '\n'
+		}
+	}
+	input := string(letters)
+	run := func(logFile string, args ...string) string {
+		t.Helper()
+		args = append([]string{"--file_prefix", filepath.Join(t.TempDir(), "out"), "--file_size", "64", "--num_files", "2",
+			"--split_on_newline", "--log_file", logFile}, args...)
+		code, stderr := runMain(t, input, args...)
+		if code != 0 {
+			t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+		}
+		return stderr
+	}
+
+	if stderr := run(logFile); stderr != "" {
+		t.Errorf("stderr with --log_file:\n%s", stderr)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "earlier line\n") {
+		t.Errorf("log file wasn't appended to:\n%s", data)
+	}
+	for _, want := range []string{"Created new file: ", "Main: Shutdown cleanly."} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log file doesn't contain %q:\n%s", want, data)
+		}
+	}
+
+	quietLog := filepath.Join(dir, "quiet.log")
+	if stderr := run(quietLog, "--quiet", "--read_buffer_size", "512", "--max_block_size", "512"); stderr != "" {
+		t.Errorf("stderr with --log_file and --quiet:\n%s", stderr)
+	}
+	data, err = os.ReadFile(quietLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rotation's 512 byte read is unlikely to have a newline to split at
+	if want := "Warning: no newline found"; !strings.Contains(string(data), want) {
+		t.Errorf("log file with --quiet doesn't contain %q:\n%s", want, data)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, "Warning: ") {
+			t.Errorf("log file has an informational line with --quiet: %q", line)
+		}
+	}
+
+	missing := filepath.Join(dir, "missing", "gzfb.log")
+	stderr := run(missing)
+	for _, want := range []string{"Warning: failed to open log file, logging to stderr: ", "Main: Shutdown cleanly."} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr doesn't contain %q:\n%s", want, stderr)
+		}
+	}
+}
+
+// TestLogFileReopen moves the log file aside as logrotate would, and checks
+// lines go to the new file once reopened
+func TestLogFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gzfb.log")
+	l := &logWriter{}
+	if err := l.open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fmt.Fprintln(l, "before rotation")
+	rotated := filepath.Join(dir, "gzfb.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(l, "before reopening")
+	l.reopen()
+	fmt.Fprintln(l, "after reopening")
+
+	for file, want := range map[string]string{
+		rotated: "before rotation\nbefore reopening\n",
+		path:    "after reopening\n",
+	} {
+		if data, err := os.ReadFile(file); err != nil || string(data) != want {
+			t.Errorf("%s has %q (%v), want %q", file, data, err, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// startMetricsServer serves the statistics at /metrics on --metrics_addr, in
+// the Prometheus text exposition format. Listening happens up front so a bad
+// address is reported straight away.
+func (fb *FileBuffer) startMetricsServer() error {
+	ln, err := net.Listen("tcp", fb.metricsAddr)
+	if err != nil {
+		return fmt.Errorf("--metrics_addr: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, fb.Stats())
+	})
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			fmt.Fprintf(logOutput, "Error: metrics server stopped: %v\n", err)
+		}
+	}()
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Serving metrics on http://%s/metrics\n", ln.Addr())
+	}
+	return nil
+}
+
+// writeMetrics writes s in the Prometheus text exposition format
+func writeMetrics(w io.Writer, s Statistics) {
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"gzfb_bytes_in_total", "counter", "Uncompressed bytes written, including headers", s.BytesWrittenUncompressed},
+		{"gzfb_bytes_out_total", "counter", "Compressed bytes written", s.BytesWrittenCompressed},
+		{"gzfb_files_created_total", "counter", "Output files created", s.FilesCreated},
+		{"gzfb_files_deleted_total", "counter", "Output files deleted", s.FilesDeleted},
+		{"gzfb_blocks_found_total", "counter", "Block headers found when rotating", s.BlocksFound},
+		{"gzfb_block_validation_failures_total", "counter", "Candidate block headers that failed validation", s.BlockValidationFailures},
+		{"gzfb_block_scans_total", "counter", "Searches of a read buffer for a block boundary", s.TotalScanIterations},
+		{"gzfb_block_scans_failed_total", "counter", "Searches that found no block boundary", s.FailedBoundaryFinds},
+		{"gzfb_block_scan_bytes_total", "counter", "Bytes passed over searching for block boundaries", s.TotalBytesScanned},
+		{"gzfb_current_file_size_bytes", "gauge", "Compressed bytes written to the current file", s.CurrentFileBytes},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	var sb strings.Builder
+	writeMetrics(&sb, Statistics{
+		BytesWrittenUncompressed: 1000,
+		BytesWrittenCompressed:   400,
+		FilesCreated:             3,
+		FilesDeleted:             1,
+		BlocksFound:              2,
+		BlockValidationFailures:  40,
+		CurrentFileBytes:         150,
+	})
+	for _, want := range []string{
+		"# HELP gzfb_bytes_in_total Uncompressed bytes written, including headers\n# TYPE gzfb_bytes_in_total counter\ngzfb_bytes_in_total 1000\n",
+		"# TYPE gzfb_bytes_out_total counter\ngzfb_bytes_out_total 400\n",
+		"# TYPE gzfb_files_created_total counter\ngzfb_files_created_total 3\n",
+		"# TYPE gzfb_files_deleted_total counter\ngzfb_files_deleted_total 1\n",
+		"# TYPE gzfb_blocks_found_total counter\ngzfb_blocks_found_total 2\n",
+		"# TYPE gzfb_block_validation_failures_total counter\ngzfb_block_validation_failures_total 40\n",
+		"# TYPE gzfb_current_file_size_bytes gauge\ngzfb_current_file_size_bytes 150\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, sb.String())
+		}
+	}
+}
+
+// TestMetricsServer scrapes /metrics after some writes and rotations, and
+// checks the values match the statistics
+func TestMetricsServer(t *testing.T) {
+	log := captureLog(t)
+	fb := newTestFileBuffer(t,
+		WithMetricsAddr("127.0.0.1:0"),
+		WithQuiet(false),
+		WithBlockFormat(presetFormat(t, "pcap")),
+		WithMaxFileSize(1),
+		WithRotateOnUncompressed(true),
+		WithMaxNumFiles(2),
+	)
+	if err := fb.startMetricsServer(); err != nil {
+		t.Fatal(err)
+	}
+	url := regexp.MustCompile(`Serving metrics on (\S+)`).FindStringSubmatch(log())
+	if url == nil {
+		t.Fatalf("no metrics address logged:\n%s", log())
+	}
+
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 4 {
+		fb.write(append(bytes.Repeat([]byte{0xFF}, 10*i), pcapRecords(4, 100, int64(i))...))
+	}
+
+	resp, err := http.Get(url[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	var want strings.Builder
+	writeMetrics(&want, fb.Stats())
+	if string(body) != want.String() {
+		t.Errorf("scraped:\n%s\nwant:\n%s", body, want.String())
+	}
+	for _, want := range []string{"gzfb_files_created_total 4\n", "gzfb_files_deleted_total 2\n", "gzfb_blocks_found_total 3\n", "gzfb_block_validation_failures_total 60\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("scraped metrics don't contain %q", want)
+		}
+	}
+	fb.close()
+
+	// The address is checked up front
+	fb = newTestFileBuffer(t, WithMetricsAddr("127.0.0.1:-1"))
+	if err := fb.startMetricsServer(); err == nil || !strings.HasPrefix(err.Error(), "--metrics_addr: ") {
+		t.Errorf("bad address got error %v, want a --metrics_addr one", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func (fb *FileBuffer) mirrorPath(filename string) string {
+	return filepath.Join(fb.mirrorDir, filepath.Base(filename))
+}
+
+// openMirror opens the mirror copy of filename. Failure isn't fatal, the
+// primary file carries on without a mirror.
+func (fb *FileBuffer) openMirror(filename string) {
+	if fb.mirrorDir == "" {
+		return
+	}
+
+	path := fb.mirrorPath(filename)
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to create mirror file %s: %v\n", path, err)
+		return
+	}
+	gzWriter, err := fb.newGzipStream(f)
+	if err != nil {
+		f.Close()
+		fmt.Fprintf(logOutput, "Warning: failed to create gzip writer for mirror file %s: %v\n", path, err)
+		return
+	}
+	fb.mirrorFile = f
+	fb.mirrorWriter = gzWriter
+	setChecksumPlaceholder(gzWriter, fb.embedChecksum)
+	fb.setGzipName(gzWriter, filename, fb.currentFileOpenedAt)
+
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Created mirror file: %s\n", path)
+	}
+}
+
+// writeMirror copies data to the mirror, giving up on the mirror for the
+// rest of the current file if the write fails.
+func (fb *FileBuffer) writeMirror(data []byte) {
+	if fb.mirrorWriter == nil {
+		return
+	}
+
+	n, err := fb.mirrorWriter.Write(data)
+	if err == nil && n != len(data) {
+		err = fmt.Errorf("short write: wrote %d bytes, expected %d bytes", n, len(data))
+	}
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: mirror write to %s failed, disabling mirror for this file: %v\n", fb.mirrorFile.Name(), err)
+		fb.closeMirror(false)
+	}
+}
+
+// closeMirror closes the mirror file. complete is false if it's being given
+// up on part way, so it doesn't get the primary file's checksum.
+func (fb *FileBuffer) closeMirror(complete bool) {
+	if fb.mirrorWriter != nil {
+		err := fb.mirrorWriter.Close()
+		if err != nil {
+			fmt.Fprintf(logOutput, "Warning: error closing mirror gzip writer: %v\n", err)
+		} else if complete {
+			if err := fb.finishChecksum(fb.mirrorFile); err != nil {
+				fmt.Fprintf(logOutput, "Warning: error writing checksum to mirror file: %v\n", err)
+			}
+		}
+		fb.mirrorWriter = nil
+	}
+	if fb.mirrorFile != nil {
+		if err := fb.mirrorFile.Close(); err != nil {
+			fmt.Fprintf(logOutput, "Warning: error closing mirror file: %v\n", err)
+		}
+		fb.mirrorFile = nil
+	}
+}
+
+// removeMirror deletes the mirror copy of a primary file being deleted
+func (fb *FileBuffer) removeMirror(filename string) {
+	if fb.mirrorDir == "" {
+		return
+	}
+
+	path := fb.mirrorPath(filename)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(logOutput, "Warning: failed to delete mirror file %s: %v\n", path, err)
+	}
+}
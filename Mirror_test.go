@@ -0,0 +1,92 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMirrorDir checks each file has an identical copy in --mirror_dir,
+// deleted along with it
+func TestMirrorDir(t *testing.T) {
+	mirrorDir := t.TempDir()
+	fb := newTestFileBuffer(t, WithMirrorDir(mirrorDir), WithMaxNumFiles(3))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	var opened []string
+	for range 3 {
+		opened = append(opened, fb.currentFileName)
+		fb.write(syntheticLog(64 * 1024))
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb.close()
+
+	for _, path := range opened[1:] {
+		mirror := filepath.Join(mirrorDir, filepath.Base(path))
+		contents := readGzipFiles(t, []string{path, mirror})
+		if !bytes.Equal(contents[0], contents[1]) || len(contents[0]) != 64*1024 {
+			t.Errorf("%s has %d bytes, mirror %d, want the same 64KB", path, len(contents[0]), len(contents[1]))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(mirrorDir, filepath.Base(opened[0]))); !os.IsNotExist(err) {
+		t.Errorf("mirror of deleted %s wasn't deleted: %v", opened[0], err)
+	}
+}
+
+// TestMirrorFailure checks the primary file carries on when the mirror
+// fails, and the next file is mirrored again
+func TestMirrorFailure(t *testing.T) {
+	discardLog(t)
+	mirrorDir := t.TempDir()
+	fb := newTestFileBuffer(t, WithMirrorDir(mirrorDir))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing to the mirror fails once the gzip writer has output for it
+	fb.mirrorFile.Close()
+	data := packetCapture(256 * 1024)
+	fb.write(data[:128*1024])
+	fb.write(data[128*1024:])
+	if fb.mirrorWriter != nil {
+		t.Fatal("mirror still enabled after a failed write")
+	}
+	first, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readGzipFiles(t, []string{first}); !bytes.Equal(got[0], data) {
+		t.Errorf("primary file has %d bytes, want the %d written", len(got[0]), len(data))
+	}
+	if fb.mirrorWriter == nil {
+		t.Error("next file isn't mirrored")
+	}
+
+	// A mirror that can't be created leaves just the primary file
+	if err := os.RemoveAll(mirrorDir); err != nil {
+		t.Fatal(err)
+	}
+	second, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.mirrorWriter != nil {
+		t.Error("mirrored without the mirror directory")
+	}
+	fb.write([]byte("primary only\n"))
+	fb.close()
+	if fb.currentFileName == second {
+		t.Fatal("didn't rotate without the mirror directory")
+	}
+	if got := readGzipFiles(t, []string{fb.currentFileName}); string(got[0]) != "primary only\n" {
+		t.Errorf("primary file has %q, want %q", got[0], "primary only\n")
+	}
+}
@@ -0,0 +1,962 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultTimeFormat = "2006-01-02T15:04:05.000Z"
+	defaultBufferSize = 262144 // 256KB, default for both read buffer and max block size
+	maxFileCounter    = 999999 // largest counter that fits the 6 digits in filenames
+
+	// zlib's window_bits and mem_level defaults, which compress/flate is
+	// used for
+	defaultGzipWindowBits = 15
+	defaultGzipMemLevel   = 8
+)
+
+// Option configures a FileBuffer constructed with NewFileBuffer
+type Option func(*FileBuffer)
+
+// WithPrefix sets the prefix for output files (required unless writing to stdout)
+func WithPrefix(prefix string) Option {
+	return func(fb *FileBuffer) { fb.filePrefix = prefix }
+}
+
+// WithMaxFileSize sets the size in bytes at which files are rotated (required unless writing to stdout)
+func WithMaxFileSize(bytes int64) Option {
+	return func(fb *FileBuffer) { fb.maxFileSize = bytes }
+}
+
+// WithRotateOnUncompressed compares the max file size with the bytes written
+// to each file before compression, instead of its compressed size
+func WithRotateOnUncompressed(uncompressed bool) Option {
+	return func(fb *FileBuffer) { fb.rotateOnUncompressed = uncompressed }
+}
+
+// WithMaxNumFiles sets the number of files to keep (required unless writing to stdout)
+func WithMaxNumFiles(n int) Option {
+	return func(fb *FileBuffer) { fb.maxNumFiles = n }
+}
+
+// WithOutputExtension sets the extension appended to each filename (".gz" by
+// default). A leading "." is added if missing, and "" appends nothing.
+func WithOutputExtension(ext string) Option {
+	return func(fb *FileBuffer) {
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		fb.outputExtension = ext
+	}
+}
+
+// WithFilenameSep sets the separator between the prefix, counter and
+// timestamp in filenames ("_" by default, may be empty)
+func WithFilenameSep(sep string) Option {
+	return func(fb *FileBuffer) { fb.filenameSep = sep }
+}
+
+// WithFilenameTemplate generates filenames from tmpl (see filenameData)
+// instead of the default prefix, counter and timestamp format
+func WithFilenameTemplate(tmpl *template.Template) Option {
+	return func(fb *FileBuffer) { fb.filenameTemplate = tmpl }
+}
+
+// WithCounterStart sets the counter of the first file. A resumed counter
+// takes precedence.
+func WithCounterStart(n int) Option {
+	return func(fb *FileBuffer) { fb.fileCounter = n }
+}
+
+// WithSubdirFormat puts each file in a subdirectory named by formatting the
+// time with layout, e.g. 2006/01/02 for one per day, created as needed
+func WithSubdirFormat(layout string) Option {
+	return func(fb *FileBuffer) { fb.subdirFormat = layout }
+}
+
+// WithTimeFormat sets the Go time layout used for the filename timestamp
+func WithTimeFormat(layout string) Option {
+	return func(fb *FileBuffer) { fb.timeFormat = layout }
+}
+
+// WithLocalTime uses local time instead of UTC for filename timestamps
+func WithLocalTime(local bool) Option {
+	return func(fb *FileBuffer) { fb.useLocalTime = local }
+}
+
+// WithHeaderBytes sets how many bytes from the start of the stream are copied to each file
+func WithHeaderBytes(n int) Option {
+	return func(fb *FileBuffer) { fb.headerBytes = n }
+}
+
+// WithTrailer sets bytes appended to each file before it's closed
+func WithTrailer(trailer []byte) Option {
+	return func(fb *FileBuffer) { fb.trailer = trailer }
+}
+
+// WithTrailerFile sets a file whose contents are appended to each file before
+// it's closed. It's read each time, so changes to it apply to the next close.
+func WithTrailerFile(path string) Option {
+	return func(fb *FileBuffer) { fb.trailerFile = path }
+}
+
+// WithInjectBlock sets a block written to each file after the header, before
+// any stream data
+func WithInjectBlock(block []byte) Option {
+	return func(fb *FileBuffer) { fb.injectBlock = block }
+}
+
+// WithSectionHeaderBytes sets how many bytes at the start of each file's data,
+// after the global header, make up its section header
+func WithSectionHeaderBytes(n int) Option {
+	return func(fb *FileBuffer) { fb.sectionHeaderBytes = n }
+}
+
+// WithBlockFormat sets the block header format used to find rotation boundaries
+func WithBlockFormat(format *BlockHeaderFormat) Option {
+	return func(fb *FileBuffer) { fb.blockFormat = format }
+}
+
+// WithBlockFormatAlts sets alternate block header formats, tried in order at
+// each offset where the block header format doesn't match
+func WithBlockFormatAlts(formats ...*BlockHeaderFormat) Option {
+	return func(fb *FileBuffer) { fb.blockFormatAlts = formats }
+}
+
+// WithBlockTrailer sets the format of the fields following each block's
+// data, e.g. an Ethernet FCS, which also have to be valid at a boundary
+func WithBlockTrailer(format *BlockHeaderFormat) Option {
+	return func(fb *FileBuffer) { fb.blockTrailer = format }
+}
+
+// WithBlockValidator finds rotation boundaries with validate, a
+// --block_validator_plugin's ValidateBlock, instead of a block header format
+func WithBlockValidator(validate func([]byte) int) Option {
+	return func(fb *FileBuffer) { fb.blockValidator = validate }
+}
+
+// WithTimestampHz sets the ticks per second of sec fields, e.g. 1000 for
+// milliseconds since the epoch, rather than 1 for seconds
+func WithTimestampHz(hz uint64) Option {
+	return func(fb *FileBuffer) { fb.timestampHz = hz }
+}
+
+// WithRequireCompleteBlock only accepts a block boundary if the whole block
+// (per its length field) is in the buffer being scanned
+func WithRequireCompleteBlock(require bool) Option {
+	return func(fb *FileBuffer) { fb.requireCompleteBlock = require }
+}
+
+// WithAutoDetectPcap configures the header bytes and block format from the
+// pcap global header at the start of the stream
+func WithAutoDetectPcap(detect bool) Option {
+	return func(fb *FileBuffer) { fb.autoDetectPcap = detect }
+}
+
+// WithBlockValidationStrict exits once more than threshold scans in a row
+// find no block header to rotate at
+func WithBlockValidationStrict(strict bool, threshold int) Option {
+	return func(fb *FileBuffer) {
+		fb.blockValidationStrict = strict
+		fb.strictMissThreshold = threshold
+	}
+}
+
+// WithDebugBlockScan logs block header candidates that partly matched, to
+// help debug a block header format
+func WithDebugBlockScan(debug bool) Option {
+	return func(fb *FileBuffer) { fb.debugBlockScan = debug }
+}
+
+// WithSplitOnNewline rotates files after the last newline in the chunk
+// rather than at a block header, skipping newlines that end a line shorter
+// than minLineLength
+func WithSplitOnNewline(split bool, minLineLength int) Option {
+	return func(fb *FileBuffer) {
+		fb.splitOnNewline = split
+		fb.minLineLength = minLineLength
+	}
+}
+
+// WithRecordSize rotates files only at multiples of size bytes after the
+// header, so every file holds whole fixed-size records
+func WithRecordSize(size int) Option {
+	return func(fb *FileBuffer) { fb.recordSize = size }
+}
+
+// WithRecordDelimiter rotates files after the last occurrence of delim (up
+// to 8 bytes) in the chunk rather than at a block header
+func WithRecordDelimiter(delim []byte) Option {
+	return func(fb *FileBuffer) { fb.recordDelimiter = delim }
+}
+
+// WithMaxBlockSize sets the largest block length considered valid
+func WithMaxBlockSize(bytes int) Option {
+	return func(fb *FileBuffer) { fb.maxBlockSize = bytes }
+}
+
+// WithReadBufferSize sets the size of the chunks data is processed in
+func WithReadBufferSize(bytes int) Option {
+	return func(fb *FileBuffer) { fb.readBufferSize = bytes }
+}
+
+// WithMaxInputRate limits uncompressed input to mbps megabits per second
+func WithMaxInputRate(mbps float64) Option {
+	return func(fb *FileBuffer) { fb.maxInputRate = mbps }
+}
+
+// WithMaxOutputRate limits compressed output to mbps megabits per second
+func WithMaxOutputRate(mbps float64) Option {
+	return func(fb *FileBuffer) { fb.maxOutputRate = mbps }
+}
+
+// WithCompressionLevel sets the gzip compression level (-1 to 9)
+func WithCompressionLevel(level int) Option {
+	return func(fb *FileBuffer) { fb.compressionLevel = level }
+}
+
+// WithGzipWindow sets zlib's window_bits (9-15) and mem_level (1-9). Other
+// than the defaults, 15 and 8, they need GzipFileBuffer's own deflate
+// compressor, which is slower and compresses a little worse than
+// compress/flate at the same window.
+func WithGzipWindow(windowBits, memLevel int) Option {
+	return func(fb *FileBuffer) {
+		fb.gzipWindowBits = windowBits
+		fb.gzipMemLevel = memLevel
+	}
+}
+
+// WithGzipSyncInterval emits a gzip sync point every bytes of uncompressed
+// data, so a partially written file can be decompressed up to the last one
+func WithGzipSyncInterval(bytes int64) Option {
+	return func(fb *FileBuffer) { fb.syncInterval = bytes }
+}
+
+// WithMaxFileAge deletes files older than age, in addition to the count limit
+func WithMaxFileAge(age time.Duration) Option {
+	return func(fb *FileBuffer) { fb.maxFileAge = age }
+}
+
+// WithMaxTotalBytes deletes the oldest files while the total size of all files exceeds bytes
+func WithMaxTotalBytes(bytes int64) Option {
+	return func(fb *FileBuffer) { fb.maxTotalBytes = bytes }
+}
+
+// WithProtectRecent keeps files modified less than d ago when --num_files
+// would delete them, letting the count go over instead
+func WithProtectRecent(d time.Duration) Option {
+	return func(fb *FileBuffer) { fb.protectRecent = d }
+}
+
+// WithPreDeleteHook runs command (with {} replaced by the file path) before
+// each file is deleted, giving up on it after timeout. If strict, a failed
+// hook means the file is kept rather than deleted.
+func WithPreDeleteHook(command string, timeout time.Duration, strict bool) Option {
+	return func(fb *FileBuffer) {
+		fb.preDeleteHook = command
+		fb.preDeleteHookTimeout = timeout
+		fb.preDeleteHookStrict = strict
+	}
+}
+
+// WithDecompressInput treats the input as gzip (possibly several streams
+// one after another) and decompresses it before it's written
+func WithDecompressInput(decompress bool) Option {
+	return func(fb *FileBuffer) { fb.decompressInput = decompress }
+}
+
+// WithWriteErrorPolicy sets what happens when writing to the output fails:
+// "exit", "warn_continue" to drop the data, or "rotate_on_error" to retry
+// in a new file, exiting after maxConsecutive failures in a row
+func WithWriteErrorPolicy(policy string, maxConsecutive int) Option {
+	return func(fb *FileBuffer) {
+		fb.writeErrorPolicy = policy
+		fb.maxConsecutiveErrors = maxConsecutive
+	}
+}
+
+// WithWriteRetry retries writes and file creation that fail with a transient
+// error (e.g. ENOSPC or EAGAIN) up to count times, interval apart, before the
+// write error policy applies
+func WithWriteRetry(count int, interval time.Duration) Option {
+	return func(fb *FileBuffer) {
+		fb.writeRetryCount = count
+		fb.writeRetryInterval = interval
+	}
+}
+
+// WithErrorHandler sets a handler for errors while writing, which returns
+// true to carry on or false to exit. Fatal errors exit regardless, after
+// closing the current file. It's usually called with the FileBuffer's lock
+// held, so it mustn't call back into the FileBuffer.
+func WithErrorHandler(handler func(e *FileBufferError) bool) Option {
+	return func(fb *FileBuffer) { fb.errorHandler = handler }
+}
+
+// WithGoroutineRestart sets whether reading and processing carry on after a
+// panic, rather than ending the input
+func WithGoroutineRestart(restart bool) Option {
+	return func(fb *FileBuffer) { fb.goroutineRestart = restart }
+}
+
+// WithShutdownTimeout sets how long to allow for finishing up after a shutdown
+// signal before forcing an exit, 0 for no limit
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(fb *FileBuffer) { fb.shutdownTimeout = timeout }
+}
+
+// WithReadTimeout applies action ("exit" or "rotate") whenever no input has
+// been read for timeout. Exiting uses exit code 3.
+func WithReadTimeout(timeout time.Duration, action string) Option {
+	return func(fb *FileBuffer) {
+		fb.readTimeout = timeout
+		fb.readTimeoutAction = action
+	}
+}
+
+// WithInputTCP reads the input from a TCP connection accepted on addr
+// instead of stdin. With multi, further connections are accepted one after
+// another and continue the stream.
+func WithInputTCP(addr string, multi bool) Option {
+	return func(fb *FileBuffer) {
+		fb.inputTCP = addr
+		fb.inputTCPMulti = multi
+	}
+}
+
+// WithInputUnix reads the input from a connection accepted on a Unix domain
+// socket at path instead of stdin, with perms (if not 0) for the socket file.
+// With multi, further connections continue the stream.
+func WithInputUnix(path string, perms os.FileMode, multi bool) Option {
+	return func(fb *FileBuffer) {
+		fb.inputUnix = path
+		fb.inputUnixPerms = perms
+		fb.inputUnixMulti = multi
+	}
+}
+
+// WithArchiveDir moves each file to dir once it's closed, out of the
+// rotation. It must be an existing directory.
+func WithArchiveDir(dir string) Option {
+	return func(fb *FileBuffer) { fb.archiveDir = dir }
+}
+
+// WithGzipDictionary compresses with dict as a preset deflate dictionary
+// (only the last 32KB is used), embedding it in each gzip header if embed.
+// The files then need the dictionary to decompress.
+func WithGzipDictionary(dict []byte, embed bool) Option {
+	return func(fb *FileBuffer) {
+		fb.dictionary = dict
+		fb.embedDictionary = embed
+	}
+}
+
+// WithEmbedChecksum sets the gzip header comment of each file to "sha256="
+// and the hex SHA-256 of its uncompressed contents, filled in on close
+func WithEmbedChecksum(embed bool) Option {
+	return func(fb *FileBuffer) { fb.embedChecksum = embed }
+}
+
+// WithGzipMultistream writes each chunk as a complete gzip member, so the
+// output is a concatenation of independently decompressible streams
+func WithGzipMultistream(enabled bool) Option {
+	return func(fb *FileBuffer) { fb.multistream = enabled }
+}
+
+// WithPreallocateBytes reserves n bytes of disk space for each new file (on
+// Linux), released again when the file is closed
+func WithPreallocateBytes(n int64) Option {
+	return func(fb *FileBuffer) { fb.preallocateBytes = n }
+}
+
+// WithS3Upload uploads each closed file to bucket, as keyPrefix followed by
+// the file's base name. Credentials come from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, and the region from AWS_REGION
+// if region is empty. Failed uploads are retried retryCount times, and with
+// deleteAfter, uploaded files are deleted locally.
+func WithS3Upload(bucket, keyPrefix, region string, deleteAfter bool, retryCount int) Option {
+	return func(fb *FileBuffer) {
+		fb.s3Bucket = bucket
+		fb.s3KeyPrefix = keyPrefix
+		fb.s3Region = region
+		fb.s3DeleteAfterUpload = deleteAfter
+		fb.s3RetryCount = retryCount
+	}
+}
+
+// WithControlAddr sets an address to serve runtime controls on, e.g.
+// POST /compression
+func WithControlAddr(addr string) Option {
+	return func(fb *FileBuffer) { fb.controlAddr = addr }
+}
+
+// WithMetricsAddr sets an address to serve Prometheus metrics on at /metrics,
+// started by the command line program
+func WithMetricsAddr(addr string) Option {
+	return func(fb *FileBuffer) { fb.metricsAddr = addr }
+}
+
+// WithStatsd sends StatsD metrics for each closed file to addr over UDP,
+// with names starting prefix
+func WithStatsd(addr, prefix string) Option {
+	return func(fb *FileBuffer) {
+		fb.statsdAddr = addr
+		fb.statsdPrefix = prefix
+	}
+}
+
+// WithWebhook POSTs a WebhookPayload to endpoint each time a file is closed, with
+// authHeader (if set) as the Authorization header, giving up on an attempt
+// after timeout
+func WithWebhook(endpoint, authHeader string, timeout time.Duration) Option {
+	return func(fb *FileBuffer) {
+		fb.webhookURL = endpoint
+		fb.webhookAuthHeader = authHeader
+		fb.webhookTimeout = timeout
+	}
+}
+
+// WithInputTLS accepts TLS connections for WithInputTCP, using the PEM
+// certificate and key files
+func WithInputTLS(certFile, keyFile string) Option {
+	return func(fb *FileBuffer) {
+		fb.inputTLSCert = certFile
+		fb.inputTLSKey = keyFile
+	}
+}
+
+// WithResumeExisting continues from matching files already on disk
+func WithResumeExisting(resume bool) Option {
+	return func(fb *FileBuffer) { fb.resumeExisting = resume }
+}
+
+// WithQuiet suppresses non-error output
+func WithQuiet(quiet bool) Option {
+	return func(fb *FileBuffer) { fb.quiet = quiet }
+}
+
+// WithLogFile appends log output to path instead of writing it to stderr
+func WithLogFile(path string) Option {
+	return func(fb *FileBuffer) { fb.logFile = path }
+}
+
+// WithLogSyslog sends log output to syslog with facility (daemon or local0
+// to local7) instead of writing it to stderr
+func WithLogSyslog(enabled bool, facility string) Option {
+	return func(fb *FileBuffer) {
+		fb.logSyslog = enabled
+		fb.logSyslogFacility = facility
+	}
+}
+
+// WithVerbose logs each write, block header scan and file open and close,
+// for debugging block header detection
+func WithVerbose(verbose bool) Option {
+	return func(fb *FileBuffer) { fb.verbose = verbose }
+}
+
+// WithStdout writes a single gzip stream to stdout instead of rotating files
+func WithStdout(toStdout bool) Option {
+	return func(fb *FileBuffer) { fb.toStdout = toStdout }
+}
+
+// WithOutputDirs spreads successive files across dirs, round-robin
+func WithOutputDirs(dirs []string) Option {
+	return func(fb *FileBuffer) { fb.outputDirs = dirs }
+}
+
+// WithDualOutput also writes an uncompressed copy of each file, named without
+// the output extension, with perms (if not 0)
+func WithDualOutput(enabled bool, perms os.FileMode) Option {
+	return func(fb *FileBuffer) {
+		fb.dualOutput = enabled
+		fb.dualOutputPerms = perms
+	}
+}
+
+// WithOutputFifo writes each file as a gzip member to the named pipe at path,
+// one after another, instead of to rotating files
+func WithOutputFifo(path string) Option {
+	return func(fb *FileBuffer) { fb.outputFifo = path }
+}
+
+// WithIndex writes a block offset index alongside each file, in format json
+// or binary
+func WithIndex(enabled bool, format string) Option {
+	return func(fb *FileBuffer) {
+		fb.writeIndex = enabled
+		fb.indexFormat = format
+	}
+}
+
+// WithMirrorDir writes a backup copy of each file to dir
+func WithMirrorDir(dir string) Option {
+	return func(fb *FileBuffer) { fb.mirrorDir = dir }
+}
+
+// WithStateFile persists the file counter and active files to path for resuming
+func WithStateFile(path string) Option {
+	return func(fb *FileBuffer) { fb.stateFile = path }
+}
+
+// WithVerifyOutput decompresses each file in the background once it's
+// closed, setting it aside as .corrupt if that fails
+func WithVerifyOutput(verify bool) Option {
+	return func(fb *FileBuffer) { fb.verifyOutput = verify }
+}
+
+// WithVerifyOnResume checks resumed files decompress cleanly, setting aside corrupt ones
+func WithVerifyOnResume(verify bool) Option {
+	return func(fb *FileBuffer) { fb.verifyOnResume = verify }
+}
+
+// WithRepairLastFile rewrites a corrupt last resumed file with its recoverable data
+func WithRepairLastFile(repair bool) Option {
+	return func(fb *FileBuffer) { fb.repairLastFile = repair }
+}
+
+// WithRotateCallback calls cb each time a new file is opened, with the path
+// of the file just closed (empty for the first file) and the new file
+func WithRotateCallback(cb func(closed, opened string)) Option {
+	return func(fb *FileBuffer) { fb.rotateCallbacks = append(fb.rotateCallbacks, cb) }
+}
+
+// WithDeleteCallback calls cb with the path of each file deleted
+func WithDeleteCallback(cb func(deleted string)) Option {
+	return func(fb *FileBuffer) { fb.deleteCallbacks = append(fb.deleteCallbacks, cb) }
+}
+
+// NewFileBuffer creates a FileBuffer from opts and validates the result.
+// All problems are returned together, one per line.
+func NewFileBuffer(opts ...Option) (*FileBuffer, error) {
+	fb := &FileBuffer{
+		outputExtension:      ".gz",
+		filenameSep:          "_",
+		timeFormat:           defaultTimeFormat,
+		maxBlockSize:         defaultBufferSize,
+		timestampHz:          1,
+		readBufferSize:       defaultBufferSize,
+		compressionLevel:     gzip.DefaultCompression,
+		gzipWindowBits:       defaultGzipWindowBits,
+		gzipMemLevel:         defaultGzipMemLevel,
+		requireCompleteBlock: true,
+		writeErrorPolicy:     "exit",
+		readTimeoutAction:    "exit",
+		maxConsecutiveErrors: 3,
+		writeRetryCount:      3,
+		writeRetryInterval:   100 * time.Millisecond,
+		clockFn:              time.Now,
+		exitFn:               os.Exit,
+	}
+	for _, opt := range opts {
+		opt(fb)
+	}
+
+	if errs := fb.validate(); len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	fb.activeFiles = make([]string, 0, max(fb.maxNumFiles, 0))
+	fb.inputLimiter = newRateLimiter(fb.maxInputRate)
+	fb.outputLimiter = newRateLimiter(fb.maxOutputRate)
+	fb.startTime = time.Now()
+	return fb, nil
+}
+
+func (fb *FileBuffer) validate() []string {
+	var errs []string
+
+	if fb.verbose && fb.quiet {
+		errs = append(errs, "--verbose cannot be used with --quiet")
+	}
+	if fb.logSyslog {
+		if fb.logFile != "" {
+			errs = append(errs, "--log_syslog cannot be used with --log_file")
+		}
+		if !slices.Contains(syslogFacilities, normalizeSyslogFacility(fb.logSyslogFacility)) {
+			errs = append(errs, fmt.Sprintf("--log_syslog_facility must be one of %s, got: %s", strings.Join(syslogFacilities, ", "), fb.logSyslogFacility))
+		}
+	}
+
+	// Required settings (not all needed when streaming to stdout or a FIFO)
+	if !fb.toStdout {
+		if fb.maxFileSize <= 0 {
+			errs = append(errs, "--file_size is required and must be positive")
+		}
+	}
+	if !fb.toStdout && fb.outputFifo == "" {
+		if fb.maxNumFiles <= 0 {
+			errs = append(errs, "--num_files is required and must be positive")
+		}
+		if fb.filePrefix == "" {
+			errs = append(errs, "--file_prefix is required")
+		}
+	} else {
+		// There are no files on disk for these to apply to
+		dest := "--output stdout"
+		if fb.outputFifo != "" {
+			dest = "--output_fifo"
+		}
+		if fb.resumeExisting {
+			errs = append(errs, "--resume_existing cannot be used with "+dest)
+		}
+		if len(fb.outputDirs) > 0 {
+			errs = append(errs, "--output_dirs cannot be used with "+dest)
+		}
+		if fb.mirrorDir != "" {
+			errs = append(errs, "--mirror_dir cannot be used with "+dest)
+		}
+		if fb.verifyOutput {
+			errs = append(errs, "--verify_output cannot be used with "+dest)
+		}
+		if fb.dualOutput {
+			errs = append(errs, "--dual_output cannot be used with "+dest)
+		}
+		if fb.writeIndex {
+			errs = append(errs, "--write_index cannot be used with "+dest)
+		}
+		if fb.stateFile != "" {
+			errs = append(errs, "--state_file cannot be used with "+dest)
+		}
+		if fb.filenameTemplate != nil {
+			errs = append(errs, "--filename_template cannot be used with "+dest)
+		}
+		if fb.subdirFormat != "" {
+			errs = append(errs, "--subdir_format cannot be used with "+dest)
+		}
+		if fb.s3Bucket != "" {
+			errs = append(errs, "--s3_bucket cannot be used with "+dest)
+		}
+		if fb.archiveDir != "" {
+			errs = append(errs, "--archive_dir cannot be used with "+dest)
+		}
+		if fb.webhookURL != "" {
+			errs = append(errs, "--webhook_url cannot be used with "+dest)
+		}
+		if fb.embedChecksum {
+			errs = append(errs, "--embed_checksum cannot be used with "+dest)
+		}
+		if fb.preallocateBytes != 0 {
+			errs = append(errs, "--preallocate_bytes cannot be used with "+dest)
+		}
+	}
+
+	if fb.toStdout {
+		if fb.readTimeout > 0 && fb.readTimeoutAction == "rotate" {
+			errs = append(errs, "--read_timeout_action rotate cannot be used with --output stdout")
+		}
+		if fb.writeErrorPolicy == "rotate_on_error" {
+			errs = append(errs, "--write_error_policy rotate_on_error cannot be used with --output stdout")
+		}
+		if fb.sectionHeaderBytes > 0 {
+			errs = append(errs, "--section_header_bytes cannot be used with --output stdout")
+		}
+		if fb.rotateOnUncompressed {
+			errs = append(errs, "--rotate_on_uncompressed cannot be used with --output stdout")
+		}
+		if fb.controlAddr != "" {
+			errs = append(errs, "--control_addr cannot be used with --output stdout, there's only one gzip stream")
+		}
+	}
+	if fb.toStdout && fb.outputFifo != "" {
+		errs = append(errs, "--output_fifo cannot be used with --output stdout")
+	}
+	if fb.outputFifo != "" {
+		if info, err := os.Stat(fb.outputFifo); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+			errs = append(errs, fmt.Sprintf("--output_fifo is not an existing named pipe (see mkfifo): %s", fb.outputFifo))
+		}
+	}
+
+	if (fb.verifyOnResume || fb.repairLastFile) && !fb.resumeExisting {
+		errs = append(errs, "--verify_on_resume and --repair_last_file require --resume_existing")
+	}
+	if fb.dictionary != nil && fb.verifyOnResume {
+		errs = append(errs, "--verify_on_resume can't check files compressed with --gzip_dictionary_file")
+	}
+	if fb.dictionary != nil && fb.verifyOutput {
+		errs = append(errs, "--verify_output can't check files compressed with --gzip_dictionary_file")
+	}
+	if fb.embedDictionary && fb.dictionary == nil {
+		errs = append(errs, "--gzip_dictionary_embed requires --gzip_dictionary_file")
+	}
+	if fb.repairLastFile && !fb.verifyOnResume {
+		errs = append(errs, "--repair_last_file requires --verify_on_resume")
+	}
+
+	// Resuming needs to be able to pick out the template's files
+	if fb.filenameTemplate != nil && fb.resumeExisting {
+		if _, _, err := fb.templatePattern(); err != nil {
+			errs = append(errs, fmt.Sprintf("--filename_template can't be used with --resume_existing: %v", err))
+		}
+	}
+
+	if fb.autoDetectPcap {
+		if fb.blockFormat != nil {
+			errs = append(errs, "--auto_detect_pcap cannot be used with a block header format")
+		}
+		if fb.headerBytes != 0 {
+			errs = append(errs, "--auto_detect_pcap sets the header bytes itself, don't use --header_bytes")
+		}
+		if fb.readBufferSize < pcapGlobalHeaderBytes {
+			errs = append(errs, fmt.Sprintf("--auto_detect_pcap needs --read_buffer_size of at least %d", pcapGlobalHeaderBytes))
+		}
+	}
+
+	if fb.blockValidationStrict {
+		if fb.blockFormat == nil && fb.blockValidator == nil && !fb.autoDetectPcap {
+			errs = append(errs, "--block_validation_strict requires a block header format or --block_validator_plugin")
+		}
+		if fb.strictMissThreshold < 0 {
+			errs = append(errs, "--strict_miss_threshold cannot be negative")
+		}
+	}
+	if len(fb.blockFormatAlts) > 0 && fb.blockFormat == nil {
+		errs = append(errs, "--block_header_alt requires --block_header or --block_format_preset")
+	}
+	hasFCS := func(f HeaderField) bool { return f.Type == FieldFCS }
+	if fb.blockFormat != nil && slices.ContainsFunc(fb.blockFormat.Fields, hasFCS) {
+		errs = append(errs, "fcs fields are only allowed in --block_trailer_format")
+	}
+	if fb.blockTrailer != nil && (fb.blockFormat == nil || !fb.blockFormat.HasLength) {
+		errs = append(errs, "--block_trailer_format requires a block header format with a length field")
+	}
+	for _, format := range fb.blockFormatAlts {
+		if slices.ContainsFunc(format.Fields, hasFCS) {
+			errs = append(errs, fmt.Sprintf("%s: fcs fields are only allowed in --block_trailer_format", fb.formatName(format)))
+		}
+		if fb.blockTrailer != nil && !format.HasLength {
+			errs = append(errs, fmt.Sprintf("%s: --block_trailer_format requires a length field", fb.formatName(format)))
+		}
+	}
+
+	if fb.blockValidator != nil && (fb.blockFormat != nil || fb.autoDetectPcap || fb.splitOnNewline || fb.recordDelimiter != nil || fb.recordSize > 0) {
+		errs = append(errs, "--block_validator_plugin cannot be used with a block header format, --auto_detect_pcap, --split_on_newline, --record_delimiter or --record_size")
+	}
+	if fb.splitOnNewline && (fb.blockFormat != nil || fb.autoDetectPcap) {
+		errs = append(errs, "--split_on_newline cannot be used with a block header format or --auto_detect_pcap")
+	}
+	if fb.recordDelimiter != nil {
+		if fb.blockFormat != nil || fb.autoDetectPcap || fb.splitOnNewline || fb.recordSize > 0 {
+			errs = append(errs, "--record_delimiter cannot be used with a block header format, --auto_detect_pcap, --split_on_newline or --record_size")
+		}
+		if len(fb.recordDelimiter) > maxRecordDelimiterBytes {
+			errs = append(errs, fmt.Sprintf("--record_delimiter can be at most %d bytes", maxRecordDelimiterBytes))
+		}
+	}
+	if fb.recordSize < 0 {
+		errs = append(errs, "--record_size cannot be negative")
+	}
+	if fb.recordSize > 0 {
+		if fb.blockFormat != nil || fb.autoDetectPcap || fb.splitOnNewline {
+			errs = append(errs, "--record_size cannot be used with a block header format, --auto_detect_pcap or --split_on_newline")
+		}
+		if fb.readBufferSize < fb.recordSize {
+			errs = append(errs, "--read_buffer_size must be at least --record_size")
+		}
+	}
+	if fb.minLineLength < 0 {
+		errs = append(errs, "--min_line_length cannot be negative")
+	}
+	if fb.minLineLength > 0 && !fb.splitOnNewline {
+		errs = append(errs, "--min_line_length requires --split_on_newline")
+	}
+
+	// Output and mirror directories must already exist
+	for _, dir := range fb.outputDirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Sprintf("--output_dirs entry is not an existing directory: %s", dir))
+		}
+	}
+	if fb.dualOutput && fb.outputExtension == "" {
+		errs = append(errs, "--dual_output needs an --output_extension to tell the uncompressed files apart")
+	}
+	if fb.dualOutputPerms != 0 && !fb.dualOutput {
+		errs = append(errs, "--dual_output_perms requires --dual_output")
+	}
+	if fb.writeIndex {
+		if fb.indexFormat != "json" && fb.indexFormat != "binary" {
+			errs = append(errs, fmt.Sprintf("--index_format must be 'json' or 'binary', got: %s", fb.indexFormat))
+		}
+		if !fb.autoDetectPcap && (fb.blockFormat == nil || !fb.blockFormat.HasLength) {
+			errs = append(errs, "--write_index requires --auto_detect_pcap or a block header format with a length field")
+		}
+	}
+	if fb.mirrorDir != "" {
+		info, err := os.Stat(fb.mirrorDir)
+		if err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Sprintf("--mirror_dir is not an existing directory: %s", fb.mirrorDir))
+		}
+	}
+
+	if fb.archiveDir != "" {
+		info, err := os.Stat(fb.archiveDir)
+		if err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Sprintf("--archive_dir is not an existing directory: %s", fb.archiveDir))
+		}
+		for _, dir := range append([]string{filepath.Dir(fb.filePrefix)}, fb.outputDirs...) {
+			if filepath.Clean(dir) == filepath.Clean(fb.archiveDir) {
+				errs = append(errs, fmt.Sprintf("--archive_dir must be a different directory to the output files: %s", fb.archiveDir))
+				break
+			}
+		}
+	}
+
+	switch fb.writeErrorPolicy {
+	case "exit", "warn_continue", "rotate_on_error":
+	default:
+		errs = append(errs, fmt.Sprintf("--write_error_policy must be 'exit', 'warn_continue' or 'rotate_on_error', got: %s", fb.writeErrorPolicy))
+	}
+	if fb.s3Bucket != "" {
+		creds, err := s3CredentialsFromEnv()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("--s3_bucket: %v", err))
+		}
+		fb.s3Credentials = creds
+	} else if fb.s3KeyPrefix != "" || fb.s3Region != "" || fb.s3DeleteAfterUpload {
+		errs = append(errs, "--s3_key_prefix, --s3_region and --s3_delete_after_upload require --s3_bucket")
+	}
+	if fb.webhookURL != "" {
+		if u, err := url.Parse(fb.webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("--webhook_url must be an http or https URL, got: %s", fb.webhookURL))
+		}
+	} else if fb.webhookAuthHeader != "" {
+		errs = append(errs, "--webhook_auth_header requires --webhook_url")
+	}
+	if fb.webhookTimeout < 0 {
+		errs = append(errs, "--webhook_timeout cannot be negative")
+	}
+	if fb.protectRecent < 0 {
+		errs = append(errs, "--protect_recent_seconds cannot be negative")
+	}
+	if fb.preallocateBytes < 0 {
+		errs = append(errs, "--preallocate_bytes cannot be negative")
+	}
+	if fb.s3RetryCount < 0 {
+		errs = append(errs, "--s3_retry_count cannot be negative")
+	}
+	if fb.inputTCP != "" && fb.inputUnix != "" {
+		errs = append(errs, "--input_tcp and --input_unix cannot be used together")
+	}
+	if fb.inputUnix == "" && (fb.inputUnixMulti || fb.inputUnixPerms != 0) {
+		errs = append(errs, "--input_unix_multi and --input_unix_perms require --input_unix")
+	}
+	if fb.inputTCP == "" && (fb.inputTCPMulti || fb.inputTLSCert != "" || fb.inputTLSKey != "") {
+		errs = append(errs, "--input_tcp_multi, --input_tls_cert and --input_tls_key require --input_tcp")
+	}
+	if (fb.inputTLSCert == "") != (fb.inputTLSKey == "") {
+		errs = append(errs, "--input_tls_cert and --input_tls_key must be used together")
+	}
+	if fb.readTimeout < 0 {
+		errs = append(errs, "--read_timeout cannot be negative")
+	}
+	if fb.statsdAddr != "" {
+		if _, err := net.ResolveUDPAddr("udp", fb.statsdAddr); err != nil {
+			errs = append(errs, fmt.Sprintf("--statsd_addr: %v", err))
+		}
+	}
+	if fb.shutdownTimeout < 0 {
+		errs = append(errs, "--shutdown_timeout cannot be negative")
+	}
+	if fb.readTimeoutAction != "exit" && fb.readTimeoutAction != "rotate" {
+		errs = append(errs, fmt.Sprintf("--read_timeout_action must be 'exit' or 'rotate', got: %s", fb.readTimeoutAction))
+	}
+	if fb.writeRetryCount < 0 {
+		errs = append(errs, "--write_retry_count cannot be negative")
+	}
+	if fb.writeRetryInterval < 0 {
+		errs = append(errs, "--write_retry_interval cannot be negative")
+	}
+	if fb.maxConsecutiveErrors < 0 {
+		errs = append(errs, "--max_consecutive_errors cannot be negative")
+	}
+	if fb.maxTotalBytes < 0 {
+		errs = append(errs, "--max_total_bytes cannot be negative")
+	}
+	if fb.preDeleteHookTimeout < 0 {
+		errs = append(errs, "--pre_delete_hook_timeout cannot be negative")
+	}
+	if fb.maxFileAge < 0 {
+		errs = append(errs, "--max_file_age cannot be negative")
+	}
+	if strings.ContainsAny(fb.outputExtension, `/\`) {
+		errs = append(errs, "--output_extension cannot contain a path separator")
+	}
+	if strings.ContainsAny(fb.filenameSep, `/\:*?"<>|`) {
+		errs = append(errs, `--filename_sep cannot contain any of /\:*?"<>|`)
+	}
+	if fb.fileCounter < 0 || fb.fileCounter > maxFileCounter {
+		errs = append(errs, fmt.Sprintf("--counter_start must be between 0 and %d", maxFileCounter))
+	}
+	if fb.subdirFormat != "" {
+		clean := filepath.Clean(fb.subdirFormat)
+		if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			errs = append(errs, "--subdir_format must be a relative path below the output directory")
+		}
+	}
+	if fb.timeFormat == "" {
+		errs = append(errs, "--time_format cannot be empty")
+	}
+	if fb.headerBytes < 0 {
+		errs = append(errs, "--header_bytes cannot be negative")
+	}
+	if fb.sectionHeaderBytes < 0 {
+		errs = append(errs, "--section_header_bytes cannot be negative")
+	}
+	if fb.trailerFile != "" {
+		if len(fb.trailer) > 0 {
+			errs = append(errs, "--trailer_file cannot be used with --trailer_hex or --trailer_bytes")
+		}
+		if _, err := os.Stat(fb.trailerFile); err != nil {
+			errs = append(errs, fmt.Sprintf("--trailer_file: %v", err))
+		}
+	}
+	if fb.timestampHz == 0 {
+		errs = append(errs, "--timestamp_hz must be positive")
+	}
+	if fb.maxBlockSize <= 0 {
+		errs = append(errs, "--max_block_size must be positive")
+	}
+	if fb.readBufferSize <= 0 {
+		errs = append(errs, "--read_buffer_size must be positive")
+	}
+	if fb.maxInputRate < 0 {
+		errs = append(errs, "--max_input_rate_mbps cannot be negative")
+	}
+	if fb.maxOutputRate < 0 {
+		errs = append(errs, "--max_output_rate_mbps cannot be negative")
+	}
+	if fb.syncInterval < 0 {
+		errs = append(errs, "--gzip_sync_interval cannot be negative")
+	}
+	if fb.compressionLevel < -1 || fb.compressionLevel > 9 {
+		errs = append(errs, "--compression_level must be between -1 and 9")
+	}
+	if fb.gzipWindowBits < 9 || fb.gzipWindowBits > 15 {
+		errs = append(errs, fmt.Sprintf("--gzip_window_bits must be between 9 and 15, got %d", fb.gzipWindowBits))
+	}
+	if fb.gzipMemLevel < 1 || fb.gzipMemLevel > 9 {
+		errs = append(errs, fmt.Sprintf("--gzip_mem_level must be between 1 and 9, got %d", fb.gzipMemLevel))
+	}
+
+	// Max block must fit in a read buffer
+	if fb.maxBlockSize > fb.readBufferSize {
+		errs = append(errs, "--read_buffer_size must be at least as large as --max_block_size")
+	}
+
+	return errs
+}
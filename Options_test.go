@@ -0,0 +1,196 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateGzipWindow(t *testing.T) {
+	tests := []struct {
+		windowBits, memLevel int
+		wantErr              string
+	}{
+		{15, 8, ""},
+		{9, 8, ""},
+		{8, 8, "--gzip_window_bits must be between 9 and 15"},
+		{16, 8, "--gzip_window_bits must be between 9 and 15"},
+		{15, 1, ""},
+		{9, 9, ""},
+		{15, 0, "--gzip_mem_level must be between 1 and 9"},
+		{15, 10, "--gzip_mem_level must be between 1 and 9"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewFileBuffer(
+			WithPrefix(filepath.Join(t.TempDir(), "test")),
+			WithMaxFileSize(1024),
+			WithMaxNumFiles(1),
+			WithGzipWindow(tt.windowBits, tt.memLevel),
+		)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("window bits %d, mem level %d: unexpected error: %v", tt.windowBits, tt.memLevel, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("window bits %d, mem level %d: got error %v, want %q", tt.windowBits, tt.memLevel, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewFileBuffer_Validation(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantErrs []string
+	}{
+		{
+			name: "all required options",
+			opts: []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1)},
+		},
+		{
+			name:     "no options",
+			wantErrs: []string{"--file_size is required", "--num_files is required", "--file_prefix is required"},
+		},
+		{
+			name:     "no prefix",
+			opts:     []Option{WithMaxFileSize(1024), WithMaxNumFiles(1)},
+			wantErrs: []string{"--file_prefix is required"},
+		},
+		{
+			name:     "no file size",
+			opts:     []Option{WithPrefix(prefix), WithMaxNumFiles(1)},
+			wantErrs: []string{"--file_size is required"},
+		},
+		{
+			name:     "negative number of files",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(-1)},
+			wantErrs: []string{"--num_files is required and must be positive"},
+		},
+		{
+			name: "stdout needs no files",
+			opts: []Option{WithStdout(true)},
+		},
+		{
+			name:     "checksum with stdout",
+			opts:     []Option{WithStdout(true), WithEmbedChecksum(true)},
+			wantErrs: []string{"--embed_checksum cannot be used with --output stdout"},
+		},
+		{
+			name:     "path separator in output extension",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithOutputExtension(".gz/x")},
+			wantErrs: []string{"--output_extension cannot contain a path separator"},
+		},
+		{
+			name:     "illegal filename separator",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithFilenameSep(":")},
+			wantErrs: []string{`--filename_sep cannot contain any of /\:*?"<>|`},
+		},
+		{
+			name:     "path separator in filename separator",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithFilenameSep("_/")},
+			wantErrs: []string{`--filename_sep cannot contain any of /\:*?"<>|`},
+		},
+		{
+			name:     "negative counter start",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithCounterStart(-1)},
+			wantErrs: []string{"--counter_start must be between 0 and 999999"},
+		},
+		{
+			name:     "counter start too big",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithCounterStart(maxFileCounter + 1)},
+			wantErrs: []string{"--counter_start must be between 0 and 999999"},
+		},
+		{
+			name:     "subdirectories above the output directory",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithSubdirFormat("../2006")},
+			wantErrs: []string{"--subdir_format must be a relative path below the output directory"},
+		},
+		{
+			name:     "negative protection period",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithProtectRecent(-time.Second)},
+			wantErrs: []string{"--protect_recent_seconds cannot be negative"},
+		},
+		{
+			name:     "negative rate limits",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithMaxInputRate(-1), WithMaxOutputRate(-8)},
+			wantErrs: []string{"--max_input_rate_mbps cannot be negative", "--max_output_rate_mbps cannot be negative"},
+		},
+		{
+			name:     "dual output without an extension",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithDualOutput(true, 0), WithOutputExtension("")},
+			wantErrs: []string{"--dual_output needs an --output_extension"},
+		},
+		{
+			name:     "dual output permissions alone",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithDualOutput(false, 0640)},
+			wantErrs: []string{"--dual_output_perms requires --dual_output"},
+		},
+		{
+			name:     "bad index format",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithIndex(true, "csv"), WithAutoDetectPcap(true)},
+			wantErrs: []string{"--index_format must be 'json' or 'binary', got: csv"},
+		},
+		{
+			name:     "index without block lengths",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithIndex(true, "json")},
+			wantErrs: []string{"--write_index requires --auto_detect_pcap or a block header format with a length field"},
+		},
+		{
+			name:     "trailer file and bytes",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithTrailer([]byte{0}), WithTrailerFile(prefix + ".trailer")},
+			wantErrs: []string{"--trailer_file cannot be used with --trailer_hex or --trailer_bytes", "--trailer_file: "},
+		},
+		{
+			name:     "negative shutdown timeout",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithShutdownTimeout(-time.Second)},
+			wantErrs: []string{"--shutdown_timeout cannot be negative"},
+		},
+		{
+			name:     "bad StatsD address",
+			opts:     []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithStatsd("localhost", "gzfb")},
+			wantErrs: []string{"--statsd_addr: "},
+		},
+		{
+			name: "syslog with a log file and a bad facility",
+			opts: []Option{WithPrefix(prefix), WithMaxFileSize(1024), WithMaxNumFiles(1), WithLogFile("gzfb.log"), WithLogSyslog(true, "local8")},
+			wantErrs: []string{"--log_syslog cannot be used with --log_file",
+				"--log_syslog_facility must be one of daemon, local0, local1, local2, local3, local4, local5, local6, local7, got: local8"},
+		},
+		{
+			name:     "conflicting options as well",
+			opts:     []Option{WithVerbose(true), WithQuiet(true)},
+			wantErrs: []string{"--verbose cannot be used with --quiet", "--file_size is required", "--num_files is required", "--file_prefix is required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb, err := NewFileBuffer(tt.opts...)
+			if len(tt.wantErrs) == 0 {
+				if err != nil || fb == nil {
+					t.Fatalf("NewFileBuffer() = %v, %v, want a FileBuffer", fb, err)
+				}
+				return
+			}
+			if err == nil || fb != nil {
+				t.Fatalf("NewFileBuffer() = %v, %v, want an error", fb, err)
+			}
+			// Every problem is reported, one per line
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.wantErrs) {
+				t.Errorf("got %d errors, want %d:\n%v", len(lines), len(tt.wantErrs), err)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+const (
+	pcapGlobalHeaderBytes = 24
+	pcapMagicMicro        = 0xA1B2C3D4
+	pcapMagicNano         = 0xA1B23C4D
+)
+
+// pcapng block types and the section header's byte order magic
+const (
+	pcapngBlockSHB       = 0x0A0D0D0A // a palindrome, so the same in either byte order
+	pcapngBlockIDB       = 0x00000001
+	pcapngByteOrderMagic = 0x1A2B3C4D
+)
+
+// detectPcap checks the start of the stream for a pcap global header and, if
+// found, configures the header bytes and record header format to match
+func (fb *FileBuffer) detectPcap(data []byte) {
+	if len(data) < pcapGlobalHeaderBytes {
+		fmt.Fprintf(logOutput, "Warning: pcap auto-detect needs %d bytes, got %d, not detecting\n", pcapGlobalHeaderBytes, len(data))
+		return
+	}
+
+	if binary.LittleEndian.Uint32(data) == pcapngBlockSHB {
+		fb.detectPcapng(data)
+		return
+	}
+
+	// The magic is written in the capturing host's byte order
+	var endianness Endianness
+	var preset string
+	switch magic := binary.LittleEndian.Uint32(data); magic {
+	case pcapMagicMicro:
+		endianness, preset = LittleEndian, "pcap"
+	case pcapMagicNano:
+		endianness, preset = LittleEndian, "pcap_ns"
+	default:
+		switch binary.BigEndian.Uint32(data) {
+		case pcapMagicMicro:
+			endianness, preset = BigEndian, "pcap"
+		case pcapMagicNano:
+			endianness, preset = BigEndian, "pcap_ns"
+		default:
+			fmt.Fprintf(logOutput, "Warning: pcap auto-detect found no pcap magic (got 0x%08X), writing without block boundaries\n", magic)
+			return
+		}
+	}
+
+	p, _ := findPreset(builtinPresets, preset)
+	format, err := ParseBlockHeaderFormat(p.Format, endianness, CRC16CCITT)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Internal error: bad %s preset: %v\n", preset, err)
+		return
+	}
+	fb.blockFormat = format
+	fb.headerBytes = pcapGlobalHeaderBytes
+
+	if !fb.quiet {
+		resolution, order := "microsecond", "little-endian"
+		if preset == "pcap_ns" {
+			resolution = "nanosecond"
+		}
+		if endianness == BigEndian {
+			order = "big-endian"
+		}
+		fmt.Fprintf(logOutput, "Detected pcap stream (%s timestamps, %s), using %s block headers\n", resolution, order, preset)
+	}
+}
+
+// detectPcapng configures for a pcapng stream, using the section header and
+// interface description blocks at the start as the header for each file
+func (fb *FileBuffer) detectPcapng(data []byte) {
+	headerBytes, endianness, err := pcapngHeaderBytes(data)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: pcapng auto-detect failed, writing without block boundaries: %v\n", err)
+		return
+	}
+
+	// Enhanced packet blocks, or simple packet blocks as the alternate
+	preset, _ := findPreset(builtinPresets, "pcapng")
+	format, err := ParseBlockHeaderFormat(preset.Format, endianness, CRC16CCITT)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Internal error: bad pcapng preset: %v\n", err)
+		return
+	}
+	alts, err := parsePresetAlts(builtinPresets, preset, "", endianness, CRC16CCITT)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Internal error: bad pcapng preset: %v\n", err)
+		return
+	}
+	fb.blockFormat = format
+	fb.blockFormatAlts = alts
+	fb.headerBytes = headerBytes
+
+	if !fb.quiet {
+		order := "little-endian"
+		if endianness == BigEndian {
+			order = "big-endian"
+		}
+		fmt.Fprintf(logOutput, "Detected pcapng stream (%s), using the first %d bytes as the header and pcapng block headers\n", order, headerBytes)
+	}
+}
+
+// pcapngHeaderBytes returns the length of the section header block and the
+// interface description blocks following it at the start of data, and the
+// section's byte order
+func pcapngHeaderBytes(data []byte) (int, Endianness, error) {
+	if len(data) < 12 {
+		return 0, LittleEndian, fmt.Errorf("need at least 12 bytes, got %d", len(data))
+	}
+	var order binary.ByteOrder
+	var endianness Endianness
+	switch {
+	case binary.LittleEndian.Uint32(data[8:]) == pcapngByteOrderMagic:
+		order, endianness = binary.LittleEndian, LittleEndian
+	case binary.BigEndian.Uint32(data[8:]) == pcapngByteOrderMagic:
+		order, endianness = binary.BigEndian, BigEndian
+	default:
+		return 0, LittleEndian, fmt.Errorf("bad section header byte order magic 0x%08X", binary.LittleEndian.Uint32(data[8:]))
+	}
+
+	offset := 0
+	for offset+8 <= len(data) {
+		blockType := order.Uint32(data[offset:])
+		if offset > 0 && blockType != pcapngBlockIDB {
+			return offset, endianness, nil
+		}
+		length := int(order.Uint32(data[offset+4:]))
+		if length < 12 || length%4 != 0 {
+			return 0, endianness, fmt.Errorf("bad block length %d at offset %d", length, offset)
+		}
+		offset += length
+	}
+	return 0, endianness, fmt.Errorf("section header and interface descriptions don't fit in the first %d bytes", len(data))
+}
+
+// checkPcapHeader stops the captured header being copied to each file when
+// the stream is pcap but doesn't start with a pcap global header, e.g. a
+// capture joined part way through. The header is only ever captured from
+// stream offset 0, so those bytes are packet data rather than the link type
+// and snaplen the files need.
+func (fb *FileBuffer) checkPcapHeader() {
+	if fb.headerBytes != pcapGlobalHeaderBytes || !(fb.autoDetectPcap || isPcapRecordFormat(fb.blockFormat)) {
+		return
+	}
+	if isPcapMagic(fb.header) {
+		return
+	}
+	fmt.Fprintf(logOutput, "Error: the first %d bytes of the stream aren't a pcap global header (% X...), not copying them to each file\n",
+		pcapGlobalHeaderBytes, fb.header[:4])
+	fb.headerBytes = 0
+	fb.header = nil
+	fb.headerCaptured = false
+}
+
+// isPcapRecordFormat reports whether format is the pcap or pcap_ns preset's
+// record header, in either byte order
+func isPcapRecordFormat(format *BlockHeaderFormat) bool {
+	if format == nil {
+		return false
+	}
+	for _, name := range []string{"pcap", "pcap_ns"} {
+		p, _ := findPreset(builtinPresets, name)
+		preset, err := ParseBlockHeaderFormat(p.Format, format.Endianness, CRC16CCITT)
+		if err == nil && slices.EqualFunc(format.Fields, preset.Fields, func(a, b HeaderField) bool { return a.String() == b.String() }) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPcapMagic reports whether data starts with a pcap magic number in either byte order
+func isPcapMagic(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	for _, magic := range []uint32{binary.LittleEndian.Uint32(data), binary.BigEndian.Uint32(data)} {
+		if magic == pcapMagicMicro || magic == pcapMagicNano {
+			return true
+		}
+	}
+	return false
+}
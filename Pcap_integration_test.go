@@ -0,0 +1,76 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// A pcap stream a little over two files long is split into files that
+// each start with the global header and hold whole packet records
+func TestPcapRotation(t *testing.T) {
+	const maxFileSize = 32 * 1024
+	var records []byte
+	packets := 0
+	for len(records) <= 2*maxFileSize+maxFileSize/4 {
+		records = append(records, pcapRecords(1, 500, int64(packets))...)
+		packets++
+	}
+	globalHeader := pcapGlobalHeader()
+	stream := append(append([]byte(nil), globalHeader...), records...)
+
+	fb := newTestFileBuffer(t,
+		WithHeaderBytes(pcapGlobalHeaderBytes),
+		WithBlockFormat(presetFormat(t, "pcap")),
+		WithReadBufferSize(8192),
+		WithMaxBlockSize(8192),
+		WithMaxFileSize(maxFileSize),
+		WithRotateOnUncompressed(true),
+		WithMaxNumFiles(100),
+	)
+	if err := fb.WriteFrom(bytes.NewReader(stream)); err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+
+	files := readGzipFiles(t, fb.activeFiles)
+	if len(files) < 3 {
+		t.Fatalf("got %d files, want at least 3", len(files))
+	}
+	count := 0
+	var written []byte
+	for i, file := range files {
+		if len(file) < pcapGlobalHeaderBytes || !isPcapMagic(file) {
+			t.Fatalf("file %d doesn't start with a pcap global header", i)
+		}
+		if !bytes.Equal(file[:pcapGlobalHeaderBytes], globalHeader) {
+			t.Fatalf("file %d starts with global header %x, want %x", i, file[:pcapGlobalHeaderBytes], globalHeader)
+		}
+		snaplen := binary.LittleEndian.Uint32(file[16:])
+		for offset := pcapGlobalHeaderBytes; offset < len(file); {
+			if offset+16 > len(file) {
+				t.Fatalf("file %d ends part way through a record header", i)
+			}
+			length := binary.LittleEndian.Uint32(file[offset+8:])
+			if length > snaplen {
+				t.Fatalf("file %d has a %d byte record at offset %d, over the %d snaplen", i, length, offset, snaplen)
+			}
+			end := offset + 16 + int(length)
+			if end > len(file) {
+				t.Fatalf("file %d ends part way through a record", i)
+			}
+			written = append(written, file[offset:end]...)
+			offset = end
+			count++
+		}
+	}
+	if count != packets {
+		t.Errorf("got %d packets, want %d", count, packets)
+	}
+	if !bytes.Equal(written, records) {
+		t.Errorf("the records in the files don't match the ones written")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// pcapGlobalHeader is a little-endian microsecond pcap global header for
+// Ethernet with a 64KB snaplen
+func pcapGlobalHeader() []byte {
+	header := make([]byte, pcapGlobalHeaderBytes)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagicMicro)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	return header
+}
+
+// pcapRecords makes n little-endian pcap packet records timestamped from
+// now, with random payloads of up to maxPayload bytes
+func pcapRecords(n, maxPayload int, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	now := time.Now()
+	var buf bytes.Buffer
+	for i := range n {
+		payload := make([]byte, 1+rng.Intn(maxPayload))
+		rng.Read(payload)
+		ts := now.Add(time.Duration(i) * time.Millisecond)
+		var header [16]byte
+		binary.LittleEndian.PutUint32(header[0:], uint32(ts.Unix()))
+		binary.LittleEndian.PutUint32(header[4:], uint32(ts.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(payload)))
+		binary.LittleEndian.PutUint32(header[12:], uint32(len(payload)))
+		buf.Write(header[:])
+		buf.Write(payload)
+	}
+	return buf.Bytes()
+}
+
+// presetFormat parses a built-in preset in little-endian byte order
+func presetFormat(t testing.TB, name string) *BlockHeaderFormat {
+	t.Helper()
+	preset, ok := findPreset(builtinPresets, name)
+	if !ok {
+		t.Fatalf("no %s preset", name)
+	}
+	format, err := ParseBlockHeaderFormat(preset.Format, LittleEndian, CRC16CCITT)
+	if err != nil {
+		t.Fatalf("parsing %s preset: %v", name, err)
+	}
+	return format
+}
+
+func TestCheckPcapHeader(t *testing.T) {
+	records := pcapRecords(50, 200, 1)
+	tests := []struct {
+		name       string
+		stream     []byte
+		opts       []Option
+		wantHeader bool
+	}{
+		{
+			name:       "global header at the start",
+			stream:     append(pcapGlobalHeader(), records...),
+			opts:       []Option{WithHeaderBytes(pcapGlobalHeaderBytes), WithBlockFormat(presetFormat(t, "pcap"))},
+			wantHeader: true,
+		},
+		{
+			name:       "joined mid-capture",
+			stream:     records,
+			opts:       []Option{WithHeaderBytes(pcapGlobalHeaderBytes), WithBlockFormat(presetFormat(t, "pcap"))},
+			wantHeader: false,
+		},
+		{
+			name:       "auto-detect without a global header",
+			stream:     records,
+			opts:       []Option{WithAutoDetectPcap(true)},
+			wantHeader: false,
+		},
+		{
+			name:       "24 byte header that isn't pcap",
+			stream:     records,
+			opts:       []Option{WithHeaderBytes(pcapGlobalHeaderBytes)},
+			wantHeader: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := newTestFileBuffer(t, tt.opts...)
+			if err := fb.WriteFrom(bytes.NewReader(tt.stream)); err != nil {
+				t.Fatalf("WriteFrom: %v", err)
+			}
+			if fb.headerCaptured != tt.wantHeader {
+				t.Errorf("headerCaptured = %v, want %v", fb.headerCaptured, tt.wantHeader)
+			}
+			if !tt.wantHeader && fb.headerBytes != 0 {
+				t.Errorf("headerBytes = %d, want 0", fb.headerBytes)
+			}
+		})
+	}
+}
+
+// pcapngStream makes a little-endian pcapng stream: a section header block
+// and an interface description block, both without options (48 bytes), then
+// n packets alternating between enhanced and simple packet blocks
+func pcapngStream(n int, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	le := binary.LittleEndian
+	var buf bytes.Buffer
+	block := func(blockType uint32, body []byte) {
+		total := uint32(12 + len(body))
+		buf.Write(le.AppendUint32(le.AppendUint32(nil, blockType), total))
+		buf.Write(body)
+		buf.Write(le.AppendUint32(nil, total))
+	}
+
+	shb := le.AppendUint32(nil, pcapngByteOrderMagic)
+	shb = le.AppendUint16(shb, 1)
+	shb = le.AppendUint16(shb, 0)
+	shb = le.AppendUint64(shb, ^uint64(0)) // section length unknown
+	block(pcapngBlockSHB, shb)
+	idb := le.AppendUint16(nil, 1) // Ethernet
+	idb = le.AppendUint16(idb, 0)
+	idb = le.AppendUint32(idb, 65535)
+	block(pcapngBlockIDB, idb)
+
+	now := uint64(time.Now().UnixMicro())
+	for i := range n {
+		payload := make([]byte, 1+rng.Intn(300))
+		rng.Read(payload)
+		padded := append(payload, make([]byte, -len(payload)&3)...)
+		if i%2 == 0 {
+			ts := now + uint64(i)
+			epb := le.AppendUint32(nil, 0)
+			epb = le.AppendUint32(epb, uint32(ts>>32))
+			epb = le.AppendUint32(epb, uint32(ts))
+			epb = le.AppendUint32(epb, uint32(len(payload)))
+			epb = le.AppendUint32(epb, uint32(len(payload)))
+			block(6, append(epb, padded...))
+		} else {
+			block(3, append(le.AppendUint32(nil, uint32(len(payload))), padded...))
+		}
+	}
+	return buf.Bytes()
+}
+
+// Files split at enhanced and simple packet blocks alike with the pcapng
+// preset, and with auto-detect
+func TestPcapngRotation(t *testing.T) {
+	const packets = 2000
+	stream := pcapngStream(packets, 1)
+	const headerBytes = 48
+
+	preset, _ := findPreset(builtinPresets, "pcapng")
+	alts, err := parsePresetAlts(builtinPresets, preset, "", LittleEndian, CRC16CCITT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"preset", []Option{WithHeaderBytes(headerBytes), WithBlockFormat(presetFormat(t, "pcapng")), WithBlockFormatAlts(alts...)}},
+		{"auto-detect", []Option{WithAutoDetectPcap(true)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{
+				WithReadBufferSize(8192),
+				WithMaxBlockSize(8192),
+				WithMaxFileSize(16 * 1024),
+				WithMaxNumFiles(100),
+			}, tt.opts...)
+			fb := newTestFileBuffer(t, opts...)
+			if err := fb.WriteFrom(bytes.NewReader(stream)); err != nil {
+				t.Fatalf("WriteFrom: %v", err)
+			}
+
+			files := readGzipFiles(t, fb.activeFiles)
+			if len(files) < 3 {
+				t.Fatalf("got %d files, want at least 3", len(files))
+			}
+			count, spbStarts := 0, 0
+			for i, file := range files {
+				if len(file) > headerBytes && binary.LittleEndian.Uint32(file[headerBytes:]) == 3 {
+					spbStarts++
+				}
+				if !bytes.HasPrefix(file, stream[:headerBytes]) {
+					t.Fatalf("file %d doesn't start with the section header and interface description", i)
+				}
+				// Each file holds whole blocks
+				for offset := headerBytes; offset < len(file); {
+					if offset+8 > len(file) {
+						t.Fatalf("file %d ends part way through a block header", i)
+					}
+					blockType := binary.LittleEndian.Uint32(file[offset:])
+					total := int(binary.LittleEndian.Uint32(file[offset+4:]))
+					if blockType != 6 && blockType != 3 {
+						t.Fatalf("file %d has block type %d at offset %d, want an EPB or SPB", i, blockType, offset)
+					}
+					if offset+total > len(file) {
+						t.Fatalf("file %d ends part way through a block", i)
+					}
+					offset += total
+					count++
+				}
+			}
+			if count != packets {
+				t.Errorf("got %d packets, want %d", count, packets)
+			}
+			if spbStarts == 0 {
+				t.Errorf("no file starts with a simple packet block")
+			}
+		})
+	}
+}
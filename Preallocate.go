@@ -0,0 +1,42 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// preallocateFile reserves --preallocate_bytes for a new file. If that's not
+// supported here, preallocation is turned off rather than warning every file.
+func (fb *FileBuffer) preallocateFile(f *os.File) {
+	fb.preallocated = false
+	if fb.preallocateBytes <= 0 {
+		return
+	}
+	if err := preallocate(f, fb.preallocateBytes); err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to preallocate %d bytes for %s, continuing without preallocation: %v\n", fb.preallocateBytes, f.Name(), err)
+		fb.preallocateBytes = 0
+		return
+	}
+	fb.preallocated = true
+}
+
+// trimPreallocation truncates the current file to what's been written,
+// releasing any preallocated space past the end
+func (fb *FileBuffer) trimPreallocation() {
+	if !fb.preallocated || fb.currentFile == nil {
+		return
+	}
+	fb.preallocated = false
+	size, err := fb.currentFile.Seek(0, io.SeekCurrent)
+	if err == nil {
+		err = fb.currentFile.Truncate(size)
+	}
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to release preallocated space of %s: %v\n", fb.currentFile.Name(), err)
+	}
+}
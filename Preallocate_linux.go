@@ -0,0 +1,21 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build linux
+
+package gzipfilebuffer
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocFlKeepSize (FALLOC_FL_KEEP_SIZE) allocates blocks without changing the file size, so
+// size based rotation still sees only what's been written
+const fallocFlKeepSize = 0x01
+
+// preallocate reserves n bytes of disk space for f
+func preallocate(f *os.File, n int64) error {
+	return syscall.Fallocate(int(f.Fd()), fallocFlKeepSize, 0, n)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build linux
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// allocated is how much disk space path has
+func allocated(t *testing.T, path string) int64 {
+	t.Helper()
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		t.Fatal(err)
+	}
+	return st.Blocks * 512
+}
+
+// TestPreallocate checks --preallocate_bytes reserves the space without
+// growing the file, and closing it gives back what wasn't used
+func TestPreallocate(t *testing.T) {
+	const reserve = 4 << 20
+	fb := newTestFileBuffer(t, WithPreallocateBytes(reserve), WithMaxFileSize(8<<20))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	if !fb.preallocated {
+		t.Skip("fallocate isn't supported here")
+	}
+	if n := allocated(t, fb.currentFileName); n < reserve {
+		t.Errorf("%d bytes allocated, want at least %d", n, reserve)
+	}
+
+	data := syntheticLog(256 * 1024)
+	fb.write(data)
+	fb.flush()
+	info, err := os.Stat(fb.currentFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != fb.Stats().CurrentFileBytes {
+		t.Errorf("size while open %d, want the %d bytes written", info.Size(), fb.Stats().CurrentFileBytes)
+	}
+	fb.close()
+
+	if info, err = os.Stat(fb.currentFileName); err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != fb.Stats().CurrentFileBytes {
+		t.Errorf("size %d, want the %d bytes written", info.Size(), fb.Stats().CurrentFileBytes)
+	}
+	if n := allocated(t, fb.currentFileName); n >= reserve {
+		t.Errorf("%d bytes still allocated after closing a %d byte file", n, info.Size())
+	}
+	if got := readGzipFiles(t, []string{fb.currentFileName}); !bytes.Equal(got[0], data) {
+		t.Errorf("file decompresses to %d bytes, want %d", len(got[0]), len(data))
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build !linux
+
+package gzipfilebuffer
+
+import (
+	"errors"
+	"os"
+)
+
+// preallocate is only implemented on Linux
+func preallocate(f *os.File, n int64) error {
+	return errors.ErrUnsupported
+}
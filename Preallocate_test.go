@@ -0,0 +1,37 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// When preallocating fails, here on a file that isn't open for writing, a
+// warning is logged once and preallocation is turned off
+func TestPreallocateFailure(t *testing.T) {
+	logged := captureLog(t)
+	path := filepath.Join(t.TempDir(), "file.gz")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fb := newTestFileBuffer(t, WithPreallocateBytes(1<<20))
+	fb.preallocateFile(f)
+	fb.preallocateFile(f)
+	if fb.preallocated || fb.preallocateBytes != 0 {
+		t.Errorf("preallocated %v, preallocateBytes %d, want preallocation off", fb.preallocated, fb.preallocateBytes)
+	}
+	if n := strings.Count(logged(), "Warning: failed to preallocate 1048576 bytes for "+path); n != 1 {
+		t.Errorf("logged %d warnings, want 1:\n%s", n, logged())
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// blockFormatPreset is a named block header format string
+type blockFormatPreset struct {
+	Name   string
+	Format string
+	Alts   []string // Other presets also matched, as if given with --block_header_alt
+}
+
+// builtinPresets are always available to --block_format_preset
+var builtinPresets = []blockFormatPreset{
+	{"pcap", "<u32:sec><u32:usec><u32:length><u32>", nil},
+	{"pcap_ns", "<u32:sec><u32:nsec><u32:length><u32>", nil},
+	// pcapng enhanced packet block: type, total length, interface ID,
+	// timestamp high and low, captured and original length. Packets can
+	// be in simple packet blocks too.
+	{"pcapng", "<u32:0x00000006><u32:length><u32:0-65535><u32><u32><u32><u32>", []string{"pcapng_spb"}},
+	// pcapng simple packet block: type, total length, original length
+	{"pcapng_spb", "<u32:0x00000003><u32:length><u32>", nil},
+}
+
+// loadPresets returns the built-in presets plus any from path (name = "format"
+// lines). Every format is parsed up front so mistakes are reported at startup.
+func loadPresets(path string, endianness Endianness) ([]blockFormatPreset, error) {
+	presets := append([]blockFormatPreset(nil), builtinPresets...)
+	seen := make(map[string]bool)
+	for _, p := range presets {
+		seen[p.Name] = true
+	}
+
+	if path != "" {
+		var filePresets []blockFormatPreset
+		err := readKeyValueFile(path, func(name, format string) error {
+			if seen[name] {
+				return fmt.Errorf("duplicate preset name: %s", name)
+			}
+			seen[name] = true
+			if _, err := ParseBlockHeaderFormat(format, endianness, CRC16CCITT); err != nil {
+				return fmt.Errorf("preset %s: %w", name, err)
+			}
+			filePresets = append(filePresets, blockFormatPreset{name, format, nil})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(filePresets, func(i, j int) bool { return filePresets[i].Name < filePresets[j].Name })
+		presets = append(presets, filePresets...)
+	}
+
+	return presets, nil
+}
+
+func findPreset(presets []blockFormatPreset, name string) (blockFormatPreset, bool) {
+	for _, p := range presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return blockFormatPreset{}, false
+}
+
+// parsePresetAlts parses the formats of preset's alternate presets, each
+// following the base fields and named after its preset for logging
+func parsePresetAlts(presets []blockFormatPreset, preset blockFormatPreset, base string, endianness Endianness, crc16 CRC16Variant) ([]*BlockHeaderFormat, error) {
+	var formats []*BlockHeaderFormat
+	for _, name := range preset.Alts {
+		alt, ok := findPreset(presets, name)
+		if !ok {
+			return nil, fmt.Errorf("preset %s: unknown alternate preset %s", preset.Name, name)
+		}
+		format, err := ParseBlockHeaderFormat(base+alt.Format, endianness, crc16)
+		if err != nil {
+			return nil, fmt.Errorf("preset %s: %w", name, err)
+		}
+		format.Name = "--block_format_preset " + name
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// listPresets prints each preset with its format and header size
+func listPresets(w io.Writer, presets []blockFormatPreset, endianness Endianness) {
+	for _, p := range presets {
+		totalBytes := 0
+		if format, err := ParseBlockHeaderFormat(p.Format, endianness, CRC16CCITT); err == nil {
+			totalBytes = format.TotalBytes
+		}
+		fmt.Fprintf(w, "%-20s %3d bytes  %s", p.Name, totalBytes, p.Format)
+		if len(p.Alts) > 0 {
+			fmt.Fprintf(w, " (or %s)", strings.Join(p.Alts, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+}
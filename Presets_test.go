@@ -0,0 +1,112 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePresetFile writes contents to a --block_format_file in a temporary
+// directory and returns its path
+func writePresetFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "presets.toml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadPresets loads several named formats from a file, after the
+// built-in ones, and lists them all with their sizes
+func TestLoadPresets(t *testing.T) {
+	path := writePresetFile(t, `# Capture card formats
+[presets]
+sync_frame = "<u8:0x7E><u32:sec><u16:length>"
+radar = '<u16:0xAA55><u16:length>'
+`)
+	presets, err := loadPresets(path, LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(presets) != len(builtinPresets)+2 {
+		t.Fatalf("loaded %d presets, want the %d built-in and 2 from the file", len(presets), len(builtinPresets))
+	}
+	for name, want := range map[string]string{
+		"pcap":       builtinPresets[0].Format,
+		"sync_frame": "<u8:0x7E><u32:sec><u16:length>",
+		"radar":      "<u16:0xAA55><u16:length>",
+	} {
+		if p, ok := findPreset(presets, name); !ok || p.Format != want {
+			t.Errorf("preset %s = %q, %v, want %q", name, p.Format, ok, want)
+		}
+	}
+
+	var out bytes.Buffer
+	listPresets(&out, presets, LittleEndian)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(presets) {
+		t.Fatalf("listed %d presets, want %d:\n%s", len(lines), len(presets), out.String())
+	}
+	// The file's presets are listed by name after the built-in ones
+	for i, want := range []string{
+		"radar                  4 bytes  <u16:0xAA55><u16:length>",
+		"sync_frame             7 bytes  <u8:0x7E><u32:sec><u16:length>",
+	} {
+		if got := lines[len(builtinPresets)+i]; got != want {
+			t.Errorf("listed %q, want %q", got, want)
+		}
+	}
+	if want := "pcapng                28 bytes  <u32:0x00000006>"; !strings.HasPrefix(lines[2], want) || !strings.HasSuffix(lines[2], "(or pcapng_spb)") {
+		t.Errorf("listed %q, want %q... (or pcapng_spb)", lines[2], want)
+	}
+}
+
+// Every format in the file is checked when it's loaded, not just the one
+// used
+func TestLoadPresetsErrors(t *testing.T) {
+	tests := []struct {
+		name, contents, wantErr string
+	}{
+		{"bad format", "good = \"<u32:length>\"\nbad = \"<u32:length><u12>\"\n", ":2: preset bad: "},
+		{"duplicate", "mine = \"<u32:length>\"\nmine = \"<u16:length>\"\n", ":2: duplicate preset name: mine"},
+		{"built-in name", "pcap = \"<u32:length>\"\n", ":1: duplicate preset name: pcap"},
+		{"no format", "mine\n", ":1: expected key = value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writePresetFile(t, tt.contents)
+			_, err := loadPresets(path, LittleEndian)
+			if want := path + tt.wantErr; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("got error %v, want %q", err, want)
+			}
+		})
+	}
+}
+
+// TestBlockFormatPresetFile uses a preset from --block_format_file on the
+// command line
+func TestBlockFormatPresetFile(t *testing.T) {
+	path := writePresetFile(t, "sync_frame = \"<u8:0x7E><u32:sec><u16:length>\"\n")
+	required := []string{"--file_prefix", filepath.Join(t.TempDir(), "test"), "--file_size", "64", "--num_files", "2"}
+
+	fb, err := parseArgs(t, append(required, "--block_format_file", path, "--block_format_preset", "sync_frame")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.blockFormat == nil || fb.blockFormat.TotalBytes != 7 || len(fb.blockFormat.Fields) != 3 {
+		t.Errorf("block format = %+v, want the 7 byte sync_frame preset", fb.blockFormat)
+	}
+
+	_, err = parseArgs(t, append(required, "--block_format_preset", "sync_frame")...)
+	if want := "unknown --block_format_preset: sync_frame"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("without the file got error %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"io"
+	"time"
+)
+
+// rateLimiter is a token bucket. Tokens are bytes, refilled continuously at
+// the rate up to a tenth of a second's worth. A wait for more tokens than
+// there are goes into debt and sleeps until it's paid off, so big writes are
+// smoothed out the same as small ones.
+type rateLimiter struct {
+	bytesPerSec float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+}
+
+// newRateLimiter limits to mbps megabits (10^6 bits) per second, or returns
+// nil for no limit if mbps isn't positive
+func newRateLimiter(mbps float64) *rateLimiter {
+	if mbps <= 0 {
+		return nil
+	}
+	bytesPerSec := mbps * 1e6 / 8
+	return &rateLimiter{
+		bytesPerSec: bytesPerSec,
+		burst:       bytesPerSec / 10,
+		tokens:      bytesPerSec / 10,
+		last:        time.Now(),
+	}
+}
+
+// wait takes n bytes worth of tokens, sleeping if there aren't enough
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.bytesPerSec)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.bytesPerSec * float64(time.Second)))
+	}
+}
+
+// rateLimitedWriter waits on a rateLimiter before each write
+type rateLimitedWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (rw *rateLimitedWriter) Write(p []byte) (int, error) {
+	rw.limiter.wait(len(p))
+	return rw.w.Write(p)
+}
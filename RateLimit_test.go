@@ -0,0 +1,46 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter writes 300KB at 8 Mbps, 1MB a second, which should take
+// 0.2s after the first 100KB burst
+func TestRateLimiter(t *testing.T) {
+	for _, mbps := range []float64{0, -1} {
+		if l := newRateLimiter(mbps); l != nil {
+			t.Errorf("newRateLimiter(%v) = %+v, want nil for no limit", mbps, l)
+		}
+	}
+
+	var out bytes.Buffer
+	rw := &rateLimitedWriter{&out, newRateLimiter(8)}
+	data := make([]byte, 300*1000)
+	start := time.Now()
+	for i := 0; i < len(data); i += 16 * 1000 {
+		if _, err := rw.Write(data[i:min(i+16*1000, len(data))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("took %v, want about 200ms", elapsed)
+	}
+	if out.Len() != len(data) {
+		t.Errorf("wrote %d bytes, want %d", out.Len(), len(data))
+	}
+
+	// One write much bigger than the burst is paced the same
+	rw = &rateLimitedWriter{io.Discard, newRateLimiter(8)}
+	start = time.Now()
+	rw.Write(data)
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("one write took %v, want about 200ms", elapsed)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// deleteExpiredFiles removes active files last modified more than
+// maxFileAge ago, on top of the count based limit
+func (fb *FileBuffer) deleteExpiredFiles() {
+	if fb.maxFileAge <= 0 || len(fb.activeFiles) == 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-fb.maxFileAge)
+	var expired, kept []string
+	for _, path := range fb.activeFiles {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().Before(cutoff) {
+			expired = append(expired, path)
+		} else {
+			kept = append(kept, path)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	if len(kept) == 0 {
+		fmt.Fprintf(logOutput, "Warning: all %d existing file(s) are older than --max_file_age %v, deleting them all\n", len(expired), fb.maxFileAge)
+	}
+	for _, path := range expired {
+		if !fb.removeFile(path, "expired") {
+			kept = append(kept, path)
+		}
+	}
+	fb.activeFiles = kept
+}
+
+// enforceTotalBytes sums the size of the active files and deletes the oldest
+// until the total is within maxTotalBytes. The newest file is never deleted.
+func (fb *FileBuffer) enforceTotalBytes() {
+	total := fb.activeFilesSize()
+	for fb.maxTotalBytes > 0 && total > fb.maxTotalBytes && len(fb.activeFiles) > 1 {
+		if !fb.removeFile(fb.activeFiles[0], "over quota") {
+			break
+		}
+		fb.activeFiles = fb.activeFiles[1:]
+		total = fb.activeFilesSize()
+	}
+	if fb.maxTotalBytes > 0 && total > fb.maxTotalBytes {
+		fmt.Fprintf(logOutput, "Warning: file %s alone (%d bytes) exceeds --max_total_bytes %d\n", fb.activeFiles[len(fb.activeFiles)-1], total, fb.maxTotalBytes)
+	}
+	fb.counters.diskUsage.Store(total)
+}
+
+func (fb *FileBuffer) activeFilesSize() int64 {
+	var total int64
+	for _, path := range fb.activeFiles {
+		if info, err := os.Stat(path); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// recentlyModified reports whether path was modified within
+// --protect_recent_seconds, and how long ago
+func (fb *FileBuffer) recentlyModified(path string) (time.Duration, bool) {
+	if fb.protectRecent <= 0 {
+		return 0, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	age := time.Since(info.ModTime())
+	return age, age < fb.protectRecent
+}
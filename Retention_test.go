@@ -0,0 +1,198 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMaxFileAge backdates the oldest files past --max_file_age and checks
+// the next rotation deletes them, firing OnDelete, and keeps the newer ones
+func TestMaxFileAge(t *testing.T) {
+	var deleted []string
+	fb := newTestFileBuffer(t, WithMaxFileAge(time.Hour), WithDeleteCallback(func(path string) {
+		deleted = append(deleted, path)
+	}))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		fb.write([]byte("some data\n"))
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := slices.Clone(fb.activeFiles)
+
+	old := time.Now().Add(-time.Hour - time.Minute)
+	for _, path := range files[:2] {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// files[2] is the current file, closed by this rotation, so it's new
+	if _, err := fb.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fb.close()
+
+	if !slices.Equal(deleted, files[:2]) {
+		t.Errorf("OnDelete called for %q, want %q", deleted, files[:2])
+	}
+	if want := append(slices.Clone(files[2:]), fb.currentFileName); !slices.Equal(fb.activeFiles, want) {
+		t.Errorf("activeFiles = %q, want %q", fb.activeFiles, want)
+	}
+	for i, path := range files {
+		if _, err := os.Stat(path); (err == nil) != (i >= 2) {
+			t.Errorf("%s exists = %v, want %v", path, err == nil, i >= 2)
+		}
+	}
+}
+
+// When every existing file is too old, as on resuming after a long stop,
+// they're all deleted
+func TestMaxFileAgeAll(t *testing.T) {
+	discardLog(t)
+	prefix := filepath.Join(t.TempDir(), "test")
+	fb := newTestFileBuffer(t, WithPrefix(prefix))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fb.close()
+	old := time.Now().Add(-time.Hour)
+	for _, path := range fb.activeFiles {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumed := newTestFileBuffer(t, WithPrefix(prefix), WithMaxFileAge(time.Minute))
+	resumed.loadExistingFiles()
+	if err := resumed.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	resumed.close()
+	if !slices.Equal(resumed.activeFiles, []string{resumed.currentFileName}) {
+		t.Errorf("activeFiles = %q, want only the new file %s", resumed.activeFiles, resumed.currentFileName)
+	}
+	for _, path := range fb.activeFiles {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("expired %s wasn't deleted", path)
+		}
+	}
+}
+
+// TestMaxTotalBytes writes files of about 10KB each, stored without
+// compression, and checks the oldest are deleted to keep the total within
+// --max_total_bytes, but never the newest
+func TestMaxTotalBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxTotal int64
+		files    int
+		wantKept int // the newest files
+	}{
+		{"within the limit", 64 * 1024, 4, 4},
+		{"over the limit", 25 * 1024, 4, 2},
+		{"one file over the limit", 5 * 1024, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discardLog(t)
+			var deleted []string
+			fb := newTestFileBuffer(t, WithCompressionLevel(0), WithMaxTotalBytes(tt.maxTotal),
+				WithDeleteCallback(func(path string) { deleted = append(deleted, path) }))
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for i := range tt.files {
+				if i > 0 {
+					if _, err := fb.Rotate(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				files = append(files, fb.currentFileName)
+				fb.write(syntheticLog(10 * 1024))
+			}
+			fb.close()
+
+			wantDeleted := files[:tt.files-tt.wantKept]
+			if !slices.Equal(fb.activeFiles, files[len(wantDeleted):]) || !slices.Equal(deleted, wantDeleted) {
+				t.Errorf("kept %q, deleted %q, want %q and %q", fb.activeFiles, deleted, files[len(wantDeleted):], wantDeleted)
+			}
+			var total int64
+			for _, path := range fb.activeFiles {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				total += info.Size()
+			}
+			if usage := fb.Stats().DiskUsage; usage != total {
+				t.Errorf("DiskUsage = %d, want %d", usage, total)
+			}
+		})
+	}
+}
+
+// TestProtectRecent stands in for an upload of each closed file that takes
+// a while, and checks the oldest file is kept past --num_files until it's
+// older than --protect_recent_seconds
+func TestProtectRecent(t *testing.T) {
+	log := captureLog(t)
+	fb := newTestFileBuffer(t, WithMaxNumFiles(1), WithProtectRecent(200*time.Millisecond))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	fb.write([]byte("still uploading\n"))
+	first, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fb.activeFiles, []string{first, fb.currentFileName}) {
+		t.Errorf("activeFiles = %q, want the protected %s kept past --num_files", fb.activeFiles, first)
+	}
+	if want := "Error: not deleting " + first; !strings.Contains(log(), want) {
+		t.Errorf("log doesn't contain %q:\n%s", want, log())
+	}
+
+	// The upload has finished
+	time.Sleep(250 * time.Millisecond)
+	second, err := fb.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.close()
+	// The file just closed is protected in turn
+	if !slices.Equal(fb.activeFiles, []string{second, fb.currentFileName}) {
+		t.Errorf("activeFiles = %q, want %s and the new file", fb.activeFiles, second)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't deleted once past the protection period: %v", first, err)
+	}
+
+	// Without protection the file is deleted straight away
+	fb = newTestFileBuffer(t, WithMaxNumFiles(1))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	if first, err = fb.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fb.close()
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't deleted without protection: %v", first, err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+// isTransientError reports whether a failed write might succeed if retried,
+// e.g. once some space has been freed
+func isTransientError(err error) bool {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EAGAIN) {
+		return true
+	}
+	var temp interface{ Temporary() bool }
+	return errors.As(err, &temp) && temp.Temporary()
+}
+
+// retryTransient calls fn, calling it again after the retry interval while it
+// fails with a transient error, up to the retry count
+func (fb *FileBuffer) retryTransient(what string, fn func() error) error {
+	err := fn()
+	for attempt := 1; err != nil && attempt <= fb.writeRetryCount && isTransientError(err); attempt++ {
+		fmt.Fprintf(logOutput, "Warning: %s failed: %v, retry %d of %d in %v\n", what, err, attempt, fb.writeRetryCount, fb.writeRetryInterval)
+		time.Sleep(fb.writeRetryInterval)
+		err = fn()
+	}
+	return err
+}
+
+// retryWriter retries writes that fail with a transient error. It sits under
+// the gzip writer, which gives up for good after its first failed write.
+type retryWriter struct {
+	fb   *FileBuffer
+	w    io.Writer
+	what string
+}
+
+func (rw *retryWriter) Write(p []byte) (int, error) {
+	written := 0
+	err := rw.fb.retryTransient(rw.what, func() error {
+		n, err := rw.w.Write(p[written:])
+		written += n
+		return err
+	})
+	return written, err
+}
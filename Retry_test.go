@@ -0,0 +1,114 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type temporaryError struct{ temporary bool }
+
+func (e temporaryError) Error() string   { return "temporary error" }
+func (e temporaryError) Temporary() bool { return e.temporary }
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.ENOSPC, true},
+		{syscall.EAGAIN, true},
+		{&fs.PathError{Op: "write", Path: "x.gz", Err: syscall.ENOSPC}, true},
+		{fmt.Errorf("writing: %w", temporaryError{true}), true},
+		{temporaryError{false}, false},
+		{syscall.EIO, false},
+		{errors.New("device failed"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.want {
+			t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// flakyWriter fails its first failures writes with ENOSPC, as on a full
+// disk, each after writing half of what it was given
+type flakyWriter struct {
+	w        io.Writer
+	failures int
+}
+
+func (fw *flakyWriter) Write(p []byte) (int, error) {
+	if fw.failures == 0 {
+		return fw.w.Write(p)
+	}
+	fw.failures--
+	n, _ := fw.w.Write(p[:len(p)/2])
+	return n, &fs.PathError{Op: "write", Path: "x.gz", Err: syscall.ENOSPC}
+}
+
+// TestWriteRetry faults the current file's output with a flakyWriter and
+// checks writes are retried, carrying on from what was written, until the
+// retries run out and the write error policy applies
+func TestWriteRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		wantError bool
+	}{
+		{"succeeds on the third try", 2, false},
+		{"out of retries", 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+			var errs []*FileBufferError
+			fb := newTestFileBuffer(t,
+				WithWriteErrorPolicy("warn_continue", 3),
+				WithWriteRetry(3, time.Millisecond),
+				WithErrorHandler(func(e *FileBufferError) bool {
+					errs = append(errs, e)
+					return true
+				}),
+			)
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			flaky := &flakyWriter{fb.gzipDest, tt.failures}
+			fb.gzipDest = &retryWriter{fb, flaky, "writing test file"}
+			fb.gzipWriter.Reset(fb.gzipDest)
+
+			data := []byte("data written to a full disk\n")
+			fb.write(data)
+			fb.flush()
+
+			if gotError := len(errs) > 0; gotError != tt.wantError {
+				t.Fatalf("errors %v, want an error %v", errs, tt.wantError)
+			}
+			for attempt := 1; attempt <= min(tt.failures, 3); attempt++ {
+				want := fmt.Sprintf("Warning: writing test file failed: write x.gz: no space left on device, retry %d of 3 in 1ms", attempt)
+				if !strings.Contains(logged(), want) {
+					t.Errorf("log doesn't have %q:\n%s", want, logged())
+				}
+			}
+			if tt.wantError {
+				return
+			}
+			fb.close()
+			if got := readGzipFiles(t, []string{fb.currentFileName}); !bytes.Equal(got[0], data) {
+				t.Errorf("file holds %q, want %q", got[0], data)
+			}
+		})
+	}
+}
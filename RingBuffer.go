@@ -0,0 +1,61 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+// RingBuffer is a fixed size circular byte buffer. head and tail count the
+// total bytes read and written, so they never wrap themselves; only the
+// index into buf does. It's not safe for concurrent use.
+type RingBuffer struct {
+	buf  []byte
+	head int64 // total bytes read
+	tail int64 // total bytes written
+}
+
+// NewRingBuffer returns an empty ring of size bytes
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{buf: make([]byte, size)}
+}
+
+// Len is the number of bytes waiting to be read
+func (r *RingBuffer) Len() int {
+	return int(r.tail - r.head)
+}
+
+// Cap is the size of the ring
+func (r *RingBuffer) Cap() int {
+	return len(r.buf)
+}
+
+// Available is the number of bytes that can be written before it's full
+func (r *RingBuffer) Available() int {
+	return r.Cap() - r.Len()
+}
+
+// Write copies as much of p as there's room for and returns how much that
+// was. It never overwrites unread data; the caller reads some and writes the
+// rest of p when n < len(p).
+func (r *RingBuffer) Write(p []byte) (n int) {
+	for n < len(p) && r.Available() > 0 {
+		i := int(r.tail % int64(len(r.buf)))
+		// Up to the end of buf, or the start of the unread data
+		end := min(len(r.buf), i+r.Available())
+		c := copy(r.buf[i:end], p[n:])
+		n += c
+		r.tail += int64(c)
+	}
+	return n
+}
+
+// Read copies up to len(p) bytes out of the ring and returns how many
+func (r *RingBuffer) Read(p []byte) (n int) {
+	for n < len(p) && r.Len() > 0 {
+		i := int(r.head % int64(len(r.buf)))
+		end := min(len(r.buf), i+r.Len())
+		c := copy(p[n:], r.buf[i:end])
+		n += c
+		r.head += int64(c)
+	}
+	return n
+}
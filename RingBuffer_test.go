@@ -0,0 +1,159 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// checkLen checks r's Len, Cap and Available
+func checkLen(t *testing.T, r *RingBuffer, wantLen, wantCap int) {
+	t.Helper()
+	if r.Len() != wantLen || r.Cap() != wantCap || r.Available() != wantCap-wantLen {
+		t.Errorf("Len %d, Cap %d, Available %d, want %d, %d and %d", r.Len(), r.Cap(), r.Available(), wantLen, wantCap, wantCap-wantLen)
+	}
+}
+
+func TestRingBufferWrapAround(t *testing.T) {
+	r := NewRingBuffer(8)
+	checkLen(t, r, 0, 8)
+
+	// Move the start along to index 6, so the next write wraps
+	if n := r.Write([]byte("abcdef")); n != 6 {
+		t.Fatalf("Write = %d, want 6", n)
+	}
+	p := make([]byte, 6)
+	if n := r.Read(p); n != 6 || string(p) != "abcdef" {
+		t.Fatalf("Read = %d %q, want 6 \"abcdef\"", n, p)
+	}
+	checkLen(t, r, 0, 8)
+
+	// 2 bytes to the end of buf, 3 from the start
+	if n := r.Write([]byte("ghijk")); n != 5 {
+		t.Fatalf("Write = %d, want 5", n)
+	}
+	checkLen(t, r, 5, 8)
+	if string(r.buf[6:]) != "gh" || string(r.buf[:3]) != "ijk" {
+		t.Errorf("buf = %q, want \"gh\" at the end and \"ijk\" at the start", r.buf)
+	}
+
+	// Read back across the end of buf, in two parts
+	p = make([]byte, 3)
+	if n := r.Read(p); n != 3 || string(p) != "ghi" {
+		t.Errorf("Read = %d %q, want 3 \"ghi\"", n, p)
+	}
+	p = make([]byte, 10)
+	if n := r.Read(p); n != 2 || string(p[:n]) != "jk" {
+		t.Errorf("Read = %d %q, want 2 \"jk\"", n, p[:n])
+	}
+	checkLen(t, r, 0, 8)
+	if n := r.Read(p); n != 0 {
+		t.Errorf("Read of an empty ring = %d, want 0", n)
+	}
+}
+
+func TestRingBufferFull(t *testing.T) {
+	r := NewRingBuffer(8)
+	r.Write([]byte("xyz"))
+	r.Read(make([]byte, 3))
+
+	// Only as much as fits is written, wrapping, and nothing unread is
+	// overwritten
+	if n := r.Write([]byte("0123456789")); n != 8 {
+		t.Fatalf("Write = %d, want the 8 that fit", n)
+	}
+	checkLen(t, r, 8, 8)
+	if n := r.Write([]byte("more")); n != 0 {
+		t.Errorf("Write to a full ring = %d, want 0", n)
+	}
+
+	// Reading some makes room for the rest of the write
+	p := make([]byte, 4)
+	r.Read(p)
+	if string(p) != "0123" {
+		t.Errorf("Read %q, want \"0123\"", p)
+	}
+	checkLen(t, r, 4, 8)
+	if n := r.Write([]byte("89ab")); n != 4 {
+		t.Errorf("Write = %d, want 4", n)
+	}
+	p = make([]byte, 8)
+	if n := r.Read(p); n != 8 || string(p) != "456789ab" {
+		t.Errorf("Read = %d %q, want 8 \"456789ab\"", n, p)
+	}
+}
+
+// Random sized writes and reads come out in the same order as a
+// bytes.Buffer, with the ring's head and tail wrapping many times
+func TestRingBufferRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	r := NewRingBuffer(100)
+	var want bytes.Buffer
+	src := make([]byte, 1000)
+	rng.Read(src)
+	for i := range 10000 {
+		if rng.Intn(2) == 0 {
+			p := src[:rng.Intn(150)]
+			n := r.Write(p)
+			if wantN := min(len(p), 100-want.Len()); n != wantN {
+				t.Fatalf("op %d: Write(%d bytes) = %d, want %d", i, len(p), n, wantN)
+			}
+			want.Write(p[:n])
+		} else {
+			p := make([]byte, rng.Intn(150))
+			n := r.Read(p)
+			if w := want.Next(len(p)); !bytes.Equal(p[:n], w) {
+				t.Fatalf("op %d: Read %x, want %x", i, p[:n], w)
+			}
+		}
+		checkLen(t, r, want.Len(), 100)
+	}
+}
+
+// BenchmarkProcessingBuffer compares the processor's ring with the slice it
+// used to append to, taking reads of 64KB in and handing out 256KB chunks.
+// Gbps is the input rate each sustains, to compare with a 1 Gbps feed.
+func BenchmarkProcessingBuffer(b *testing.B) {
+	const readSize, chunkSize = 64 * 1024, defaultBufferSize
+	in := make([]byte, readSize)
+	rand.New(rand.NewSource(1)).Read(in)
+
+	gbps := func(b *testing.B, start time.Time) {
+		b.ReportMetric(float64(b.N)*readSize*8/1e9/time.Since(start).Seconds(), "Gbps")
+	}
+
+	b.Run("ring", func(b *testing.B) {
+		ring := NewRingBuffer(chunkSize * 4)
+		chunk := make([]byte, chunkSize)
+		b.SetBytes(readSize)
+		b.ReportAllocs()
+		start := time.Now()
+		for range b.N {
+			ring.Write(in)
+			for ring.Len() >= chunkSize {
+				ring.Read(chunk)
+			}
+		}
+		gbps(b, start)
+	})
+	b.Run("append", func(b *testing.B) {
+		var processingBuffer []byte
+		b.SetBytes(readSize)
+		b.ReportAllocs()
+		start := time.Now()
+		for range b.N {
+			processingBuffer = append(processingBuffer, in...)
+			for len(processingBuffer) >= chunkSize {
+				chunk := processingBuffer[:chunkSize]
+				processingBuffer = processingBuffer[chunkSize:]
+				_ = chunk
+			}
+		}
+		gbps(b, start)
+	})
+}
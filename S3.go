@@ -0,0 +1,248 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// s3Credentials are read from the standard AWS environment variables
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string // only for temporary credentials
+}
+
+func s3CredentialsFromEnv() (s3Credentials, error) {
+	creds := s3Credentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return creds, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+// s3Region is --s3_region, or else the region from the environment
+func s3Region(region string) string {
+	for _, r := range []string{region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if r != "" {
+			return r
+		}
+	}
+	return "us-east-1"
+}
+
+// s3ObjectURL is where key is PUT. AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL
+// (e.g. a MinIO server) is addressed path-style, as is a bucket with dots in
+// its name, which wouldn't match the wildcard TLS certificate.
+func s3ObjectURL(bucket, region, key string) string {
+	path := "/" + awsURIEncode(key, false)
+	for _, endpoint := range []string{os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")} {
+		if endpoint != "" {
+			return strings.TrimSuffix(endpoint, "/") + "/" + awsURIEncode(bucket, true) + path
+		}
+	}
+	if strings.Contains(bucket, ".") {
+		return "https://s3." + region + ".amazonaws.com/" + awsURIEncode(bucket, true) + path
+	}
+	return "https://" + bucket + ".s3." + region + ".amazonaws.com" + path
+}
+
+// queueUpload starts uploading a just-closed file to --s3_bucket in the
+// background. The file is opened straight away, so it can still be uploaded
+// if retention deletes it first.
+func (fb *FileBuffer) queueUpload(path string) {
+	if fb.s3Bucket == "" || path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: not uploading %s to S3: %v\n", path, err)
+		return
+	}
+
+	fb.background.Add(1)
+	go func() {
+		defer fb.background.Done()
+		defer f.Close()
+		fb.uploadFile(path, f)
+	}()
+}
+
+// uploadFile uploads f, retrying failures --s3_retry_count times, then with
+// --s3_delete_after_upload deletes the local copy
+func (fb *FileBuffer) uploadFile(path string, f *os.File) {
+	key := fb.s3KeyPrefix + filepath.Base(path)
+	var err error
+	for attempt := 0; attempt <= fb.s3RetryCount; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(logOutput, "Warning: uploading %s to s3://%s/%s failed, retrying (%d/%d): %v\n",
+				path, fb.s3Bucket, key, attempt, fb.s3RetryCount, err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err = fb.putObject(key, f); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(logOutput, "Error: failed to upload %s to s3://%s/%s: %v\n", path, fb.s3Bucket, key, err)
+		return
+	}
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Uploaded %s to s3://%s/%s\n", path, fb.s3Bucket, key)
+	}
+
+	if fb.s3DeleteAfterUpload {
+		fb.mu.Lock()
+		defer fb.unlock()
+		// Retention may have got to it first
+		i := slices.Index(fb.activeFiles, path)
+		if i < 0 {
+			return
+		}
+		if fb.removeFile(path, "uploaded") {
+			fb.activeFiles = slices.Delete(fb.activeFiles, i, i+1)
+			fb.saveState()
+		}
+	}
+}
+
+// putObject uploads the contents of f as key with a SigV4 signed PUT
+func (fb *FileBuffer) putObject(key string, f *os.File) error {
+	hash := sha256.New()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	region := s3Region(fb.s3Region)
+	req, err := http.NewRequest(http.MethodPut, s3ObjectURL(fb.s3Bucket, region, key), io.NopCloser(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	signS3Request(req, fb.s3Credentials, region, hex.EncodeToString(hash.Sum(nil)), time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signS3Request adds an AWS Signature Version 4 Authorization header to req,
+// whose body has the hex SHA-256 payloadHash
+func signS3Request(req *http.Request, creds s3Credentials, region, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	// Sign the host and every x-amz-* header, in sorted order
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	slices.Sort(params)
+	return strings.Join(params, "&")
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, as
+// SigV4 requires, leaving "/" alone unless encodeSlash
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
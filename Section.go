@@ -0,0 +1,59 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import "fmt"
+
+// startSectionHeader fills a new file's section header slot, after the
+// global header (and any injected block), with the section header captured
+// from the stream. Each file then stands alone even if it starts part way
+// through a section. Until one has been captured, which is from the start
+// of the first file's data, the new file's own data is captured instead.
+func (fb *FileBuffer) startSectionHeader() error {
+	if fb.sectionHeaderBytes <= 0 {
+		return nil
+	}
+	if fb.sectionCapturing || len(fb.sectionHeader) != fb.sectionHeaderBytes {
+		fb.sectionHeader = nil
+		fb.sectionCapturing = true
+		return nil
+	}
+
+	fb.updateChecksum(fb.sectionHeader)
+	if _, err := fb.gzipWriter.Write(fb.sectionHeader); err != nil {
+		return fmt.Errorf("writing section header to %s: %w", fb.currentFileName, err)
+	}
+	fb.counters.bytesUncompressed.Add(int64(len(fb.sectionHeader)))
+	fb.writeMirror(fb.sectionHeader)
+	fb.writeCompanion(fb.sectionHeader)
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Wrote %d section header bytes to file\n", len(fb.sectionHeader))
+	}
+	return nil
+}
+
+// captureSectionHeader takes the current file's section header from data,
+// which starts at stream offset offset. The global header at the start of
+// the stream is skipped, so the first file's section header follows it.
+func (fb *FileBuffer) captureSectionHeader(data []byte, offset int64) {
+	if !fb.sectionCapturing {
+		return
+	}
+	if skip := int64(fb.headerBytes) - offset; skip > 0 {
+		if skip >= int64(len(data)) {
+			return
+		}
+		data = data[skip:]
+	}
+
+	n := min(len(data), fb.sectionHeaderBytes-len(fb.sectionHeader))
+	fb.sectionHeader = append(fb.sectionHeader, data[:n]...)
+	if len(fb.sectionHeader) == fb.sectionHeaderBytes {
+		fb.sectionCapturing = false
+		if !fb.quiet {
+			fmt.Fprintf(logOutput, "Captured %d section header bytes for %s\n", fb.sectionHeaderBytes, fb.currentFileName)
+		}
+	}
+}
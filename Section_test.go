@@ -0,0 +1,58 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestSectionHeader(t *testing.T) {
+	global := []byte("GLOBALHEADER0123")
+	section := []byte("SECTION1")
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	stream := append(append(append([]byte(nil), global...), section...), data...)
+
+	fb := newTestFileBuffer(t,
+		WithHeaderBytes(len(global)),
+		WithSectionHeaderBytes(len(section)),
+		WithReadBufferSize(4096),
+		WithMaxBlockSize(4096),
+		WithMaxFileSize(8192),
+		WithMaxNumFiles(100),
+	)
+	if err := fb.WriteFrom(bytes.NewReader(stream)); err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+
+	files := readGzipFiles(t, fb.activeFiles)
+	if len(files) < 3 {
+		t.Fatalf("got %d files, want at least 3", len(files))
+	}
+
+	// Each file is global header | section header | data, and the data
+	// reassembles the input
+	var rest []byte
+	for i, file := range files {
+		if !bytes.HasPrefix(file, global) {
+			t.Fatalf("file %d doesn't start with the global header", i)
+		}
+		file = file[len(global):]
+		if !bytes.HasPrefix(file, section) {
+			t.Fatalf("file %d has no section header after the global header", i)
+		}
+		if i == 0 {
+			// The first file's section header is from the stream
+			rest = append(rest, file...)
+		} else {
+			rest = append(rest, file[len(section):]...)
+		}
+	}
+	if !bytes.Equal(rest, stream[len(global):]) {
+		t.Errorf("files' data doesn't reassemble the input: got %d bytes, want %d", len(rest), len(stream)-len(global))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// maxRecordDelimiterBytes is the longest --record_delimiter allowed
+const maxRecordDelimiterBytes = 8
+
+// findNewlineSplit returns the offset just after the last newline in data
+// that ends a line of at least --min_line_length bytes, for --split_on_newline.
+// A line running back to the start of data may have begun in an earlier
+// chunk, so its length isn't held against it. Short lines passed over are
+// logged once per read buffer, not once each.
+func (fb *FileBuffer) findNewlineSplit(data []byte) int {
+	shortLines := 0
+	defer func() {
+		if shortLines > 0 && !fb.quiet {
+			fmt.Fprintf(logOutput, "Warning: not splitting after %d line(s) shorter than --min_line_length %d\n", shortLines, fb.minLineLength)
+		}
+	}()
+
+	end := len(data)
+	for {
+		nl := bytes.LastIndexByte(data[:end], '\n')
+		if nl < 0 {
+			fmt.Fprintf(logOutput, "Warning: no newline found (to split on) in read buffer, splitting mid-line. Try a bigger buffer?\n")
+			return len(data)
+		}
+		start := bytes.LastIndexByte(data[:nl], '\n') + 1
+		if start == 0 || nl-start >= fb.minLineLength {
+			return nl + 1
+		}
+		shortLines++
+		end = nl
+	}
+}
+
+// findRecordSplit returns the offset of the first record boundary in a chunk
+// starting at streamOffset, for --record_size. Records are counted from the
+// end of the header, which every file starts with.
+func (fb *FileBuffer) findRecordSplit(streamOffset int64, dataLen int) int {
+	intoRecord := max(streamOffset-int64(fb.headerBytes), 0) % int64(fb.recordSize)
+	if intoRecord == 0 {
+		return 0
+	}
+	return min(int(int64(fb.recordSize)-intoRecord), dataLen)
+}
+
+// parseRecordDelimiter decodes --record_delimiter, which is hex (e.g. 0d0a,
+// optionally with a 0x prefix) if it can be, otherwise the literal text
+func parseRecordDelimiter(s string) ([]byte, error) {
+	delim, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+	if err != nil || len(delim) == 0 {
+		delim = []byte(s)
+	}
+	if len(delim) == 0 || len(delim) > maxRecordDelimiterBytes {
+		return nil, fmt.Errorf("must be 1-%d bytes, got %d", maxRecordDelimiterBytes, len(delim))
+	}
+	return delim, nil
+}
+
+// findDelimiterSplit returns the offset just after the last --record_delimiter
+// in data. prevTail is the end of the previous chunk, so a delimiter split
+// across the two is found too.
+func (fb *FileBuffer) findDelimiterSplit(prevTail, data []byte) int {
+	search := append(append([]byte(nil), prevTail...), data...)
+	i := bytes.LastIndex(search, fb.recordDelimiter)
+	if i < 0 {
+		fmt.Fprintf(logOutput, "Warning: no record delimiter found (to split on) in read buffer, splitting mid-record. Try a bigger buffer?\n")
+		return len(data)
+	}
+	return i + len(fb.recordDelimiter) - len(prevTail)
+}
+
+// nextDelimiterTail returns the bytes at the end of data that could be the start
+// of a delimiter finishing in the next chunk, appended to the previous tail
+// in case data is shorter than that
+func (fb *FileBuffer) nextDelimiterTail(prevTail, data []byte) []byte {
+	keep := len(fb.recordDelimiter) - 1
+	tail := append(append([]byte(nil), prevTail...), data...)
+	return tail[max(len(tail)-keep, 0):]
+}
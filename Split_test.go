@@ -0,0 +1,148 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestFindNewlineSplit(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		minLineLength int
+		want          int
+	}{
+		{"after the last newline", "one\ntwo\nthr", 0, 8},
+		{"ends with a newline", "one\ntwo\n", 0, 8},
+		{"no newline", "no newline here", 0, 15},
+		{"skips a short last line", "a long line\nab\ncd", 5, 12},
+		{"skips several short lines", "a long line\na\nb\nc\nd", 5, 12},
+		{"first line may have started earlier", "a\nb\n", 5, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &FileBuffer{minLineLength: tt.minLineLength, quiet: true}
+			if got := fb.findNewlineSplit([]byte(tt.data)); got != tt.want {
+				t.Errorf("findNewlineSplit(%q) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindRecordSplit(t *testing.T) {
+	tests := []struct {
+		name         string
+		headerBytes  int
+		streamOffset int64
+		dataLen      int
+		want         int
+	}{
+		{"on a boundary", 0, 128, 100, 0},
+		{"part way through a record", 0, 130, 100, 62},
+		{"record ends after the data", 0, 130, 10, 10},
+		{"counted from the end of the header", 16, 80, 100, 0},
+		{"inside the header", 16, 8, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &FileBuffer{recordSize: 64, headerBytes: tt.headerBytes}
+			if got := fb.findRecordSplit(tt.streamOffset, tt.dataLen); got != tt.want {
+				t.Errorf("findRecordSplit(%d, %d) = %d, want %d", tt.streamOffset, tt.dataLen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRecordDelimiter(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []byte
+		wantErr bool
+	}{
+		{"0d0a", []byte("\r\n"), false},
+		{"0x00", []byte{0}, false},
+		{"END", []byte("END"), false},
+		{"", nil, true},
+		{"too long a delimiter", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRecordDelimiter(tt.in)
+		if (err != nil) != tt.wantErr || !bytes.Equal(got, tt.want) {
+			t.Errorf("parseRecordDelimiter(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFindDelimiterSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		prevTail string
+		data     string
+		want     int
+	}{
+		{"after the last delimiter", "", "a\r\nb\r\nc", 6},
+		{"split across chunks", "\r", "\nabc", 1},
+		{"not found", "", "abc", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &FileBuffer{recordDelimiter: []byte("\r\n")}
+			if got := fb.findDelimiterSplit([]byte(tt.prevTail), []byte(tt.data)); got != tt.want {
+				t.Errorf("findDelimiterSplit(%q, %q) = %d, want %d", tt.prevTail, tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+// Each file holds only complete JSON Lines records with --split_on_newline
+func TestSplitOnNewlineJSONL(t *testing.T) {
+	var stream bytes.Buffer
+	for i := range 2000 {
+		fmt.Fprintf(&stream, `{"seq":%d,"msg":"record %d of the test stream"}`+"\n", i, i*7919%10007)
+	}
+
+	fb := newTestFileBuffer(t,
+		WithSplitOnNewline(true, 0),
+		WithReadBufferSize(4096),
+		WithMaxBlockSize(4096),
+		WithMaxFileSize(4096),
+		WithMaxNumFiles(100),
+		WithCompressionLevel(0),
+	)
+	if err := fb.WriteFrom(bytes.NewReader(stream.Bytes())); err != nil {
+		t.Fatalf("WriteFrom: %v", err)
+	}
+
+	files := readGzipFiles(t, fb.activeFiles)
+	if len(files) < 2 {
+		t.Fatalf("got %d files, want several", len(files))
+	}
+	records := 0
+	for i, file := range files {
+		if len(file) == 0 {
+			continue
+		}
+		if !bytes.HasSuffix(file, []byte("\n")) {
+			t.Errorf("file %d doesn't end with a newline", i)
+		}
+		for _, line := range bytes.Split(bytes.TrimSuffix(file, []byte("\n")), []byte("\n")) {
+			if !json.Valid(line) {
+				t.Fatalf("file %d has an incomplete record: %q", i, line)
+			}
+			records++
+		}
+	}
+	if records != 2000 {
+		t.Errorf("got %d records, want 2000", records)
+	}
+}
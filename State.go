@@ -0,0 +1,121 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistedState is written to --state_file so a restart can resume exactly
+// where it left off, without relying on filename pattern matching
+type persistedState struct {
+	FileCounter       int       `json:"fileCounter"`
+	ActiveFiles       []string  `json:"activeFiles"`
+	ProgramStartTime  time.Time `json:"programStartTime"`
+	TotalBytesWritten int64     `json:"totalBytesWritten"`
+}
+
+// saveState atomically replaces the state file (write to temp file + rename)
+func (fb *FileBuffer) saveState() {
+	if fb.stateFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(persistedState{
+		FileCounter:       fb.fileCounter,
+		ActiveFiles:       fb.activeFiles,
+		ProgramStartTime:  fb.startTime,
+		TotalBytesWritten: fb.counters.bytesUncompressed.Load(),
+	}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to encode state: %v\n", err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fb.stateFile), filepath.Base(fb.stateFile)+".tmp*")
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to write state file %s: %v\n", fb.stateFile, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fb.stateFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		fmt.Fprintf(logOutput, "Warning: failed to write state file %s: %v\n", fb.stateFile, err)
+	}
+}
+
+// loadState restores the file counter and active files from the state file
+func (fb *FileBuffer) loadState() error {
+	data, err := os.ReadFile(fb.stateFile)
+	if err != nil {
+		return err
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("corrupt state file: %w", err)
+	}
+	if state.FileCounter < 0 {
+		return fmt.Errorf("corrupt state file: negative file counter %d", state.FileCounter)
+	}
+
+	// Only track files that are still there
+	fb.activeFiles = make([]string, 0, len(state.ActiveFiles))
+	for _, path := range state.ActiveFiles {
+		if _, err := os.Stat(path); err == nil {
+			fb.activeFiles = append(fb.activeFiles, path)
+		}
+	}
+
+	// Delete excess files if more than maxNumFiles
+	for len(fb.activeFiles) > fb.maxNumFiles {
+		if !fb.removeFile(fb.activeFiles[0], "excess") {
+			break
+		}
+		fb.activeFiles = fb.activeFiles[1:]
+	}
+
+	fb.fileCounter = state.FileCounter
+
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Loaded state from %s: %d existing file(s), resuming from counter %d\n",
+			fb.stateFile, len(fb.activeFiles), fb.fileCounter)
+	}
+	return nil
+}
+
+// resume picks up existing files, preferring the state file if there is a
+// usable one and falling back to scanning the output directory
+func (fb *FileBuffer) resume() {
+	fb.mu.Lock()
+	defer fb.unlock()
+
+	loaded := false
+	if fb.stateFile != "" {
+		err := fb.loadState()
+		if err == nil {
+			loaded = true
+		} else if !os.IsNotExist(err) {
+			fmt.Fprintf(logOutput, "Warning: can't use state file %s, scanning for existing files instead: %v\n", fb.stateFile, err)
+		}
+	}
+	if !loaded {
+		fb.loadExistingFiles()
+	}
+
+	if fb.verifyOnResume {
+		fb.verifyExistingFiles()
+	}
+}
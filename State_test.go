@@ -0,0 +1,83 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestStateFile saves the state as files are opened and closed, then
+// resumes from it in a new FileBuffer, or from the files on disk if it's
+// corrupt
+func TestStateFile(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "test")
+	stateFile := filepath.Join(dir, "state.json")
+	fb := newTestFileBuffer(t, WithPrefix(prefix), WithStateFile(stateFile), WithMaxNumFiles(3))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		fb.write([]byte("some data\n"))
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb.close()
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.FileCounter != fb.fileCounter || !slices.Equal(state.ActiveFiles, fb.activeFiles) ||
+		!state.ProgramStartTime.Equal(fb.startTime) || state.TotalBytesWritten != 30 {
+		t.Errorf("saved %+v, want counter %d, files %q, start time %v and 30 bytes", state, fb.fileCounter, fb.activeFiles, fb.startTime)
+	}
+	if temps, _ := filepath.Glob(stateFile + ".tmp*"); len(temps) != 0 {
+		t.Errorf("temporary state files left behind: %q", temps)
+	}
+
+	resume := func() *FileBuffer {
+		fb := newTestFileBuffer(t, WithPrefix(prefix), WithStateFile(stateFile), WithMaxNumFiles(3), WithResumeExisting(true))
+		fb.resume()
+		return fb
+	}
+
+	// The state file's counter is used even where the files on disk would
+	// suggest another, and files that have gone are dropped
+	state.FileCounter = 42
+	if err := os.Remove(state.ActiveFiles[0]); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = json.Marshal(state)
+	if err := os.WriteFile(stateFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	resumed := resume()
+	if resumed.fileCounter != 42 || !slices.Equal(resumed.activeFiles, state.ActiveFiles[1:]) {
+		t.Errorf("resumed with counter %d, files %q, want 42 and %q", resumed.fileCounter, resumed.activeFiles, state.ActiveFiles[1:])
+	}
+
+	// A corrupt state file falls back to scanning for files
+	discardLog(t)
+	for _, corrupt := range []string{`{"fileCounter": 3, "activeFiles": [`, `{"fileCounter": -1}`} {
+		if err := os.WriteFile(stateFile, []byte(corrupt), 0644); err != nil {
+			t.Fatal(err)
+		}
+		resumed = resume()
+		if resumed.fileCounter != fb.fileCounter || !slices.Equal(resumed.activeFiles, state.ActiveFiles[1:]) {
+			t.Errorf("%s: resumed with counter %d, files %q, want %d and %q",
+				corrupt, resumed.fileCounter, resumed.activeFiles, fb.fileCounter, state.ActiveFiles[1:])
+		}
+	}
+}
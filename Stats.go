@@ -0,0 +1,122 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Statistics is a snapshot of a FileBuffer's counters, returned by Stats
+type Statistics struct {
+	CurrentFile              string
+	FilesCreated             int64
+	FilesDeleted             int64
+	BytesWrittenUncompressed int64
+	BytesWrittenCompressed   int64
+	CurrentFileBytes         int64 // Compressed bytes written to the current file (or FIFO member)
+	BlocksFound              int64
+	BlockValidationFailures  int64
+	TotalScanIterations      int64 // Searches of a read buffer for a block boundary to rotate at
+	SuccessfulBoundaryFinds  int64 // Searches that found a boundary
+	FailedBoundaryFinds      int64 // Searches that found no boundary, so the whole buffer went in the old file
+	TotalBytesScanned        int64 // Bytes passed over before the boundary, or the whole buffer if none was found
+	DiskUsage                int64 // Total size of the managed files as of the last file close
+	StartTime                time.Time
+	CurrentFileOpenedAt      time.Time
+}
+
+// counters are updated atomically from the write path
+type counters struct {
+	filesCreated            atomic.Int64
+	filesDeleted            atomic.Int64
+	bytesUncompressed       atomic.Int64
+	bytesCompressed         atomic.Int64
+	blocksFound             atomic.Int64
+	blockValidationFailures atomic.Int64
+	blockScans              atomic.Int64
+	blockScansFailed        atomic.Int64
+	blockScanBytes          atomic.Int64
+	diskUsage               atomic.Int64
+}
+
+// countingWriter counts the compressed bytes the gzip writer passes through to the file
+type countingWriter struct {
+	w     io.Writer
+	count *atomic.Int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count.Add(int64(n))
+	return n, err
+}
+
+// Stats returns a snapshot of the buffer's statistics
+func (fb *FileBuffer) Stats() Statistics {
+	fb.mu.Lock()
+	currentFile := fb.currentFileName
+	openedAt := fb.currentFileOpenedAt
+	fileStart := fb.fileStartCompressed
+	fb.unlock()
+
+	bytesCompressed := fb.counters.bytesCompressed.Load()
+
+	return Statistics{
+		CurrentFile:              currentFile,
+		FilesCreated:             fb.counters.filesCreated.Load(),
+		FilesDeleted:             fb.counters.filesDeleted.Load(),
+		BytesWrittenUncompressed: fb.counters.bytesUncompressed.Load(),
+		BytesWrittenCompressed:   bytesCompressed,
+		CurrentFileBytes:         bytesCompressed - fileStart,
+		BlocksFound:              fb.counters.blocksFound.Load(),
+		BlockValidationFailures:  fb.counters.blockValidationFailures.Load(),
+		TotalScanIterations:      fb.counters.blockScans.Load(),
+		SuccessfulBoundaryFinds:  fb.counters.blocksFound.Load(),
+		FailedBoundaryFinds:      fb.counters.blockScansFailed.Load(),
+		TotalBytesScanned:        fb.counters.blockScanBytes.Load(),
+		DiskUsage:                fb.counters.diskUsage.Load(),
+		StartTime:                fb.startTime,
+		CurrentFileOpenedAt:      openedAt,
+	}
+}
+
+// printStats writes a human readable statistics summary, for SIGUSR1
+func printStats(w io.Writer, s Statistics) {
+	fmt.Fprintf(w, "Stats: uptime %v, current file %s (open %v)\n",
+		time.Since(s.StartTime).Round(time.Second), s.CurrentFile, time.Since(s.CurrentFileOpenedAt).Round(time.Second))
+	fmt.Fprintf(w, "Stats: files created %d, deleted %d, disk usage %d bytes\n",
+		s.FilesCreated, s.FilesDeleted, s.DiskUsage)
+	fmt.Fprintf(w, "Stats: bytes in %d, bytes out %d, blocks found %d, block validation failures %d\n",
+		s.BytesWrittenUncompressed, s.BytesWrittenCompressed, s.BlocksFound, s.BlockValidationFailures)
+	fmt.Fprintf(w, "Stats: block scans %d (%d successful boundaries, %d failed), %d bytes scanned\n",
+		s.TotalScanIterations, s.SuccessfulBoundaryFinds, s.FailedBoundaryFinds, s.TotalBytesScanned)
+}
+
+// countBlockScan records a search for a block boundary that passed over
+// skipped bytes, and whether it found one
+func (fb *FileBuffer) countBlockScan(skipped int, found bool) {
+	fb.counters.blockScans.Add(1)
+	fb.counters.blockScanBytes.Add(int64(skipped))
+	if found {
+		fb.counters.blocksFound.Add(1)
+	} else {
+		fb.counters.blockScansFailed.Add(1)
+	}
+	fb.checkStrictBlockScan(found)
+}
+
+// logBlockScans logs the block scan counts for the file being closed
+func (fb *FileBuffer) logBlockScans() {
+	if fb.quiet || fb.blockFormat == nil && fb.blockValidator == nil {
+		return
+	}
+	fmt.Fprintf(logOutput, "Closed %s after %d bytes scanned (%d successful boundaries, %d failed)\n", fb.currentFileName,
+		fb.counters.blockScanBytes.Load()-fb.fileStartScanBytes,
+		fb.counters.blocksFound.Load()-fb.fileStartBlocks,
+		fb.counters.blockScansFailed.Load()-fb.fileStartScansFailed)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Each write after the first rotates, and scans for the pcap record after
+// some noise, until the last write, which is all noise
+func TestBoundaryStatistics(t *testing.T) {
+	noise := func(n int) []byte { return bytes.Repeat([]byte{0xFF}, n) }
+	writes := [][]byte{
+		pcapRecords(4, 100, 1),
+		append(noise(10), pcapRecords(4, 100, 2)...),
+		append(noise(30), pcapRecords(4, 100, 3)...),
+		noise(200),
+	}
+
+	discardLog(t)
+	fb := newTestFileBuffer(t,
+		WithBlockFormat(presetFormat(t, "pcap")),
+		WithMaxFileSize(1),
+		WithRotateOnUncompressed(true),
+	)
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range writes {
+		fb.write(data)
+		if i == 2 {
+			if s := fb.Stats(); s.SuccessfulBoundaryFinds != 2 {
+				t.Errorf("after two rotations SuccessfulBoundaryFinds = %d, want 2", s.SuccessfulBoundaryFinds)
+			}
+		}
+	}
+	fb.close()
+
+	s := fb.Stats()
+	if s.FilesCreated != 4 {
+		t.Errorf("FilesCreated = %d, want 4", s.FilesCreated)
+	}
+	if s.TotalScanIterations != 3 || s.SuccessfulBoundaryFinds != 2 || s.FailedBoundaryFinds != 1 {
+		t.Errorf("TotalScanIterations %d, SuccessfulBoundaryFinds %d, FailedBoundaryFinds %d, want 3, 2 and 1",
+			s.TotalScanIterations, s.SuccessfulBoundaryFinds, s.FailedBoundaryFinds)
+	}
+	if want := int64(10 + 30 + 200); s.TotalBytesScanned != want {
+		t.Errorf("TotalBytesScanned = %d, want %d", s.TotalBytesScanned, want)
+	}
+
+	var sb strings.Builder
+	printStats(&sb, s)
+	if want := "block scans 3 (2 successful boundaries, 1 failed), 240 bytes scanned"; !strings.Contains(sb.String(), want) {
+		t.Errorf("printStats wrote:\n%s\nwant it to contain %q", sb.String(), want)
+	}
+}
+
+// TestStats checks each counter after several writes and rotations, two of
+// which delete the oldest file
+func TestStats(t *testing.T) {
+	noise := func(n int) []byte { return bytes.Repeat([]byte{0xFF}, n) }
+	writes := [][]byte{
+		pcapRecords(4, 100, 1),
+		append(noise(10), pcapRecords(4, 100, 2)...),
+		append(noise(30), pcapRecords(4, 100, 3)...),
+		pcapRecords(4, 100, 4),
+	}
+
+	start := time.Now()
+	fb := newTestFileBuffer(t,
+		WithBlockFormat(presetFormat(t, "pcap")),
+		WithMaxFileSize(1),
+		WithRotateOnUncompressed(true),
+		WithMaxNumFiles(2),
+	)
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	var written int64
+	for _, data := range writes {
+		fb.write(data)
+		written += int64(len(data))
+	}
+	opened := time.Now()
+	fb.close()
+
+	s := fb.Stats()
+	var onDisk, lastSize int64
+	for _, path := range fb.activeFiles {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		onDisk += info.Size()
+		lastSize = info.Size()
+	}
+	if s.CurrentFile != fb.activeFiles[len(fb.activeFiles)-1] {
+		t.Errorf("CurrentFile = %q, want the last file %q", s.CurrentFile, fb.activeFiles[len(fb.activeFiles)-1])
+	}
+	if s.FilesCreated != 4 || s.FilesDeleted != 2 {
+		t.Errorf("FilesCreated %d, FilesDeleted %d, want 4 and 2", s.FilesCreated, s.FilesDeleted)
+	}
+	if s.BytesWrittenUncompressed != written {
+		t.Errorf("BytesWrittenUncompressed = %d, want %d", s.BytesWrittenUncompressed, written)
+	}
+	// Two files were deleted, so more has been written than is on disk
+	if s.BytesWrittenCompressed <= onDisk || s.DiskUsage != onDisk || s.CurrentFileBytes != lastSize {
+		t.Errorf("BytesWrittenCompressed %d, DiskUsage %d, CurrentFileBytes %d, want more than %d, %d and %d",
+			s.BytesWrittenCompressed, s.DiskUsage, s.CurrentFileBytes, onDisk, onDisk, lastSize)
+	}
+	if s.BlocksFound != 3 {
+		t.Errorf("BlocksFound = %d, want 3", s.BlocksFound)
+	}
+	// Every offset tried before the block header, at 10 and 30 bytes in
+	if s.BlockValidationFailures != 40 {
+		t.Errorf("BlockValidationFailures = %d, want 40", s.BlockValidationFailures)
+	}
+	if s.StartTime.Before(start) || s.StartTime.After(s.CurrentFileOpenedAt) || s.CurrentFileOpenedAt.After(opened) {
+		t.Errorf("StartTime %v, CurrentFileOpenedAt %v, want in order between %v and %v", s.StartTime, s.CurrentFileOpenedAt, start, opened)
+	}
+}
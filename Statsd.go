@@ -0,0 +1,44 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// sendStatsd sends metrics for the file just closed to --statsd_addr in the
+// StatsD wire protocol, as a single UDP packet. It's fire and forget: a lost
+// packet or an error isn't retried.
+func (fb *FileBuffer) sendStatsd(file WebhookPayload) {
+	if fb.statsdAddr == "" {
+		return
+	}
+	if fb.statsdConn == nil {
+		conn, err := net.Dial("udp", fb.statsdAddr)
+		if err != nil {
+			fmt.Fprintf(logOutput, "Warning: can't send StatsD metrics to %s: %v\n", fb.statsdAddr, err)
+			return
+		}
+		fb.statsdConn = conn
+	}
+
+	prefix := fb.statsdPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	lines := []string{
+		fmt.Sprintf("%sbytes_in:%d|c", prefix, file.BytesUncompressed),
+		fmt.Sprintf("%sbytes_out:%d|c", prefix, file.SizeBytes),
+		fmt.Sprintf("%sfiles_total:1|c", prefix),
+	}
+	if file.SizeBytes > 0 {
+		lines = append(lines, fmt.Sprintf("%scompression_ratio:%.3f|g", prefix, float64(file.BytesUncompressed)/float64(file.SizeBytes)))
+	}
+	if _, err := fb.statsdConn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to send StatsD metrics for %s: %v\n", file.File, err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatsd captures the StatsD packet sent as each file is closed, and
+// checks its metrics against the file
+func TestStatsd(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, prefix := range []string{"gzfb", "capture.host1."} {
+		t.Run(prefix, func(t *testing.T) {
+			fb := newTestFileBuffer(t, WithStatsd(conn.LocalAddr().String(), prefix), WithMaxNumFiles(3))
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			written := []int{32 * 1024, 48 * 1024}
+			fb.write(syntheticLog(written[0]))
+			if _, err := fb.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			fb.write(syntheticLog(written[1]))
+			fb.close()
+
+			name := strings.TrimSuffix(prefix, ".") + "."
+			for i, path := range fb.activeFiles {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := []string{
+					fmt.Sprintf("%sbytes_in:%d|c", name, written[i]),
+					fmt.Sprintf("%sbytes_out:%d|c", name, info.Size()),
+					name + "files_total:1|c",
+					fmt.Sprintf("%scompression_ratio:%.3f|g", name, float64(written[i])/float64(info.Size())),
+				}
+
+				buf := make([]byte, 1500)
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatalf("no packet for %s: %v", path, err)
+				}
+				got := string(buf[:n])
+				if got != strings.Join(want, "\n") {
+					t.Errorf("%s sent:\n%s\nwant:\n%s", path, got, strings.Join(want, "\n"))
+				}
+				for _, line := range strings.Split(got, "\n") {
+					metric, value, ok := strings.Cut(line, ":")
+					value, kind, ok2 := strings.Cut(value, "|")
+					if _, err := strconv.ParseFloat(value, 64); !ok || !ok2 || err != nil || !strings.HasPrefix(metric, name) || (kind != "c" && kind != "g") {
+						t.Errorf("%q isn't a StatsD metric starting %q", line, name)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// withSubdir inserts the --subdir_format directory for t between the
+// directory and name of filename, creating it if needed
+func (fb *FileBuffer) withSubdir(filename string, t time.Time) (string, error) {
+	if fb.subdirFormat == "" {
+		return filename, nil
+	}
+	if !fb.useLocalTime {
+		t = t.UTC()
+	}
+	dir := filepath.Join(filepath.Dir(filename), t.Format(fb.subdirFormat))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(filename)), nil
+}
+
+// removeEmptySubdirs removes the --subdir_format directories a deleted file
+// was in, deepest first, while they're empty
+func (fb *FileBuffer) removeEmptySubdirs(path string) {
+	dir := filepath.Dir(path)
+	for range fb.subdirDepth() {
+		// Fails, leaving the directory in place, if it isn't empty
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// subdirDepth is how many directory levels --subdir_format adds
+func (fb *FileBuffer) subdirDepth() int {
+	if fb.subdirFormat == "" {
+		return 0
+	}
+	return strings.Count(filepath.Clean(fb.subdirFormat), string(filepath.Separator)) + 1
+}
+
+// listOutputFiles returns the files that could have been written to dir:
+// those directly in it, or with --subdir_format those at the depth of the
+// subdirectories below it
+func (fb *FileBuffer) listOutputFiles(dir string) ([]string, error) {
+	depth := fb.subdirDepth()
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		level := 0
+		if rel != "." {
+			level = strings.Count(rel, string(filepath.Separator)) + 1
+		}
+		if d.IsDir() {
+			if level > depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if level == depth+1 {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
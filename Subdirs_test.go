@@ -0,0 +1,87 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestSubdirFormat rotates across midnight with daily subdirectories and
+// checks each file goes in the directory for its day, emptied directories
+// are removed along with the files, and resuming finds the files in them
+func TestSubdirFormat(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "cap")
+	clock := time.Date(2025, 3, 4, 23, 59, 58, 0, time.UTC)
+	newFileBuffer := func() *FileBuffer {
+		fb := newTestFileBuffer(t, WithPrefix(prefix), WithTimeFormat("epoch"), WithSubdirFormat("2006/01/02"), WithMaxNumFiles(3))
+		fb.clockFn = func() time.Time { return clock }
+		return fb
+	}
+
+	fb := newFileBuffer()
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	first := fb.currentFileName
+	var days []string
+	for range 3 {
+		clock = clock.Add(time.Second) // the second rotation is at midnight
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dir, filepath.Dir(fb.currentFileName))
+		days = append(days, rel)
+	}
+	fb.close()
+
+	want := []string{filepath.Join("2025", "03", "04"), filepath.Join("2025", "03", "05"), filepath.Join("2025", "03", "05")}
+	if !slices.Equal(days, want) {
+		t.Errorf("files went in %q, want %q", days, want)
+	}
+	if got := filepath.Dir(first); got != filepath.Join(dir, "2025", "03", "04") {
+		t.Errorf("first file went in %s", got)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("first file wasn't deleted: %v", err)
+	}
+	// The second file, from the same day, keeps its directory
+	if _, err := os.Stat(filepath.Dir(first)); err != nil {
+		t.Errorf("directory still in use was removed: %v", err)
+	}
+
+	// Files outside the subdirectories, or at the wrong depth, aren't ours
+	for _, path := range []string{
+		prefix + "_000009_1741132800.gz",
+		filepath.Join(dir, "2025", "03", "cap_000010_1741132800.gz"),
+	} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resumed := newFileBuffer()
+	resumed.loadExistingFiles()
+	if !slices.Equal(resumed.activeFiles, fb.activeFiles) || resumed.fileCounter != 4 {
+		t.Errorf("resumed files %q, counter %d, want %q and 4", resumed.activeFiles, resumed.fileCounter, fb.activeFiles)
+	}
+
+	// Deleting the last file in a day's directory removes the directory,
+	// but not its parent while another day is in it
+	clock = clock.Add(time.Second)
+	if _, err := resumed.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	resumed.close()
+	if _, err := os.Stat(filepath.Dir(first)); !os.IsNotExist(err) {
+		t.Errorf("empty directory %s wasn't removed: %v", filepath.Dir(first), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2025", "03")); err != nil {
+		t.Errorf("directory still in use was removed: %v", err)
+	}
+}
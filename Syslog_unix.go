@@ -0,0 +1,31 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build !windows
+
+package gzipfilebuffer
+
+import (
+	"log/syslog"
+	"os"
+	"path/filepath"
+)
+
+var syslogFacilityPriorities = map[string]syslog.Priority{
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// openSyslog connects to the local syslog daemon with the program name as
+// the ident
+func openSyslog(facility string) (syslogLogger, error) {
+	return syslog.New(syslogFacilityPriorities[normalizeSyslogFacility(facility)]|syslog.LOG_INFO, filepath.Base(os.Args[0]))
+}
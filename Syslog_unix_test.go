@@ -0,0 +1,105 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build !windows
+
+package gzipfilebuffer
+
+import (
+	"errors"
+	"fmt"
+	"log/syslog"
+	"os"
+	"slices"
+	"testing"
+)
+
+// mockSyslog records each message sent with its priority
+type mockSyslog struct {
+	sent   []string
+	err    error
+	closed bool
+}
+
+func (m *mockSyslog) send(priority syslog.Priority, msg string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, fmt.Sprintf("%d %s", priority, msg))
+	return nil
+}
+
+func (m *mockSyslog) Debug(msg string) error   { return m.send(syslog.LOG_DEBUG, msg) }
+func (m *mockSyslog) Info(msg string) error    { return m.send(syslog.LOG_INFO, msg) }
+func (m *mockSyslog) Warning(msg string) error { return m.send(syslog.LOG_WARNING, msg) }
+func (m *mockSyslog) Err(msg string) error     { return m.send(syslog.LOG_ERR, msg) }
+func (m *mockSyslog) Close() error             { m.closed = true; return nil }
+
+// TestLogSyslog checks each log line is sent to syslog at the priority its
+// prefix gives, and logging falls back to stderr if sending fails
+func TestLogSyslog(t *testing.T) {
+	mock := &mockSyslog{}
+	l := &logWriter{syslog: mock}
+	fmt.Fprintf(l, "Created new file: %s\n", "test_000000.gz")
+	fmt.Fprintf(l, "Warning: no newline found\n")
+	fmt.Fprintf(l, "Error: failed to open file\n")
+	fmt.Fprintf(l, "Internal error: processor panicked\ngoroutine 1 [running]:\n")
+	fmt.Fprintf(l, "Debug: write 10 bytes\n")
+
+	want := []string{
+		fmt.Sprintf("%d Created new file: test_000000.gz", syslog.LOG_INFO),
+		fmt.Sprintf("%d Warning: no newline found", syslog.LOG_WARNING),
+		fmt.Sprintf("%d Error: failed to open file", syslog.LOG_ERR),
+		// The stack trace goes with its error
+		fmt.Sprintf("%d Internal error: processor panicked", syslog.LOG_ERR),
+		fmt.Sprintf("%d goroutine 1 [running]:", syslog.LOG_ERR),
+		fmt.Sprintf("%d Debug: write 10 bytes", syslog.LOG_DEBUG),
+	}
+	if !slices.Equal(mock.sent, want) {
+		t.Errorf("sent %q, want %q", mock.sent, want)
+	}
+
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *os.File) { os.Stderr = saved }(os.Stderr)
+	os.Stderr = stderr
+	mock.err = errors.New("connection refused")
+	fmt.Fprintf(l, "Closed file\n")
+	fmt.Fprintf(l, "Created new file\n")
+	if !mock.closed || l.syslog != nil {
+		t.Errorf("syslog still in use after a failed send")
+	}
+	data, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Warning: writing to syslog failed, logging to stderr: connection refused\nClosed file\nCreated new file\n"; string(data) != want {
+		t.Errorf("stderr has %q, want %q", data, want)
+	}
+}
+
+// The facility is accepted with or without the LOG_ prefix, in either case
+func TestSyslogFacility(t *testing.T) {
+	tests := []struct {
+		facility string
+		want     syslog.Priority
+	}{
+		{"daemon", syslog.LOG_DAEMON},
+		{"LOG_LOCAL0", syslog.LOG_LOCAL0},
+		{"Local7", syslog.LOG_LOCAL7},
+		{"log_local3", syslog.LOG_LOCAL3},
+	}
+	for _, tt := range tests {
+		if got, ok := syslogFacilityPriorities[normalizeSyslogFacility(tt.facility)]; !ok || got != tt.want {
+			t.Errorf("%s gives facility %d (%v), want %d", tt.facility, got, ok, tt.want)
+		}
+	}
+	for _, facility := range syslogFacilities {
+		if _, ok := syslogFacilityPriorities[facility]; !ok {
+			t.Errorf("no syslog priority for --log_syslog_facility %s", facility)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build windows
+
+package gzipfilebuffer
+
+import "errors"
+
+// openSyslog isn't available on Windows, which has no log/syslog
+func openSyslog(facility string) (syslogLogger, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+)
+
+// ParseBlockTrailerFormat parses --block_trailer_format, which uses the block
+// header syntax for fields following each block's data. The trailer is found
+// from the header's length field, so fields that describe the block's layout
+// aren't allowed in it. An fcs field has to be last.
+func ParseBlockTrailerFormat(format string, endianness Endianness) (*BlockHeaderFormat, error) {
+	result, err := ParseBlockHeaderFormat(format, endianness, CRC16CCITT)
+	if err != nil {
+		return nil, err
+	}
+	for i, field := range result.Fields {
+		switch {
+		case field.Type == FieldLength:
+			return nil, fmt.Errorf("length fields are only allowed in the block header")
+		case field.Type == FieldCRC16:
+			return nil, fmt.Errorf("crc16 fields are only allowed in the block header, use fcs")
+		case field.Conditional:
+			return nil, fmt.Errorf("conditional fields are only allowed in the block header")
+		case field.Type == FieldFCS && i != len(result.Fields)-1:
+			return nil, fmt.Errorf("fcs must be the last field")
+		}
+	}
+	return result, nil
+}
+
+// checkBlockTrailer checks the --block_trailer_format fields starting at
+// offset, straight after the block's data. The whole trailer has to be in the
+// buffer to be checked, whether or not --require_complete_block is set.
+func (fb *FileBuffer) checkBlockTrailer(data []byte, offset uint64, now int64) blockCheck {
+	fieldIndex := 0
+	fail := func(reason string) blockCheck {
+		return blockCheck{field: fieldIndex, reason: reason, plausible: true, trailer: true}
+	}
+
+	if offset+uint64(fb.blockTrailer.TotalBytes) > uint64(len(data)) {
+		return fail(trailerPastEnd)
+	}
+
+	pos := int(offset)
+	for i, field := range fb.blockTrailer.Fields {
+		fieldIndex = i
+
+		if field.Type == FieldStringMagic {
+			if !bytes.Equal(data[pos:pos+len(field.MagicBytes)], field.MagicBytes) {
+				return fail("string magic doesn't match")
+			}
+			pos += len(field.MagicBytes)
+			continue
+		}
+
+		value, _ := fb.blockTrailer.readValue(data, pos, field)
+		if field.Type == FieldFCS {
+			// Ethernet CRC32 (0x04C11DB7 reflected, same as IEEE) of
+			// everything from the start of the header up to the FCS
+			if uint64(crc32.ChecksumIEEE(data[:pos])) != value {
+				return fail("FCS doesn't match")
+			}
+		} else if reason := fb.checkValue(field, value, now); reason != "" {
+			return fail(reason)
+		}
+		pos += field.Width / 8
+	}
+
+	return blockCheck{field: -1}
+}
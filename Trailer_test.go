@@ -0,0 +1,105 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestParseBlockTrailerFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr string
+	}{
+		{format: "<u32:fcs>"},
+		{format: "<u8:0x7E><u16><u32:fcs>"},
+		{format: "<str2:\\xAA\\x55>"},
+		{format: "<u16:length>", wantErr: "length fields are only allowed in the block header"},
+		{format: "<u16:crc16>", wantErr: "use fcs"},
+		{format: "<u8:flags?0x80:skip4>", wantErr: "conditional fields are only allowed in the block header"},
+		{format: "<u32:fcs><u8>", wantErr: "fcs must be the last field"},
+		{format: "<u16:fcs>", wantErr: "fcs field must be u32"},
+		{format: "<s32:fcs>", wantErr: "fcs field must be u32"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseBlockTrailerFormat(tt.format, LittleEndian)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("ParseBlockTrailerFormat(%q): %v", tt.format, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("ParseBlockTrailerFormat(%q) error = %v, want one containing %q", tt.format, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckBlockTrailer(t *testing.T) {
+	header, err := ParseBlockHeaderFormat("<u8><u8:length>", LittleEndian, CRC16CCITT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// frame is a block with a header in that format, with its CRC32
+	// appended as an Ethernet FCS
+	frame := func(b ...byte) []byte {
+		return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	}
+	corrupt := func(b []byte) []byte {
+		b[2] ^= 1
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		trailer    string
+		data       []byte
+		offset     uint64 // where the block's data ends
+		wantField  int    // -1 if valid
+		wantReason string
+	}{
+		{name: "fcs", trailer: "<u32:fcs>", data: frame(0x00, 0x03, 'a', 'b', 'c'), offset: 5, wantField: -1},
+		{name: "fcs of a corrupt block", trailer: "<u32:fcs>", data: corrupt(frame(0x00, 0x03, 'a', 'b', 'c')), offset: 5, wantField: 0, wantReason: "FCS doesn't match"},
+		{name: "fcs after other fields", trailer: "<u8:0x7E><u32:fcs>", data: frame(0x00, 0x03, 'a', 'b', 'c', 0x7E), offset: 5, wantField: -1},
+		{name: "field before the fcs", trailer: "<u8:0x7E><u32:fcs>", data: frame(0x00, 0x03, 'a', 'b', 'c', 0x7F), offset: 5, wantField: 0, wantReason: "magic number doesn't match"},
+		{name: "string magic", trailer: "<str2:\\xAA\\x55>", data: []byte{0x00, 0x01, 'a', 0xAA, 0x55}, offset: 3, wantField: -1},
+		{name: "string magic mismatch", trailer: "<str2:\\xAA\\x55>", data: []byte{0x00, 0x01, 'a', 0x55, 0xAA}, offset: 3, wantField: 0, wantReason: "string magic doesn't match"},
+		{name: "past the end", trailer: "<u32:fcs>", data: frame(0x00, 0x03, 'a', 'b', 'c')[:8], offset: 5, wantField: 0, wantReason: trailerPastEnd},
+		{name: "offset past the end", trailer: "<u32:fcs>", data: frame(0x00, 0x03, 'a', 'b', 'c'), offset: 1 << 40, wantField: 0, wantReason: trailerPastEnd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trailer, err := ParseBlockTrailerFormat(tt.trailer, LittleEndian)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fb := newTestFileBuffer(t, WithBlockFormat(header), WithBlockTrailer(trailer))
+			check := fb.checkBlockTrailer(tt.data, tt.offset, checkNow)
+			if check.field != tt.wantField || check.reason != tt.wantReason {
+				t.Errorf("checkBlockTrailer(% X, %d) failed field %d (%q), want %d (%q)", tt.data, tt.offset, check.field, check.reason, tt.wantField, tt.wantReason)
+			}
+			if tt.wantField >= 0 && !check.trailer {
+				t.Errorf("checkBlockTrailer failure isn't marked as in the trailer")
+			}
+		})
+	}
+
+	// checkBlock finds the trailer after the data from the length field, and
+	// counts it in the block size
+	trailer, err := ParseBlockTrailerFormat("<u32:fcs>", LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb := newTestFileBuffer(t, WithBlockFormat(header), WithBlockTrailer(trailer))
+	block := frame(0x00, 0x03, 'a', 'b', 'c')
+	if check := fb.checkBlock(header, append(block, 0x00, 0x01), false, checkNow); check.field >= 0 || check.size != uint64(len(block)) {
+		t.Errorf("checkBlock(% X) failed field %d (%q) with size %d, want valid with size %d", block, check.field, check.reason, check.size, len(block))
+	}
+	if check := fb.checkBlock(header, corrupt(block), false, checkNow); !check.trailer || check.reason != "FCS doesn't match" {
+		t.Errorf("checkBlock(% X) failed with %q, trailer %v, want an FCS mismatch in the trailer", block, check.reason, check.trailer)
+	}
+}
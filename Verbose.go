@@ -0,0 +1,41 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"fmt"
+	"time"
+)
+
+// verboseScanInterval is how often, in offsets, --verbose logs the block
+// header check while scanning a read buffer
+const verboseScanInterval = 1024
+
+// verbosef logs a trace line with --verbose
+func (fb *FileBuffer) verbosef(format string, args ...any) {
+	if fb.verbose {
+		fmt.Fprintf(logOutput, "Debug: "+format+"\n", args...)
+	}
+}
+
+// logTiming logs how long op took on the current file, deferred with the
+// start time
+func (fb *FileBuffer) logTiming(op string, start time.Time) {
+	fb.verbosef("%s %s took %v", op, fb.currentFileName, time.Since(start))
+}
+
+// logCandidate logs the block header check at offset into data
+func (fb *FileBuffer) logCandidate(data []byte, offset int, check blockCheck) {
+	streamOffset := fb.streamOffset - int64(len(data)) + int64(offset)
+	if check.field < 0 {
+		fb.verbosef("block header candidate at stream offset %d: valid %s", streamOffset, fb.formatName(check.format))
+	} else if check.trailer {
+		fb.verbosef("block header candidate at stream offset %d: trailer field %d <%s>: %s",
+			streamOffset, check.field+1, fb.blockTrailer.Fields[check.field], check.reason)
+	} else {
+		fb.verbosef("block header candidate at stream offset %d: %s field %d <%s>: %s",
+			streamOffset, fb.formatName(check.format), check.field+1, check.format.Fields[check.field], check.reason)
+	}
+}
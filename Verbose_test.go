@@ -0,0 +1,71 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestVerbose checks --verbose logs each write, block header scan and
+// candidate, and file open and close, and without it none of them are
+func TestVerbose(t *testing.T) {
+	noise := func(n int) []byte { return bytes.Repeat([]byte{0xFF}, n) }
+	first := pcapRecords(4, 100, 1)
+	second := append(noise(2000), pcapRecords(4, 100, 2)...)
+	last := noise(300)
+
+	for _, verbose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verbose=%v", verbose), func(t *testing.T) {
+			log := captureLog(t)
+			fb := newTestFileBuffer(t,
+				WithQuiet(false),
+				WithVerbose(verbose),
+				WithBlockFormat(presetFormat(t, "pcap")),
+				WithMaxFileSize(1),
+				WithRotateOnUncompressed(true),
+			)
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			for _, data := range [][]byte{first, second, last} {
+				fb.write(data)
+			}
+			fb.close()
+
+			secondAt := len(first)
+			lastAt := secondAt + len(second)
+			want := []string{
+				`^Debug: openNewFile \S+ took \S+$`,
+				fmt.Sprintf(`^Debug: write %d bytes, current file \d+ bytes \(0 uncompressed\)$`, len(first)),
+				fmt.Sprintf(`^Debug: write %d bytes, current file \d+ bytes \(%d uncompressed\)$`, len(second), len(first)),
+				// The scan is logged every 1024 offsets, and where it finds the header
+				fmt.Sprintf(`^Debug: block header candidate at stream offset %d: --block_header field 1 <u32:sec>: not within 48 hours of now$`, secondAt),
+				fmt.Sprintf(`^Debug: block header candidate at stream offset %d: --block_header field 1 <u32:sec>: not within 48 hours of now$`, secondAt+1024),
+				fmt.Sprintf(`^Debug: block header candidate at stream offset %d: valid --block_header$`, secondAt+2000),
+				fmt.Sprintf(`^Debug: findBlockHeader found a --block_header block header at stream offset %d after searching 2000 bytes$`, secondAt+2000),
+				`^Debug: closeCurrentFile \S+ took \S+$`,
+				fmt.Sprintf(`^Debug: findBlockHeader searched stream offsets %d-%d, not found$`, lastAt, lastAt+len(last)),
+			}
+			lines := strings.Split(log(), "\n")
+			for _, pattern := range want {
+				re := regexp.MustCompile(pattern)
+				found := false
+				for _, line := range lines {
+					found = found || re.MatchString(line)
+				}
+				if found != verbose {
+					t.Errorf("log has a line matching %s: %v, want %v", pattern, found, verbose)
+				}
+			}
+			if !verbose && strings.Contains(log(), "Debug:") {
+				t.Errorf("Debug lines without --verbose:\n%s", log())
+			}
+		})
+	}
+}
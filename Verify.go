@@ -0,0 +1,183 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// verifyGzipFile decompresses path, returning the number of bytes that
+// decompressed before any error. A checksum written with --embed_checksum
+// is checked too.
+func verifyGzipFile(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return verifyGzip(f)
+}
+
+// verifyGzip decompresses everything in f, as verifyGzipFile
+func verifyGzip(f io.Reader) (int64, error) {
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return n, err
+	}
+	if want, ok := checksumFromComment(r.Header.Comment); ok {
+		if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+			return n, fmt.Errorf("SHA-256 is %s, but the embedded checksum is %s", got, want)
+		}
+	}
+	return n, nil
+}
+
+// queueVerify checks a just-closed file decompresses, with --verify_output,
+// in the background. The file is opened straight away, so it's still the
+// file that was written that gets checked if it's archived or deleted first.
+// written is how many bytes went into it before compression.
+func (fb *FileBuffer) queueVerify(path string, written int64) {
+	if !fb.verifyOutput || path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: not verifying %s: %v\n", path, err)
+		return
+	}
+
+	fb.background.Add(1)
+	go func() {
+		defer fb.background.Done()
+		defer f.Close()
+		fb.verifyClosedFile(path, f, written)
+	}()
+}
+
+// verifyClosedFile decompresses f, renaming path to .corrupt if that fails
+func (fb *FileBuffer) verifyClosedFile(path string, f *os.File, written int64) {
+	n, err := verifyGzip(f)
+	if err == nil {
+		if n != written {
+			fmt.Fprintf(logOutput, "Warning: %s decompressed to %d bytes, but %d were written to it\n", path, n, written)
+		} else if !fb.quiet {
+			fmt.Fprintf(logOutput, "Verified %s (%d bytes)\n", path, n)
+		}
+		return
+	}
+	fmt.Fprintf(logOutput, "Error: %s is corrupt after %d of %d bytes: %v\n", path, n, written, err)
+
+	fb.mu.Lock()
+	defer fb.unlock()
+	// Archiving or retention may have got to it first
+	i := slices.Index(fb.activeFiles, path)
+	if i < 0 {
+		fmt.Fprintf(logOutput, "Warning: corrupt file %s was moved or deleted before it could be renamed\n", path)
+		return
+	}
+	corruptPath := path + ".corrupt"
+	if err := os.Rename(path, corruptPath); err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to rename corrupt file %s: %v\n", path, err)
+		return
+	}
+	fb.activeFiles = slices.Delete(fb.activeFiles, i, i+1)
+	fb.saveState()
+	if !fb.quiet {
+		fmt.Fprintf(logOutput, "Renamed corrupt file to %s\n", corruptPath)
+	}
+}
+
+// verifyExistingFiles checks each resumed file decompresses cleanly. Corrupt
+// files are renamed to .corrupt and dropped from activeFiles, except that with
+// --repair_last_file the last file is rewritten with whatever could be recovered.
+func (fb *FileBuffer) verifyExistingFiles() {
+	verified := make([]string, 0, len(fb.activeFiles))
+	for i, path := range fb.activeFiles {
+		n, err := verifyGzipFile(path)
+		if err == nil {
+			verified = append(verified, path)
+			continue
+		}
+
+		isLast := i == len(fb.activeFiles)-1
+		fmt.Fprintf(logOutput, "Warning: existing file %s is corrupt after %d bytes: %v\n", path, n, err)
+
+		if isLast && fb.repairLastFile {
+			recovered, err := fb.repairFile(path)
+			if err == nil {
+				if !fb.quiet {
+					fmt.Fprintf(logOutput, "Repaired %s, recovered %d bytes\n", path, recovered)
+				}
+				verified = append(verified, path)
+				continue
+			}
+			fmt.Fprintf(logOutput, "Warning: failed to repair %s: %v\n", path, err)
+		}
+
+		corruptPath := path + ".corrupt"
+		if err := os.Rename(path, corruptPath); err != nil {
+			fmt.Fprintf(logOutput, "Warning: failed to rename corrupt file %s: %v\n", path, err)
+		} else if !fb.quiet {
+			fmt.Fprintf(logOutput, "Renamed corrupt file to %s\n", corruptPath)
+		}
+	}
+	fb.activeFiles = verified
+}
+
+// repairFile rewrites path as a complete gzip stream containing the prefix
+// of its data that can still be decompressed
+func (fb *FileBuffer) repairFile(path string) (int64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	r, err := gzip.NewReader(in)
+	if err != nil {
+		return 0, err
+	}
+
+	tmpPath := path + ".repair"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	gzWriter, err := gzip.NewWriterLevel(out, fb.compressionLevel)
+	if err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	// The copy is expected to fail part way, keep what came out before that
+	recovered, _ := io.Copy(gzWriter, r)
+
+	err = gzWriter.Close()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return recovered, nil
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestVerifyClosedFile damages a closed file's gzip trailer (CRC-32 then
+// uncompressed size) and checks verification renames it to .corrupt and
+// takes it out of activeFiles, leaving an undamaged file alone
+func TestVerifyClosedFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		damage      func(path string) error
+		wantCorrupt bool
+	}{
+		{"intact", func(string) error { return nil }, false},
+		{"no size", func(path string) error { return truncateBy(path, 4) }, true},
+		{"no trailer", func(path string) error { return truncateBy(path, 8) }, true},
+		{"bad checksum", func(path string) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			data[len(data)-8] ^= 0xff
+			return os.WriteFile(path, data, 0644)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discardLog(t)
+			fb := newTestFileBuffer(t)
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			data := syntheticLog(64 * 1024)
+			fb.write(data)
+			fb.close()
+			path := fb.activeFiles[0]
+
+			if err := tt.damage(path); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			fb.verifyClosedFile(path, f, int64(len(data)))
+
+			_, statErr := os.Stat(path + ".corrupt")
+			if renamed := statErr == nil; renamed != tt.wantCorrupt {
+				t.Errorf("renamed to .corrupt: %v, want %v", renamed, tt.wantCorrupt)
+			}
+			if active := slices.Contains(fb.activeFiles, path); active == tt.wantCorrupt {
+				t.Errorf("in activeFiles: %v, want %v", active, !tt.wantCorrupt)
+			}
+			if _, err := os.Stat(path); (err == nil) == tt.wantCorrupt {
+				t.Errorf("%s exists: %v, want %v", path, err == nil, !tt.wantCorrupt)
+			}
+		})
+	}
+}
+
+// TestVerifyOutput checks --verify_output leaves good files as they are
+func TestVerifyOutput(t *testing.T) {
+	discardLog(t)
+	fb := newTestFileBuffer(t, WithVerifyOutput(true))
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		fb.write(packetCapture(16 * 1024))
+		if _, err := fb.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb.close()
+	fb.waitForBackground()
+
+	if len(fb.activeFiles) < 2 {
+		t.Fatalf("%d files, want a few", len(fb.activeFiles))
+	}
+	for _, path := range fb.activeFiles {
+		if _, err := os.Stat(path + ".corrupt"); err == nil {
+			t.Errorf("%s was renamed to .corrupt", path)
+		}
+	}
+}
+
+func truncateBy(path string, n int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, info.Size()-n)
+}
+
+// TestVerifyOnResume truncates the last of some existing files, as a crash
+// would leave it, and checks resuming sets it aside as .corrupt, or with
+// --repair_last_file rewrites it with the data that can be recovered
+func TestVerifyOnResume(t *testing.T) {
+	for _, repair := range []bool{false, true} {
+		t.Run(fmt.Sprintf("repair=%v", repair), func(t *testing.T) {
+			discardLog(t)
+			prefix := filepath.Join(t.TempDir(), "test")
+			fb := newTestFileBuffer(t, WithPrefix(prefix))
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			var last []byte
+			for i := range 3 {
+				if i > 0 {
+					if _, err := fb.Rotate(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				last = nil
+				for j := range 4 {
+					line := []byte(fmt.Sprintf("file %d line %d\n", i, j))
+					fb.write(line)
+					last = append(last, line...)
+				}
+			}
+			fb.close()
+			files := slices.Clone(fb.activeFiles)
+			// Cut into the last write, which was flushed when the file closed
+			if err := truncateBy(files[2], 12); err != nil {
+				t.Fatal(err)
+			}
+
+			resumed := newTestFileBuffer(t, WithPrefix(prefix), WithResumeExisting(true),
+				WithVerifyOnResume(true), WithRepairLastFile(repair))
+			resumed.resume()
+
+			if !repair {
+				if !slices.Equal(resumed.activeFiles, files[:2]) {
+					t.Errorf("resumed with %q, want %q", resumed.activeFiles, files[:2])
+				}
+				if _, err := os.Stat(files[2] + ".corrupt"); err != nil {
+					t.Errorf("truncated file wasn't renamed: %v", err)
+				}
+				return
+			}
+			if !slices.Equal(resumed.activeFiles, files) {
+				t.Fatalf("resumed with %q, want %q", resumed.activeFiles, files)
+			}
+			got := readGzipFiles(t, files[2:])[0]
+			// Everything up to the last write was flushed before it
+			if want := last[:len(last)-len("file 2 line 3\n")]; !bytes.HasPrefix(got, want) || !bytes.HasPrefix(last, got) {
+				t.Errorf("repaired file has %q, want at least %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// webhookRetries is how many times a webhook is retried after a 5xx
+// response or a failure to connect
+const webhookRetries = 3
+
+// WebhookPayload is the JSON body POSTed to --webhook_url
+type WebhookPayload struct {
+	Event             string    `json:"event"` // always "file_closed"
+	File              string    `json:"file"`
+	SizeBytes         int64     `json:"size_bytes"` // compressed, on disk
+	OpenedAt          time.Time `json:"opened_at"`
+	ClosedAt          time.Time `json:"closed_at"`
+	DurationSeconds   float64   `json:"duration_seconds"`
+	BytesUncompressed int64     `json:"bytes_uncompressed"` // including the header
+	BlockCount        int64     `json:"block_count"`        // block boundaries found while it was open
+}
+
+// newWebhookPayload describes the file closeCurrentFile just closed
+func (fb *FileBuffer) newWebhookPayload() WebhookPayload {
+	now := time.Now()
+	payload := WebhookPayload{
+		Event:             "file_closed",
+		File:              fb.currentFileName,
+		OpenedAt:          fb.currentFileOpenedAt,
+		ClosedAt:          now,
+		DurationSeconds:   now.Sub(fb.currentFileOpenedAt).Seconds(),
+		BytesUncompressed: fb.counters.bytesUncompressed.Load() - fb.fileStartUncompressed,
+		BlockCount:        fb.counters.blocksFound.Load() - fb.fileStartBlocks,
+	}
+	if info, err := os.Stat(fb.currentFileName); err == nil {
+		payload.SizeBytes = info.Size()
+	}
+	return payload
+}
+
+// sendWebhook POSTs payload to --webhook_url in the background, so a slow
+// or unreachable endpoint never holds up writing
+func (fb *FileBuffer) sendWebhook(payload WebhookPayload) {
+	if fb.webhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to encode webhook for %s: %v\n", payload.File, err)
+		return
+	}
+
+	fb.background.Add(1)
+	go func() {
+		defer fb.background.Done()
+		for attempt := 0; ; attempt++ {
+			retry, err := fb.postWebhook(body)
+			if err == nil {
+				return
+			}
+			if !retry || attempt == webhookRetries {
+				fmt.Fprintf(logOutput, "Warning: webhook for %s failed: %v\n", payload.File, err)
+				return
+			}
+			time.Sleep(time.Duration(attempt+1) * time.Second)
+		}
+	}()
+}
+
+// postWebhook makes one attempt at delivering body. retry reports whether
+// the failure might be temporary.
+func (fb *FileBuffer) postWebhook(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, fb.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if fb.webhookAuthHeader != "" {
+		req.Header.Set("Authorization", fb.webhookAuthHeader)
+	}
+
+	client := http.Client{Timeout: fb.webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode >= 500, fmt.Errorf("%s", resp.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the payloads POSTed to it, failing the first
+// failures requests with status
+type webhookServer struct {
+	*httptest.Server
+	status   int
+	failures int
+
+	mu       sync.Mutex
+	requests int
+	payloads []WebhookPayload
+}
+
+func newWebhookServer(t *testing.T, status, failures int) *webhookServer {
+	s := &webhookServer{status: status, failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if s.requests <= s.failures {
+			w.WriteHeader(s.status)
+			return
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("got %s with Content-Type %q and Authorization %q", r.Method, r.Header.Get("Content-Type"), r.Header.Get("Authorization"))
+		}
+		var payload WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		s.payloads = append(s.payloads, payload)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// TestWebhook checks a payload is POSTed for each closed file, with its
+// size, data and blocks
+func TestWebhook(t *testing.T) {
+	server := newWebhookServer(t, 0, 0)
+	fb := newTestFileBuffer(t,
+		WithWebhook(server.URL, "Bearer secret", 5*time.Second),
+		WithBlockFormat(presetFormat(t, "pcap")),
+		WithMaxFileSize(1),
+		WithRotateOnUncompressed(true),
+	)
+	start := time.Now()
+	if err := fb.openNewFile(); err != nil {
+		t.Fatal(err)
+	}
+	writes := [][]byte{
+		pcapRecords(4, 100, 1),
+		append(bytes.Repeat([]byte{0xFF}, 10), pcapRecords(4, 100, 2)...),
+		pcapRecords(4, 100, 3),
+	}
+	var written int64
+	for _, data := range writes {
+		fb.write(data)
+		written += int64(len(data))
+	}
+	fb.close()
+	fb.waitForBackground()
+
+	payloads := server.payloads
+	slices.SortFunc(payloads, func(a, b WebhookPayload) int { return strings.Compare(a.File, b.File) })
+	if len(payloads) != len(fb.activeFiles) {
+		t.Fatalf("got %d webhooks, want one for each of %d files", len(payloads), len(fb.activeFiles))
+	}
+	var uncompressed, blocks int64
+	for i, p := range payloads {
+		info, err := os.Stat(fb.activeFiles[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Event != "file_closed" || p.File != fb.activeFiles[i] || p.SizeBytes != info.Size() {
+			t.Errorf("webhook %+v, want file_closed for %s of %d bytes", p, fb.activeFiles[i], info.Size())
+		}
+		if p.OpenedAt.Before(start) || p.ClosedAt.Before(p.OpenedAt) || math.Abs(p.DurationSeconds-p.ClosedAt.Sub(p.OpenedAt).Seconds()) > 0.001 {
+			t.Errorf("%s opened at %v, closed at %v after %vs, want times after %v", p.File, p.OpenedAt, p.ClosedAt, p.DurationSeconds, start)
+		}
+		uncompressed += p.BytesUncompressed
+		blocks += p.BlockCount
+	}
+	if s := fb.Stats(); uncompressed != written || blocks != s.BlocksFound {
+		t.Errorf("webhooks add up to %d bytes and %d blocks, want %d and %d", uncompressed, blocks, written, s.BlocksFound)
+	}
+}
+
+// A 5xx response is retried, after a second, but a 4xx isn't
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantRequests int
+		wantPayloads int
+	}{
+		{"server error", http.StatusServiceUnavailable, 2, 1},
+		{"client error", http.StatusUnauthorized, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discardLog(t)
+			server := newWebhookServer(t, tt.status, 1)
+			fb := newTestFileBuffer(t, WithWebhook(server.URL, "Bearer secret", 5*time.Second))
+			if err := fb.openNewFile(); err != nil {
+				t.Fatal(err)
+			}
+			fb.close()
+			fb.waitForBackground()
+
+			if server.requests != tt.wantRequests || len(server.payloads) != tt.wantPayloads {
+				t.Errorf("%d requests, %d payloads, want %d and %d", server.requests, len(server.payloads), tt.wantRequests, tt.wantPayloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// packetCapture makes size bytes of pcap-like data: a global header, then
+// records of one TCP flow's Ethernet, IPv4 and TCP headers, which differ only
+// in their lengths and sequence numbers. Half the payloads are random, like
+// encrypted traffic, and half are jumbled HTTP request text.
+func packetCapture(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	headers := []byte{
+		0x00, 0x1b, 0x21, 0x3a, 0x4c, 0x5d, 0x00, 0x0c, 0x29, 0x6e, 0x7f, 0x80, 0x08, 0x00, // Ethernet
+		0x45, 0x00, 0x00, 0x00, 0x1c, 0x46, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00, 10, 0, 0, 1, 10, 0, 0, 2, // IPv4
+		0xc3, 0x50, 0x01, 0xbb, 0, 0, 0, 0, 0, 0, 0, 0, 0x50, 0x18, 0x01, 0xf6, 0x00, 0x00, 0x00, 0x00, // TCP
+	}
+	text := []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n")
+	buf := bytes.NewBuffer(pcapGlobalHeader())
+	seq := uint32(0)
+	for i := 0; buf.Len() < size; i++ {
+		payload := make([]byte, rng.Intn(1400))
+		if i%2 == 0 {
+			rng.Read(payload)
+		} else {
+			for j := range payload {
+				payload[j] = text[(j+rng.Intn(4))%len(text)]
+			}
+		}
+		length := len(headers) + len(payload)
+		binary.BigEndian.PutUint16(headers[16:], uint16(length-14))
+		binary.BigEndian.PutUint32(headers[38:], seq)
+		seq += uint32(len(payload))
+
+		var record [16]byte
+		binary.LittleEndian.PutUint32(record[0:], uint32(1735689600+i/1000))
+		binary.LittleEndian.PutUint32(record[4:], uint32(i%1000*1000))
+		binary.LittleEndian.PutUint32(record[8:], uint32(length))
+		binary.LittleEndian.PutUint32(record[12:], uint32(length))
+		buf.Write(record[:])
+		buf.Write(headers)
+		buf.Write(payload)
+	}
+	return buf.Bytes()[:size]
+}
+
+// benchmarkCompressionLevel gzips 1MB of packet capture at level, as each
+// file is, reporting the compressed size as a fraction of the input
+func benchmarkCompressionLevel(b *testing.B, level int) {
+	data := packetCapture(1 << 20)
+	fb := newTestFileBuffer(b, WithCompressionLevel(level))
+	var counter countingDiscard
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for range b.N {
+		counter = 0
+		z, err := fb.newGzipStream(&counter)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := z.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(counter)/float64(len(data)), "ratio")
+}
+
+// countingDiscard counts the bytes written to it and discards them
+type countingDiscard int64
+
+func (c *countingDiscard) Write(p []byte) (int, error) {
+	*c += countingDiscard(len(p))
+	return len(p), nil
+}
+
+func BenchmarkCompressionLevelDefault(b *testing.B) {
+	benchmarkCompressionLevel(b, gzip.DefaultCompression)
+}
+func BenchmarkCompressionLevel0(b *testing.B) { benchmarkCompressionLevel(b, 0) }
+func BenchmarkCompressionLevel1(b *testing.B) { benchmarkCompressionLevel(b, 1) }
+func BenchmarkCompressionLevel2(b *testing.B) { benchmarkCompressionLevel(b, 2) }
+func BenchmarkCompressionLevel3(b *testing.B) { benchmarkCompressionLevel(b, 3) }
+func BenchmarkCompressionLevel4(b *testing.B) { benchmarkCompressionLevel(b, 4) }
+func BenchmarkCompressionLevel5(b *testing.B) { benchmarkCompressionLevel(b, 5) }
+func BenchmarkCompressionLevel6(b *testing.B) { benchmarkCompressionLevel(b, 6) }
+func BenchmarkCompressionLevel7(b *testing.B) { benchmarkCompressionLevel(b, 7) }
+func BenchmarkCompressionLevel8(b *testing.B) { benchmarkCompressionLevel(b, 8) }
+func BenchmarkCompressionLevel9(b *testing.B) { benchmarkCompressionLevel(b, 9) }
+
+// BenchmarkPipeline runs the reader, processor and gzip writer end to end
+// through WriteFrom, reading b.N read buffers of packet capture from a pipe
+// into rotating files, at a compression level that leaves it I/O bound (0),
+// light on CPU (1) and heavy on CPU (9)
+func BenchmarkPipeline(b *testing.B) {
+	data := packetCapture(defaultBufferSize)
+	for _, level := range []int{0, 1, 9} {
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			fb := newTestFileBuffer(b, WithCompressionLevel(level), WithMaxFileSize(16<<20), WithMaxNumFiles(4))
+			pr, pw := io.Pipe()
+			go func() {
+				for range b.N {
+					if _, err := pw.Write(data); err != nil {
+						break
+					}
+				}
+				pw.Close()
+			}()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			start := time.Now()
+			if err := fb.WriteFrom(pr); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(b.N*len(data))/1e6/time.Since(start).Seconds(), "MB/s")
+		})
+	}
+}
+
+// BenchmarkRateLimit runs packet capture through WriteFrom with
+// --max_input_rate_mbps, and uncompressed with --max_output_rate_mbps, and
+// fails if the rate after the initial burst is more than 5% off the target
+func BenchmarkRateLimit(b *testing.B) {
+	const mbps = 80 // 10MB a second
+	data := packetCapture(defaultBufferSize)
+	for _, limit := range []string{"input", "output"} {
+		b.Run(limit, func(b *testing.B) {
+			discardLog(b)
+			opts := []Option{WithCompressionLevel(0), WithMaxFileSize(16 << 20), WithMaxNumFiles(4), WithMaxInputRate(mbps)}
+			counter := func(fb *FileBuffer) int64 { return fb.counters.bytesUncompressed.Load() }
+			if limit == "output" {
+				opts[len(opts)-1] = WithMaxOutputRate(mbps)
+				counter = func(fb *FileBuffer) int64 { return fb.counters.bytesCompressed.Load() }
+			}
+			fb := newTestFileBuffer(b, opts...)
+			pr, pw := io.Pipe()
+			go func() {
+				for range b.N {
+					if _, err := pw.Write(data); err != nil {
+						break
+					}
+				}
+				pw.Close()
+			}()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			start := time.Now()
+			if err := fb.WriteFrom(pr); err != nil {
+				b.Fatal(err)
+			}
+			elapsed := time.Since(start).Seconds()
+			burst := float64(mbps) * 1e6 / 8 / 10
+			got := (float64(counter(fb)) - burst) * 8 / 1e6 / elapsed
+			b.ReportMetric(got, "Mbps")
+			// Too short a run is mostly burst and the unthrottled end
+			if elapsed >= 0.5 && (got < mbps*0.95 || got > mbps*1.05) {
+				b.Errorf("%s rate %.1f Mbps, want within 5%% of %d", limit, got, mbps)
+			}
+		})
+	}
+}
+
+// BenchmarkGzipWindow compares compression ratio and speed of the smallest
+// and largest --gzip_window_bits on 1MB of log lines. Both use the
+// windowDeflater, so only the window differs.
+func BenchmarkGzipWindow(b *testing.B) {
+	data := syntheticLog(1 << 20)
+	for _, windowBits := range []int{9, 15} {
+		b.Run(fmt.Sprintf("window_bits=%d", windowBits), func(b *testing.B) {
+			var counter countingDiscard
+			z := newWindowDeflater(&counter, gzip.DefaultCompression, windowBits, defaultGzipMemLevel, nil)
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for range b.N {
+				counter = 0
+				z.Reset(&counter)
+				if _, err := z.Write(data); err != nil {
+					b.Fatal(err)
+				}
+				if err := z.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data))/float64(counter), "ratio")
+		})
+	}
+}
+
+// BenchmarkGzipDictionary compares the compression ratio of 16KB files of
+// packet capture with and without a dictionary of earlier capture. Small
+// files gain the most, as each starts with an empty window otherwise.
+func BenchmarkGzipDictionary(b *testing.B) {
+	capture := packetCapture(1 << 20)
+	dict, data := capture[:32*1024], capture[32*1024:]
+	const fileSize = 16 * 1024
+	for _, name := range []string{"none", "32KB"} {
+		b.Run("dictionary="+name, func(b *testing.B) {
+			var opts []Option
+			if name != "none" {
+				opts = append(opts, WithGzipDictionary(dict, false))
+			}
+			fb := newTestFileBuffer(b, opts...)
+			var counter countingDiscard
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for range b.N {
+				counter = 0
+				for i := 0; i+fileSize <= len(data); i += fileSize {
+					z, err := fb.newGzipStream(&counter)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := z.Write(data[i : i+fileSize]); err != nil {
+						b.Fatal(err)
+					}
+					if err := z.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(counter)/float64(len(data)/fileSize*fileSize), "ratio")
+		})
+	}
+}
+
+// BenchmarkFileSize compares finding the current file's size with a Stat
+// after every write, as write() used to, with the byte counter it keeps
+// now. The writes are small and stored uncompressed, so the size check is a
+// noticeable part of each.
+func BenchmarkFileSize(b *testing.B) {
+	data := packetCapture(1024)
+	for _, stat := range []bool{true, false} {
+		name := "counter"
+		if stat {
+			name = "stat"
+		}
+		b.Run(name, func(b *testing.B) {
+			fb := newTestFileBuffer(b, WithCompressionLevel(0), WithMaxFileSize(1<<40))
+			if err := fb.openNewFile(); err != nil {
+				b.Fatal(err)
+			}
+			defer fb.close()
+			b.SetBytes(int64(len(data)))
+			var size int64
+			for range b.N {
+				fb.write(data)
+				if stat {
+					info, err := fb.currentFile.Stat()
+					if err != nil {
+						b.Fatal(err)
+					}
+					size = info.Size()
+				} else {
+					size = fb.currentSize()
+				}
+			}
+			if size == 0 {
+				b.Fatal("nothing written")
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// GzipFileBuffer streams stdin to rotating gzip-compressed files. Run it with
+// --help for the options.
+package main
+
+import gzipfilebuffer "GzipFileBuffer"
+
+func main() {
+	gzipfilebuffer.Main()
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package gzipfilebuffer_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gzipfilebuffer "GzipFileBuffer"
+)
+
+// Compress a stream of newline-terminated records into at most 5 files of
+// about 1KB each, splitting between lines, and report each file opened
+func Example() {
+	dir, err := os.MkdirTemp("", "example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fb, err := gzipfilebuffer.NewFileBuffer(
+		gzipfilebuffer.WithPrefix(filepath.Join(dir, "records.txt")),
+		gzipfilebuffer.WithMaxFileSize(1024),
+		gzipfilebuffer.WithRotateOnUncompressed(true),
+		gzipfilebuffer.WithMaxNumFiles(5),
+		gzipfilebuffer.WithReadBufferSize(256),
+		gzipfilebuffer.WithMaxBlockSize(256),
+		gzipfilebuffer.WithSplitOnNewline(true, 0),
+		gzipfilebuffer.WithCompressionLevel(9),
+		gzipfilebuffer.WithQuiet(true),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fb.OnRotate(func(closed, opened string) {
+		// Filenames are the prefix, a counter and a timestamp
+		fmt.Println("opened", strings.Join(strings.Split(filepath.Base(opened), "_")[:2], "_"))
+	})
+
+	var input strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&input, "record %03d\n", i)
+	}
+	if err := fb.WriteFrom(strings.NewReader(input.String())); err != nil {
+		log.Fatal(err)
+	}
+
+	stats := fb.Stats()
+	fmt.Printf("%d bytes in, %d files created\n", stats.BytesWrittenUncompressed, stats.FilesCreated)
+	// Output:
+	// opened records_000000
+	// opened records_000001
+	// 2200 bytes in, 2 files created
+}
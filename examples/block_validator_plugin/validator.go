@@ -0,0 +1,44 @@
+// Copyright (c) 2025 Neil Stephens. All rights reserved.
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build ignore
+
+// Sample --block_validator_plugin, with the validation itself in C. It
+// accepts blocks of:
+//
+//	0x7E | u16 big-endian payload length | payload | XOR of the payload bytes
+//
+// The XOR check byte after the payload is something a --block_header format
+// can't express. Build it with the same Go version as GzipFileBuffer:
+//
+//	go build -buildmode=plugin -o validator.so examples/block_validator_plugin/validator.go
+package main
+
+/*
+// validate_block returns the block length if a valid block starts at data,
+// 0 if not, or -1 if the block runs past len
+static int validate_block(const unsigned char *data, int len) {
+	if (len < 1 || data[0] != 0x7E)
+		return 0;
+	if (len < 3)
+		return -1;
+	int payload = data[1] << 8 | data[2];
+	int total = 3 + payload + 1;
+	if (len < total)
+		return -1;
+	unsigned char x = 0;
+	for (int i = 0; i < payload; i++)
+		x ^= data[3 + i];
+	return x == data[3 + payload] ? total : 0;
+}
+*/
+import "C"
+
+import "unsafe"
+
+// ValidateBlock is looked up by GzipFileBuffer, see blockValidatorSymbol.
+// data points at n bytes of the read buffer, which go straight to C.
+func ValidateBlock(data *byte, n int) int {
+	return int(C.validate_block((*C.uchar)(unsafe.Pointer(data)), C.int(n)))
+}